@@ -0,0 +1,272 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// start of frame character, 2 hex characters per byte of unit id,
+	// function code, payload (up to 252 bytes) and LRC, followed by CR LF
+	maxASCIIFrameLength int = 513
+)
+
+type asciiTransport struct {
+	logger           *logger
+	link             *ctxLink
+	timeout          time.Duration
+	interCharTimeout time.Duration
+	// set when a request is abandoned after being sent, in which case
+	// its response may still come off the link
+	resync bool
+	// bytes read from the link but not consumed yet
+	rxbuf  [maxASCIIFrameLength]byte
+	rxHead int
+	rxTail int
+}
+
+// Returns a new ASCII transport.
+func newASCIITransport(link rtuLink, addr string, timeout time.Duration,
+	interCharTimeout time.Duration, customLogger *log.Logger) (at *asciiTransport) {
+	at = &asciiTransport{
+		logger:           newLogger(fmt.Sprintf("ascii-transport(%s)", addr), customLogger),
+		link:             newCtxLink(link),
+		timeout:          timeout,
+		interCharTimeout: interCharTimeout,
+	}
+
+	return
+}
+
+// Closes the ascii link.
+func (at *asciiTransport) Close() (err error) {
+	err = at.link.Close()
+
+	return
+}
+
+// Runs a request across the ascii link and returns a response.
+// I/O deadlines are bounded by ctx.
+func (at *asciiTransport) ExecuteRequest(ctx context.Context, req *pdu) (res *pdu, err error) {
+	defer at.link.bind(ctx)()
+
+	// drop any stale data left over from a previous transaction
+	at.rxHead = 0
+	at.rxTail = 0
+	if at.resync {
+		discard(at.link)
+		at.resync = false
+	}
+
+	// set an i/o deadline on the link
+	err = at.link.SetDeadline(time.Now().Add(at.timeout))
+	if err != nil {
+		return
+	}
+
+	// build an ASCII frame out of the request object and send it on the wire
+	_, err = at.link.Write(at.assembleASCIIFrame(req))
+	if err != nil {
+		return
+	}
+
+	// read the response back from the wire
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+
+	if err != nil && ctxErr(ctx) != nil {
+		// the request was abandoned: discard whatever is left of the
+		// response before sending the next request
+		at.resync = true
+		err = ctxErr(ctx)
+	}
+
+	return
+}
+
+// Reads a request from the ascii link.
+func (at *asciiTransport) ReadRequest() (req *pdu, err error) {
+	req, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+
+	return
+}
+
+// Ties request reads to ctx (see readBinder).
+func (at *asciiTransport) bindReads(ctx context.Context) (unbind func()) {
+	unbind = at.link.bindReads(ctx, at.timeout)
+
+	return
+}
+
+// Writes a response to the ascii link.
+func (at *asciiTransport) WriteResponse(res *pdu) (err error) {
+	err = at.link.SetDeadline(time.Now().Add(at.timeout))
+	if err != nil {
+		return
+	}
+
+	_, err = at.link.Write(at.assembleASCIIFrame(res))
+
+	return
+}
+
+// Waits for, reads and decodes a frame from the ascii link.
+// The start of the frame is expected before deadline, and subsequent
+// characters within the inter-character timeout of one another.
+func (at *asciiTransport) readASCIIFrame(deadline time.Time) (res *pdu, err error) {
+	var c byte
+	var hexChars []byte
+	var frame []byte
+	var lrc lrc
+	var done bool
+
+	// wait for the start of frame character, discarding anything else
+	for c != ':' {
+		c, err = at.readChar(deadline)
+		if err != nil {
+			return
+		}
+	}
+
+	hexChars = make([]byte, 0, maxASCIIFrameLength)
+
+	for !done {
+		c, err = at.readChar(time.Now().Add(at.interCharTimeout))
+		if err != nil {
+			break
+		}
+
+		switch c {
+		case ':':
+			// a start of frame character always starts a new frame
+			hexChars = hexChars[:0]
+
+		case '\r':
+			// the frame should end with CR LF
+			c, err = at.readChar(time.Now().Add(at.interCharTimeout))
+			if err == nil && c != '\n' {
+				at.logger.Warningf("expected LF after CR, received 0x%02x", c)
+				err = ErrProtocolError
+				return
+			}
+			done = true
+
+		default:
+			// never read more than the max allowed frame length
+			// (accounting for the start of frame character and CR LF)
+			if len(hexChars) >= maxASCIIFrameLength-3 {
+				err = ErrProtocolError
+				return
+			}
+			hexChars = append(hexChars, c)
+		}
+	}
+
+	// a frame cut short by a timeout or by the end of the stream is
+	// incomplete
+	if err != nil {
+		if err == ErrRequestTimedOut || os.IsTimeout(err) || err == io.EOF {
+			at.logger.Warningf("incomplete frame (%v characters received)",
+				len(hexChars))
+			err = ErrShortFrame
+		}
+		return
+	}
+
+	// decode the hex characters into bytes
+	if len(hexChars)%2 != 0 {
+		err = ErrProtocolError
+		return
+	}
+	frame = make([]byte, len(hexChars)/2)
+	_, err = hex.Decode(frame, hexChars)
+	if err != nil {
+		at.logger.Warningf("failed to decode frame: %v", err)
+		err = ErrProtocolError
+		return
+	}
+
+	// expect at least a unit id, a function code and an LRC
+	if len(frame) < 3 {
+		err = ErrShortFrame
+		return
+	}
+
+	// compute the LRC on the entire frame, excluding the LRC
+	lrc.init()
+	lrc.add(frame[0 : len(frame)-1])
+
+	// compare LRC values
+	if !lrc.isEqual(frame[len(frame)-1]) {
+		err = ErrBadLRC
+		return
+	}
+
+	res = &pdu{
+		unitId:       frame[0],
+		functionCode: frame[1],
+		payload:      frame[2 : len(frame)-1],
+	}
+
+	return
+}
+
+// Returns the next character from the ascii link, reading more bytes from
+// the link if needed. Reads which have not returned any data by deadline
+// fail with a timeout error.
+func (at *asciiTransport) readChar(deadline time.Time) (c byte, err error) {
+	var n int
+
+	for at.rxHead == at.rxTail {
+		err = at.link.SetDeadline(deadline)
+		if err != nil {
+			return
+		}
+
+		// note: serial ports return empty reads until either some data
+		// is received or the deadline expires
+		n, err = at.link.Read(at.rxbuf[:])
+		at.rxHead = 0
+		at.rxTail = n
+
+		// use any data received before the error, if any, as the error
+		// will be returned again on the next read
+		if n == 0 && err != nil {
+			return
+		}
+		err = nil
+	}
+
+	c = at.rxbuf[at.rxHead]
+	at.rxHead++
+
+	return
+}
+
+// Turns a PDU object into an ASCII frame.
+func (at *asciiTransport) assembleASCIIFrame(p *pdu) (frame []byte) {
+	var adu []byte
+	var lrc lrc
+
+	adu = append(adu, p.unitId)
+	adu = append(adu, p.functionCode)
+	adu = append(adu, p.payload...)
+
+	// run the ADU through the LRC generator and append the LRC to the ADU
+	lrc.init()
+	lrc.add(adu)
+	adu = append(adu, lrc.value())
+
+	// hex-encode the ADU with uppercase characters, between the start of
+	// frame character and CR LF
+	frame = append(frame, ':')
+	frame = append(frame, bytes.ToUpper([]byte(hex.EncodeToString(adu)))...)
+	frame = append(frame, '\r', '\n')
+
+	return
+}
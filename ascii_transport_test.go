@@ -0,0 +1,217 @@
+package modbus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAssembleASCIIFrame(t *testing.T) {
+	var at *asciiTransport
+	var frame []byte
+
+	at = &asciiTransport{}
+
+	// read 3 holding registers at address 0x006b from unit #17
+	frame = at.assembleASCIIFrame(&pdu{
+		unitId:       0x11,
+		functionCode: 0x03,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x03},
+	})
+	// expect a start of frame character, 2 characters of unit id,
+	// 2 characters of function code, 8 characters of payload, 2 characters
+	// of LRC and CR LF
+	if string(frame) != ":1103006B00037E\r\n" {
+		t.Errorf("unexpected frame: %q", frame)
+	}
+
+	frame = at.assembleASCIIFrame(&pdu{
+		unitId:       0x31,
+		functionCode: 0x86,
+		payload:      []byte{0x02},
+	})
+	if string(frame) != ":31860247\r\n" {
+		t.Errorf("unexpected frame: %q", frame)
+	}
+
+	return
+}
+
+func TestASCIITransportReadASCIIFrame(t *testing.T) {
+	var at *asciiTransport
+	var p1, p2 net.Conn
+	var txchan chan []byte
+	var err error
+	var res *pdu
+
+	txchan = make(chan []byte, 2)
+	p1, p2 = net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	at = newASCIITransport(p2, "", 50*time.Millisecond, 20*time.Millisecond, nil)
+
+	// read a valid response (illegal data address)
+	txchan <- []byte(":3182024B\r\n")
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err != nil {
+		t.Fatalf("readASCIIFrame() should have succeeded, got %v", err)
+	}
+	if res.unitId != 0x31 {
+		t.Errorf("expected 0x31 as unit id, got 0x%02x", res.unitId)
+	}
+	if res.functionCode != 0x82 {
+		t.Errorf("expected 0x82 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 1 || res.payload[0] != 0x02 {
+		t.Errorf("expected {0x02} as payload, got %v", res.payload)
+	}
+
+	// read a longer, valid response split across writes, with lowercase
+	// hex characters and leading garbage
+	txchan <- []byte("\x00garbage:310304112233")
+	txchan <- []byte("441e\r\n")
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err != nil {
+		t.Fatalf("readASCIIFrame() should have succeeded, got %v", err)
+	}
+	if res.unitId != 0x31 {
+		t.Errorf("expected 0x31 as unit id, got 0x%02x", res.unitId)
+	}
+	if res.functionCode != 0x03 {
+		t.Errorf("expected 0x03 as function code, got 0x%02x", res.functionCode)
+	}
+	for i, b := range []byte{0x04, 0x11, 0x22, 0x33, 0x44} {
+		if len(res.payload) != 5 || res.payload[i] != b {
+			t.Errorf("expected 0x%02x at position %v, got %v", b, i, res.payload)
+		}
+	}
+
+	// read a frame with a bad LRC
+	txchan <- []byte(":3182024A\r\n")
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err != ErrBadLRC {
+		t.Errorf("readASCIIFrame() should have returned ErrBadLRC, got %v", err)
+	}
+
+	// a start of frame character should restart the frame
+	txchan <- []byte(":3182:3182024B\r\n")
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err != nil {
+		t.Errorf("readASCIIFrame() should have succeeded, got %v", err)
+	}
+
+	// read a frame with invalid hex characters
+	txchan <- []byte(":3182024G\r\n")
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err != ErrProtocolError {
+		t.Errorf("readASCIIFrame() should have returned ErrProtocolError, got %v", err)
+	}
+
+	// read a frame with an odd number of characters
+	txchan <- []byte(":3182024B1\r\n")
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err != ErrProtocolError {
+		t.Errorf("readASCIIFrame() should have returned ErrProtocolError, got %v", err)
+	}
+
+	// read a frame terminated by CR alone
+	txchan <- []byte(":3182024B\r\r")
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err != ErrProtocolError {
+		t.Errorf("readASCIIFrame() should have returned ErrProtocolError, got %v", err)
+	}
+
+	// read a frame too short to hold a unit id, function code and LRC
+	txchan <- []byte(":3131\r\n")
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err != ErrShortFrame {
+		t.Errorf("readASCIIFrame() should have returned ErrShortFrame, got %v", err)
+	}
+
+	// read a frame exceeding the inter-character timeout
+	txchan <- []byte(":318202")
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err != ErrShortFrame {
+		t.Errorf("readASCIIFrame() should have returned ErrShortFrame, got %v", err)
+	}
+
+	// the remainder of the frame should be discarded while waiting
+	// for the next start of frame character
+	txchan <- []byte("4B\r\n:3182024B\r\n")
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err != nil {
+		t.Errorf("readASCIIFrame() should have succeeded, got %v", err)
+	}
+
+	// wait for a frame which never comes
+	res, err = at.readASCIIFrame(time.Now().Add(at.timeout))
+	if err == nil {
+		t.Errorf("readASCIIFrame() should have failed")
+	}
+
+	p1.Close()
+	p2.Close()
+
+	return
+}
+
+func TestASCIITransportExecuteRequest(t *testing.T) {
+	var at *asciiTransport
+	var p1, p2 net.Conn
+	var rxbuf []byte
+	var n int
+	var err error
+	var res *pdu
+	var done chan error
+
+	p1, p2 = net.Pipe()
+	done = make(chan error, 1)
+
+	at = newASCIITransport(p2, "", 100*time.Millisecond, 20*time.Millisecond, nil)
+
+	// play the part of the server: read the request, then reply with
+	// the value of the requested register
+	go func() {
+		var err error
+
+		rxbuf = make([]byte, 64)
+		n, err = p1.Read(rxbuf)
+		if err == nil {
+			_, err = p1.Write([]byte(":1103021234A4\r\n"))
+		}
+		done <- err
+	}()
+
+	res, err = at.ExecuteRequest(context.Background(), &pdu{
+		unitId:       0x11,
+		functionCode: fcReadHoldingRegisters,
+		payload:      []byte{0x00, 0x6b, 0x00, 0x01},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteRequest() should have succeeded, got %v", err)
+	}
+
+	err = <-done
+	if err != nil {
+		t.Fatalf("failed to serve request: %v", err)
+	}
+
+	if string(rxbuf[0:n]) != ":1103006B000180\r\n" {
+		t.Errorf("unexpected request frame: %q", rxbuf[0:n])
+	}
+
+	if res.unitId != 0x11 || res.functionCode != fcReadHoldingRegisters {
+		t.Errorf("unexpected unit id/function code: 0x%02x/0x%02x",
+			res.unitId, res.functionCode)
+	}
+	if len(res.payload) != 3 || res.payload[0] != 0x02 ||
+		res.payload[1] != 0x12 || res.payload[2] != 0x34 {
+		t.Errorf("unexpected payload: %v", res.payload)
+	}
+
+	p1.Close()
+	p2.Close()
+
+	return
+}
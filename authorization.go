@@ -0,0 +1,251 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Role-based authorization policy object.
+//
+// A policy maps client roles (see ClientRole, as extracted from client
+// certificates on tcp+tls servers) to the rules granting them access.
+// Requests are denied unless at least one rule of the client role allows
+// them. Requests from clients without a role (e.g. on plain tcp servers)
+// are matched against the rules of the empty role "".
+//
+// Denied requests are answered with an illegal function exception, as
+// suggested by the MBAPS spec, and logged along with the client identity.
+//
+// Read/write multiple registers requests (0x17) need both read and write
+// access, and so do mask write register requests (0x16), which read the
+// target register before writing to it. Requests carrying function codes
+// unknown to the server (see FallbackHandler) are considered writes.
+type AuthorizationPolicy struct {
+	Roles map[string][]AuthorizationRule `json:"roles" yaml:"roles"`
+}
+
+// Authorization rule object. Empty lists match everything.
+type AuthorizationRule struct {
+	// FunctionCodes sets the function codes allowed by the rule.
+	FunctionCodes []uint8 `json:"functionCodes,omitempty" yaml:"functionCodes,omitempty"`
+	// UnitIds sets the unit ids allowed by the rule.
+	UnitIds []uint8 `json:"unitIds,omitempty" yaml:"unitIds,omitempty"`
+	// Ranges sets the address ranges allowed by the rule. Requests
+	// covering an address range (e.g. register reads) are allowed only if
+	// that range fits entirely within one of them. Requests without an
+	// address range (e.g. file record or diagnostics requests) are never
+	// allowed by rules with ranges.
+	Ranges []AddressRange `json:"ranges,omitempty" yaml:"ranges,omitempty"`
+	// Read allows read requests.
+	Read bool `json:"read" yaml:"read"`
+	// Write allows write requests.
+	Write bool `json:"write" yaml:"write"`
+}
+
+// Address range object, from First to Last (inclusive).
+type AddressRange struct {
+	First uint16 `json:"first" yaml:"first"`
+	Last  uint16 `json:"last" yaml:"last"`
+}
+
+// Loads an authorization policy from a JSON document, e.g.:
+//
+//	{"roles": {
+//	  "operator": [
+//	    {"read": true},
+//	    {"write": true, "unitIds": [1], "ranges": [{"first": 100, "last": 199}]}
+//	  ],
+//	  "monitor": [{"read": true, "functionCodes": [3, 4]}]
+//	}}
+//
+// Unknown fields and invalid rules yield errors wrapping
+// ErrConfigurationError.
+func LoadAuthorizationPolicy(r io.Reader) (ap *AuthorizationPolicy, err error) {
+	var dec = json.NewDecoder(r)
+
+	dec.DisallowUnknownFields()
+
+	ap = &AuthorizationPolicy{}
+	err = dec.Decode(ap)
+	if err != nil {
+		ap = nil
+		err = fmt.Errorf("%w: %v", ErrConfigurationError, err)
+		return
+	}
+
+	err = ap.validate()
+	if err != nil {
+		ap = nil
+	}
+
+	return
+}
+
+// Parses an authorization policy from a JSON document (see
+// LoadAuthorizationPolicy).
+func ParseAuthorizationPolicy(doc []byte) (ap *AuthorizationPolicy, err error) {
+	ap, err = LoadAuthorizationPolicy(bytes.NewReader(doc))
+
+	return
+}
+
+// Loads an authorization policy from a YAML document, e.g.:
+//
+//	roles:
+//	  operator:
+//	    - read: true
+//	    - write: true
+//	      unitIds: [1]
+//	      ranges: [{first: 100, last: 199}]
+//	  monitor:
+//	    - {read: true, functionCodes: [3, 4]}
+//
+// Unknown fields and invalid rules yield errors wrapping
+// ErrConfigurationError.
+func LoadAuthorizationPolicyYAML(r io.Reader) (ap *AuthorizationPolicy, err error) {
+	var dec = yaml.NewDecoder(r)
+
+	dec.KnownFields(true)
+
+	ap = &AuthorizationPolicy{}
+	err = dec.Decode(ap)
+	if err != nil {
+		ap = nil
+		err = fmt.Errorf("%w: %v", ErrConfigurationError, err)
+		return
+	}
+
+	err = ap.validate()
+	if err != nil {
+		ap = nil
+	}
+
+	return
+}
+
+// Parses an authorization policy from a YAML document (see
+// LoadAuthorizationPolicyYAML).
+func ParseAuthorizationPolicyYAML(doc []byte) (ap *AuthorizationPolicy, err error) {
+	ap, err = LoadAuthorizationPolicyYAML(bytes.NewReader(doc))
+
+	return
+}
+
+// Returns true if the policy allows req.
+// Requests reading registers on top of writing to them (see ServerRequest)
+// need both read and write access.
+func (ap *AuthorizationPolicy) Allows(req *ServerRequest) (allowed bool) {
+	if req.IsWrite && req.ReadQuantity > 0 {
+		var read = *req
+
+		read.IsWrite = false
+		read.Addr = req.ReadAddr
+		read.Quantity = req.ReadQuantity
+
+		allowed = ap.allows(&read) && ap.allows(req)
+		return
+	}
+
+	allowed = ap.allows(req)
+
+	return
+}
+
+// Returns true if any rule of the client role allows req.
+func (ap *AuthorizationPolicy) allows(req *ServerRequest) (allowed bool) {
+	for _, rule := range ap.Roles[req.ClientRole] {
+		if rule.allows(req) {
+			allowed = true
+			return
+		}
+	}
+
+	return
+}
+
+// Returns an error if any rule of the policy is invalid.
+func (ap *AuthorizationPolicy) validate() (err error) {
+	for role, rules := range ap.Roles {
+		for i, rule := range rules {
+			for _, r := range rule.Ranges {
+				if r.First > r.Last {
+					err = fmt.Errorf("%w: role %q: rule #%v: range first address "+
+						"(%v) is past its last address (%v)",
+						ErrConfigurationError, role, i+1, r.First, r.Last)
+					return
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// Returns true if the rule allows req.
+func (rule *AuthorizationRule) allows(req *ServerRequest) (allowed bool) {
+	if req.IsWrite && !rule.Write || !req.IsWrite && !rule.Read {
+		return
+	}
+
+	if len(rule.FunctionCodes) > 0 && !slices.Contains(rule.FunctionCodes, req.FunctionCode) {
+		return
+	}
+
+	if len(rule.UnitIds) > 0 && !slices.Contains(rule.UnitIds, req.UnitId) {
+		return
+	}
+
+	if len(rule.Ranges) > 0 {
+		var last uint32
+
+		// fail closed on requests which cannot be matched against ranges
+		if req.Quantity == 0 {
+			return
+		}
+
+		last = uint32(req.Addr) + uint32(req.Quantity) - 1
+
+		if !slices.ContainsFunc(rule.Ranges, func(r AddressRange) bool {
+			return req.Addr >= r.First && last <= uint32(r.Last)
+		}) {
+			return
+		}
+	}
+
+	allowed = true
+
+	return
+}
+
+// Middleware enforcing the authorization policy of the server.
+func (ms *ModbusServer) authorize(req *ServerRequest, next func() error) (err error) {
+	err = ms.checkAuthorization(req)
+	if err != nil {
+		return
+	}
+
+	err = next()
+
+	return
+}
+
+// Returns ErrIllegalFunction and logs the denial if req is not allowed by
+// the authorization policy of the server, if any.
+func (ms *ModbusServer) checkAuthorization(req *ServerRequest) (err error) {
+	if ms.conf.AuthorizationPolicy == nil || ms.conf.AuthorizationPolicy.Allows(req) {
+		return
+	}
+
+	ms.logger.Warningf("access denied to client '%s' (role: '%s'): unit id %v, "+
+		"fc 0x%02x, addr %v, quantity %v, write: %v", req.ClientAddr,
+		req.ClientRole, req.UnitId, req.FunctionCode, req.Addr, req.Quantity,
+		req.IsWrite)
+	err = ErrIllegalFunction
+
+	return
+}
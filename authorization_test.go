@@ -0,0 +1,327 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a client talking to a server enforcing policy, as seen from a
+// client with the given role.
+func newAuthzTestClient(t *testing.T, policy *AuthorizationPolicy, role string,
+	handler RequestHandler, logger *log.Logger) (mc *ModbusClient) {
+	var ms *ModbusServer
+	var err error
+
+	ms, err = NewServer(&ServerConfiguration{
+		URL:                 "tcp://fake",
+		AuthorizationPolicy: policy,
+		Logger:              logger,
+	}, handler)
+	if err != nil {
+		t.Fatalf("NewServer() should have succeeded, got: %v", err)
+	}
+
+	mc, err = NewClient(&ClientConfiguration{URL: "tcp://fake"})
+	if err != nil {
+		t.Fatalf("NewClient() should have succeeded, got: %v", err)
+	}
+
+	mc.dialer = func() (tr transport, err error) {
+		var p1, p2 net.Conn
+
+		p1, p2 = net.Pipe()
+		go ms.handleTransport(newTCPTransport(p1, time.Hour, nil), "10.0.0.1:5000", role)
+
+		tr = newTCPTransport(p2, mc.conf.Timeout, nil)
+
+		return
+	}
+
+	err = mc.Open()
+	if err != nil {
+		t.Fatalf("Open() should have succeeded, got: %v", err)
+	}
+
+	return
+}
+
+func TestLoadAuthorizationPolicy(t *testing.T) {
+	var ap *AuthorizationPolicy
+	var err error
+
+	ap, err = ParseAuthorizationPolicy([]byte(`{"roles": {
+		"operator": [
+			{"read": true},
+			{"write": true, "unitIds": [1], "ranges": [{"first": 100, "last": 199}]}
+		],
+		"monitor": [{"read": true, "functionCodes": [3, 4]}]
+	}}`))
+	if err != nil {
+		t.Fatalf("ParseAuthorizationPolicy() should have succeeded, got: %v", err)
+	}
+
+	if len(ap.Roles["operator"]) != 2 || len(ap.Roles["monitor"]) != 1 {
+		t.Fatalf("unexpected roles: %+v", ap.Roles)
+	}
+	if rule := ap.Roles["operator"][1]; !rule.Write || rule.Read ||
+		len(rule.UnitIds) != 1 || rule.UnitIds[0] != 1 ||
+		len(rule.Ranges) != 1 || rule.Ranges[0] != (AddressRange{100, 199}) {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+	if rule := ap.Roles["monitor"][0]; len(rule.FunctionCodes) != 2 || rule.FunctionCodes[1] != 4 {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+
+	// YAML documents should yield the same policy
+	ap, err = ParseAuthorizationPolicyYAML([]byte(`
+roles:
+  operator:
+    - read: true
+    - write: true
+      unitIds: [1]
+      ranges: [{first: 100, last: 199}]
+  monitor:
+    - {read: true, functionCodes: [3, 4]}
+`))
+	if err != nil {
+		t.Fatalf("ParseAuthorizationPolicyYAML() should have succeeded, got: %v", err)
+	}
+	if rule := ap.Roles["operator"][1]; len(ap.Roles["operator"]) != 2 ||
+		!rule.Write || rule.Read || len(rule.UnitIds) != 1 || rule.UnitIds[0] != 1 ||
+		len(rule.Ranges) != 1 || rule.Ranges[0] != (AddressRange{100, 199}) {
+		t.Errorf("unexpected rules: %+v", ap.Roles["operator"])
+	}
+	if rule := ap.Roles["monitor"][0]; len(rule.FunctionCodes) != 2 || rule.FunctionCodes[1] != 4 {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+
+	for _, doc := range []string{
+		"roles:\n  operator:\n    - {read: true, unitId: [1]}\n",
+		"roles:\n  operator:\n    - {write: true, ranges: [{first: 10, last: 9}]}\n",
+		"roles:\n  operator:\n    - {read: true, unitIds: [256]}\n",
+		"roles: [",
+	} {
+		ap, err = LoadAuthorizationPolicyYAML(strings.NewReader(doc))
+		if !errors.Is(err, ErrConfigurationError) || ap != nil {
+			t.Errorf("expected ErrConfigurationError for %s, got: %v", doc, err)
+		}
+	}
+
+	for _, doc := range []string{
+		`{"roles": {"operator": [{"read": true, "unitId": [1]}]}}`,
+		`{"roles": {"operator": [{"write": true, "ranges": [{"first": 10, "last": 9}]}]}}`,
+		`{"roles": {"operator": [{"read": true, "unitIds": [256]}]}}`,
+		`{"roles": `,
+	} {
+		ap, err = LoadAuthorizationPolicy(strings.NewReader(doc))
+		if !errors.Is(err, ErrConfigurationError) || ap != nil {
+			t.Errorf("expected ErrConfigurationError for %s, got: %v", doc, err)
+		}
+	}
+
+	return
+}
+
+func TestServerAuthorization(t *testing.T) {
+	var ds *DataStore
+	var ap *AuthorizationPolicy
+	var client *ModbusClient
+	var buf bytes.Buffer
+	var err error
+	var regs []uint16
+
+	ds, _ = NewDataStore(&DataStoreConfiguration{
+		Coils:            10,
+		HoldingRegisters: 300,
+	})
+
+	ap = &AuthorizationPolicy{Roles: map[string][]AuthorizationRule{
+		"operator": {
+			{Read: true},
+			{Write: true, UnitIds: []uint8{1}, Ranges: []AddressRange{{100, 199}}},
+		},
+		"monitor": {
+			{Read: true, FunctionCodes: []uint8{fcReadHoldingRegisters}},
+		},
+	}}
+
+	// invalid policies should be rejected by NewServer
+	_, err = NewServer(&ServerConfiguration{
+		URL: "tcp://fake",
+		AuthorizationPolicy: &AuthorizationPolicy{Roles: map[string][]AuthorizationRule{
+			"operator": {{Ranges: []AddressRange{{2, 1}}}},
+		}},
+	}, ds)
+	if !errors.Is(err, ErrConfigurationError) {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	client = newAuthzTestClient(t, ap, "operator", ds, log.New(&buf, "", 0))
+	defer client.Close()
+	client.SetUnitId(1)
+
+	// writes should be allowed within the range of the rule only
+	err = client.WriteRegisters(100, []uint16{1, 2})
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+	err = client.WriteRegisters(199, []uint16{1, 2})
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	err = client.WriteCoil(0, true)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	err = client.Unit(2).WriteRegister(150, 1)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	// denied requests should never reach the handler
+	regs, err = client.ReadRegisters(199, 2, HOLDING_REGISTER)
+	if err != nil || regs[0] != 0 || regs[1] != 0 {
+		t.Errorf("expected [0 0], got: %v, %v", regs, err)
+	}
+
+	// denials should be logged with the client identity
+	if !strings.Contains(buf.String(), "access denied to client '10.0.0.1:5000' (role: 'operator')") {
+		t.Errorf("unexpected log output: %s", buf.String())
+	}
+
+	// read/write requests need both read and write access
+	regs, err = client.ReadWriteRegisters(0, 1, 150, []uint16{0x1234})
+	if err != nil || len(regs) != 1 {
+		t.Errorf("ReadWriteRegisters() should have succeeded, got: %v, %v", regs, err)
+	}
+
+	// other roles should only get what their rules allow
+	client = newAuthzTestClient(t, ap, "monitor", ds, log.New(&buf, "", 0))
+	defer client.Close()
+
+	regs, err = client.ReadRegisters(150, 1, HOLDING_REGISTER)
+	if err != nil || regs[0] != 0x1234 {
+		t.Errorf("expected [0x1234], got: %v, %v", regs, err)
+	}
+	_, err = client.ReadCoils(0, 1)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	_, err = client.ReadWriteRegisters(0, 1, 150, []uint16{0x5678})
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	_, _, err = client.GetCommEventCounter()
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	// as should clients without a role (no rules: deny all)
+	client = newAuthzTestClient(t, ap, "", ds, log.New(&buf, "", 0))
+	defer client.Close()
+
+	_, err = client.ReadRegisters(150, 1, HOLDING_REGISTER)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	// a denied read must not leave the write of a read/write request behind
+	client = newAuthzTestClient(t, &AuthorizationPolicy{Roles: map[string][]AuthorizationRule{
+		"writer": {{Write: true}},
+	}}, "writer", ds, log.New(&buf, "", 0))
+	defer client.Close()
+
+	_, err = client.ReadWriteRegisters(0, 1, 150, []uint16{0x5678})
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	if reg, _ := ds.Unit(1).GetRegister(150, HOLDING_REGISTER); reg != 0x1234 {
+		t.Errorf("expected 0x1234, got: 0x%04x", reg)
+	}
+
+	return
+}
+
+func TestServerAuthorizationWithoutRanges(t *testing.T) {
+	var ap *AuthorizationPolicy
+	var client *ModbusClient
+	var buf bytes.Buffer
+	var err error
+	var res *RawResponse
+
+	ap = &AuthorizationPolicy{Roles: map[string][]AuthorizationRule{
+		"reader": {{Read: true, Ranges: []AddressRange{{100, 199}}}},
+		"fifo":   {{Read: true, Ranges: []AddressRange{{0x04de, 0x04de}}}},
+		"ro":     {{Read: true}},
+		"vendor": {{Write: true, FunctionCodes: []uint8{0x41}}},
+		"admin":  {{Read: true, Write: true}},
+	}}
+
+	// FIFO reads should be matched against ranges at their pointer address
+	for _, tc := range []struct {
+		role string
+		err  error
+	}{
+		{"reader", ErrIllegalFunction},
+		{"fifo", nil},
+		{"admin", nil},
+	} {
+		client = newAuthzTestClient(t, ap, tc.role,
+			&fifoTestHandler{queue: []uint16{1, 2}}, log.New(&buf, "", 0))
+		_, err = client.ReadFIFOQueue(0x04de)
+		if err != tc.err {
+			t.Errorf("role %q: expected %v, got: %v", tc.role, tc.err, err)
+		}
+		client.Close()
+	}
+
+	// requests without an address range should not pass rules with ranges
+	for _, tc := range []struct {
+		role string
+		err  error
+	}{
+		{"reader", ErrIllegalFunction},
+		{"ro", nil},
+	} {
+		client = newAuthzTestClient(t, ap, tc.role, &fileRecordTestHandler{
+			files: map[uint16][]uint16{1: make([]uint16, 10)},
+		}, log.New(&buf, "", 0))
+		_, err = client.ReadFileRecords([]FileRecord{{
+			FileNumber: 1, RecordNumber: 0, RecordLength: 2,
+		}})
+		if err != tc.err {
+			t.Errorf("role %q: expected %v, got: %v", tc.role, tc.err, err)
+		}
+		client.Close()
+	}
+
+	// unknown function codes should be considered writes
+	for _, tc := range []struct {
+		role string
+		err  error
+	}{
+		{"reader", ErrIllegalFunction},
+		{"ro", ErrIllegalFunction},
+		{"vendor", nil},
+		{"admin", nil},
+	} {
+		client = newAuthzTestClient(t, ap, tc.role, &fallbackTestHandler{},
+			log.New(&buf, "", 0))
+		res, err = client.ExecuteRawRequest(&RawRequest{
+			UnitId:       1,
+			FunctionCode: 0x41,
+			Payload:      []byte{0x01, 0x02},
+		})
+		if err != tc.err || (err == nil && len(res.Payload) != 2) {
+			t.Errorf("role %q: expected %v, got: %v, %v", tc.role, tc.err, res, err)
+		}
+		client.Close()
+	}
+
+	return
+}
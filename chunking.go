@@ -0,0 +1,218 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+)
+
+// Chunking policy object.
+//
+// With a chunking policy, reads and writes of coils, discrete inputs and
+// registers which exceed the max number of items per request are split
+// into as many requests (chunks) as needed, run one after the other, and
+// their results reassembled. Chunks are split on value boundaries: 32-bit
+// and 64-bit values never straddle two chunks.
+//
+// Chunks are not atomic as a whole: other requests may run in between
+// chunks, and writes failing midway leave the chunks before the failed
+// one written. Requests failing after having been split return a
+// *ChunkError wrapping the error of the failed chunk: use errors.Is()
+// rather than == to test for specific errors.
+type ChunkingPolicy struct {
+	// MaxRegisters sets the max number of registers per request (defaults
+	// to the protocol limits: 125 for reads, 123 for writes)
+	MaxRegisters uint16
+	// MaxBits sets the max number of coils or discrete inputs per request
+	// (defaults to the protocol limits: 2000 for reads, 1968 for writes)
+	MaxBits uint16
+}
+
+// ChunkError is returned by split requests which failed.
+type ChunkError struct {
+	// Chunk holds the index of the failed chunk, from 0
+	Chunk uint
+	// Chunks holds the number of chunks the request was split into
+	Chunks uint
+	// Addr and Quantity hold the range of the failed chunk
+	Addr     uint16
+	Quantity uint16
+	// Err holds the error returned by the failed chunk
+	Err error
+}
+
+func (ce *ChunkError) Error() string {
+	return fmt.Sprintf("%v (chunk %v of %v, addr %v, quantity %v)",
+		ce.Err, ce.Chunk+1, ce.Chunks, ce.Addr, ce.Quantity)
+}
+
+func (ce *ChunkError) Unwrap() error {
+	return ce.Err
+}
+
+// Returns the max number of registers per read (or write) request.
+func (cp *ChunkingPolicy) maxRegisters(write bool) (max uint16) {
+	max = 125
+	if write {
+		max = 123
+	}
+
+	if cp.MaxRegisters != 0 && cp.MaxRegisters < max {
+		max = cp.MaxRegisters
+	}
+
+	return
+}
+
+// Returns the max number of coils or discrete inputs per read (or write)
+// request.
+func (cp *ChunkingPolicy) maxBits(write bool) (max uint16) {
+	max = 2000
+	if write {
+		max = 1968
+	}
+
+	if cp.MaxBits != 0 && cp.MaxBits < max {
+		max = cp.MaxBits
+	}
+
+	return
+}
+
+// Splits quantity items starting at addr into chunks of at most max items,
+// each a multiple of step items, and runs fn over each chunk in turn with
+// its address, quantity and offset from addr.
+func (mc *ModbusClient) runChunks(addr uint16, quantity uint32, step uint16, max uint16,
+	fn func(addr uint16, quantity uint16, offset uint32) error) (err error) {
+	var chunkSize uint32
+	var chunks uint
+	var offset uint32
+	var count uint16
+
+	// never split values across chunks
+	chunkSize = uint32(max - max%step)
+	if chunkSize == 0 {
+		mc.logger.Errorf("values of %v items cannot fit within %v items per request",
+			step, max)
+		err = ErrUnexpectedParameters
+		return
+	}
+
+	if quantity == 0 {
+		mc.logger.Error("quantity is 0")
+		err = ErrUnexpectedParameters
+		return
+	}
+
+	if uint32(addr)+quantity-1 > 0xffff {
+		mc.logger.Error("end address is past 0xffff")
+		err = ErrUnexpectedParameters
+		return
+	}
+
+	chunks = uint((quantity + chunkSize - 1) / chunkSize)
+	for chunk := uint(0); offset < quantity; chunk++ {
+		count = uint16(min(chunkSize, quantity-offset))
+
+		err = fn(addr+uint16(offset), count, offset)
+		if err != nil {
+			if chunks > 1 {
+				err = &ChunkError{
+					Chunk:    chunk,
+					Chunks:   chunks,
+					Addr:     addr + uint16(offset),
+					Quantity: count,
+					Err:      err,
+				}
+			}
+			return
+		}
+
+		offset += uint32(count)
+	}
+
+	return
+}
+
+// Reads quantity coils (or discrete inputs if di is true), splitting the
+// read as per the chunking policy of the client, if any.
+func (mc *ModbusClient) readManyBools(ctx context.Context, addr uint16, quantity uint16, di bool) (values []bool, err error) {
+	if mc.conf.Chunking == nil {
+		return mc.readBools(ctx, addr, quantity, di)
+	}
+
+	err = mc.runChunks(addr, uint32(quantity), 1, mc.conf.Chunking.maxBits(false),
+		func(addr uint16, quantity uint16, _ uint32) (err error) {
+			var chunk []bool
+
+			chunk, err = mc.readBools(ctx, addr, quantity, di)
+			values = append(values, chunk...)
+
+			return
+		})
+	if err != nil {
+		values = []bool{}
+	}
+
+	return
+}
+
+// Writes coils, splitting the write as per the chunking policy of the
+// client, if any.
+func (mc *ModbusClient) writeManyCoils(ctx context.Context, addr uint16, values []bool) (err error) {
+	if mc.conf.Chunking == nil {
+		return mc.writeCoils(ctx, addr, values)
+	}
+
+	err = mc.runChunks(addr, uint32(len(values)), 1, mc.conf.Chunking.maxBits(true),
+		func(addr uint16, quantity uint16, offset uint32) error {
+			return mc.writeCoils(ctx, addr, values[offset:offset+uint32(quantity)])
+		})
+
+	return
+}
+
+// Reads quantity values of valueSize registers each, as bytes, splitting
+// the read as per the chunking policy of the client, if any.
+func (mc *ModbusClient) readManyRegisters(ctx context.Context, addr uint16, quantity uint16,
+	valueSize uint16, regType RegType) (values []byte, err error) {
+	var regCount = uint32(quantity) * uint32(valueSize)
+
+	if regCount > 0xffff {
+		mc.logger.Error("quantity of registers exceeds 65535")
+		return []byte{}, ErrUnexpectedParameters
+	}
+
+	if mc.conf.Chunking == nil {
+		return mc.readRegisters(ctx, addr, uint16(regCount), regType)
+	}
+
+	err = mc.runChunks(addr, regCount, valueSize, mc.conf.Chunking.maxRegisters(false),
+		func(addr uint16, quantity uint16, _ uint32) (err error) {
+			var chunk []byte
+
+			chunk, err = mc.readRegisters(ctx, addr, quantity, regType)
+			values = append(values, chunk...)
+
+			return
+		})
+	if err != nil {
+		values = []byte{}
+	}
+
+	return
+}
+
+// Writes values of valueSize registers each, passed as bytes, splitting
+// the write as per the chunking policy of the client, if any.
+func (mc *ModbusClient) writeManyRegisters(ctx context.Context, addr uint16, values []byte, valueSize uint16) (err error) {
+	if mc.conf.Chunking == nil {
+		return mc.writeRegisters(ctx, addr, values)
+	}
+
+	err = mc.runChunks(addr, uint32(len(values)/2), valueSize, mc.conf.Chunking.maxRegisters(true),
+		func(addr uint16, quantity uint16, offset uint32) error {
+			return mc.writeRegisters(ctx, addr, values[2*offset:2*(offset+uint32(quantity))])
+		})
+
+	return
+}
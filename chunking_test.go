@@ -0,0 +1,202 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// chunkTestHandler serves 1000 coils and holding registers, failing
+// accesses covering the bad address.
+type chunkTestHandler struct {
+	coils   [1000]bool
+	holding [1000]uint16
+	bad     uint16
+}
+
+func (ch *chunkTestHandler) covers(addr uint16, quantity uint16) bool {
+	return ch.bad != 0 && addr <= ch.bad && ch.bad < addr+quantity
+}
+
+func (ch *chunkTestHandler) HandleCoils(req *CoilsRequest) (res []bool, err error) {
+	if int(req.Addr)+int(req.Quantity) > len(ch.coils) || ch.covers(req.Addr, req.Quantity) {
+		err = ErrIllegalDataAddress
+		return
+	}
+
+	if req.IsWrite {
+		copy(ch.coils[req.Addr:], req.Args)
+	}
+	res = ch.coils[req.Addr : req.Addr+req.Quantity]
+
+	return
+}
+
+func (ch *chunkTestHandler) HandleDiscreteInputs(req *DiscreteInputsRequest) (res []bool, err error) {
+	err = ErrIllegalFunction
+
+	return
+}
+
+func (ch *chunkTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	if int(req.Addr)+int(req.Quantity) > len(ch.holding) || ch.covers(req.Addr, req.Quantity) {
+		err = ErrIllegalDataAddress
+		return
+	}
+
+	if req.IsWrite {
+		copy(ch.holding[req.Addr:], req.Args)
+	}
+	res = ch.holding[req.Addr : req.Addr+req.Quantity]
+
+	return
+}
+
+func (ch *chunkTestHandler) HandleInputRegisters(req *InputRegistersRequest) (res []uint16, err error) {
+	err = ErrIllegalFunction
+
+	return
+}
+
+func TestClientChunking(t *testing.T) {
+	var client *ModbusClient
+	var handler *chunkTestHandler
+	var err error
+	var chunkErr *ChunkError
+	var ranges [][2]uint16
+	var regs []uint16
+	var u64s []uint64
+	var coils []bool
+	var values []uint16
+
+	handler = &chunkTestHandler{}
+	client, err = NewFakeClient(handler)
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	// without a chunking policy, protocol limits apply
+	_, err = client.ReadRegisters(0, 600, HOLDING_REGISTER)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// record the range of each request
+	client.conf.Chunking = &ChunkingPolicy{}
+	client.conf.Interceptors = []Interceptor{
+		func(ctx context.Context, call *Call, next Invoker) error {
+			addr, quantity, ok := call.ReadRange()
+			if !ok {
+				addr, quantity, _ = call.WriteRange()
+			}
+			ranges = append(ranges, [2]uint16{addr, quantity})
+
+			return next(ctx, call)
+		},
+	}
+
+	values = make([]uint16, 600)
+	for i := range values {
+		values[i] = uint16(i)
+	}
+
+	// writes should be split at 123 registers...
+	err = client.WriteRegisters(10, values)
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+	if len(ranges) != 5 || ranges[0] != [2]uint16{10, 123} ||
+		ranges[4] != [2]uint16{502, 108} {
+		t.Errorf("unexpected chunks: %v", ranges)
+	}
+
+	// ... and reads at 125
+	ranges = nil
+	regs, err = client.ReadRegisters(10, 600, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(ranges) != 5 || ranges[0] != [2]uint16{10, 125} ||
+		ranges[4] != [2]uint16{510, 100} {
+		t.Errorf("unexpected chunks: %v", ranges)
+	}
+	if len(regs) != 600 {
+		t.Fatalf("expected 600 registers, got %v", len(regs))
+	}
+	for i := range regs {
+		if regs[i] != uint16(i) {
+			t.Fatalf("expected %v at index %v, got %v", i, i, regs[i])
+		}
+	}
+
+	// 64-bit values should never be split across chunks
+	client.conf.Chunking = &ChunkingPolicy{MaxRegisters: 10}
+	client.SetEncoding(BIG_ENDIAN, HIGH_WORD_FIRST)
+	ranges = nil
+	u64s, err = client.ReadUint64s(10, 5, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadUint64s() should have succeeded, got: %v", err)
+	}
+	if len(ranges) != 3 || ranges[0] != [2]uint16{10, 8} ||
+		ranges[1] != [2]uint16{18, 8} || ranges[2] != [2]uint16{26, 4} {
+		t.Errorf("unexpected chunks: %v", ranges)
+	}
+	if len(u64s) != 5 || u64s[4] != 0x0010001100120013 {
+		t.Errorf("unexpected values: %x", u64s)
+	}
+
+	// values larger than the max per request cannot be read
+	client.conf.Chunking = &ChunkingPolicy{MaxRegisters: 3}
+	_, err = client.ReadUint64s(10, 5, HOLDING_REGISTER)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// coils should be split as well
+	client.conf.Chunking = &ChunkingPolicy{MaxBits: 300}
+	ranges = nil
+	err = client.WriteCoils(100, make([]bool, 700))
+	if err != nil {
+		t.Errorf("client.WriteCoils() should have succeeded, got: %v", err)
+	}
+	if len(ranges) != 3 || ranges[2] != [2]uint16{700, 100} {
+		t.Errorf("unexpected chunks: %v", ranges)
+	}
+
+	handler.coils[799] = true
+	coils, err = client.ReadCoils(0, 800)
+	if err != nil {
+		t.Errorf("client.ReadCoils() should have succeeded, got: %v", err)
+	}
+	if len(coils) != 800 || !coils[799] || coils[798] {
+		t.Errorf("unexpected coil values")
+	}
+
+	// failures should report the failed chunk
+	handler.bad = 650
+	_, err = client.ReadCoils(0, 800)
+	if !errors.As(err, &chunkErr) {
+		t.Fatalf("expected a *ChunkError, got: %v", err)
+	}
+	if chunkErr.Chunk != 2 || chunkErr.Chunks != 3 ||
+		chunkErr.Addr != 600 || chunkErr.Quantity != 200 {
+		t.Errorf("unexpected chunk error: %+v", chunkErr)
+	}
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// requests fitting within a single chunk should fail as usual
+	_, err = client.ReadCoils(640, 20)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	_, err = client.ReadCoils(0xff00, 0x200)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	return
+}
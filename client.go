@@ -1,6 +1,8 @@
 package modbus
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -8,13 +10,15 @@ import (
 	"net"
 	"os"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type RegType uint
 type Endianness uint
 type WordOrder uint
+type ReadDeviceIdCode uint8
+type DiagSubFunction uint16
 
 const (
 	PARITY_NONE uint = 0
@@ -31,6 +35,41 @@ const (
 	// word order of 32-bit registers
 	HIGH_WORD_FIRST WordOrder = 1
 	LOW_WORD_FIRST  WordOrder = 2
+
+	// device identification categories (read device id codes)
+	READ_DEVICE_ID_BASIC    ReadDeviceIdCode = 0x01 // stream access to basic objects
+	READ_DEVICE_ID_REGULAR  ReadDeviceIdCode = 0x02 // stream access to regular objects
+	READ_DEVICE_ID_EXTENDED ReadDeviceIdCode = 0x03 // stream access to extended objects
+	READ_DEVICE_ID_SPECIFIC ReadDeviceIdCode = 0x04 // access to one specific object
+
+	// device identification object ids
+	// basic objects (mandatory)
+	OBJECT_ID_VENDOR_NAME          uint8 = 0x00
+	OBJECT_ID_PRODUCT_CODE         uint8 = 0x01
+	OBJECT_ID_MAJOR_MINOR_REVISION uint8 = 0x02
+	// regular objects (optional)
+	OBJECT_ID_VENDOR_URL            uint8 = 0x03
+	OBJECT_ID_PRODUCT_NAME          uint8 = 0x04
+	OBJECT_ID_MODEL_NAME            uint8 = 0x05
+	OBJECT_ID_USER_APPLICATION_NAME uint8 = 0x06
+	// objects 0x80 to 0xff are extended, product dependent objects (optional)
+
+	// diagnostics (function code 08) sub-functions
+	DIAG_RETURN_QUERY_DATA            DiagSubFunction = 0x0000
+	DIAG_RESTART_COMMUNICATIONS       DiagSubFunction = 0x0001
+	DIAG_RETURN_DIAGNOSTIC_REGISTER   DiagSubFunction = 0x0002
+	DIAG_CHANGE_ASCII_INPUT_DELIMITER DiagSubFunction = 0x0003
+	DIAG_FORCE_LISTEN_ONLY_MODE       DiagSubFunction = 0x0004
+	DIAG_CLEAR_COUNTERS               DiagSubFunction = 0x000a
+	DIAG_BUS_MESSAGE_COUNT            DiagSubFunction = 0x000b
+	DIAG_BUS_COMM_ERROR_COUNT         DiagSubFunction = 0x000c
+	DIAG_BUS_EXCEPTION_ERROR_COUNT    DiagSubFunction = 0x000d
+	DIAG_SERVER_MESSAGE_COUNT         DiagSubFunction = 0x000e
+	DIAG_SERVER_NO_RESPONSE_COUNT     DiagSubFunction = 0x000f
+	DIAG_SERVER_NAK_COUNT             DiagSubFunction = 0x0010
+	DIAG_SERVER_BUSY_COUNT            DiagSubFunction = 0x0011
+	DIAG_BUS_CHAR_OVERRUN_COUNT       DiagSubFunction = 0x0012
+	DIAG_CLEAR_OVERRUN_COUNTER        DiagSubFunction = 0x0014
 )
 
 // Modbus client configuration object.
@@ -38,16 +77,20 @@ type ClientConfiguration struct {
 	// URL sets the client mode and target location in the form
 	// <mode>://<serial device or host:port> e.g. tcp://plc:502
 	URL string
-	// Speed sets the serial link speed (in bps, rtu only)
+	// Speed sets the serial link speed (in bps, rtu and ascii only)
 	Speed uint
-	// DataBits sets the number of bits per serial character (rtu only)
+	// DataBits sets the number of bits per serial character (rtu and
+	// ascii only)
 	DataBits uint
-	// Parity sets the serial link parity mode (rtu only)
+	// Parity sets the serial link parity mode (rtu and ascii only)
 	Parity uint
-	// StopBits sets the number of serial stop bits (rtu only)
+	// StopBits sets the number of serial stop bits (rtu and ascii only)
 	StopBits uint
 	// Timeout sets the request timeout value
 	Timeout time.Duration
+	// InterCharTimeout sets the maximum delay between two characters of
+	// the same frame (ascii and asciiovertcp only)
+	InterCharTimeout time.Duration
 	// TLSClientCert sets the client-side TLS key pair (tcp+tls only)
 	TLSClientCert *tls.Certificate
 	// TLSRootCAs sets the list of CA certificates used to authenticate
@@ -57,18 +100,119 @@ type ClientConfiguration struct {
 	// Logger provides a custom sink for log messages.
 	// If nil, messages will be written to stdout.
 	Logger *log.Logger
+	// Reconnect, if set, enables automatic reconnection when the link to
+	// the remote device breaks (tcp, tcp+tls, rtuovertcp and asciiovertcp
+	// only). See ReconnectPolicy.
+	Reconnect *ReconnectPolicy
+	// MaxInFlight sets the max number of requests outstanding at any given
+	// time on the connection (tcp and tcp+tls only). Values above 1 enable
+	// pipelining: concurrent requests are sent without waiting for earlier
+	// ones to complete, provided the remote device supports it.
+	MaxInFlight uint
+	// Retry, if set, enables automatic retries of requests failing with
+	// transient errors. See RetryPolicy.
+	Retry *RetryPolicy
+	// Interceptors, if set, wrap the execution of every request, the
+	// first interceptor being the outermost. See Interceptor.
+	Interceptors []Interceptor
+	// Chunking, if set, enables the splitting of reads and writes
+	// exceeding the max number of items per request. See ChunkingPolicy.
+	Chunking *ChunkingPolicy
+	// OnConnect, if set, is called whenever the client connects or
+	// reconnects to the remote device.
+	OnConnect func()
+	// OnDisconnect, if set, is called whenever the client disconnects
+	// from the remote device, with the cause of the disconnection (nil
+	// when Close() is called). Broken links are only detected (and
+	// reported) if Reconnect is set.
+	// Callbacks are run with the client lock held: they must not call any
+	// client method other than State().
+	OnDisconnect func(cause error)
 }
 
 // Modbus client object.
+//
+// Each request method comes in two flavours: one bounded by the configured
+// Timeout only (e.g. ReadRegisters()) and a context-aware one (e.g.
+// ReadRegistersCtx()). With the latter, the request is abandoned as soon as
+// ctx is done, be it while waiting for another request to complete, for the
+// serial line to go quiet or for the response, and ctx.Err() is returned.
+// Transport i/o deadlines never extend past the deadline of ctx, if any.
+// Abandoned requests leave the transport in a usable state: late responses
+// are discarded before the next request is sent.
+//
+// Client methods are safe for concurrent use. Requests are serialized unless
+// pipelining is enabled (see ClientConfiguration.MaxInFlight), in which case
+// up to MaxInFlight requests are outstanding at once. Note that encoding
+// changes made while pipelined requests are in flight may apply to the
+// decoding of their responses.
+//
+// On network links, broken links (i.e. io.EOF or non-timeout network errors)
+// are left as is unless a reconnect policy is configured: requests keep
+// failing with the underlying i/o error until the client is closed and
+// re-opened. With a reconnect policy, the client closes its transport as
+// soon as the link is found to be broken, after which requests fail with
+// ErrNotConnected until the client reconnects.
 type ModbusClient struct {
+	*clientLink
+	// unit id and encoding of requests issued through this client (or
+	// unit view, see Unit())
+	unitId     uint8
+	endianness Endianness
+	wordOrder  WordOrder
+}
+
+// clientLink holds the configuration and connection state of a client,
+// shared by the client and all its unit views.
+type clientLink struct {
 	conf          ClientConfiguration
 	logger        *logger
-	lock          sync.Mutex
-	endianness    Endianness
-	wordOrder     WordOrder
+	lock          clientLock
 	transport     transport
-	unitId        uint8
 	transportType transportType
+	state         atomic.Uint32
+	// closed to stop the reconnection goroutine, if any
+	reconnectStop chan struct{}
+	// if set, used by dial() in place of the configured transport
+	dialer func() (transport, error)
+}
+
+// clientLock is a mutual exclusion lock, which can also be waited on until
+// a context is done.
+type clientLock chan struct{}
+
+// Locks the lock, waiting for it to be available if necessary.
+func (cl clientLock) Lock() {
+	cl <- struct{}{}
+}
+
+// Locks the lock, waiting for it to be available or for ctx to be done,
+// whichever happens first. Returns ctx.Err() if ctx is done.
+func (cl clientLock) LockCtx(ctx context.Context) (err error) {
+	// never take the lock on behalf of an abandoned request
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	select {
+	case cl <- struct{}{}:
+		// the lock may have been taken after ctx was done, as select
+		// picks a random case if both are ready
+		err = ctx.Err()
+		if err != nil {
+			<-cl
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+// Unlocks the lock.
+func (cl clientLock) Unlock() {
+	<-cl
 }
 
 // NewClient creates, configures and returns a modbus client object.
@@ -77,7 +221,10 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 	var splitURL []string
 
 	mc = &ModbusClient{
-		conf: *conf,
+		clientLink: &clientLink{
+			conf: *conf,
+			lock: make(clientLock, 1),
+		},
 	}
 
 	splitURL = strings.SplitN(mc.conf.URL, "://", 2)
@@ -142,6 +289,50 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 
 		mc.transportType = modbusRTUOverUDP
 
+	case "ascii":
+		if mc.conf.Speed == 0 {
+			mc.conf.Speed = 19200
+		}
+
+		// note: the "modbus over serial line v1.02" document specifies a
+		// 10-bit character frame in ASCII mode, with 7 data bits, even
+		// parity and 1 stop bit as default, and 2 stop bits when no parity
+		// is used.
+		if mc.conf.DataBits == 0 {
+			mc.conf.DataBits = 7
+		}
+
+		if mc.conf.StopBits == 0 {
+			if mc.conf.Parity == PARITY_NONE {
+				mc.conf.StopBits = 2
+			} else {
+				mc.conf.StopBits = 1
+			}
+		}
+
+		// ASCII frames are twice as long as their RTU counterparts
+		if mc.conf.Timeout == 0 {
+			mc.conf.Timeout = 1 * time.Second
+		}
+
+		// the spec allows for up to 1s between characters of a frame
+		if mc.conf.InterCharTimeout == 0 {
+			mc.conf.InterCharTimeout = 1 * time.Second
+		}
+
+		mc.transportType = modbusASCII
+
+	case "asciiovertcp":
+		if mc.conf.Timeout == 0 {
+			mc.conf.Timeout = 1 * time.Second
+		}
+
+		if mc.conf.InterCharTimeout == 0 {
+			mc.conf.InterCharTimeout = 1 * time.Second
+		}
+
+		mc.transportType = modbusASCIIOverTCP
+
 	case "tcp":
 		if mc.conf.Timeout == 0 {
 			mc.conf.Timeout = 1 * time.Second
@@ -190,6 +381,33 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 		return
 	}
 
+	if mc.conf.Reconnect != nil {
+		// work on a copy of the policy, with defaults filled in
+		mc.conf.Reconnect, err = mc.conf.Reconnect.withDefaults()
+		if err != nil {
+			mc.logger.Errorf("invalid reconnect policy: %v", err)
+			err = ErrConfigurationError
+			return
+		}
+	}
+
+	if mc.conf.MaxInFlight > 1 &&
+		mc.transportType != modbusTCP && mc.transportType != modbusTCPOverTLS {
+		mc.logger.Errorf("pipelining is only supported by tcp and tcp+tls clients")
+		err = ErrConfigurationError
+		return
+	}
+
+	if mc.conf.Retry != nil {
+		// work on a copy of the policy, with defaults filled in
+		mc.conf.Retry, err = mc.conf.Retry.withDefaults()
+		if err != nil {
+			mc.logger.Errorf("invalid retry policy: %v", err)
+			err = ErrConfigurationError
+			return
+		}
+	}
+
 	mc.unitId = 1
 	mc.endianness = BIG_ENDIAN
 	mc.wordOrder = HIGH_WORD_FIRST
@@ -198,13 +416,61 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 }
 
 // Opens the underlying transport (network socket or serial line).
+// OnConnect is called once the transport is open.
 func (mc *ModbusClient) Open() (err error) {
-	var spw *serialPortWrapper
-	var sock net.Conn
+	var t transport
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// stop reconnecting in the background, if applicable
+	mc.stopReconnecting()
+
+	t, err = mc.dial()
+	if err != nil {
+		return
+	}
+
+	mc.connected(t)
+
+	return
+}
 
+// Closes the underlying transport.
+// OnDisconnect is called with a nil error if the transport was open.
+func (mc *ModbusClient) Close() (err error) {
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
+	mc.stopReconnecting()
+
+	if mc.transport != nil {
+		err = mc.transport.Close()
+		mc.disconnected(nil, STATE_CLOSED)
+	}
+
+	return
+}
+
+// Returns the state of the connection to the remote device.
+// Unlike other methods, State() never waits for requests in flight and
+// may be called from within OnConnect and OnDisconnect callbacks.
+func (mc *ModbusClient) State() ClientState {
+	return ClientState(mc.state.Load())
+}
+
+// Opens a new transport (network socket or serial line) as configured.
+// Does not touch the state of the client, which allows for running without
+// holding the client lock.
+func (mc *ModbusClient) dial() (t transport, err error) {
+	var spw *serialPortWrapper
+	var sock net.Conn
+
+	if mc.dialer != nil {
+		t, err = mc.dialer()
+		return
+	}
+
 	switch mc.transportType {
 	case modbusRTU:
 		// create a serial port wrapper object
@@ -226,7 +492,7 @@ func (mc *ModbusClient) Open() (err error) {
 		discard(spw)
 
 		// create the RTU transport
-		mc.transport = newRTUTransport(
+		t = newRTUTransport(
 			spw, mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.conf.Logger)
 
 	case modbusRTUOverTCP:
@@ -240,7 +506,7 @@ func (mc *ModbusClient) Open() (err error) {
 		discard(sock)
 
 		// create the RTU transport
-		mc.transport = newRTUTransport(
+		t = newRTUTransport(
 			sock, mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.conf.Logger)
 
 	case modbusRTUOverUDP:
@@ -254,10 +520,46 @@ func (mc *ModbusClient) Open() (err error) {
 		// create the RTU transport, wrapping the UDP socket in
 		// an adapter to allow the transport to read the stream of
 		// packets byte per byte
-		mc.transport = newRTUTransport(
+		t = newRTUTransport(
 			newUDPSockWrapper(sock),
 			mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.conf.Logger)
 
+	case modbusASCII:
+		// create a serial port wrapper object
+		spw = newSerialPortWrapper(&serialPortConfig{
+			Device:   mc.conf.URL,
+			Speed:    mc.conf.Speed,
+			DataBits: mc.conf.DataBits,
+			Parity:   mc.conf.Parity,
+			StopBits: mc.conf.StopBits,
+		})
+
+		// open the serial device
+		err = spw.Open()
+		if err != nil {
+			return
+		}
+
+		// discard potentially stale serial data
+		discard(spw)
+
+		// create the ASCII transport
+		t = newASCIITransport(
+			spw, mc.conf.URL, mc.conf.Timeout, mc.conf.InterCharTimeout,
+			mc.conf.Logger)
+
+	case modbusASCIIOverTCP:
+		// connect to the remote host
+		sock, err = net.DialTimeout("tcp", mc.conf.URL, 5*time.Second)
+		if err != nil {
+			return
+		}
+
+		// create the ASCII transport
+		t = newASCIITransport(
+			sock, mc.conf.URL, mc.conf.Timeout, mc.conf.InterCharTimeout,
+			mc.conf.Logger)
+
 	case modbusTCP:
 		// connect to the remote host
 		sock, err = net.DialTimeout("tcp", mc.conf.URL, 5*time.Second)
@@ -266,7 +568,12 @@ func (mc *ModbusClient) Open() (err error) {
 		}
 
 		// create the TCP transport
-		mc.transport = newTCPTransport(sock, mc.conf.Timeout, mc.conf.Logger)
+		if mc.conf.MaxInFlight > 1 {
+			t = newPipelinedTCPTransport(
+				sock, mc.conf.Timeout, mc.conf.MaxInFlight, mc.conf.Logger)
+		} else {
+			t = newTCPTransport(sock, mc.conf.Timeout, mc.conf.Logger)
+		}
 
 	case modbusTCPOverTLS:
 		// connect to the remote host with TLS
@@ -296,8 +603,14 @@ func (mc *ModbusClient) Open() (err error) {
 		// create the TCP transport, wrapping the TLS socket in
 		// an adapter to work around write timeouts corrupting internal
 		// state (see https://pkg.go.dev/crypto/tls#Conn.SetWriteDeadline)
-		mc.transport = newTCPTransport(
-			newTLSSockWrapper(sock), mc.conf.Timeout, mc.conf.Logger)
+		if mc.conf.MaxInFlight > 1 {
+			t = newPipelinedTCPTransport(
+				newTLSSockWrapper(sock), mc.conf.Timeout,
+				mc.conf.MaxInFlight, mc.conf.Logger)
+		} else {
+			t = newTCPTransport(
+				newTLSSockWrapper(sock), mc.conf.Timeout, mc.conf.Logger)
+		}
 
 	case modbusTCPOverUDP:
 		// open a socket to the remote host (note: no actual connection is
@@ -310,7 +623,7 @@ func (mc *ModbusClient) Open() (err error) {
 		// create the TCP transport, wrapping the UDP socket in
 		// an adapter to allow the transport to read the stream of
 		// packets byte per byte
-		mc.transport = newTCPTransport(
+		t = newTCPTransport(
 			newUDPSockWrapper(sock), mc.conf.Timeout, mc.conf.Logger)
 
 	default:
@@ -321,17 +634,6 @@ func (mc *ModbusClient) Open() (err error) {
 	return
 }
 
-// Closes the underlying transport.
-func (mc *ModbusClient) Close() error {
-	mc.lock.Lock()
-	defer mc.lock.Unlock()
-
-	if mc.transport != nil {
-		return mc.transport.Close()
-	}
-	return nil
-}
-
 // Sets the unit id of subsequent requests.
 func (mc *ModbusClient) SetUnitId(id uint8) error {
 	mc.lock.Lock()
@@ -341,6 +643,28 @@ func (mc *ModbusClient) SetUnitId(id uint8) error {
 	return nil
 }
 
+// Returns a view of the client addressing unit id, with the encoding of
+// the client at the time of the call.
+//
+// Views share the connection and lock of the client but have their own
+// unit id and encoding: SetUnitId() and SetEncoding() on a view only apply
+// to that view, making views suitable for goroutines sharing a connection
+// to poll different devices (e.g. behind a gateway).
+// Open() and Close() apply to the shared connection.
+func (mc *ModbusClient) Unit(id uint8) (view *ModbusClient) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	view = &ModbusClient{
+		clientLink: mc.clientLink,
+		unitId:     id,
+		endianness: mc.endianness,
+		wordOrder:  mc.wordOrder,
+	}
+
+	return
+}
+
 // Sets the encoding (endianness and word ordering) of subsequent requests.
 func (mc *ModbusClient) SetEncoding(endianness Endianness, wordOrder WordOrder) error {
 	mc.lock.Lock()
@@ -361,14 +685,26 @@ func (mc *ModbusClient) SetEncoding(endianness Endianness, wordOrder WordOrder)
 
 // Reads multiple coils (function code 01).
 func (mc *ModbusClient) ReadCoils(addr uint16, quantity uint16) ([]bool, error) {
-	return mc.readBools(addr, quantity, false)
+	return mc.ReadCoilsCtx(context.Background(), addr, quantity)
+}
+
+// ReadCoilsCtx is like ReadCoils, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadCoilsCtx(ctx context.Context, addr uint16, quantity uint16) ([]bool, error) {
+	return mc.readManyBools(ctx, addr, quantity, false)
 }
 
 // Reads a single coil (function code 01).
 func (mc *ModbusClient) ReadCoil(addr uint16) (value bool, err error) {
+	value, err = mc.ReadCoilCtx(context.Background(), addr)
+
+	return
+}
+
+// ReadCoilCtx is like ReadCoil, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadCoilCtx(ctx context.Context, addr uint16) (value bool, err error) {
 	var values []bool
 
-	values, err = mc.readBools(addr, 1, false)
+	values, err = mc.readBools(ctx, addr, 1, false)
 	if err == nil {
 		value = values[0]
 	}
@@ -378,14 +714,26 @@ func (mc *ModbusClient) ReadCoil(addr uint16) (value bool, err error) {
 
 // Reads multiple discrete inputs (function code 02).
 func (mc *ModbusClient) ReadDiscreteInputs(addr uint16, quantity uint16) ([]bool, error) {
-	return mc.readBools(addr, quantity, true)
+	return mc.ReadDiscreteInputsCtx(context.Background(), addr, quantity)
+}
+
+// ReadDiscreteInputsCtx is like ReadDiscreteInputs, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadDiscreteInputsCtx(ctx context.Context, addr uint16, quantity uint16) ([]bool, error) {
+	return mc.readManyBools(ctx, addr, quantity, true)
 }
 
 // Reads a single discrete input (function code 02).
 func (mc *ModbusClient) ReadDiscreteInput(addr uint16) (value bool, err error) {
+	value, err = mc.ReadDiscreteInputCtx(context.Background(), addr)
+
+	return
+}
+
+// ReadDiscreteInputCtx is like ReadDiscreteInput, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadDiscreteInputCtx(ctx context.Context, addr uint16) (value bool, err error) {
 	var values []bool
 
-	values, err = mc.readBools(addr, 1, true)
+	values, err = mc.readBools(ctx, addr, 1, true)
 	if err == nil {
 		value = values[0]
 	}
@@ -394,8 +742,13 @@ func (mc *ModbusClient) ReadDiscreteInput(addr uint16) (value bool, err error) {
 
 // Reads multiple 16-bit registers (function code 03 or 04).
 func (mc *ModbusClient) ReadRegisters(addr uint16, quantity uint16, regType RegType) ([]uint16, error) {
+	return mc.ReadRegistersCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadRegistersCtx is like ReadRegisters, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadRegistersCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]uint16, error) {
 	// read quantity uint16 registers, as bytes
-	mbPayload, err := mc.readRegisters(addr, quantity, regType)
+	mbPayload, err := mc.readManyRegisters(ctx, addr, quantity, 1, regType)
 	if err != nil {
 		return []uint16{}, err
 	}
@@ -407,8 +760,13 @@ func (mc *ModbusClient) ReadRegisters(addr uint16, quantity uint16, regType RegT
 
 // Reads a single 16-bit register (function code 03 or 04).
 func (mc *ModbusClient) ReadRegister(addr uint16, regType RegType) (uint16, error) {
+	return mc.ReadRegisterCtx(context.Background(), addr, regType)
+}
+
+// ReadRegisterCtx is like ReadRegister, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadRegisterCtx(ctx context.Context, addr uint16, regType RegType) (uint16, error) {
 	// read 1 uint16 register, as bytes
-	values, err := mc.ReadRegisters(addr, 1, regType)
+	values, err := mc.ReadRegistersCtx(ctx, addr, 1, regType)
 	if err != nil {
 		return 0, err
 	}
@@ -417,8 +775,13 @@ func (mc *ModbusClient) ReadRegister(addr uint16, regType RegType) (uint16, erro
 
 // Reads multiple 32-bit registers.
 func (mc *ModbusClient) ReadUint32s(addr uint16, quantity uint16, regType RegType) ([]uint32, error) {
+	return mc.ReadUint32sCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadUint32sCtx is like ReadUint32s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadUint32sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]uint32, error) {
 	// read 2 * quantity uint16 registers, as bytes
-	mbPayload, err := mc.readRegisters(addr, quantity*2, regType)
+	mbPayload, err := mc.readManyRegisters(ctx, addr, quantity, 2, regType)
 	if err != nil {
 		return []uint32{}, err
 	}
@@ -429,7 +792,12 @@ func (mc *ModbusClient) ReadUint32s(addr uint16, quantity uint16, regType RegTyp
 
 // Reads a single 32-bit register.
 func (mc *ModbusClient) ReadUint32(addr uint16, regType RegType) (uint32, error) {
-	values, err := mc.ReadUint32s(addr, 1, regType)
+	return mc.ReadUint32Ctx(context.Background(), addr, regType)
+}
+
+// ReadUint32Ctx is like ReadUint32, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadUint32Ctx(ctx context.Context, addr uint16, regType RegType) (uint32, error) {
+	values, err := mc.ReadUint32sCtx(ctx, addr, 1, regType)
 	if err != nil {
 		return 0, err
 	}
@@ -438,8 +806,13 @@ func (mc *ModbusClient) ReadUint32(addr uint16, regType RegType) (uint32, error)
 
 // Reads multiple 32-bit float registers.
 func (mc *ModbusClient) ReadFloat32s(addr uint16, quantity uint16, regType RegType) ([]float32, error) {
+	return mc.ReadFloat32sCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadFloat32sCtx is like ReadFloat32s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadFloat32sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]float32, error) {
 	// read 2 * quantity uint16 registers, as bytes
-	mbPayload, err := mc.readRegisters(addr, quantity*2, regType)
+	mbPayload, err := mc.readManyRegisters(ctx, addr, quantity, 2, regType)
 	if err != nil {
 		return []float32{}, err
 	}
@@ -450,7 +823,12 @@ func (mc *ModbusClient) ReadFloat32s(addr uint16, quantity uint16, regType RegTy
 
 // Reads a single 32-bit float register.
 func (mc *ModbusClient) ReadFloat32(addr uint16, regType RegType) (float32, error) {
-	values, err := mc.ReadFloat32s(addr, 1, regType)
+	return mc.ReadFloat32Ctx(context.Background(), addr, regType)
+}
+
+// ReadFloat32Ctx is like ReadFloat32, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadFloat32Ctx(ctx context.Context, addr uint16, regType RegType) (float32, error) {
+	values, err := mc.ReadFloat32sCtx(ctx, addr, 1, regType)
 	if err != nil {
 		return 0, err
 	}
@@ -459,8 +837,13 @@ func (mc *ModbusClient) ReadFloat32(addr uint16, regType RegType) (float32, erro
 
 // Reads multiple 64-bit registers.
 func (mc *ModbusClient) ReadUint64s(addr uint16, quantity uint16, regType RegType) ([]uint64, error) {
+	return mc.ReadUint64sCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadUint64sCtx is like ReadUint64s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadUint64sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]uint64, error) {
 	// read 4 * quantity uint16 registers, as bytes
-	mbPayload, err := mc.readRegisters(addr, quantity*4, regType)
+	mbPayload, err := mc.readManyRegisters(ctx, addr, quantity, 4, regType)
 	if err != nil {
 		return []uint64{}, err
 	}
@@ -471,7 +854,12 @@ func (mc *ModbusClient) ReadUint64s(addr uint16, quantity uint16, regType RegTyp
 
 // Reads a single 64-bit register.
 func (mc *ModbusClient) ReadUint64(addr uint16, regType RegType) (uint64, error) {
-	values, err := mc.ReadUint64s(addr, 1, regType)
+	return mc.ReadUint64Ctx(context.Background(), addr, regType)
+}
+
+// ReadUint64Ctx is like ReadUint64, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadUint64Ctx(ctx context.Context, addr uint16, regType RegType) (uint64, error) {
+	values, err := mc.ReadUint64sCtx(ctx, addr, 1, regType)
 	if err != nil {
 		return 0, err
 	}
@@ -480,8 +868,13 @@ func (mc *ModbusClient) ReadUint64(addr uint16, regType RegType) (uint64, error)
 
 // Reads multiple 64-bit float registers.
 func (mc *ModbusClient) ReadFloat64s(addr uint16, quantity uint16, regType RegType) ([]float64, error) {
+	return mc.ReadFloat64sCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadFloat64sCtx is like ReadFloat64s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadFloat64sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]float64, error) {
 	// read 4 * quantity uint16 registers, as bytes
-	mbPayload, err := mc.readRegisters(addr, quantity*4, regType)
+	mbPayload, err := mc.readManyRegisters(ctx, addr, quantity, 4, regType)
 	if err != nil {
 		return []float64{}, err
 	}
@@ -492,7 +885,12 @@ func (mc *ModbusClient) ReadFloat64s(addr uint16, quantity uint16, regType RegTy
 
 // Reads a single 64-bit float register.
 func (mc *ModbusClient) ReadFloat64(addr uint16, regType RegType) (float64, error) {
-	values, err := mc.ReadFloat64s(addr, 1, regType)
+	return mc.ReadFloat64Ctx(context.Background(), addr, regType)
+}
+
+// ReadFloat64Ctx is like ReadFloat64, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadFloat64Ctx(ctx context.Context, addr uint16, regType RegType) (float64, error) {
+	values, err := mc.ReadFloat64sCtx(ctx, addr, 1, regType)
 	if err != nil {
 		return 0, err
 	}
@@ -502,114 +900,1040 @@ func (mc *ModbusClient) ReadFloat64(addr uint16, regType RegType) (float64, erro
 // Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
 // A per-register byteswap is performed if endianness is set to LITTLE_ENDIAN.
 func (mc *ModbusClient) ReadBytes(addr uint16, quantity uint16, regType RegType) ([]byte, error) {
-	return mc.readBytes(addr, quantity, regType, true)
+	return mc.ReadBytesCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadBytesCtx is like ReadBytes, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadBytesCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]byte, error) {
+	return mc.readBytes(ctx, addr, quantity, regType, true)
 }
 
 // Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
 // No byte or word reordering is performed: bytes are returned exactly as they come
 // off the wire, allowing the caller to handle encoding/endianness/word order manually.
 func (mc *ModbusClient) ReadRawBytes(addr uint16, quantity uint16, regType RegType) ([]byte, error) {
-	return mc.readBytes(addr, quantity, regType, false)
+	return mc.ReadRawBytesCtx(context.Background(), addr, quantity, regType)
 }
 
-// Writes a single coil (function code 05)
-func (mc *ModbusClient) WriteCoil(addr uint16, value bool) error {
-	var req *pdu
-	var res *pdu
+// ReadRawBytesCtx is like ReadRawBytes, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadRawBytesCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]byte, error) {
+	return mc.readBytes(ctx, addr, quantity, regType, false)
+}
 
-	mc.lock.Lock()
+// Reads the contents of a first-in-first-out queue of 16-bit registers
+// (function code 24). addr is the address of the FIFO pointer register.
+// Up to 31 queued register values are returned, without removing them from
+// the queue.
+func (mc *ModbusClient) ReadFIFOQueue(addr uint16) ([]uint16, error) {
+	return mc.ReadFIFOQueueCtx(context.Background(), addr)
+}
+
+// ReadFIFOQueueCtx is like ReadFIFOQueue, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadFIFOQueueCtx(ctx context.Context, addr uint16) ([]uint16, error) {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return []uint16{}, err
+	}
 	defer mc.lock.Unlock()
 
+	var req *pdu
+	var res *pdu
+	var fifoCount uint16
+
 	// create and fill in the request object
 	req = &pdu{
 		unitId:       mc.unitId,
-		functionCode: fcWriteSingleCoil,
+		functionCode: fcReadFifoQueue,
 	}
 
-	// coil address
+	// FIFO pointer address
 	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
-	// coil value
-	if value {
-		req.payload = append(req.payload, 0xff, 0x00)
-	} else {
-		req.payload = append(req.payload, 0x00, 0x00)
-	}
 
 	// run the request across the transport and wait for a response
-	res, err := mc.executeRequest(req)
+	res, err := mc.executeRequest(ctx, req)
 	if err != nil {
-		return err
+		return []uint16{}, err
 	}
 
+	var values []uint16
 	// validate the response code
 	switch {
 	case res.functionCode == req.functionCode:
-		// expect 4 bytes (2 byte of address + 2 bytes of value)
-		if len(res.payload) != 4 ||
-			// bytes 1-2 should be the coil address
-			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
-			// bytes 3-4 should either be {0xff, 0x00} or {0x00, 0x00}
-			// depending on the coil value
-			(value && res.payload[2] != 0xff) ||
-			res.payload[3] != 0x00 {
-			return ErrProtocolError
+		// expect at least 4 bytes (2 bytes of byte count + 2 bytes of
+		// FIFO count)
+		if len(res.payload) < 4 {
+			return []uint16{}, ErrProtocolError
+		}
+
+		// validate the byte count field (2 bytes of FIFO count +
+		// 2 bytes per register)
+		if int(bytesToUint16(BIG_ENDIAN, res.payload[0:2])) != len(res.payload)-2 {
+			return []uint16{}, ErrProtocolError
+		}
+
+		// validate the FIFO count field
+		fifoCount = bytesToUint16(BIG_ENDIAN, res.payload[2:4])
+		if fifoCount > 31 || len(res.payload) != 4+2*int(fifoCount) {
+			return []uint16{}, ErrProtocolError
 		}
+
+		// decode payload bytes as uint16s
+		values = bytesToUint16s(mc.endianness, res.payload[4:])
+
 	case res.functionCode == (req.functionCode | 0x80):
 		if len(res.payload) != 1 {
-			return ErrProtocolError
+			return []uint16{}, ErrProtocolError
 		}
-		return mapExceptionCodeToError(res.payload[0])
+		return []uint16{}, mapExceptionCodeToError(res.payload[0])
+
 	default:
 		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
-		return ErrProtocolError
+		return []uint16{}, ErrProtocolError
 	}
-	return nil
+	return values, nil
 }
 
-// Writes multiple coils (function code 15)
-func (mc *ModbusClient) WriteCoils(addr uint16, values []bool) error {
-	mc.lock.Lock()
+// File record object, describing a group of consecutive 16-bit registers
+// within a file (function codes 20 and 21).
+type FileRecord struct {
+	FileNumber   uint16   // the file number (1 to 0xffff)
+	RecordNumber uint16   // the starting record number within the file (0 to 9999)
+	RecordLength uint16   // the number of 16-bit registers to read (reads only)
+	Data         []uint16 // the register values to write (writes only)
+}
+
+// Raw request object, passed to ExecuteRawRequest() to issue requests
+// carrying arbitrary (e.g. user-defined or vendor-specific) function codes.
+type RawRequest struct {
+	UnitId       uint8  // the target unit id (slave id)
+	FunctionCode uint8  // the function code, from 0x01 to 0x7f
+	Payload      []byte // the request data, up to 252 bytes
+	// ResponseLength is only used on RTU transports, where the length of a
+	// response frame cannot be known from the frame itself for function
+	// codes the library knows nothing about.
+	// Given the response payload bytes received so far (at least 1), it
+	// should return the total number of payload bytes expected, excluding
+	// unit id, function code and CRC. Returning a length greater than
+	// len(payload) causes more bytes to be read before it is called again.
+	// Exception responses are handled without calling ResponseLength.
+	ResponseLength func(payload []byte) (length int, err error)
+}
+
+// Raw response object, returned by ExecuteRawRequest().
+type RawResponse struct {
+	UnitId       uint8  // the source unit id (slave id)
+	FunctionCode uint8  // the function code (that of the request)
+	Payload      []byte // the response data
+}
+
+// Communication event log, as returned by GetCommEventLog().
+type CommEventLog struct {
+	Status       uint16 // 0xffff if a previous command is still being processed, 0x0000 otherwise
+	EventCount   uint16 // the communication event counter (see GetCommEventCounter())
+	MessageCount uint16 // the number of messages processed since the last restart
+	Events       []byte // up to 64 event bytes, most recent first
+}
+
+// Reads multiple groups of file records in a single request (function code 20).
+// Each record specifies the file number, starting record number and number of
+// 16-bit registers to read (RecordLength). Register values are returned
+// in the same order as the records passed in.
+func (mc *ModbusClient) ReadFileRecords(records []FileRecord) ([][]uint16, error) {
+	return mc.ReadFileRecordsCtx(context.Background(), records)
+}
+
+// ReadFileRecordsCtx is like ReadFileRecords, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadFileRecordsCtx(ctx context.Context, records []FileRecord) ([][]uint16, error) {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return [][]uint16{}, err
+	}
 	defer mc.lock.Unlock()
 
 	var req *pdu
 	var res *pdu
-	var quantity uint16
-	var encodedValues []byte
-
-	quantity = uint16(len(values))
-	if quantity == 0 {
-		mc.logger.Error("quantity of coils is 0")
-		return ErrUnexpectedParameters
-	}
-	if quantity > 0x7b0 {
-		mc.logger.Error("quantity of coils exceeds 1968")
-		return ErrUnexpectedParameters
-	}
+	var responseLength int
 
-	if uint32(addr)+uint32(quantity)-1 > 0xffff {
-		mc.logger.Error("end coil address is past 0xffff")
-		return ErrUnexpectedParameters
+	if len(records) == 0 {
+		mc.logger.Error("no file record to read")
+		return [][]uint16{}, ErrUnexpectedParameters
 	}
 
-	encodedValues = encodeBools(values)
-
 	// create and fill in the request object
 	req = &pdu{
 		unitId:       mc.unitId,
-		functionCode: fcWriteMultipleCoils,
+		functionCode: fcReadFileRecord,
+		// byte count, filled in below
+		payload: []byte{0x00},
 	}
 
-	// start address
-	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
-	// quantity
-	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, quantity)...)
-	// byte count
+	for _, record := range records {
+		if record.FileNumber == 0 {
+			mc.logger.Error("file number is 0")
+			return [][]uint16{}, ErrUnexpectedParameters
+		}
+
+		if record.RecordNumber > 9999 {
+			mc.logger.Error("record number exceeds 9999")
+			return [][]uint16{}, ErrUnexpectedParameters
+		}
+
+		if record.RecordLength == 0 {
+			mc.logger.Error("record length is 0")
+			return [][]uint16{}, ErrUnexpectedParameters
+		}
+
+		// each sub-response carries 1 byte of length, 1 byte of reference
+		// type and 2 bytes per register
+		responseLength += 2 + 2*int(record.RecordLength)
+
+		// reference type (always 6)
+		req.payload = append(req.payload, 0x06)
+		// file number
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, record.FileNumber)...)
+		// record number
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordNumber)...)
+		// record length
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordLength)...)
+	}
+
+	// make sure both the request and the response fit in a PDU
+	if len(req.payload)-1 > 0xf5 || responseLength > 0xf5 {
+		mc.logger.Error("file record request or response exceeds the maximum PDU length")
+		return [][]uint16{}, ErrUnexpectedParameters
+	}
+	req.payload[0] = byte(len(req.payload) - 1)
+
+	// run the request across the transport and wait for a response
+	res, err := mc.executeRequest(ctx, req)
+	if err != nil {
+		return [][]uint16{}, err
+	}
+
+	var values [][]uint16
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// make sure the payload length is what we expect
+		// (1 byte of length + sub-responses)
+		if len(res.payload) != 1+responseLength ||
+			int(res.payload[0]) != responseLength {
+			return [][]uint16{}, ErrProtocolError
+		}
+
+		// decode each sub-response
+		offset := 1
+		for _, record := range records {
+			// validate the file response length and reference type fields
+			if int(res.payload[offset]) != 1+2*int(record.RecordLength) ||
+				res.payload[offset+1] != 0x06 {
+				return [][]uint16{}, ErrProtocolError
+			}
+			offset += 2
+
+			values = append(values, bytesToUint16s(mc.endianness,
+				res.payload[offset:offset+2*int(record.RecordLength)]))
+			offset += 2 * int(record.RecordLength)
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			return [][]uint16{}, ErrProtocolError
+		}
+		return [][]uint16{}, mapExceptionCodeToError(res.payload[0])
+
+	default:
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return [][]uint16{}, ErrProtocolError
+	}
+	return values, nil
+}
+
+// Reads length 16-bit registers from a file, starting at record recordNumber
+// (function code 20).
+func (mc *ModbusClient) ReadFileRecord(fileNumber uint16, recordNumber uint16, length uint16) ([]uint16, error) {
+	return mc.ReadFileRecordCtx(context.Background(), fileNumber, recordNumber, length)
+}
+
+// ReadFileRecordCtx is like ReadFileRecord, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadFileRecordCtx(ctx context.Context, fileNumber uint16, recordNumber uint16, length uint16) ([]uint16, error) {
+	values, err := mc.ReadFileRecordsCtx(ctx, []FileRecord{{
+		FileNumber:   fileNumber,
+		RecordNumber: recordNumber,
+		RecordLength: length,
+	}})
+	if err != nil {
+		return []uint16{}, err
+	}
+	return values[0], nil
+}
+
+// Writes multiple groups of file records in a single request (function code 21).
+// Each record specifies the file number, starting record number and register
+// values to write (Data). RecordLength is ignored.
+func (mc *ModbusClient) WriteFileRecords(records []FileRecord) error {
+	return mc.WriteFileRecordsCtx(context.Background(), records)
+}
+
+// WriteFileRecordsCtx is like WriteFileRecords, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteFileRecordsCtx(ctx context.Context, records []FileRecord) error {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return err
+	}
+	defer mc.lock.Unlock()
+
+	var req *pdu
+	var res *pdu
+
+	if len(records) == 0 {
+		mc.logger.Error("no file record to write")
+		return ErrUnexpectedParameters
+	}
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: fcWriteFileRecord,
+		// request data length, filled in below
+		payload: []byte{0x00},
+	}
+
+	for _, record := range records {
+		if record.FileNumber == 0 {
+			mc.logger.Error("file number is 0")
+			return ErrUnexpectedParameters
+		}
+
+		if record.RecordNumber > 9999 {
+			mc.logger.Error("record number exceeds 9999")
+			return ErrUnexpectedParameters
+		}
+
+		if len(record.Data) == 0 {
+			mc.logger.Error("record data is empty")
+			return ErrUnexpectedParameters
+		}
+
+		if len(record.Data) > 0x7a {
+			mc.logger.Error("record data exceeds the maximum PDU length")
+			return ErrUnexpectedParameters
+		}
+
+		// reference type (always 6)
+		req.payload = append(req.payload, 0x06)
+		// file number
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, record.FileNumber)...)
+		// record number
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordNumber)...)
+		// record length
+		req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, uint16(len(record.Data)))...)
+		// record data
+		for _, value := range record.Data {
+			req.payload = append(req.payload, uint16ToBytes(mc.endianness, value)...)
+		}
+	}
+
+	// make sure the request fits in a PDU
+	if len(req.payload)-1 > 0xfb {
+		mc.logger.Error("file record request exceeds the maximum PDU length")
+		return ErrUnexpectedParameters
+	}
+	req.payload[0] = byte(len(req.payload) - 1)
+
+	// run the request across the transport and wait for a response
+	res, err := mc.executeRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// the response should be an echo of the request
+		if len(res.payload) != len(req.payload) {
+			return ErrProtocolError
+		}
+		for i := range req.payload {
+			if res.payload[i] != req.payload[i] {
+				return ErrProtocolError
+			}
+		}
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			return ErrProtocolError
+		}
+		return mapExceptionCodeToError(res.payload[0])
+	default:
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return ErrProtocolError
+	}
+	return nil
+}
+
+// Writes 16-bit registers to a file, starting at record recordNumber
+// (function code 21).
+func (mc *ModbusClient) WriteFileRecord(fileNumber uint16, recordNumber uint16, values []uint16) error {
+	return mc.WriteFileRecordCtx(context.Background(), fileNumber, recordNumber, values)
+}
+
+// WriteFileRecordCtx is like WriteFileRecord, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteFileRecordCtx(ctx context.Context, fileNumber uint16, recordNumber uint16, values []uint16) error {
+	return mc.WriteFileRecordsCtx(ctx, []FileRecord{{
+		FileNumber:   fileNumber,
+		RecordNumber: recordNumber,
+		Data:         values,
+	}})
+}
+
+// Reads device identification objects (function code 43 / MEI type 14).
+// With stream access categories (READ_DEVICE_ID_BASIC, READ_DEVICE_ID_REGULAR
+// and READ_DEVICE_ID_EXTENDED), all objects of the category are read starting
+// from objectId, following "more follows" continuations across as many
+// requests as necessary. With READ_DEVICE_ID_SPECIFIC, only objectId is read.
+// Objects are returned as a map of object ids to values.
+func (mc *ModbusClient) ReadDeviceIdentification(category ReadDeviceIdCode, objectId uint8) (map[uint8]string, error) {
+	return mc.ReadDeviceIdentificationCtx(context.Background(), category, objectId)
+}
+
+// ReadDeviceIdentificationCtx is like ReadDeviceIdentification, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadDeviceIdentificationCtx(ctx context.Context, category ReadDeviceIdCode, objectId uint8) (map[uint8]string, error) {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return nil, err
+	}
+	defer mc.lock.Unlock()
+
+	var req *pdu
+	var res *pdu
+	var err error
+	var objects map[uint8]string
+	var moreFollows bool
+	var nextObjectId uint8
+
+	if category < READ_DEVICE_ID_BASIC || category > READ_DEVICE_ID_SPECIFIC {
+		mc.logger.Errorf("unexpected read device id code (%v)", category)
+		return nil, ErrUnexpectedParameters
+	}
+
+	objects = make(map[uint8]string)
+
+	// at most 256 objects can be read, guard against servers
+	// looping forever on continuations
+	for round := 0; round < 256; round++ {
+		// create and fill in the request object
+		req = &pdu{
+			unitId:       mc.unitId,
+			functionCode: fcEncapsulatedInterface,
+			payload: []byte{
+				meiReadDeviceIdentification,
+				byte(category),
+				objectId,
+			},
+		}
+
+		// run the request across the transport and wait for a response
+		res, err = mc.executeRequest(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		// validate the response code
+		switch {
+		case res.functionCode == req.functionCode:
+			moreFollows, nextObjectId, err = decodeDeviceIdentification(
+				res.payload, category, objects)
+			if err != nil {
+				return nil, err
+			}
+
+		case res.functionCode == (req.functionCode | 0x80):
+			if len(res.payload) != 1 {
+				return nil, ErrProtocolError
+			}
+			return nil, mapExceptionCodeToError(res.payload[0])
+
+		default:
+			mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+			return nil, ErrProtocolError
+		}
+
+		// individual access never spans multiple requests
+		if !moreFollows || category == READ_DEVICE_ID_SPECIFIC {
+			return objects, nil
+		}
+
+		// continuations should always move forward
+		if nextObjectId <= objectId {
+			mc.logger.Warningf("unexpected next object id (%v)", nextObjectId)
+			return nil, ErrProtocolError
+		}
+		objectId = nextObjectId
+	}
+
+	return nil, ErrProtocolError
+}
+
+// Reads the eight exception status outputs of a serial line device
+// (function code 07). The meaning of each bit is device specific.
+func (mc *ModbusClient) ReadExceptionStatus() (status uint8, err error) {
+	status, err = mc.ReadExceptionStatusCtx(context.Background())
+
+	return
+}
+
+// ReadExceptionStatusCtx is like ReadExceptionStatus, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadExceptionStatusCtx(ctx context.Context) (status uint8, err error) {
+	err = mc.lock.LockCtx(ctx)
+	if err != nil {
+		return
+	}
+	defer mc.lock.Unlock()
+
+	var req *pdu
+	var res *pdu
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: fcReadExceptionStatus,
+	}
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect a single byte of output data
+		if len(res.payload) != 1 {
+			err = ErrProtocolError
+			return
+		}
+		status = res.payload[0]
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocolError
+			return
+		}
+		err = mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err = ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Runs a diagnostics (function code 08) request with the given sub-function
+// and data field, and returns the data field of the response.
+// Note that DIAG_FORCE_LISTEN_ONLY_MODE requests are never answered by
+// compliant devices, hence always yield ErrRequestTimedOut.
+func (mc *ModbusClient) Diagnostics(subFunction DiagSubFunction, data []byte) (res []byte, err error) {
+	res, err = mc.DiagnosticsCtx(context.Background(), subFunction, data)
+
+	return
+}
+
+// DiagnosticsCtx is like Diagnostics, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) DiagnosticsCtx(ctx context.Context, subFunction DiagSubFunction, data []byte) (res []byte, err error) {
+	err = mc.lock.LockCtx(ctx)
+	if err != nil {
+		return
+	}
+	defer mc.lock.Unlock()
+
+	res, err = mc.diagnostics(ctx, subFunction, data)
+
+	return
+}
+
+// Asks the device to echo data back (diagnostics sub-function 0x00), and
+// returns ErrProtocolError if the echoed data does not match.
+func (mc *ModbusClient) ReturnQueryData(data []byte) (err error) {
+	err = mc.ReturnQueryDataCtx(context.Background(), data)
+
+	return
+}
+
+// ReturnQueryDataCtx is like ReturnQueryData, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReturnQueryDataCtx(ctx context.Context, data []byte) (err error) {
+	var res []byte
+
+	res, err = mc.DiagnosticsCtx(ctx, DIAG_RETURN_QUERY_DATA, data)
+	if err != nil {
+		return
+	}
+
+	if !bytes.Equal(res, data) {
+		mc.logger.Warningf("echoed data does not match query data")
+		err = ErrProtocolError
+	}
+
+	return
+}
+
+// Restarts the communication port of the device and brings it out of
+// listen only mode (diagnostics sub-function 0x01). The communication event
+// log is cleared as well if clearEventLog is true.
+func (mc *ModbusClient) RestartCommunications(clearEventLog bool) (err error) {
+	err = mc.RestartCommunicationsCtx(context.Background(), clearEventLog)
+
+	return
+}
+
+// RestartCommunicationsCtx is like RestartCommunications, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) RestartCommunicationsCtx(ctx context.Context, clearEventLog bool) (err error) {
+	var data uint16
+
+	if clearEventLog {
+		data = 0xff00
+	}
+
+	_, err = mc.diagnosticsUint16(ctx, DIAG_RESTART_COMMUNICATIONS, data)
+
+	return
+}
+
+// Reads the 16-bit diagnostic register of the device (diagnostics
+// sub-function 0x02).
+func (mc *ModbusClient) ReadDiagnosticRegister() (value uint16, err error) {
+	value, err = mc.ReadDiagnosticRegisterCtx(context.Background())
+
+	return
+}
+
+// ReadDiagnosticRegisterCtx is like ReadDiagnosticRegister, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadDiagnosticRegisterCtx(ctx context.Context) (value uint16, err error) {
+	value, err = mc.diagnosticsUint16(ctx, DIAG_RETURN_DIAGNOSTIC_REGISTER, 0x0000)
+
+	return
+}
+
+// Clears all counters and the diagnostic register of the device
+// (diagnostics sub-function 0x0a).
+func (mc *ModbusClient) ClearDiagnosticCounters() (err error) {
+	err = mc.ClearDiagnosticCountersCtx(context.Background())
+
+	return
+}
+
+// ClearDiagnosticCountersCtx is like ClearDiagnosticCounters, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ClearDiagnosticCountersCtx(ctx context.Context) (err error) {
+	_, err = mc.diagnosticsUint16(ctx, DIAG_CLEAR_COUNTERS, 0x0000)
+
+	return
+}
+
+// Reads one of the diagnostic counters of the device, from
+// DIAG_BUS_MESSAGE_COUNT (diagnostics sub-function 0x0b) to
+// DIAG_BUS_CHAR_OVERRUN_COUNT (diagnostics sub-function 0x12).
+func (mc *ModbusClient) ReadDiagnosticCounter(counter DiagSubFunction) (value uint16, err error) {
+	value, err = mc.ReadDiagnosticCounterCtx(context.Background(), counter)
+
+	return
+}
+
+// ReadDiagnosticCounterCtx is like ReadDiagnosticCounter, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadDiagnosticCounterCtx(ctx context.Context, counter DiagSubFunction) (value uint16, err error) {
+	if counter < DIAG_BUS_MESSAGE_COUNT || counter > DIAG_BUS_CHAR_OVERRUN_COUNT {
+		mc.logger.Errorf("unexpected diagnostic counter (%v)", counter)
+		err = ErrUnexpectedParameters
+		return
+	}
+
+	value, err = mc.diagnosticsUint16(ctx, counter, 0x0000)
+
+	return
+}
+
+// Clears the character overrun counter and error flag of the device
+// (diagnostics sub-function 0x14).
+func (mc *ModbusClient) ClearOverrunCounter() (err error) {
+	err = mc.ClearOverrunCounterCtx(context.Background())
+
+	return
+}
+
+// ClearOverrunCounterCtx is like ClearOverrunCounter, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ClearOverrunCounterCtx(ctx context.Context) (err error) {
+	_, err = mc.diagnosticsUint16(ctx, DIAG_CLEAR_OVERRUN_COUNTER, 0x0000)
+
+	return
+}
+
+// Reads the status word and communication event counter of a serial line
+// device (function code 0x0b).
+func (mc *ModbusClient) GetCommEventCounter() (status uint16, eventCount uint16, err error) {
+	status, eventCount, err = mc.GetCommEventCounterCtx(context.Background())
+
+	return
+}
+
+// GetCommEventCounterCtx is like GetCommEventCounter, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) GetCommEventCounterCtx(ctx context.Context) (status uint16, eventCount uint16, err error) {
+	err = mc.lock.LockCtx(ctx)
+	if err != nil {
+		return
+	}
+	defer mc.lock.Unlock()
+
+	var req *pdu
+	var res *pdu
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: fcGetCommEventCounter,
+	}
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect 2 bytes of status and 2 bytes of event count
+		if len(res.payload) != 4 {
+			err = ErrProtocolError
+			return
+		}
+		status = bytesToUint16(BIG_ENDIAN, res.payload[0:2])
+		eventCount = bytesToUint16(BIG_ENDIAN, res.payload[2:4])
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocolError
+			return
+		}
+		err = mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err = ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Reads the communication event log of a serial line device
+// (function code 0x0c).
+func (mc *ModbusClient) GetCommEventLog() (eventLog *CommEventLog, err error) {
+	eventLog, err = mc.GetCommEventLogCtx(context.Background())
+
+	return
+}
+
+// GetCommEventLogCtx is like GetCommEventLog, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) GetCommEventLogCtx(ctx context.Context) (eventLog *CommEventLog, err error) {
+	err = mc.lock.LockCtx(ctx)
+	if err != nil {
+		return
+	}
+	defer mc.lock.Unlock()
+
+	var req *pdu
+	var res *pdu
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: fcGetCommEventLog,
+	}
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect 1 byte of byte count, 2 bytes of status, 2 bytes of
+		// event count, 2 bytes of message count and up to 64 events
+		if len(res.payload) < 7 || len(res.payload) > 7+64 ||
+			int(res.payload[0]) != len(res.payload)-1 {
+			err = ErrProtocolError
+			return
+		}
+
+		eventLog = &CommEventLog{
+			Status:       bytesToUint16(BIG_ENDIAN, res.payload[1:3]),
+			EventCount:   bytesToUint16(BIG_ENDIAN, res.payload[3:5]),
+			MessageCount: bytesToUint16(BIG_ENDIAN, res.payload[5:7]),
+			Events:       res.payload[7:],
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocolError
+			return
+		}
+		err = mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err = ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Reads the description of a serial line device (function code 0x11).
+// The returned data is device specific but usually consists of a server
+// id, a run indicator status byte (0x00 for off, 0xff for on) and
+// optional additional data.
+func (mc *ModbusClient) ReportServerId() (data []byte, err error) {
+	data, err = mc.ReportServerIdCtx(context.Background())
+
+	return
+}
+
+// ReportServerIdCtx is like ReportServerId, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReportServerIdCtx(ctx context.Context) (data []byte, err error) {
+	err = mc.lock.LockCtx(ctx)
+	if err != nil {
+		return
+	}
+	defer mc.lock.Unlock()
+
+	var req *pdu
+	var res *pdu
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: fcReportServerId,
+	}
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect 1 byte of byte count followed by at least 1 byte of
+		// data
+		if len(res.payload) < 2 || int(res.payload[0]) != len(res.payload)-1 {
+			err = ErrProtocolError
+			return
+		}
+		data = res.payload[1:]
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocolError
+			return
+		}
+		err = mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err = ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Runs a raw request carrying any function code across the transport and
+// returns the response.
+// The source unit id of the response is checked against that of the request
+// and exception responses are mapped to errors, as with any other request.
+// Note that on RTU transports, req.ResponseLength must be set for function
+// codes not otherwise supported by this package.
+func (mc *ModbusClient) ExecuteRawRequest(req *RawRequest) (res *RawResponse, err error) {
+	res, err = mc.ExecuteRawRequestCtx(context.Background(), req)
+
+	return
+}
+
+// ExecuteRawRequestCtx is like ExecuteRawRequest, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ExecuteRawRequestCtx(ctx context.Context, req *RawRequest) (res *RawResponse, err error) {
+	err = mc.lock.LockCtx(ctx)
+	if err != nil {
+		return
+	}
+	defer mc.lock.Unlock()
+
+	var rreq *pdu
+	var rres *pdu
+
+	if req.FunctionCode == 0x00 || req.FunctionCode&0x80 != 0 {
+		mc.logger.Errorf("illegal function code (0x%02x)", req.FunctionCode)
+		err = ErrUnexpectedParameters
+		return
+	}
+
+	// the payload should fit within the 253-byte PDU, function code included
+	if len(req.Payload) > 252 {
+		mc.logger.Errorf("payload too long (%v bytes, max 252)", len(req.Payload))
+		err = ErrUnexpectedParameters
+		return
+	}
+
+	// create and fill in the request object
+	rreq = &pdu{
+		unitId:         req.UnitId,
+		functionCode:   req.FunctionCode,
+		payload:        req.Payload,
+		responseLength: req.ResponseLength,
+	}
+
+	// run the request across the transport and wait for a response
+	rres, err = mc.executeRequest(ctx, rreq)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case rres.functionCode == rreq.functionCode:
+		res = &RawResponse{
+			UnitId:       rres.unitId,
+			FunctionCode: rres.functionCode,
+			Payload:      rres.payload,
+		}
+
+	case rres.functionCode == (rreq.functionCode | 0x80):
+		if len(rres.payload) != 1 {
+			err = ErrProtocolError
+			return
+		}
+		err = mapExceptionCodeToError(rres.payload[0])
+
+	default:
+		err = ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", rres.functionCode)
+	}
+
+	return
+}
+
+// Writes a single coil (function code 05)
+func (mc *ModbusClient) WriteCoil(addr uint16, value bool) error {
+	return mc.WriteCoilCtx(context.Background(), addr, value)
+}
+
+// WriteCoilCtx is like WriteCoil, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteCoilCtx(ctx context.Context, addr uint16, value bool) error {
+	var req *pdu
+	var res *pdu
+
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return err
+	}
+	defer mc.lock.Unlock()
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: fcWriteSingleCoil,
+	}
+
+	// coil address
+	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
+	// coil value
+	if value {
+		req.payload = append(req.payload, 0xff, 0x00)
+	} else {
+		req.payload = append(req.payload, 0x00, 0x00)
+	}
+
+	// run the request across the transport and wait for a response
+	res, err := mc.executeRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect 4 bytes (2 byte of address + 2 bytes of value)
+		if len(res.payload) != 4 ||
+			// bytes 1-2 should be the coil address
+			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
+			// bytes 3-4 should either be {0xff, 0x00} or {0x00, 0x00}
+			// depending on the coil value
+			(value && res.payload[2] != 0xff) ||
+			res.payload[3] != 0x00 {
+			return ErrProtocolError
+		}
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			return ErrProtocolError
+		}
+		return mapExceptionCodeToError(res.payload[0])
+	default:
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return ErrProtocolError
+	}
+	return nil
+}
+
+// Writes multiple coils (function code 15)
+func (mc *ModbusClient) WriteCoils(addr uint16, values []bool) error {
+	return mc.WriteCoilsCtx(context.Background(), addr, values)
+}
+
+// WriteCoilsCtx is like WriteCoils, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteCoilsCtx(ctx context.Context, addr uint16, values []bool) error {
+	return mc.writeManyCoils(ctx, addr, values)
+}
+
+// Writes multiple coils in a single request.
+func (mc *ModbusClient) writeCoils(ctx context.Context, addr uint16, values []bool) error {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return err
+	}
+	defer mc.lock.Unlock()
+
+	var req *pdu
+	var res *pdu
+	var quantity uint16
+	var encodedValues []byte
+
+	quantity = uint16(len(values))
+	if quantity == 0 {
+		mc.logger.Error("quantity of coils is 0")
+		return ErrUnexpectedParameters
+	}
+	if quantity > 0x7b0 {
+		mc.logger.Error("quantity of coils exceeds 1968")
+		return ErrUnexpectedParameters
+	}
+
+	if uint32(addr)+uint32(quantity)-1 > 0xffff {
+		mc.logger.Error("end coil address is past 0xffff")
+		return ErrUnexpectedParameters
+	}
+
+	encodedValues = encodeBools(values)
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: fcWriteMultipleCoils,
+	}
+
+	// start address
+	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
+	// quantity
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, quantity)...)
+	// byte count
 	req.payload = append(req.payload, byte(len(encodedValues)))
 	// payload
 	req.payload = append(req.payload, encodedValues...)
 
 	// run the request across the transport and wait for a response
-	res, err := mc.executeRequest(req)
+	res, err := mc.executeRequest(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -635,12 +1959,329 @@ func (mc *ModbusClient) WriteCoils(addr uint16, values []bool) error {
 		return ErrProtocolError
 
 	}
-	return nil
+	return nil
+}
+
+// Writes a single 16-bit register (function code 06).
+func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) error {
+	return mc.WriteRegisterCtx(context.Background(), addr, value)
+}
+
+// WriteRegisterCtx is like WriteRegister, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteRegisterCtx(ctx context.Context, addr uint16, value uint16) error {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return err
+	}
+	defer mc.lock.Unlock()
+
+	var req *pdu
+	var res *pdu
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: fcWriteSingleRegister,
+	}
+
+	// register address
+	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
+	// register value
+	req.payload = append(req.payload, uint16ToBytes(mc.endianness, value)...)
+
+	// run the request across the transport and wait for a response
+	res, err := mc.executeRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	// validate the response code
+	switch res.functionCode {
+	case req.functionCode:
+		// expect 4 bytes (2 byte of address + 2 bytes of value)
+		if len(res.payload) != 4 ||
+			// bytes 1-2 should be the register address
+			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
+			// bytes 3-4 should be the value
+			bytesToUint16(mc.endianness, res.payload[2:4]) != value {
+			return ErrProtocolError
+		}
+	case (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			return ErrProtocolError
+		}
+		return mapExceptionCodeToError(res.payload[0])
+	default:
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return ErrProtocolError
+	}
+	return nil
+}
+
+// Writes multiple 16-bit registers (function code 16).
+func (mc *ModbusClient) WriteRegisters(addr uint16, values []uint16) error {
+	return mc.WriteRegistersCtx(context.Background(), addr, values)
+}
+
+// WriteRegistersCtx is like WriteRegisters, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteRegistersCtx(ctx context.Context, addr uint16, values []uint16) error {
+	payload := make([]byte, 0)
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint16ToBytes(mc.endianness, value)...)
+	}
+	return mc.writeManyRegisters(ctx, addr, payload, 1)
+}
+
+// Writes multiple 32-bit registers.
+func (mc *ModbusClient) WriteUint32s(addr uint16, values []uint32) error {
+	return mc.WriteUint32sCtx(context.Background(), addr, values)
+}
+
+// WriteUint32sCtx is like WriteUint32s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteUint32sCtx(ctx context.Context, addr uint16, values []uint32) error {
+	payload := make([]byte, 0)
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint32ToBytes(mc.endianness, mc.wordOrder, value)...)
+	}
+	return mc.writeManyRegisters(ctx, addr, payload, 2)
+}
+
+// Writes a single 32-bit register.
+func (mc *ModbusClient) WriteUint32(addr uint16, value uint32) error {
+	return mc.WriteUint32Ctx(context.Background(), addr, value)
+}
+
+// WriteUint32Ctx is like WriteUint32, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteUint32Ctx(ctx context.Context, addr uint16, value uint32) error {
+	return mc.writeRegisters(ctx, addr, uint32ToBytes(mc.endianness, mc.wordOrder, value))
+}
+
+// Writes multiple 32-bit float registers.
+func (mc *ModbusClient) WriteFloat32s(addr uint16, values []float32) error {
+	return mc.WriteFloat32sCtx(context.Background(), addr, values)
+}
+
+// WriteFloat32sCtx is like WriteFloat32s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteFloat32sCtx(ctx context.Context, addr uint16, values []float32) error {
+	payload := make([]byte, 0)
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, float32ToBytes(mc.endianness, mc.wordOrder, value)...)
+	}
+	return mc.writeManyRegisters(ctx, addr, payload, 2)
+}
+
+// Writes a single 32-bit float register.
+func (mc *ModbusClient) WriteFloat32(addr uint16, value float32) (err error) {
+	err = mc.WriteFloat32Ctx(context.Background(), addr, value)
+
+	return
+}
+
+// WriteFloat32Ctx is like WriteFloat32, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteFloat32Ctx(ctx context.Context, addr uint16, value float32) (err error) {
+	err = mc.writeRegisters(ctx, addr, float32ToBytes(mc.endianness, mc.wordOrder, value))
+
+	return
+}
+
+// Writes multiple 64-bit registers.
+func (mc *ModbusClient) WriteUint64s(addr uint16, values []uint64) (err error) {
+	err = mc.WriteUint64sCtx(context.Background(), addr, values)
+
+	return
+}
+
+// WriteUint64sCtx is like WriteUint64s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteUint64sCtx(ctx context.Context, addr uint16, values []uint64) (err error) {
+	payload := make([]byte, 0)
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint64ToBytes(mc.endianness, mc.wordOrder, value)...)
+	}
+	return mc.writeManyRegisters(ctx, addr, payload, 4)
+}
+
+// Writes a single 64-bit register.
+func (mc *ModbusClient) WriteUint64(addr uint16, value uint64) error {
+	return mc.WriteUint64Ctx(context.Background(), addr, value)
+}
+
+// WriteUint64Ctx is like WriteUint64, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteUint64Ctx(ctx context.Context, addr uint16, value uint64) error {
+	return mc.writeRegisters(ctx, addr, uint64ToBytes(mc.endianness, mc.wordOrder, value))
+}
+
+// Writes multiple 64-bit float registers.
+func (mc *ModbusClient) WriteFloat64s(addr uint16, values []float64) error {
+	return mc.WriteFloat64sCtx(context.Background(), addr, values)
+}
+
+// WriteFloat64sCtx is like WriteFloat64s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteFloat64sCtx(ctx context.Context, addr uint16, values []float64) error {
+	payload := make([]byte, 0)
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, float64ToBytes(mc.endianness, mc.wordOrder, value)...)
+	}
+	return mc.writeManyRegisters(ctx, addr, payload, 4)
+}
+
+// Writes a single 64-bit float register.
+func (mc *ModbusClient) WriteFloat64(addr uint16, value float64) error {
+	return mc.WriteFloat64Ctx(context.Background(), addr, value)
+}
+
+// WriteFloat64Ctx is like WriteFloat64, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteFloat64Ctx(ctx context.Context, addr uint16, value float64) error {
+	return mc.writeRegisters(ctx, addr, float64ToBytes(mc.endianness, mc.wordOrder, value))
+}
+
+// Writes multiple 16-bit registers then reads multiple 16-bit registers
+// in a single transaction (function code 23).
+// The write operation is performed before the read.
+func (mc *ModbusClient) ReadWriteRegisters(readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []uint16) ([]uint16, error) {
+	return mc.ReadWriteRegistersCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+}
+
+// ReadWriteRegistersCtx is like ReadWriteRegisters, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadWriteRegistersCtx(ctx context.Context, readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []uint16) ([]uint16, error) {
+	payload := make([]byte, 0)
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint16ToBytes(mc.endianness, value)...)
+	}
+
+	mbPayload, err := mc.readWriteRegisters(ctx, readAddr, readQuantity, writeAddr, payload)
+	if err != nil {
+		return []uint16{}, err
+	}
+
+	// decode payload bytes as uint16s
+	return bytesToUint16s(mc.endianness, mbPayload), nil
+}
+
+// Writes multiple 32-bit registers then reads readQuantity 32-bit registers
+// in a single transaction (function code 23).
+func (mc *ModbusClient) ReadWriteUint32s(readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []uint32) ([]uint32, error) {
+	return mc.ReadWriteUint32sCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+}
+
+// ReadWriteUint32sCtx is like ReadWriteUint32s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadWriteUint32sCtx(ctx context.Context, readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []uint32) ([]uint32, error) {
+	payload := make([]byte, 0)
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint32ToBytes(mc.endianness, mc.wordOrder, value)...)
+	}
+
+	// read 2 * readQuantity uint16 registers, as bytes
+	mbPayload, err := mc.readWriteRegisters(ctx, readAddr, readQuantity*2, writeAddr, payload)
+	if err != nil {
+		return []uint32{}, err
+	}
+
+	// decode payload bytes as uint32s
+	return bytesToUint32s(mc.endianness, mc.wordOrder, mbPayload), nil
+}
+
+// Writes multiple 32-bit float registers then reads readQuantity 32-bit
+// float registers in a single transaction (function code 23).
+func (mc *ModbusClient) ReadWriteFloat32s(readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []float32) ([]float32, error) {
+	return mc.ReadWriteFloat32sCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+}
+
+// ReadWriteFloat32sCtx is like ReadWriteFloat32s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadWriteFloat32sCtx(ctx context.Context, readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []float32) ([]float32, error) {
+	payload := make([]byte, 0)
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, float32ToBytes(mc.endianness, mc.wordOrder, value)...)
+	}
+
+	// read 2 * readQuantity uint16 registers, as bytes
+	mbPayload, err := mc.readWriteRegisters(ctx, readAddr, readQuantity*2, writeAddr, payload)
+	if err != nil {
+		return []float32{}, err
+	}
+
+	// decode payload bytes as float32s
+	return bytesToFloat32s(mc.endianness, mc.wordOrder, mbPayload), nil
+}
+
+// Writes multiple 64-bit registers then reads readQuantity 64-bit registers
+// in a single transaction (function code 23).
+func (mc *ModbusClient) ReadWriteUint64s(readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []uint64) ([]uint64, error) {
+	return mc.ReadWriteUint64sCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+}
+
+// ReadWriteUint64sCtx is like ReadWriteUint64s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadWriteUint64sCtx(ctx context.Context, readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []uint64) ([]uint64, error) {
+	payload := make([]byte, 0)
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, uint64ToBytes(mc.endianness, mc.wordOrder, value)...)
+	}
+
+	// read 4 * readQuantity uint16 registers, as bytes
+	mbPayload, err := mc.readWriteRegisters(ctx, readAddr, readQuantity*4, writeAddr, payload)
+	if err != nil {
+		return []uint64{}, err
+	}
+
+	// decode payload bytes as uint64s
+	return bytesToUint64s(mc.endianness, mc.wordOrder, mbPayload), nil
+}
+
+// Writes multiple 64-bit float registers then reads readQuantity 64-bit
+// float registers in a single transaction (function code 23).
+func (mc *ModbusClient) ReadWriteFloat64s(readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []float64) ([]float64, error) {
+	return mc.ReadWriteFloat64sCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+}
+
+// ReadWriteFloat64sCtx is like ReadWriteFloat64s, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadWriteFloat64sCtx(ctx context.Context, readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []float64) ([]float64, error) {
+	payload := make([]byte, 0)
+	// turn registers to bytes
+	for _, value := range values {
+		payload = append(payload, float64ToBytes(mc.endianness, mc.wordOrder, value)...)
+	}
+
+	// read 4 * readQuantity uint16 registers, as bytes
+	mbPayload, err := mc.readWriteRegisters(ctx, readAddr, readQuantity*4, writeAddr, payload)
+	if err != nil {
+		return []float64{}, err
+	}
+
+	// decode payload bytes as float64s
+	return bytesToFloat64s(mc.endianness, mc.wordOrder, mbPayload), nil
 }
 
-// Writes a single 16-bit register (function code 06).
-func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) error {
-	mc.lock.Lock()
+// Modifies the contents of a single 16-bit holding register using a
+// combination of an AND mask and an OR mask (function code 22).
+// The server computes (current value AND andMask) OR (orMask AND (NOT andMask)),
+// allowing individual bits to be set or cleared without a read-modify-write
+// cycle on the client side.
+func (mc *ModbusClient) MaskWriteRegister(addr uint16, andMask uint16, orMask uint16) error {
+	return mc.MaskWriteRegisterCtx(context.Background(), addr, andMask, orMask)
+}
+
+// MaskWriteRegisterCtx is like MaskWriteRegister, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) MaskWriteRegisterCtx(ctx context.Context, addr uint16, andMask uint16, orMask uint16) error {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return err
+	}
 	defer mc.lock.Unlock()
 
 	var req *pdu
@@ -649,16 +2290,18 @@ func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) error {
 	// create and fill in the request object
 	req = &pdu{
 		unitId:       mc.unitId,
-		functionCode: fcWriteSingleRegister,
+		functionCode: fcMaskWriteRegister,
 	}
 
 	// register address
 	req.payload = uint16ToBytes(BIG_ENDIAN, addr)
-	// register value
-	req.payload = append(req.payload, uint16ToBytes(mc.endianness, value)...)
+	// AND mask
+	req.payload = append(req.payload, uint16ToBytes(mc.endianness, andMask)...)
+	// OR mask
+	req.payload = append(req.payload, uint16ToBytes(mc.endianness, orMask)...)
 
 	// run the request across the transport and wait for a response
-	res, err := mc.executeRequest(req)
+	res, err := mc.executeRequest(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -666,12 +2309,15 @@ func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) error {
 	// validate the response code
 	switch res.functionCode {
 	case req.functionCode:
-		// expect 4 bytes (2 byte of address + 2 bytes of value)
-		if len(res.payload) != 4 ||
+		// expect 6 bytes (2 bytes of address + 2 bytes of AND mask +
+		// 2 bytes of OR mask), echoing the request
+		if len(res.payload) != 6 ||
 			// bytes 1-2 should be the register address
 			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
-			// bytes 3-4 should be the value
-			bytesToUint16(mc.endianness, res.payload[2:4]) != value {
+			// bytes 3-4 should be the AND mask
+			bytesToUint16(mc.endianness, res.payload[2:4]) != andMask ||
+			// bytes 5-6 should be the OR mask
+			bytesToUint16(mc.endianness, res.payload[4:6]) != orMask {
 			return ErrProtocolError
 		}
 	case (req.functionCode | 0x80):
@@ -686,83 +2332,16 @@ func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) error {
 	return nil
 }
 
-// Writes multiple 16-bit registers (function code 16).
-func (mc *ModbusClient) WriteRegisters(addr uint16, values []uint16) error {
-	payload := make([]byte, 0)
-	// turn registers to bytes
-	for _, value := range values {
-		payload = append(payload, uint16ToBytes(mc.endianness, value)...)
-	}
-	return mc.writeRegisters(addr, payload)
-}
-
-// Writes multiple 32-bit registers.
-func (mc *ModbusClient) WriteUint32s(addr uint16, values []uint32) error {
-	payload := make([]byte, 0)
-	// turn registers to bytes
-	for _, value := range values {
-		payload = append(payload, uint32ToBytes(mc.endianness, mc.wordOrder, value)...)
-	}
-	return mc.writeRegisters(addr, payload)
-}
-
-// Writes a single 32-bit register.
-func (mc *ModbusClient) WriteUint32(addr uint16, value uint32) error {
-	return mc.writeRegisters(addr, uint32ToBytes(mc.endianness, mc.wordOrder, value))
-}
-
-// Writes multiple 32-bit float registers.
-func (mc *ModbusClient) WriteFloat32s(addr uint16, values []float32) error {
-	payload := make([]byte, 0)
-	// turn registers to bytes
-	for _, value := range values {
-		payload = append(payload, float32ToBytes(mc.endianness, mc.wordOrder, value)...)
-	}
-	return mc.writeRegisters(addr, payload)
-}
-
-// Writes a single 32-bit float register.
-func (mc *ModbusClient) WriteFloat32(addr uint16, value float32) (err error) {
-	err = mc.writeRegisters(addr, float32ToBytes(mc.endianness, mc.wordOrder, value))
-
-	return
-}
-
-// Writes multiple 64-bit registers.
-func (mc *ModbusClient) WriteUint64s(addr uint16, values []uint64) (err error) {
-	payload := make([]byte, 0)
-	// turn registers to bytes
-	for _, value := range values {
-		payload = append(payload, uint64ToBytes(mc.endianness, mc.wordOrder, value)...)
-	}
-	return mc.writeRegisters(addr, payload)
-}
-
-// Writes a single 64-bit register.
-func (mc *ModbusClient) WriteUint64(addr uint16, value uint64) error {
-	return mc.writeRegisters(addr, uint64ToBytes(mc.endianness, mc.wordOrder, value))
-}
-
-// Writes multiple 64-bit float registers.
-func (mc *ModbusClient) WriteFloat64s(addr uint16, values []float64) error {
-	payload := make([]byte, 0)
-	// turn registers to bytes
-	for _, value := range values {
-		payload = append(payload, float64ToBytes(mc.endianness, mc.wordOrder, value)...)
-	}
-	return mc.writeRegisters(addr, payload)
-}
-
-// Writes a single 64-bit float register.
-func (mc *ModbusClient) WriteFloat64(addr uint16, value float64) error {
-	return mc.writeRegisters(addr, float64ToBytes(mc.endianness, mc.wordOrder, value))
-}
-
 // Writes the given slice of bytes to 16-bit registers starting at addr.
 // A per-register byteswap is performed if endianness is set to LITTLE_ENDIAN.
 // Odd byte quantities are padded with a null byte to fall on 16-bit register boundaries.
 func (mc *ModbusClient) WriteBytes(addr uint16, values []byte) error {
-	return mc.writeBytes(addr, values, true)
+	return mc.WriteBytesCtx(context.Background(), addr, values)
+}
+
+// WriteBytesCtx is like WriteBytes, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteBytesCtx(ctx context.Context, addr uint16, values []byte) error {
+	return mc.writeBytes(ctx, addr, values, true)
 }
 
 // Writes the given slice of bytes to 16-bit registers starting at addr.
@@ -770,17 +2349,22 @@ func (mc *ModbusClient) WriteBytes(addr uint16, values []byte) error {
 // allowing the caller to handle encoding/endianness/word order manually.
 // Odd byte quantities are padded with a null byte to fall on 16-bit register boundaries.
 func (mc *ModbusClient) WriteRawBytes(addr uint16, values []byte) error {
-	return mc.writeBytes(addr, values, false)
+	return mc.WriteRawBytesCtx(context.Background(), addr, values)
+}
+
+// WriteRawBytesCtx is like WriteRawBytes, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) WriteRawBytesCtx(ctx context.Context, addr uint16, values []byte) error {
+	return mc.writeBytes(ctx, addr, values, false)
 }
 
 /*** unexported methods ***/
 // Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
-func (mc *ModbusClient) readBytes(addr uint16, quantity uint16, regType RegType, observeEndianness bool) ([]byte, error) {
+func (mc *ModbusClient) readBytes(ctx context.Context, addr uint16, quantity uint16, regType RegType, observeEndianness bool) ([]byte, error) {
 	// read enough registers to get the requested number of bytes
 	// (2 bytes per reg)
 	regCount := (quantity / 2) + (quantity % 2)
 
-	values, err := mc.readRegisters(addr, regCount, regType)
+	values, err := mc.readManyRegisters(ctx, addr, regCount, 1, regType)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -800,7 +2384,7 @@ func (mc *ModbusClient) readBytes(addr uint16, quantity uint16, regType RegType,
 }
 
 // Writes the given slice of bytes to 16-bit registers starting at addr.
-func (mc *ModbusClient) writeBytes(addr uint16, values []byte, observeEndianness bool) error {
+func (mc *ModbusClient) writeBytes(ctx context.Context, addr uint16, values []byte, observeEndianness bool) error {
 	// pad odd quantities to make for full registers
 	if len(values)%2 == 1 {
 		values = append(values, 0x00)
@@ -812,13 +2396,15 @@ func (mc *ModbusClient) writeBytes(addr uint16, values []byte, observeEndianness
 			values[i], values[i+1] = values[i+1], values[i]
 		}
 	}
-	return mc.writeRegisters(addr, values)
+	return mc.writeManyRegisters(ctx, addr, values, 1)
 }
 
 // Reads and returns quantity booleans.
 // Digital inputs are read if di is true, otherwise coils are read.
-func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) ([]bool, error) {
-	mc.lock.Lock()
+func (mc *ModbusClient) readBools(ctx context.Context, addr uint16, quantity uint16, di bool) ([]bool, error) {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return []bool{}, err
+	}
 	defer mc.lock.Unlock()
 
 	var req *pdu
@@ -858,7 +2444,7 @@ func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) ([]bool
 	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, quantity)...)
 
 	// run the request across the transport and wait for a response
-	res, err := mc.executeRequest(req)
+	res, err := mc.executeRequest(ctx, req)
 	if err != nil {
 		return []bool{}, err
 	}
@@ -899,8 +2485,10 @@ func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) ([]bool
 }
 
 // Reads and returns quantity registers of type regType, as bytes.
-func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegType) ([]byte, error) {
-	mc.lock.Lock()
+func (mc *ModbusClient) readRegisters(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]byte, error) {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return []byte{}, err
+	}
 	defer mc.lock.Unlock()
 
 	var req *pdu
@@ -942,7 +2530,7 @@ func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegT
 	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, quantity)...)
 
 	// run the request across the transport and wait for a response
-	res, err := mc.executeRequest(req)
+	res, err := mc.executeRequest(ctx, req)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -981,8 +2569,10 @@ func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegT
 
 // Writes multiple registers starting from base address addr.
 // Register values are passed as bytes, each value being exactly 2 bytes.
-func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) error {
-	mc.lock.Lock()
+func (mc *ModbusClient) writeRegisters(ctx context.Context, addr uint16, values []byte) error {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return err
+	}
 	defer mc.lock.Unlock()
 
 	var req *pdu
@@ -1024,7 +2614,7 @@ func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) error {
 	req.payload = append(req.payload, values...)
 
 	// run the request across the transport and wait for a response
-	res, err := mc.executeRequest(req)
+	res, err := mc.executeRequest(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -1052,10 +2642,304 @@ func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) error {
 	return nil
 }
 
-func (mc *ModbusClient) executeRequest(req *pdu) (*pdu, error) {
-	// send the request over the wire, wait for and decode the response
-	res, err := mc.transport.ExecuteRequest(req)
+// Writes multiple registers starting from base address writeAddr, then reads
+// readQuantity registers starting from base address readAddr, in a single
+// request. Register values to write are passed as bytes, each value being
+// exactly 2 bytes. Read register values are returned as bytes.
+func (mc *ModbusClient) readWriteRegisters(ctx context.Context, readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []byte) ([]byte, error) {
+	if err := mc.lock.LockCtx(ctx); err != nil {
+		return []byte{}, err
+	}
+	defer mc.lock.Unlock()
+
+	var req *pdu
+	var res *pdu
+	var payloadLength uint16
+	var writeQuantity uint16
+
+	payloadLength = uint16(len(values))
+	writeQuantity = payloadLength / 2
+
+	if readQuantity == 0 {
+		mc.logger.Error("quantity of registers to read is 0")
+		return []byte{}, ErrUnexpectedParameters
+	}
+
+	if readQuantity > 125 {
+		mc.logger.Error("quantity of registers to read exceeds 125")
+		return []byte{}, ErrUnexpectedParameters
+	}
+
+	if uint32(readAddr)+uint32(readQuantity)-1 > 0xffff {
+		mc.logger.Error("end read register address is past 0xffff")
+		return []byte{}, ErrUnexpectedParameters
+	}
+
+	if writeQuantity == 0 {
+		mc.logger.Error("quantity of registers to write is 0")
+		return []byte{}, ErrUnexpectedParameters
+	}
+
+	if writeQuantity > 121 {
+		mc.logger.Error("quantity of registers to write exceeds 121")
+		return []byte{}, ErrUnexpectedParameters
+	}
+
+	if uint32(writeAddr)+uint32(writeQuantity)-1 > 0xffff {
+		mc.logger.Error("end write register address is past 0xffff")
+		return []byte{}, ErrUnexpectedParameters
+	}
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: fcReadWriteMultipleRegisters,
+	}
+
+	// read base address
+	req.payload = uint16ToBytes(BIG_ENDIAN, readAddr)
+	// quantity of registers to read
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, readQuantity)...)
+	// write base address
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, writeAddr)...)
+	// quantity of registers to write
+	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, writeQuantity)...)
+	// byte count
+	req.payload = append(req.payload, byte(payloadLength))
+	// registers value
+	req.payload = append(req.payload, values...)
+
+	// run the request across the transport and wait for a response
+	res, err := mc.executeRequest(ctx, req)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	var bts []byte
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// make sure the payload length is what we expect
+		// (1 byte of length + 2 bytes per register read)
+		if len(res.payload) != 1+2*int(readQuantity) {
+			return []byte{}, ErrProtocolError
+		}
+
+		// validate the byte count field
+		// (2 bytes per register * number of registers read)
+		if uint(res.payload[0]) != 2*uint(readQuantity) {
+			return []byte{}, ErrProtocolError
+		}
+
+		// remove the byte count field from the returned slice
+		bts = res.payload[1:]
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			return []byte{}, ErrProtocolError
+		}
+		return []byte{}, mapExceptionCodeToError(res.payload[0])
+
+	default:
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+		return []byte{}, ErrProtocolError
+	}
+	return bts, nil
+}
+
+// Runs a diagnostics request with a 16-bit data field and returns the
+// 16-bit data field of the response.
+func (mc *ModbusClient) diagnosticsUint16(ctx context.Context, subFunction DiagSubFunction, data uint16) (value uint16, err error) {
+	var res []byte
+
+	res, err = mc.DiagnosticsCtx(ctx, subFunction, uint16ToBytes(BIG_ENDIAN, data))
+	if err != nil {
+		return
+	}
+
+	if len(res) != 2 {
+		err = ErrProtocolError
+		return
+	}
+	value = bytesToUint16(BIG_ENDIAN, res)
+
+	return
+}
+
+// Runs a diagnostics request and returns the data field of the response.
+func (mc *ModbusClient) diagnostics(ctx context.Context, subFunction DiagSubFunction, data []byte) (values []byte, err error) {
+	var req *pdu
+	var res *pdu
+
+	// 2 bytes of sub-function followed by data should fit within the
+	// 253-byte PDU, function code included
+	if len(data) > 250 {
+		mc.logger.Errorf("diagnostics data too long (%v bytes, max 250)", len(data))
+		err = ErrUnexpectedParameters
+		return
+	}
+
+	// create and fill in the request object
+	req = &pdu{
+		unitId:       mc.unitId,
+		functionCode: fcDiagnostics,
+	}
+
+	// sub-function
+	req.payload = uint16ToBytes(BIG_ENDIAN, uint16(subFunction))
+	// data
+	req.payload = append(req.payload, data...)
+
+	// run the request across the transport and wait for a response
+	res, err = mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// the response should start with the sub-function of the request
+		if len(res.payload) < 2 ||
+			bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != uint16(subFunction) {
+			err = ErrProtocolError
+			return
+		}
+		values = res.payload[2:]
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err = ErrProtocolError
+			return
+		}
+		err = mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err = ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Decodes a read device identification response payload, storing objects
+// into the objects map. Returns whether more objects follow and, if so,
+// the id of the next object to request.
+func decodeDeviceIdentification(payload []byte, category ReadDeviceIdCode,
+	objects map[uint8]string) (moreFollows bool, nextObjectId uint8, err error) {
+	var objectCount int
+	var objectLength int
+	var offset int
+
+	// expect at least 6 bytes (MEI type, read device id code, conformity
+	// level, more follows, next object id and number of objects)
+	if len(payload) < 6 ||
+		payload[0] != meiReadDeviceIdentification ||
+		payload[1] != byte(category) {
+		err = ErrProtocolError
+		return
+	}
+
+	moreFollows = (payload[3] == 0xff)
+	nextObjectId = payload[4]
+	objectCount = int(payload[5])
+
+	offset = 6
+	for i := 0; i < objectCount; i++ {
+		// object id (1 byte) and object length (1 byte)
+		if len(payload) < offset+2 {
+			err = ErrProtocolError
+			return
+		}
+		objectLength = int(payload[offset+1])
+
+		// object value
+		if len(payload) < offset+2+objectLength {
+			err = ErrProtocolError
+			return
+		}
+		objects[payload[offset]] = string(payload[offset+2 : offset+2+objectLength])
+
+		offset += 2 + objectLength
+	}
+
+	// make sure there are no trailing bytes
+	if offset != len(payload) {
+		err = ErrProtocolError
+	}
+
+	return
+}
+
+// Runs fn with the client lock released if the client is pipelined, to let
+// other goroutines issue requests concurrently. Must be called with the
+// client lock held. fn must not access any client field.
+func (mc *ModbusClient) unlockedIfPipelined(fn func()) {
+	if mc.conf.MaxInFlight <= 1 {
+		fn()
+		return
+	}
+
+	mc.lock.Unlock()
+	defer mc.lock.Lock()
+
+	fn()
+
+	return
+}
+
+// Runs a request through the interceptors of the client, if any, and
+// across the transport, and returns its response.
+func (mc *ModbusClient) executeRequest(ctx context.Context, req *pdu) (*pdu, error) {
+	if len(mc.conf.Interceptors) > 0 {
+		return mc.executeInterceptedRequest(ctx, req)
+	}
+
+	return mc.runRequest(ctx, req)
+}
+
+// Runs a request across the transport, with retries if the retry policy
+// of the client allows for them.
+func (mc *ModbusClient) runRequest(ctx context.Context, req *pdu) (*pdu, error) {
+	if mc.conf.Retry != nil && mc.conf.Retry.allows(req) {
+		return mc.executeRequestWithRetries(ctx, req)
+	}
+
+	return mc.executeRequestOnce(ctx, req)
+}
+
+// Runs a request across the transport and returns its response.
+// Transport i/o deadlines never extend past that of ctx, and the request is
+// abandoned as soon as ctx is done, in which case ctx.Err() is returned.
+func (mc *ModbusClient) executeRequestOnce(ctx context.Context, req *pdu) (*pdu, error) {
+	// the transport is either not open yet, closed or being reconnected
+	if mc.transport == nil {
+		return nil, ErrNotConnected
+	}
+
+	var t = mc.transport
+	var res *pdu
+	var err error
+
+	// send the request over the wire, wait for and decode the response.
+	// pipelined transports let other requests go through in the meantime.
+	mc.unlockedIfPipelined(func() {
+		res, err = t.ExecuteRequest(ctx, req)
+	})
 	if err != nil {
+		// report abandoned requests as such
+		if ctxErr := ctxErr(ctx); ctxErr != nil {
+			return nil, ctxErr
+		}
+		// drop broken links and reconnect if configured to do so (unless
+		// the transport was closed or replaced while the lock was released).
+		// without a reconnect policy, the transport is left as is and
+		// subsequent requests fail with the underlying i/o error.
+		if mc.conf.Reconnect != nil && mc.isStreamLink() && isLinkError(err) &&
+			mc.transport == t {
+			mc.linkLost(err)
+		}
 		// map i/o timeouts to ErrRequestTimedOut
 		if os.IsTimeout(err) {
 			return nil, ErrRequestTimedOut
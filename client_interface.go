@@ -0,0 +1,132 @@
+package modbus
+
+import (
+	"context"
+)
+
+// Client is the set of methods shared by modbus clients: ModbusClient,
+// ModbusClientPool and fake clients returned by NewFakeClient().
+// Code depending on Client rather than on ModbusClient can be handed
+// fakes in tests, or decorated (e.g. for logging or caching purposes).
+//
+// Each request method comes in two flavours, as described in the
+// ModbusClient documentation.
+type Client interface {
+	Open() error
+	Close() error
+	SetUnitId(id uint8) error
+	SetEncoding(endianness Endianness, wordOrder WordOrder) error
+	ReadCoils(addr uint16, quantity uint16) ([]bool, error)
+	ReadCoilsCtx(ctx context.Context, addr uint16, quantity uint16) ([]bool, error)
+	ReadCoil(addr uint16) (bool, error)
+	ReadCoilCtx(ctx context.Context, addr uint16) (bool, error)
+	ReadDiscreteInputs(addr uint16, quantity uint16) ([]bool, error)
+	ReadDiscreteInputsCtx(ctx context.Context, addr uint16, quantity uint16) ([]bool, error)
+	ReadDiscreteInput(addr uint16) (bool, error)
+	ReadDiscreteInputCtx(ctx context.Context, addr uint16) (bool, error)
+	ReadRegisters(addr uint16, quantity uint16, regType RegType) ([]uint16, error)
+	ReadRegistersCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]uint16, error)
+	ReadRegister(addr uint16, regType RegType) (uint16, error)
+	ReadRegisterCtx(ctx context.Context, addr uint16, regType RegType) (uint16, error)
+	ReadUint32s(addr uint16, quantity uint16, regType RegType) ([]uint32, error)
+	ReadUint32sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]uint32, error)
+	ReadUint32(addr uint16, regType RegType) (uint32, error)
+	ReadUint32Ctx(ctx context.Context, addr uint16, regType RegType) (uint32, error)
+	ReadFloat32s(addr uint16, quantity uint16, regType RegType) ([]float32, error)
+	ReadFloat32sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]float32, error)
+	ReadFloat32(addr uint16, regType RegType) (float32, error)
+	ReadFloat32Ctx(ctx context.Context, addr uint16, regType RegType) (float32, error)
+	ReadUint64s(addr uint16, quantity uint16, regType RegType) ([]uint64, error)
+	ReadUint64sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]uint64, error)
+	ReadUint64(addr uint16, regType RegType) (uint64, error)
+	ReadUint64Ctx(ctx context.Context, addr uint16, regType RegType) (uint64, error)
+	ReadFloat64s(addr uint16, quantity uint16, regType RegType) ([]float64, error)
+	ReadFloat64sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]float64, error)
+	ReadFloat64(addr uint16, regType RegType) (float64, error)
+	ReadFloat64Ctx(ctx context.Context, addr uint16, regType RegType) (float64, error)
+	ReadBytes(addr uint16, quantity uint16, regType RegType) ([]byte, error)
+	ReadBytesCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]byte, error)
+	ReadRawBytes(addr uint16, quantity uint16, regType RegType) ([]byte, error)
+	ReadRawBytesCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) ([]byte, error)
+	ReadFIFOQueue(addr uint16) ([]uint16, error)
+	ReadFIFOQueueCtx(ctx context.Context, addr uint16) ([]uint16, error)
+	ReadFileRecords(records []FileRecord) ([][]uint16, error)
+	ReadFileRecordsCtx(ctx context.Context, records []FileRecord) ([][]uint16, error)
+	ReadFileRecord(fileNumber uint16, recordNumber uint16, length uint16) ([]uint16, error)
+	ReadFileRecordCtx(ctx context.Context, fileNumber uint16, recordNumber uint16, length uint16) ([]uint16, error)
+	WriteFileRecords(records []FileRecord) error
+	WriteFileRecordsCtx(ctx context.Context, records []FileRecord) error
+	WriteFileRecord(fileNumber uint16, recordNumber uint16, values []uint16) error
+	WriteFileRecordCtx(ctx context.Context, fileNumber uint16, recordNumber uint16, values []uint16) error
+	ReadDeviceIdentification(category ReadDeviceIdCode, objectId uint8) (map[uint8]string, error)
+	ReadDeviceIdentificationCtx(ctx context.Context, category ReadDeviceIdCode, objectId uint8) (map[uint8]string, error)
+	ReadExceptionStatus() (uint8, error)
+	ReadExceptionStatusCtx(ctx context.Context) (uint8, error)
+	Diagnostics(subFunction DiagSubFunction, data []byte) ([]byte, error)
+	DiagnosticsCtx(ctx context.Context, subFunction DiagSubFunction, data []byte) ([]byte, error)
+	ReturnQueryData(data []byte) error
+	ReturnQueryDataCtx(ctx context.Context, data []byte) error
+	RestartCommunications(clearEventLog bool) error
+	RestartCommunicationsCtx(ctx context.Context, clearEventLog bool) error
+	ReadDiagnosticRegister() (uint16, error)
+	ReadDiagnosticRegisterCtx(ctx context.Context) (uint16, error)
+	ClearDiagnosticCounters() error
+	ClearDiagnosticCountersCtx(ctx context.Context) error
+	ReadDiagnosticCounter(counter DiagSubFunction) (uint16, error)
+	ReadDiagnosticCounterCtx(ctx context.Context, counter DiagSubFunction) (uint16, error)
+	ClearOverrunCounter() error
+	ClearOverrunCounterCtx(ctx context.Context) error
+	GetCommEventCounter() (status uint16, eventCount uint16, err error)
+	GetCommEventCounterCtx(ctx context.Context) (status uint16, eventCount uint16, err error)
+	GetCommEventLog() (*CommEventLog, error)
+	GetCommEventLogCtx(ctx context.Context) (*CommEventLog, error)
+	ReportServerId() ([]byte, error)
+	ReportServerIdCtx(ctx context.Context) ([]byte, error)
+	ExecuteRawRequest(req *RawRequest) (*RawResponse, error)
+	ExecuteRawRequestCtx(ctx context.Context, req *RawRequest) (*RawResponse, error)
+	WriteCoil(addr uint16, value bool) error
+	WriteCoilCtx(ctx context.Context, addr uint16, value bool) error
+	WriteCoils(addr uint16, values []bool) error
+	WriteCoilsCtx(ctx context.Context, addr uint16, values []bool) error
+	WriteRegister(addr uint16, value uint16) error
+	WriteRegisterCtx(ctx context.Context, addr uint16, value uint16) error
+	WriteRegisters(addr uint16, values []uint16) error
+	WriteRegistersCtx(ctx context.Context, addr uint16, values []uint16) error
+	WriteUint32s(addr uint16, values []uint32) error
+	WriteUint32sCtx(ctx context.Context, addr uint16, values []uint32) error
+	WriteUint32(addr uint16, value uint32) error
+	WriteUint32Ctx(ctx context.Context, addr uint16, value uint32) error
+	WriteFloat32s(addr uint16, values []float32) error
+	WriteFloat32sCtx(ctx context.Context, addr uint16, values []float32) error
+	WriteFloat32(addr uint16, value float32) error
+	WriteFloat32Ctx(ctx context.Context, addr uint16, value float32) error
+	WriteUint64s(addr uint16, values []uint64) error
+	WriteUint64sCtx(ctx context.Context, addr uint16, values []uint64) error
+	WriteUint64(addr uint16, value uint64) error
+	WriteUint64Ctx(ctx context.Context, addr uint16, value uint64) error
+	WriteFloat64s(addr uint16, values []float64) error
+	WriteFloat64sCtx(ctx context.Context, addr uint16, values []float64) error
+	WriteFloat64(addr uint16, value float64) error
+	WriteFloat64Ctx(ctx context.Context, addr uint16, value float64) error
+	ReadWriteRegisters(readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint16) ([]uint16, error)
+	ReadWriteRegistersCtx(ctx context.Context, readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint16) ([]uint16, error)
+	ReadWriteUint32s(readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint32) ([]uint32, error)
+	ReadWriteUint32sCtx(ctx context.Context, readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint32) ([]uint32, error)
+	ReadWriteFloat32s(readAddr uint16, readQuantity uint16, writeAddr uint16, values []float32) ([]float32, error)
+	ReadWriteFloat32sCtx(ctx context.Context, readAddr uint16, readQuantity uint16, writeAddr uint16, values []float32) ([]float32, error)
+	ReadWriteUint64s(readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint64) ([]uint64, error)
+	ReadWriteUint64sCtx(ctx context.Context, readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint64) ([]uint64, error)
+	ReadWriteFloat64s(readAddr uint16, readQuantity uint16, writeAddr uint16, values []float64) ([]float64, error)
+	ReadWriteFloat64sCtx(ctx context.Context, readAddr uint16, readQuantity uint16, writeAddr uint16, values []float64) ([]float64, error)
+	MaskWriteRegister(addr uint16, andMask uint16, orMask uint16) error
+	MaskWriteRegisterCtx(ctx context.Context, addr uint16, andMask uint16, orMask uint16) error
+	WriteBytes(addr uint16, values []byte) error
+	WriteBytesCtx(ctx context.Context, addr uint16, values []byte) error
+	WriteRawBytes(addr uint16, values []byte) error
+	WriteRawBytesCtx(ctx context.Context, addr uint16, values []byte) error
+}
+
+var (
+	_ Client = (*ModbusClient)(nil)
+	_ Client = (*ModbusClientPool)(nil)
+)
@@ -0,0 +1,1075 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Modbus client pool object.
+//
+// A pool spreads requests across several connections to the same remote
+// device, for devices which serve multiple connections concurrently but
+// do not support pipelining. Each request is run over whichever pooled
+// client is idle first, and clients found to be disconnected (e.g. after
+// a broken link) are re-opened before being reused.
+//
+// The pool exposes the same request methods as ModbusClient, each run
+// over one of the pooled clients, as well as Do(), which lends a pooled
+// client to a function for the duration of its call (e.g. to issue a
+// sequence of requests over the same connection). The pool is safe for
+// concurrent use.
+type ModbusClientPool struct {
+	*clientPoolMembers
+	// unit id and encoding of requests issued through this pool (or unit
+	// view, see Unit())
+	unitId     uint8
+	endianness Endianness
+	wordOrder  WordOrder
+}
+
+// clientPoolMembers holds the clients of a pool, shared by the pool and
+// all its unit views.
+type clientPoolMembers struct {
+	logger  *logger
+	clients []*ModbusClient
+	// pooled clients not running any request
+	idle chan *ModbusClient
+
+	lock sync.Mutex
+	open bool
+}
+
+// NewClientPool creates, configures and returns a pool of size clients,
+// all configured with conf. Serial (rtu and ascii) clients cannot be pooled.
+func NewClientPool(conf *ClientConfiguration, size uint) (mp *ModbusClientPool, err error) {
+	var mc *ModbusClient
+
+	mp = &ModbusClientPool{
+		clientPoolMembers: &clientPoolMembers{
+			logger: newLogger(
+				fmt.Sprintf("modbus-client-pool(%s)", conf.URL), conf.Logger),
+			idle: make(chan *ModbusClient, size),
+		},
+		unitId:     1,
+		endianness: BIG_ENDIAN,
+		wordOrder:  HIGH_WORD_FIRST,
+	}
+
+	if size == 0 {
+		mp.logger.Errorf("pool size must be at least 1")
+		err = ErrConfigurationError
+		return
+	}
+
+	if strings.HasPrefix(conf.URL, "rtu://") || strings.HasPrefix(conf.URL, "ascii://") {
+		mp.logger.Errorf("serial links cannot be pooled")
+		err = ErrConfigurationError
+		return
+	}
+
+	for i := uint(0); i < size; i++ {
+		mc, err = NewClient(conf)
+		if err != nil {
+			return
+		}
+
+		mp.clients = append(mp.clients, mc)
+		mp.idle <- mc
+	}
+
+	return
+}
+
+// Opens all pooled clients. Fails if any of them fails to open, in which
+// case all pooled clients are closed.
+func (mp *ModbusClientPool) Open() (err error) {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	for _, mc := range mp.clients {
+		err = mc.Open()
+		if err != nil {
+			for _, mc := range mp.clients {
+				mc.Close()
+			}
+			return
+		}
+	}
+
+	mp.open = true
+
+	return
+}
+
+// Closes all pooled clients, waiting for requests in flight to complete.
+// Subsequent requests fail with ErrNotConnected until Open() is called.
+func (mp *ModbusClientPool) Close() (err error) {
+	mp.lock.Lock()
+	mp.open = false
+	mp.lock.Unlock()
+
+	for _, mc := range mp.clients {
+		if closeErr := mc.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+
+	return
+}
+
+// Sets the unit id of subsequent requests.
+func (mp *ModbusClientPool) SetUnitId(id uint8) error {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	mp.unitId = id
+
+	return nil
+}
+
+// Returns a view of the pool addressing unit id, with the encoding of the
+// pool at the time of the call.
+// Views share the pooled clients but have their own unit id and encoding
+// (see ModbusClient.Unit()).
+func (mp *ModbusClientPool) Unit(id uint8) (view *ModbusClientPool) {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	view = &ModbusClientPool{
+		clientPoolMembers: mp.clientPoolMembers,
+		unitId:            id,
+		endianness:        mp.endianness,
+		wordOrder:         mp.wordOrder,
+	}
+
+	return
+}
+
+// Sets the encoding (endianness and word ordering) of subsequent requests.
+func (mp *ModbusClientPool) SetEncoding(endianness Endianness, wordOrder WordOrder) error {
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+
+	if endianness != BIG_ENDIAN && endianness != LITTLE_ENDIAN {
+		mp.logger.Errorf("unknown endianness value %v", endianness)
+		return ErrUnexpectedParameters
+	}
+	if wordOrder != HIGH_WORD_FIRST && wordOrder != LOW_WORD_FIRST {
+		mp.logger.Errorf("unknown word order value %v", wordOrder)
+		return ErrUnexpectedParameters
+	}
+
+	mp.endianness = endianness
+	mp.wordOrder = wordOrder
+
+	return nil
+}
+
+// Runs fn over an idle pooled client, waiting for one to be available or
+// for ctx to be done, whichever happens first, and returns the error
+// returned by fn.
+// The client is set up with the unit id and encoding of the pool (or view)
+// and is taken out of the pool until fn returns: fn may issue any number
+// of requests through it, but must neither retain it nor call its Open()
+// and Close() methods.
+//
+//	err = pool.Do(ctx, func(c *modbus.ModbusClient) (err error) {
+//		regs, err = c.ReadRegistersCtx(ctx, 100, 4, modbus.HOLDING_REGISTER)
+//		return
+//	})
+func (mp *ModbusClientPool) Do(ctx context.Context, fn func(client *ModbusClient) error) (err error) {
+	var mc *ModbusClient
+
+	mc, err = mp.acquire(ctx)
+	if err != nil {
+		return
+	}
+	defer func() { mp.idle <- mc }()
+
+	err = fn(mc)
+
+	// close clients whose link broke, for them to be re-opened on next use
+	// (clients with a reconnect policy take care of this on their own)
+	if err != nil && mc.conf.Reconnect == nil && mc.isStreamLink() && isLinkError(err) {
+		mp.logger.Warningf("closing client after link error: %v", err)
+		mc.Close()
+	}
+
+	return
+}
+
+// Takes an idle client out of the pool and readies it for use: the client
+// is re-opened if disconnected and set up with the unit id and encoding of
+// the pool.
+func (mp *ModbusClientPool) acquire(ctx context.Context) (mc *ModbusClient, err error) {
+	var unitId uint8
+	var endianness Endianness
+	var wordOrder WordOrder
+
+	// never take a client on behalf of an abandoned request
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	select {
+	case mc = <-mp.idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+
+	mp.lock.Lock()
+	if !mp.open {
+		err = ErrNotConnected
+	}
+	unitId = mp.unitId
+	endianness = mp.endianness
+	wordOrder = mp.wordOrder
+	mp.lock.Unlock()
+
+	// replace broken connections (clients reconnecting on their own, as
+	// per their reconnect policy, are left alone)
+	if err == nil && mc.State() == STATE_CLOSED {
+		mp.logger.Warning("re-opening disconnected client")
+		err = mc.Open()
+	}
+
+	if err != nil {
+		mp.idle <- mc
+		mc = nil
+		return
+	}
+
+	mc.SetUnitId(unitId)
+	mc.SetEncoding(endianness, wordOrder)
+
+	return
+}
+
+// ReadCoils is like ModbusClient.ReadCoils, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadCoils(addr uint16, quantity uint16) ([]bool, error) {
+	return mp.ReadCoilsCtx(context.Background(), addr, quantity)
+}
+
+// ReadCoilsCtx is like ModbusClient.ReadCoilsCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadCoilsCtx(ctx context.Context, addr uint16, quantity uint16) (res []bool, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadCoilsCtx(ctx, addr, quantity)
+		return
+	})
+
+	return
+}
+
+// ReadCoil is like ModbusClient.ReadCoil, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadCoil(addr uint16) (value bool, err error) {
+	return mp.ReadCoilCtx(context.Background(), addr)
+}
+
+// ReadCoilCtx is like ModbusClient.ReadCoilCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadCoilCtx(ctx context.Context, addr uint16) (value bool, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		value, err = mc.ReadCoilCtx(ctx, addr)
+		return
+	})
+
+	return
+}
+
+// ReadDiscreteInputs is like ModbusClient.ReadDiscreteInputs, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadDiscreteInputs(addr uint16, quantity uint16) ([]bool, error) {
+	return mp.ReadDiscreteInputsCtx(context.Background(), addr, quantity)
+}
+
+// ReadDiscreteInputsCtx is like ModbusClient.ReadDiscreteInputsCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadDiscreteInputsCtx(ctx context.Context, addr uint16, quantity uint16) (res []bool, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadDiscreteInputsCtx(ctx, addr, quantity)
+		return
+	})
+
+	return
+}
+
+// ReadDiscreteInput is like ModbusClient.ReadDiscreteInput, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadDiscreteInput(addr uint16) (value bool, err error) {
+	return mp.ReadDiscreteInputCtx(context.Background(), addr)
+}
+
+// ReadDiscreteInputCtx is like ModbusClient.ReadDiscreteInputCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadDiscreteInputCtx(ctx context.Context, addr uint16) (value bool, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		value, err = mc.ReadDiscreteInputCtx(ctx, addr)
+		return
+	})
+
+	return
+}
+
+// ReadRegisters is like ModbusClient.ReadRegisters, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadRegisters(addr uint16, quantity uint16, regType RegType) ([]uint16, error) {
+	return mp.ReadRegistersCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadRegistersCtx is like ModbusClient.ReadRegistersCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadRegistersCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (res []uint16, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadRegistersCtx(ctx, addr, quantity, regType)
+		return
+	})
+
+	return
+}
+
+// ReadRegister is like ModbusClient.ReadRegister, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadRegister(addr uint16, regType RegType) (uint16, error) {
+	return mp.ReadRegisterCtx(context.Background(), addr, regType)
+}
+
+// ReadRegisterCtx is like ModbusClient.ReadRegisterCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadRegisterCtx(ctx context.Context, addr uint16, regType RegType) (res uint16, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadRegisterCtx(ctx, addr, regType)
+		return
+	})
+
+	return
+}
+
+// ReadUint32s is like ModbusClient.ReadUint32s, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadUint32s(addr uint16, quantity uint16, regType RegType) ([]uint32, error) {
+	return mp.ReadUint32sCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadUint32sCtx is like ModbusClient.ReadUint32sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadUint32sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (res []uint32, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadUint32sCtx(ctx, addr, quantity, regType)
+		return
+	})
+
+	return
+}
+
+// ReadUint32 is like ModbusClient.ReadUint32, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadUint32(addr uint16, regType RegType) (uint32, error) {
+	return mp.ReadUint32Ctx(context.Background(), addr, regType)
+}
+
+// ReadUint32Ctx is like ModbusClient.ReadUint32Ctx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadUint32Ctx(ctx context.Context, addr uint16, regType RegType) (res uint32, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadUint32Ctx(ctx, addr, regType)
+		return
+	})
+
+	return
+}
+
+// ReadFloat32s is like ModbusClient.ReadFloat32s, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFloat32s(addr uint16, quantity uint16, regType RegType) ([]float32, error) {
+	return mp.ReadFloat32sCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadFloat32sCtx is like ModbusClient.ReadFloat32sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFloat32sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (res []float32, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadFloat32sCtx(ctx, addr, quantity, regType)
+		return
+	})
+
+	return
+}
+
+// ReadFloat32 is like ModbusClient.ReadFloat32, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFloat32(addr uint16, regType RegType) (float32, error) {
+	return mp.ReadFloat32Ctx(context.Background(), addr, regType)
+}
+
+// ReadFloat32Ctx is like ModbusClient.ReadFloat32Ctx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFloat32Ctx(ctx context.Context, addr uint16, regType RegType) (res float32, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadFloat32Ctx(ctx, addr, regType)
+		return
+	})
+
+	return
+}
+
+// ReadUint64s is like ModbusClient.ReadUint64s, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadUint64s(addr uint16, quantity uint16, regType RegType) ([]uint64, error) {
+	return mp.ReadUint64sCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadUint64sCtx is like ModbusClient.ReadUint64sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadUint64sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (res []uint64, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadUint64sCtx(ctx, addr, quantity, regType)
+		return
+	})
+
+	return
+}
+
+// ReadUint64 is like ModbusClient.ReadUint64, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadUint64(addr uint16, regType RegType) (uint64, error) {
+	return mp.ReadUint64Ctx(context.Background(), addr, regType)
+}
+
+// ReadUint64Ctx is like ModbusClient.ReadUint64Ctx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadUint64Ctx(ctx context.Context, addr uint16, regType RegType) (res uint64, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadUint64Ctx(ctx, addr, regType)
+		return
+	})
+
+	return
+}
+
+// ReadFloat64s is like ModbusClient.ReadFloat64s, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFloat64s(addr uint16, quantity uint16, regType RegType) ([]float64, error) {
+	return mp.ReadFloat64sCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadFloat64sCtx is like ModbusClient.ReadFloat64sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFloat64sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (res []float64, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadFloat64sCtx(ctx, addr, quantity, regType)
+		return
+	})
+
+	return
+}
+
+// ReadFloat64 is like ModbusClient.ReadFloat64, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFloat64(addr uint16, regType RegType) (float64, error) {
+	return mp.ReadFloat64Ctx(context.Background(), addr, regType)
+}
+
+// ReadFloat64Ctx is like ModbusClient.ReadFloat64Ctx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFloat64Ctx(ctx context.Context, addr uint16, regType RegType) (res float64, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadFloat64Ctx(ctx, addr, regType)
+		return
+	})
+
+	return
+}
+
+// ReadBytes is like ModbusClient.ReadBytes, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadBytes(addr uint16, quantity uint16, regType RegType) ([]byte, error) {
+	return mp.ReadBytesCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadBytesCtx is like ModbusClient.ReadBytesCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadBytesCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (res []byte, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadBytesCtx(ctx, addr, quantity, regType)
+		return
+	})
+
+	return
+}
+
+// ReadRawBytes is like ModbusClient.ReadRawBytes, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadRawBytes(addr uint16, quantity uint16, regType RegType) ([]byte, error) {
+	return mp.ReadRawBytesCtx(context.Background(), addr, quantity, regType)
+}
+
+// ReadRawBytesCtx is like ModbusClient.ReadRawBytesCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadRawBytesCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (res []byte, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadRawBytesCtx(ctx, addr, quantity, regType)
+		return
+	})
+
+	return
+}
+
+// ReadFIFOQueue is like ModbusClient.ReadFIFOQueue, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFIFOQueue(addr uint16) ([]uint16, error) {
+	return mp.ReadFIFOQueueCtx(context.Background(), addr)
+}
+
+// ReadFIFOQueueCtx is like ModbusClient.ReadFIFOQueueCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFIFOQueueCtx(ctx context.Context, addr uint16) (res []uint16, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadFIFOQueueCtx(ctx, addr)
+		return
+	})
+
+	return
+}
+
+// ReadFileRecords is like ModbusClient.ReadFileRecords, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFileRecords(records []FileRecord) ([][]uint16, error) {
+	return mp.ReadFileRecordsCtx(context.Background(), records)
+}
+
+// ReadFileRecordsCtx is like ModbusClient.ReadFileRecordsCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFileRecordsCtx(ctx context.Context, records []FileRecord) (res [][]uint16, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadFileRecordsCtx(ctx, records)
+		return
+	})
+
+	return
+}
+
+// ReadFileRecord is like ModbusClient.ReadFileRecord, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFileRecord(fileNumber uint16, recordNumber uint16, length uint16) ([]uint16, error) {
+	return mp.ReadFileRecordCtx(context.Background(), fileNumber, recordNumber, length)
+}
+
+// ReadFileRecordCtx is like ModbusClient.ReadFileRecordCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadFileRecordCtx(ctx context.Context, fileNumber uint16, recordNumber uint16, length uint16) (res []uint16, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadFileRecordCtx(ctx, fileNumber, recordNumber, length)
+		return
+	})
+
+	return
+}
+
+// WriteFileRecords is like ModbusClient.WriteFileRecords, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFileRecords(records []FileRecord) error {
+	return mp.WriteFileRecordsCtx(context.Background(), records)
+}
+
+// WriteFileRecordsCtx is like ModbusClient.WriteFileRecordsCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFileRecordsCtx(ctx context.Context, records []FileRecord) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteFileRecordsCtx(ctx, records)
+		return
+	})
+
+	return
+}
+
+// WriteFileRecord is like ModbusClient.WriteFileRecord, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFileRecord(fileNumber uint16, recordNumber uint16, values []uint16) error {
+	return mp.WriteFileRecordCtx(context.Background(), fileNumber, recordNumber, values)
+}
+
+// WriteFileRecordCtx is like ModbusClient.WriteFileRecordCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFileRecordCtx(ctx context.Context, fileNumber uint16, recordNumber uint16, values []uint16) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteFileRecordCtx(ctx, fileNumber, recordNumber, values)
+		return
+	})
+
+	return
+}
+
+// ReadDeviceIdentification is like ModbusClient.ReadDeviceIdentification, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadDeviceIdentification(category ReadDeviceIdCode, objectId uint8) (map[uint8]string, error) {
+	return mp.ReadDeviceIdentificationCtx(context.Background(), category, objectId)
+}
+
+// ReadDeviceIdentificationCtx is like ModbusClient.ReadDeviceIdentificationCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadDeviceIdentificationCtx(ctx context.Context, category ReadDeviceIdCode, objectId uint8) (res map[uint8]string, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadDeviceIdentificationCtx(ctx, category, objectId)
+		return
+	})
+
+	return
+}
+
+// ReadExceptionStatus is like ModbusClient.ReadExceptionStatus, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadExceptionStatus() (status uint8, err error) {
+	return mp.ReadExceptionStatusCtx(context.Background())
+}
+
+// ReadExceptionStatusCtx is like ModbusClient.ReadExceptionStatusCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadExceptionStatusCtx(ctx context.Context) (status uint8, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		status, err = mc.ReadExceptionStatusCtx(ctx)
+		return
+	})
+
+	return
+}
+
+// Diagnostics is like ModbusClient.Diagnostics, run over one of the pooled clients.
+func (mp *ModbusClientPool) Diagnostics(subFunction DiagSubFunction, data []byte) (res []byte, err error) {
+	return mp.DiagnosticsCtx(context.Background(), subFunction, data)
+}
+
+// DiagnosticsCtx is like ModbusClient.DiagnosticsCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) DiagnosticsCtx(ctx context.Context, subFunction DiagSubFunction, data []byte) (res []byte, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.DiagnosticsCtx(ctx, subFunction, data)
+		return
+	})
+
+	return
+}
+
+// ReturnQueryData is like ModbusClient.ReturnQueryData, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReturnQueryData(data []byte) (err error) {
+	return mp.ReturnQueryDataCtx(context.Background(), data)
+}
+
+// ReturnQueryDataCtx is like ModbusClient.ReturnQueryDataCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReturnQueryDataCtx(ctx context.Context, data []byte) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.ReturnQueryDataCtx(ctx, data)
+		return
+	})
+
+	return
+}
+
+// RestartCommunications is like ModbusClient.RestartCommunications, run over one of the pooled clients.
+func (mp *ModbusClientPool) RestartCommunications(clearEventLog bool) (err error) {
+	return mp.RestartCommunicationsCtx(context.Background(), clearEventLog)
+}
+
+// RestartCommunicationsCtx is like ModbusClient.RestartCommunicationsCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) RestartCommunicationsCtx(ctx context.Context, clearEventLog bool) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.RestartCommunicationsCtx(ctx, clearEventLog)
+		return
+	})
+
+	return
+}
+
+// ReadDiagnosticRegister is like ModbusClient.ReadDiagnosticRegister, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadDiagnosticRegister() (value uint16, err error) {
+	return mp.ReadDiagnosticRegisterCtx(context.Background())
+}
+
+// ReadDiagnosticRegisterCtx is like ModbusClient.ReadDiagnosticRegisterCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadDiagnosticRegisterCtx(ctx context.Context) (value uint16, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		value, err = mc.ReadDiagnosticRegisterCtx(ctx)
+		return
+	})
+
+	return
+}
+
+// ClearDiagnosticCounters is like ModbusClient.ClearDiagnosticCounters, run over one of the pooled clients.
+func (mp *ModbusClientPool) ClearDiagnosticCounters() (err error) {
+	return mp.ClearDiagnosticCountersCtx(context.Background())
+}
+
+// ClearDiagnosticCountersCtx is like ModbusClient.ClearDiagnosticCountersCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ClearDiagnosticCountersCtx(ctx context.Context) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.ClearDiagnosticCountersCtx(ctx)
+		return
+	})
+
+	return
+}
+
+// ReadDiagnosticCounter is like ModbusClient.ReadDiagnosticCounter, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadDiagnosticCounter(counter DiagSubFunction) (value uint16, err error) {
+	return mp.ReadDiagnosticCounterCtx(context.Background(), counter)
+}
+
+// ReadDiagnosticCounterCtx is like ModbusClient.ReadDiagnosticCounterCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadDiagnosticCounterCtx(ctx context.Context, counter DiagSubFunction) (value uint16, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		value, err = mc.ReadDiagnosticCounterCtx(ctx, counter)
+		return
+	})
+
+	return
+}
+
+// ClearOverrunCounter is like ModbusClient.ClearOverrunCounter, run over one of the pooled clients.
+func (mp *ModbusClientPool) ClearOverrunCounter() (err error) {
+	return mp.ClearOverrunCounterCtx(context.Background())
+}
+
+// ClearOverrunCounterCtx is like ModbusClient.ClearOverrunCounterCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ClearOverrunCounterCtx(ctx context.Context) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.ClearOverrunCounterCtx(ctx)
+		return
+	})
+
+	return
+}
+
+// GetCommEventCounter is like ModbusClient.GetCommEventCounter, run over one of the pooled clients.
+func (mp *ModbusClientPool) GetCommEventCounter() (status uint16, eventCount uint16, err error) {
+	return mp.GetCommEventCounterCtx(context.Background())
+}
+
+// GetCommEventCounterCtx is like ModbusClient.GetCommEventCounterCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) GetCommEventCounterCtx(ctx context.Context) (status uint16, eventCount uint16, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		status, eventCount, err = mc.GetCommEventCounterCtx(ctx)
+		return
+	})
+
+	return
+}
+
+// GetCommEventLog is like ModbusClient.GetCommEventLog, run over one of the pooled clients.
+func (mp *ModbusClientPool) GetCommEventLog() (eventLog *CommEventLog, err error) {
+	return mp.GetCommEventLogCtx(context.Background())
+}
+
+// GetCommEventLogCtx is like ModbusClient.GetCommEventLogCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) GetCommEventLogCtx(ctx context.Context) (eventLog *CommEventLog, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		eventLog, err = mc.GetCommEventLogCtx(ctx)
+		return
+	})
+
+	return
+}
+
+// ReportServerId is like ModbusClient.ReportServerId, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReportServerId() (data []byte, err error) {
+	return mp.ReportServerIdCtx(context.Background())
+}
+
+// ReportServerIdCtx is like ModbusClient.ReportServerIdCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReportServerIdCtx(ctx context.Context) (data []byte, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		data, err = mc.ReportServerIdCtx(ctx)
+		return
+	})
+
+	return
+}
+
+// ExecuteRawRequest is like ModbusClient.ExecuteRawRequest, run over one of the pooled clients.
+func (mp *ModbusClientPool) ExecuteRawRequest(req *RawRequest) (res *RawResponse, err error) {
+	return mp.ExecuteRawRequestCtx(context.Background(), req)
+}
+
+// ExecuteRawRequestCtx is like ModbusClient.ExecuteRawRequestCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ExecuteRawRequestCtx(ctx context.Context, req *RawRequest) (res *RawResponse, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ExecuteRawRequestCtx(ctx, req)
+		return
+	})
+
+	return
+}
+
+// WriteCoil is like ModbusClient.WriteCoil, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteCoil(addr uint16, value bool) error {
+	return mp.WriteCoilCtx(context.Background(), addr, value)
+}
+
+// WriteCoilCtx is like ModbusClient.WriteCoilCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteCoilCtx(ctx context.Context, addr uint16, value bool) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteCoilCtx(ctx, addr, value)
+		return
+	})
+
+	return
+}
+
+// WriteCoils is like ModbusClient.WriteCoils, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteCoils(addr uint16, values []bool) error {
+	return mp.WriteCoilsCtx(context.Background(), addr, values)
+}
+
+// WriteCoilsCtx is like ModbusClient.WriteCoilsCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteCoilsCtx(ctx context.Context, addr uint16, values []bool) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteCoilsCtx(ctx, addr, values)
+		return
+	})
+
+	return
+}
+
+// WriteRegister is like ModbusClient.WriteRegister, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteRegister(addr uint16, value uint16) error {
+	return mp.WriteRegisterCtx(context.Background(), addr, value)
+}
+
+// WriteRegisterCtx is like ModbusClient.WriteRegisterCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteRegisterCtx(ctx context.Context, addr uint16, value uint16) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteRegisterCtx(ctx, addr, value)
+		return
+	})
+
+	return
+}
+
+// WriteRegisters is like ModbusClient.WriteRegisters, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteRegisters(addr uint16, values []uint16) error {
+	return mp.WriteRegistersCtx(context.Background(), addr, values)
+}
+
+// WriteRegistersCtx is like ModbusClient.WriteRegistersCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteRegistersCtx(ctx context.Context, addr uint16, values []uint16) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteRegistersCtx(ctx, addr, values)
+		return
+	})
+
+	return
+}
+
+// WriteUint32s is like ModbusClient.WriteUint32s, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteUint32s(addr uint16, values []uint32) error {
+	return mp.WriteUint32sCtx(context.Background(), addr, values)
+}
+
+// WriteUint32sCtx is like ModbusClient.WriteUint32sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteUint32sCtx(ctx context.Context, addr uint16, values []uint32) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteUint32sCtx(ctx, addr, values)
+		return
+	})
+
+	return
+}
+
+// WriteUint32 is like ModbusClient.WriteUint32, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteUint32(addr uint16, value uint32) error {
+	return mp.WriteUint32Ctx(context.Background(), addr, value)
+}
+
+// WriteUint32Ctx is like ModbusClient.WriteUint32Ctx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteUint32Ctx(ctx context.Context, addr uint16, value uint32) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteUint32Ctx(ctx, addr, value)
+		return
+	})
+
+	return
+}
+
+// WriteFloat32s is like ModbusClient.WriteFloat32s, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFloat32s(addr uint16, values []float32) error {
+	return mp.WriteFloat32sCtx(context.Background(), addr, values)
+}
+
+// WriteFloat32sCtx is like ModbusClient.WriteFloat32sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFloat32sCtx(ctx context.Context, addr uint16, values []float32) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteFloat32sCtx(ctx, addr, values)
+		return
+	})
+
+	return
+}
+
+// WriteFloat32 is like ModbusClient.WriteFloat32, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFloat32(addr uint16, value float32) (err error) {
+	return mp.WriteFloat32Ctx(context.Background(), addr, value)
+}
+
+// WriteFloat32Ctx is like ModbusClient.WriteFloat32Ctx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFloat32Ctx(ctx context.Context, addr uint16, value float32) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteFloat32Ctx(ctx, addr, value)
+		return
+	})
+
+	return
+}
+
+// WriteUint64s is like ModbusClient.WriteUint64s, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteUint64s(addr uint16, values []uint64) (err error) {
+	return mp.WriteUint64sCtx(context.Background(), addr, values)
+}
+
+// WriteUint64sCtx is like ModbusClient.WriteUint64sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteUint64sCtx(ctx context.Context, addr uint16, values []uint64) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteUint64sCtx(ctx, addr, values)
+		return
+	})
+
+	return
+}
+
+// WriteUint64 is like ModbusClient.WriteUint64, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteUint64(addr uint16, value uint64) error {
+	return mp.WriteUint64Ctx(context.Background(), addr, value)
+}
+
+// WriteUint64Ctx is like ModbusClient.WriteUint64Ctx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteUint64Ctx(ctx context.Context, addr uint16, value uint64) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteUint64Ctx(ctx, addr, value)
+		return
+	})
+
+	return
+}
+
+// WriteFloat64s is like ModbusClient.WriteFloat64s, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFloat64s(addr uint16, values []float64) error {
+	return mp.WriteFloat64sCtx(context.Background(), addr, values)
+}
+
+// WriteFloat64sCtx is like ModbusClient.WriteFloat64sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFloat64sCtx(ctx context.Context, addr uint16, values []float64) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteFloat64sCtx(ctx, addr, values)
+		return
+	})
+
+	return
+}
+
+// WriteFloat64 is like ModbusClient.WriteFloat64, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFloat64(addr uint16, value float64) error {
+	return mp.WriteFloat64Ctx(context.Background(), addr, value)
+}
+
+// WriteFloat64Ctx is like ModbusClient.WriteFloat64Ctx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteFloat64Ctx(ctx context.Context, addr uint16, value float64) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteFloat64Ctx(ctx, addr, value)
+		return
+	})
+
+	return
+}
+
+// ReadWriteRegisters is like ModbusClient.ReadWriteRegisters, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadWriteRegisters(readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint16) ([]uint16, error) {
+	return mp.ReadWriteRegistersCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+}
+
+// ReadWriteRegistersCtx is like ModbusClient.ReadWriteRegistersCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadWriteRegistersCtx(ctx context.Context, readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint16) (res []uint16, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadWriteRegistersCtx(ctx, readAddr, readQuantity, writeAddr, values)
+		return
+	})
+
+	return
+}
+
+// ReadWriteUint32s is like ModbusClient.ReadWriteUint32s, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadWriteUint32s(readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint32) ([]uint32, error) {
+	return mp.ReadWriteUint32sCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+}
+
+// ReadWriteUint32sCtx is like ModbusClient.ReadWriteUint32sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadWriteUint32sCtx(ctx context.Context, readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint32) (res []uint32, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadWriteUint32sCtx(ctx, readAddr, readQuantity, writeAddr, values)
+		return
+	})
+
+	return
+}
+
+// ReadWriteFloat32s is like ModbusClient.ReadWriteFloat32s, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadWriteFloat32s(readAddr uint16, readQuantity uint16, writeAddr uint16, values []float32) ([]float32, error) {
+	return mp.ReadWriteFloat32sCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+}
+
+// ReadWriteFloat32sCtx is like ModbusClient.ReadWriteFloat32sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadWriteFloat32sCtx(ctx context.Context, readAddr uint16, readQuantity uint16, writeAddr uint16, values []float32) (res []float32, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadWriteFloat32sCtx(ctx, readAddr, readQuantity, writeAddr, values)
+		return
+	})
+
+	return
+}
+
+// ReadWriteUint64s is like ModbusClient.ReadWriteUint64s, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadWriteUint64s(readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint64) ([]uint64, error) {
+	return mp.ReadWriteUint64sCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+}
+
+// ReadWriteUint64sCtx is like ModbusClient.ReadWriteUint64sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadWriteUint64sCtx(ctx context.Context, readAddr uint16, readQuantity uint16, writeAddr uint16, values []uint64) (res []uint64, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadWriteUint64sCtx(ctx, readAddr, readQuantity, writeAddr, values)
+		return
+	})
+
+	return
+}
+
+// ReadWriteFloat64s is like ModbusClient.ReadWriteFloat64s, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadWriteFloat64s(readAddr uint16, readQuantity uint16, writeAddr uint16, values []float64) ([]float64, error) {
+	return mp.ReadWriteFloat64sCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+}
+
+// ReadWriteFloat64sCtx is like ModbusClient.ReadWriteFloat64sCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadWriteFloat64sCtx(ctx context.Context, readAddr uint16, readQuantity uint16, writeAddr uint16, values []float64) (res []float64, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadWriteFloat64sCtx(ctx, readAddr, readQuantity, writeAddr, values)
+		return
+	})
+
+	return
+}
+
+// MaskWriteRegister is like ModbusClient.MaskWriteRegister, run over one of the pooled clients.
+func (mp *ModbusClientPool) MaskWriteRegister(addr uint16, andMask uint16, orMask uint16) error {
+	return mp.MaskWriteRegisterCtx(context.Background(), addr, andMask, orMask)
+}
+
+// MaskWriteRegisterCtx is like ModbusClient.MaskWriteRegisterCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) MaskWriteRegisterCtx(ctx context.Context, addr uint16, andMask uint16, orMask uint16) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.MaskWriteRegisterCtx(ctx, addr, andMask, orMask)
+		return
+	})
+
+	return
+}
+
+// WriteBytes is like ModbusClient.WriteBytes, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteBytes(addr uint16, values []byte) error {
+	return mp.WriteBytesCtx(context.Background(), addr, values)
+}
+
+// WriteBytesCtx is like ModbusClient.WriteBytesCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteBytesCtx(ctx context.Context, addr uint16, values []byte) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteBytesCtx(ctx, addr, values)
+		return
+	})
+
+	return
+}
+
+// WriteRawBytes is like ModbusClient.WriteRawBytes, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteRawBytes(addr uint16, values []byte) error {
+	return mp.WriteRawBytesCtx(context.Background(), addr, values)
+}
+
+// WriteRawBytesCtx is like ModbusClient.WriteRawBytesCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) WriteRawBytesCtx(ctx context.Context, addr uint16, values []byte) (err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		err = mc.WriteRawBytesCtx(ctx, addr, values)
+		return
+	})
+
+	return
+}
+
+// ReadTags is like ModbusClient.ReadTags, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadTags(plan *ReadPlan) (map[string]any, error) {
+	return mp.ReadTagsCtx(context.Background(), plan)
+}
+
+// ReadTagsCtx is like ModbusClient.ReadTagsCtx, run over one of the pooled clients.
+func (mp *ModbusClientPool) ReadTagsCtx(ctx context.Context, plan *ReadPlan) (res map[string]any, err error) {
+	err = mp.Do(ctx, func(mc *ModbusClient) (err error) {
+		res, err = mc.ReadTagsCtx(ctx, plan)
+		return
+	})
+
+	return
+}
@@ -0,0 +1,216 @@
+package modbus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientPool(t *testing.T) {
+	var server *ModbusServer
+	var pool *ModbusClientPool
+	var err error
+	var wg sync.WaitGroup
+	var ts time.Time
+	var regs []uint16
+	var value uint32
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	_, err = NewClientPool(&ClientConfiguration{
+		URL: "rtu:///dev/ttyUSB0",
+	}, 2)
+	if err != ErrConfigurationError {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	_, err = NewClientPool(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+	}, 0)
+	if err != ErrConfigurationError {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 3,
+	}, &slowTestHandler{delay: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	pool, err = NewClientPool(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+	}, 3)
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+
+	// requests made before opening the pool should fail
+	_, err = pool.ReadCoil(0)
+	if err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got: %v", err)
+	}
+
+	err = pool.Open()
+	if err != nil {
+		t.Fatalf("pool.Open() should have succeeded, got: %v", err)
+	}
+	pool.SetUnitId(9)
+
+	err = pool.WriteRegisters(0, []uint16{0x1234, 0x5678})
+	if err != nil {
+		t.Errorf("pool.WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	// Do() should lend a client set up with the unit id of the pool
+	err = pool.Do(context.Background(), func(c *ModbusClient) (err error) {
+		regs, err = c.ReadRegisters(0, 2, HOLDING_REGISTER)
+		if err != nil {
+			return
+		}
+
+		return c.WriteRegister(1, regs[1])
+	})
+	if err != nil {
+		t.Errorf("pool.Do() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0x1234 || regs[1] != 0x5678 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	// pools should be usable wherever a Client is expected
+	for i := uint32(1); i <= 2; i++ {
+		value, err = readAndIncrement(pool, 4)
+		if err != nil {
+			t.Errorf("readAndIncrement() should have succeeded, got: %v", err)
+		}
+		if value != i {
+			t.Errorf("expected %v, got %v", i, value)
+		}
+	}
+
+	// 6 slow requests should be spread across the 3 connections and
+	// complete in 2 rounds
+	ts = time.Now()
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			reg, err := pool.ReadRegister(9, HOLDING_REGISTER)
+			if err != nil {
+				t.Errorf("pool.ReadRegister() should have succeeded, got: %v", err)
+			}
+			if reg != 0 {
+				t.Errorf("expected 0, got 0x%04x", reg)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if time.Since(ts) > 350*time.Millisecond {
+		t.Errorf("requests should have completed in ~200ms, took %v", time.Since(ts))
+	}
+
+	// waiting for an idle client should be bounded by the context
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pool.ReadRegister(9, HOLDING_REGISTER)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, err = pool.ReadRegistersCtx(ctx, 0, 2, HOLDING_REGISTER)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	wg.Wait()
+
+	// broken connections should be replaced
+	server.lock.Lock()
+	for _, sock := range server.tcpClients {
+		sock.Close()
+	}
+	server.lock.Unlock()
+
+	for i := 0; i < 6; i++ {
+		regs, err = pool.ReadRegisters(0, 2, HOLDING_REGISTER)
+		if err != nil {
+			// each connection fails once when first used after the drop
+			continue
+		}
+		if len(regs) != 2 || regs[0] != 0x1234 || regs[1] != 0x5678 {
+			t.Errorf("unexpected register values: %v", regs)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		regs, err = pool.ReadRegisters(0, 2, HOLDING_REGISTER)
+		if err != nil {
+			t.Errorf("pool.ReadRegisters() should have succeeded, got: %v", err)
+		}
+	}
+
+	// the encoding of the pool should apply to all clients
+	pool.SetEncoding(LITTLE_ENDIAN, LOW_WORD_FIRST)
+	for i := 0; i < 3; i++ {
+		regs, err = pool.ReadRegisters(0, 1, HOLDING_REGISTER)
+		if err != nil {
+			t.Errorf("pool.ReadRegisters() should have succeeded, got: %v", err)
+		}
+		if len(regs) != 1 || regs[0] != 0x3412 {
+			t.Errorf("unexpected register values: %v", regs)
+		}
+	}
+
+	err = pool.SetEncoding(LITTLE_ENDIAN, 0)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// unit views should have their own unit id and encoding
+	_, err = pool.Unit(8).ReadRegisters(0, 1, HOLDING_REGISTER)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	view := pool.Unit(9)
+	view.SetEncoding(BIG_ENDIAN, HIGH_WORD_FIRST)
+	regs, err = view.ReadRegisters(0, 1, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("view.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 1 || regs[0] != 0x1234 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	regs, err = pool.ReadRegisters(0, 1, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("pool.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 1 || regs[0] != 0x3412 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	pool.Close()
+
+	// requests made after closing the pool should fail
+	_, err = pool.ReadCoil(0)
+	if err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got: %v", err)
+	}
+
+	return
+}
@@ -0,0 +1,105 @@
+package modbus
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestClientUnitViews(t *testing.T) {
+	var client *ModbusClient
+	var view *ModbusClient
+	var handler *tcpTestHandler
+	var err error
+	var wg sync.WaitGroup
+	var value uint32
+
+	handler = &tcpTestHandler{}
+	handler.holding[0] = 0x1234
+	handler.holding[1] = 0x5678
+
+	client, err = NewFakeClient(handler)
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	// goroutines polling different units over the same connection should
+	// not step on each other's unit id
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+
+			unit := client.Unit(9)
+			for j := 0; j < 20; j++ {
+				_, err := unit.ReadRegisters(0, 2, HOLDING_REGISTER)
+				if err != nil {
+					t.Errorf("ReadRegisters() on unit 9 should have succeeded, got: %v", err)
+					return
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			unit := client.Unit(8)
+			for j := 0; j < 20; j++ {
+				_, err := unit.ReadRegisters(0, 2, HOLDING_REGISTER)
+				if err != ErrIllegalFunction {
+					t.Errorf("expected ErrIllegalFunction on unit 8, got: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the unit id of the client itself should be left untouched
+	_, err = client.ReadRegisters(0, 2, HOLDING_REGISTER)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	// views should inherit the encoding of the client...
+	client.SetEncoding(BIG_ENDIAN, LOW_WORD_FIRST)
+	view = client.Unit(9)
+
+	value, err = view.ReadUint32(0, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadUint32() should have succeeded, got: %v", err)
+	}
+	if value != 0x56781234 {
+		t.Errorf("expected 0x56781234, got 0x%08x", value)
+	}
+
+	// ... then keep their own
+	view.SetEncoding(BIG_ENDIAN, HIGH_WORD_FIRST)
+	client.SetUnitId(9)
+
+	value, err = view.ReadUint32(0, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadUint32() should have succeeded, got: %v", err)
+	}
+	if value != 0x12345678 {
+		t.Errorf("expected 0x12345678, got 0x%08x", value)
+	}
+
+	value, err = client.ReadUint32(0, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadUint32() should have succeeded, got: %v", err)
+	}
+	if value != 0x56781234 {
+		t.Errorf("expected 0x56781234, got 0x%08x", value)
+	}
+
+	// closing a view should close the shared connection
+	view.Close()
+
+	_, err = client.ReadUint32(0, HOLDING_REGISTER)
+	if err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got: %v", err)
+	}
+
+	return
+}
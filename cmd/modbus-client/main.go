@@ -7,6 +7,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -37,10 +38,10 @@ func main() {
 	var runList []operation
 
 	flag.StringVar(&target, "target", "", "target device to connect to (e.g. tcp://somehost:502) [required]")
-	flag.UintVar(&speed, "speed", 19200, "serial bus speed in bps (rtu)")
-	flag.UintVar(&dataBits, "data-bits", 8, "number of bits per character on the serial bus (rtu)")
-	flag.StringVar(&parity, "parity", "none", "parity bit <none|even|odd> on the serial bus (rtu)")
-	flag.UintVar(&stopBits, "stop-bits", 2, "number of stop bits <0|1|2>) on the serial bus (rtu)")
+	flag.UintVar(&speed, "speed", 19200, "serial bus speed in bps (rtu, ascii)")
+	flag.UintVar(&dataBits, "data-bits", 8, "number of bits per character on the serial bus (rtu, ascii)")
+	flag.StringVar(&parity, "parity", "none", "parity bit <none|even|odd> on the serial bus (rtu, ascii)")
+	flag.UintVar(&stopBits, "stop-bits", 2, "number of stop bits <0|1|2>) on the serial bus (rtu, ascii)")
 	flag.StringVar(&timeout, "timeout", "3s", "timeout value")
 	flag.StringVar(&endianness, "endianness", "big", "register endianness <little|big>")
 	flag.StringVar(&wordOrder, "word-order", "highfirst", "word ordering for 32-bit registers <highfirst|hf|lowfirst|lf>")
@@ -1030,6 +1031,9 @@ func performUnitIdScan(client *modbus.ModbusClient) {
 			fmt.Printf("0x%02x (%3v): ok\n", unitId, unitId)
 			countOk++
 
+			// print whatever identification the device is willing to share
+			printDeviceIdentification(client)
+
 		case modbus.ErrRequestTimedOut:
 			countTimeout++
 
@@ -1048,6 +1052,43 @@ func performUnitIdScan(client *modbus.ModbusClient) {
 	return
 }
 
+func printDeviceIdentification(client *modbus.ModbusClient) {
+	var err error
+	var objects map[uint8]string
+	var ids []int
+
+	objectNames := map[uint8]string{
+		modbus.OBJECT_ID_VENDOR_NAME:           "vendor name",
+		modbus.OBJECT_ID_PRODUCT_CODE:          "product code",
+		modbus.OBJECT_ID_MAJOR_MINOR_REVISION:  "revision",
+		modbus.OBJECT_ID_VENDOR_URL:            "vendor url",
+		modbus.OBJECT_ID_PRODUCT_NAME:          "product name",
+		modbus.OBJECT_ID_MODEL_NAME:            "model name",
+		modbus.OBJECT_ID_USER_APPLICATION_NAME: "user application name",
+	}
+
+	// many devices do not support device identification: stay silent if so
+	objects, err = client.ReadDeviceIdentification(modbus.READ_DEVICE_ID_REGULAR, 0x00)
+	if err != nil {
+		return
+	}
+
+	for id := range objects {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		name, ok := objectNames[uint8(id)]
+		if !ok {
+			name = fmt.Sprintf("object 0x%02x", id)
+		}
+		fmt.Printf("\t%s: %q\n", name, objects[uint8(id)])
+	}
+
+	return
+}
+
 func performPing(client *modbus.ModbusClient, count uint16, interval time.Duration) {
 	var err error
 	var okCount uint
@@ -1065,8 +1106,9 @@ func performPing(client *modbus.ModbusClient, count uint16, interval time.Durati
 	startTs = time.Now()
 
 	for run := uint16(0); run < count; run++ {
+		// ask the target to echo the sequence number back
 		ts = time.Now()
-		_, err = client.ReadRegister(0x0000, modbus.HOLDING_REGISTER)
+		err = client.ReturnQueryData([]byte{byte(run >> 8), byte(run)})
 
 		rtt = time.Since(ts)
 		avgRTT += rtt
@@ -1082,7 +1124,7 @@ func performPing(client *modbus.ModbusClient, count uint16, interval time.Durati
 		switch err {
 		// mask illegal data address and illegal function errors since we
 		// only care about getting a response from the target device
-		// (on which holding reg #0 may or may not exist)
+		// (which may not support the diagnostics function code)
 		case nil, modbus.ErrIllegalDataAddress, modbus.ErrIllegalFunction:
 			okCount++
 			fmt.Printf("ok: seq = %v, time: %v\n",
@@ -1251,12 +1293,15 @@ Available commands:
 
   Scans all unit IDs (0 to 255) using a single read input register request. Addresses responding
   positively or with non-timeout errors are shown, while timeouts and gateway timeouts are ignored.
+  Basic and regular device identification objects (vendor name, product code, revision, etc.)
+  are printed for responding devices supporting the read device identification function code.
   This command can be used to find active nodes on RS485 buses, behind gateways or in composite
   devices.
 
 * ping:<count>[:interval]
-  Executes <count> modbus reads (1 holding register at address 0x0000), either back to back or
+  Executes <count> diagnostics return query data (echo) requests, either back to back or
   separated by [interval] if specified, then prints timing and outcome statistics.
+  Devices answering with an illegal function exception are counted as replies.
   This command can be used to troubleshoot network or serial connections.
 
 Register endianness and word order:
@@ -1270,6 +1315,8 @@ Supported transports and associated target schemes:
   - Modbus RTU using a local serial device:               rtu:///path/to/device
   - Modbus RTU over TCP (RTU framing over a TCP socket):  rtuovertcp://host:port
   - Modbus RTU over UDP (RTU framing over an UDP socket): rtuoverudp://host:port
+  - Modbus ASCII using a local serial device:             ascii:///path/to/device
+  - Modbus ASCII over TCP (ASCII framing over TCP):       asciiovertcp://host:port
   - Modbus TCP (MBAP):                                    tcp://host:port
   - Modbus TCP over TLS (MBAPS or Modbus Security):       tcp+tls://host:port
   - Modbus TCP over UDP (MBAP over UDP):                  udp://host:port
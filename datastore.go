@@ -0,0 +1,444 @@
+package modbus
+
+import (
+	"sync"
+)
+
+// Data store configuration object.
+type DataStoreConfiguration struct {
+	// Coils, DiscreteInputs, HoldingRegisters and InputRegisters set the
+	// size of each table: addresses from 0 to size - 1 are served, others
+	// yield ErrIllegalDataAddress (up to 65536 each, defaults to 0)
+	Coils            uint
+	DiscreteInputs   uint
+	HoldingRegisters uint
+	InputRegisters   uint
+	// UnitIds lists the unit ids served by the store, each with its own
+	// set of tables. Requests to other unit ids yield ErrGWPathUnavailable.
+	// If empty, a single set of tables is served to all unit ids.
+	UnitIds []uint8
+	// Endianness and WordOrder set the encoding used by typed accessors
+	// (defaults to BIG_ENDIAN and HIGH_WORD_FIRST)
+	Endianness Endianness
+	WordOrder  WordOrder
+}
+
+// Data store object.
+//
+// A data store is an in-memory request handler, holding the coils,
+// discrete inputs, holding and input registers of one or more units.
+// Values can be read and set from go code through typed accessors (see
+// Unit()) while the store is served to clients. All methods are safe for
+// concurrent use.
+type DataStore struct {
+	// set of tables per unit id, or under key 0 if serving all unit ids
+	units    map[uint8]*UnitData
+	allUnits bool
+}
+
+// Unit data object, holding the tables of a unit served by a data store.
+type UnitData struct {
+	lock             sync.RWMutex
+	endianness       Endianness
+	wordOrder        WordOrder
+	coils            []bool
+	discreteInputs   []bool
+	holdingRegisters []uint16
+	inputRegisters   []uint16
+}
+
+// NewDataStore creates and returns a data store, with all values set to
+// zero.
+func NewDataStore(conf *DataStoreConfiguration) (ds *DataStore, err error) {
+	var unitIds = conf.UnitIds
+	var endianness = conf.Endianness
+	var wordOrder = conf.WordOrder
+
+	if conf.Coils > 65536 || conf.DiscreteInputs > 65536 ||
+		conf.HoldingRegisters > 65536 || conf.InputRegisters > 65536 {
+		err = ErrConfigurationError
+		return
+	}
+
+	if endianness == 0 {
+		endianness = BIG_ENDIAN
+	}
+	if wordOrder == 0 {
+		wordOrder = HIGH_WORD_FIRST
+	}
+	if endianness != BIG_ENDIAN && endianness != LITTLE_ENDIAN ||
+		wordOrder != HIGH_WORD_FIRST && wordOrder != LOW_WORD_FIRST {
+		err = ErrConfigurationError
+		return
+	}
+
+	ds = &DataStore{
+		units:    make(map[uint8]*UnitData),
+		allUnits: len(unitIds) == 0,
+	}
+
+	if ds.allUnits {
+		unitIds = []uint8{0}
+	}
+
+	for _, unitId := range unitIds {
+		ds.units[unitId] = &UnitData{
+			endianness:       endianness,
+			wordOrder:        wordOrder,
+			coils:            make([]bool, conf.Coils),
+			discreteInputs:   make([]bool, conf.DiscreteInputs),
+			holdingRegisters: make([]uint16, conf.HoldingRegisters),
+			inputRegisters:   make([]uint16, conf.InputRegisters),
+		}
+	}
+
+	return
+}
+
+// Returns the tables of unit id, or nil if the unit id is not served by
+// the store. Stores serving all unit ids return the same tables for any id.
+func (ds *DataStore) Unit(id uint8) *UnitData {
+	if ds.allUnits {
+		id = 0
+	}
+
+	return ds.units[id]
+}
+
+// HandleCoils handles coil reads and writes (see RequestHandler).
+func (ds *DataStore) HandleCoils(req *CoilsRequest) (res []bool, err error) {
+	var ud *UnitData
+
+	ud, err = ds.unitData(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	if req.IsWrite {
+		err = ud.SetCoils(req.Addr, req.Args)
+		return
+	}
+
+	res, err = ud.GetCoils(req.Addr, req.Quantity)
+
+	return
+}
+
+// HandleDiscreteInputs handles discrete input reads (see RequestHandler).
+func (ds *DataStore) HandleDiscreteInputs(req *DiscreteInputsRequest) (res []bool, err error) {
+	var ud *UnitData
+
+	ud, err = ds.unitData(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	res, err = ud.GetDiscreteInputs(req.Addr, req.Quantity)
+
+	return
+}
+
+// HandleHoldingRegisters handles holding register reads and writes (see
+// RequestHandler).
+func (ds *DataStore) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	var ud *UnitData
+
+	ud, err = ds.unitData(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	ud.lock.Lock()
+	defer ud.lock.Unlock()
+
+	if req.IsWrite {
+		err = copyIn(ud.holdingRegisters, req.Addr, req.Args)
+		return
+	}
+
+	res, err = copyOut(ud.holdingRegisters, req.Addr, req.Quantity)
+
+	return
+}
+
+// HandleInputRegisters handles input register reads (see RequestHandler).
+func (ds *DataStore) HandleInputRegisters(req *InputRegistersRequest) (res []uint16, err error) {
+	var ud *UnitData
+
+	ud, err = ds.unitData(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	ud.lock.RLock()
+	defer ud.lock.RUnlock()
+
+	res, err = copyOut(ud.inputRegisters, req.Addr, req.Quantity)
+
+	return
+}
+
+// Returns the tables of unit id, or ErrGWPathUnavailable if the unit id is
+// not served by the store.
+func (ds *DataStore) unitData(id uint8) (ud *UnitData, err error) {
+	ud = ds.Unit(id)
+	if ud == nil {
+		err = ErrGWPathUnavailable
+	}
+
+	return
+}
+
+// Returns the value of a coil.
+func (ud *UnitData) GetCoil(addr uint16) (value bool, err error) {
+	var values []bool
+
+	values, err = ud.GetCoils(addr, 1)
+	if err == nil {
+		value = values[0]
+	}
+
+	return
+}
+
+// Returns the values of quantity coils starting at addr.
+func (ud *UnitData) GetCoils(addr uint16, quantity uint16) (values []bool, err error) {
+	ud.lock.RLock()
+	defer ud.lock.RUnlock()
+
+	values, err = copyOut(ud.coils, addr, quantity)
+
+	return
+}
+
+// Sets the value of a coil.
+func (ud *UnitData) SetCoil(addr uint16, value bool) error {
+	return ud.SetCoils(addr, []bool{value})
+}
+
+// Sets the values of coils starting at addr.
+func (ud *UnitData) SetCoils(addr uint16, values []bool) error {
+	ud.lock.Lock()
+	defer ud.lock.Unlock()
+
+	return copyIn(ud.coils, addr, values)
+}
+
+// Returns the value of a discrete input.
+func (ud *UnitData) GetDiscreteInput(addr uint16) (value bool, err error) {
+	var values []bool
+
+	values, err = ud.GetDiscreteInputs(addr, 1)
+	if err == nil {
+		value = values[0]
+	}
+
+	return
+}
+
+// Returns the values of quantity discrete inputs starting at addr.
+func (ud *UnitData) GetDiscreteInputs(addr uint16, quantity uint16) (values []bool, err error) {
+	ud.lock.RLock()
+	defer ud.lock.RUnlock()
+
+	values, err = copyOut(ud.discreteInputs, addr, quantity)
+
+	return
+}
+
+// Sets the value of a discrete input.
+func (ud *UnitData) SetDiscreteInput(addr uint16, value bool) error {
+	return ud.SetDiscreteInputs(addr, []bool{value})
+}
+
+// Sets the values of discrete inputs starting at addr.
+func (ud *UnitData) SetDiscreteInputs(addr uint16, values []bool) error {
+	ud.lock.Lock()
+	defer ud.lock.Unlock()
+
+	return copyIn(ud.discreteInputs, addr, values)
+}
+
+// Returns the value of a 16-bit register.
+func (ud *UnitData) GetRegister(addr uint16, regType RegType) (value uint16, err error) {
+	var values []uint16
+
+	values, err = ud.GetRegisters(addr, 1, regType)
+	if err == nil {
+		value = values[0]
+	}
+
+	return
+}
+
+// Returns the values of quantity 16-bit registers starting at addr.
+func (ud *UnitData) GetRegisters(addr uint16, quantity uint16, regType RegType) (values []uint16, err error) {
+	var raw []byte
+
+	raw, err = ud.getBytes(addr, quantity, regType)
+	if err == nil {
+		values = bytesToUint16s(ud.endianness, raw)
+	}
+
+	return
+}
+
+// Sets the value of a 16-bit register.
+func (ud *UnitData) SetRegister(addr uint16, regType RegType, value uint16) error {
+	return ud.SetRegisters(addr, regType, []uint16{value})
+}
+
+// Sets the values of 16-bit registers starting at addr.
+func (ud *UnitData) SetRegisters(addr uint16, regType RegType, values []uint16) error {
+	return ud.setBytes(addr, regType, uint16sToBytes(ud.endianness, values))
+}
+
+// Returns the value of a 32-bit register.
+func (ud *UnitData) GetUint32(addr uint16, regType RegType) (value uint32, err error) {
+	var raw []byte
+
+	raw, err = ud.getBytes(addr, 2, regType)
+	if err == nil {
+		value = bytesToUint32s(ud.endianness, ud.wordOrder, raw)[0]
+	}
+
+	return
+}
+
+// Sets the value of a 32-bit register.
+func (ud *UnitData) SetUint32(addr uint16, regType RegType, value uint32) error {
+	return ud.setBytes(addr, regType, uint32ToBytes(ud.endianness, ud.wordOrder, value))
+}
+
+// Returns the value of a 32-bit float register.
+func (ud *UnitData) GetFloat32(addr uint16, regType RegType) (value float32, err error) {
+	var raw []byte
+
+	raw, err = ud.getBytes(addr, 2, regType)
+	if err == nil {
+		value = bytesToFloat32s(ud.endianness, ud.wordOrder, raw)[0]
+	}
+
+	return
+}
+
+// Sets the value of a 32-bit float register.
+func (ud *UnitData) SetFloat32(addr uint16, regType RegType, value float32) error {
+	return ud.setBytes(addr, regType, float32ToBytes(ud.endianness, ud.wordOrder, value))
+}
+
+// Returns the value of a 64-bit register.
+func (ud *UnitData) GetUint64(addr uint16, regType RegType) (value uint64, err error) {
+	var raw []byte
+
+	raw, err = ud.getBytes(addr, 4, regType)
+	if err == nil {
+		value = bytesToUint64s(ud.endianness, ud.wordOrder, raw)[0]
+	}
+
+	return
+}
+
+// Sets the value of a 64-bit register.
+func (ud *UnitData) SetUint64(addr uint16, regType RegType, value uint64) error {
+	return ud.setBytes(addr, regType, uint64ToBytes(ud.endianness, ud.wordOrder, value))
+}
+
+// Returns the value of a 64-bit float register.
+func (ud *UnitData) GetFloat64(addr uint16, regType RegType) (value float64, err error) {
+	var raw []byte
+
+	raw, err = ud.getBytes(addr, 4, regType)
+	if err == nil {
+		value = bytesToFloat64s(ud.endianness, ud.wordOrder, raw)[0]
+	}
+
+	return
+}
+
+// Sets the value of a 64-bit float register.
+func (ud *UnitData) SetFloat64(addr uint16, regType RegType, value float64) error {
+	return ud.setBytes(addr, regType, float64ToBytes(ud.endianness, ud.wordOrder, value))
+}
+
+// Returns the table of regType.
+func (ud *UnitData) registers(regType RegType) (table []uint16, err error) {
+	switch regType {
+	case HOLDING_REGISTER:
+		table = ud.holdingRegisters
+	case INPUT_REGISTER:
+		table = ud.inputRegisters
+	default:
+		err = ErrUnexpectedParameters
+	}
+
+	return
+}
+
+// Returns quantity registers starting at addr as bytes, as they would go
+// on the wire.
+func (ud *UnitData) getBytes(addr uint16, quantity uint16, regType RegType) (raw []byte, err error) {
+	var table []uint16
+	var values []uint16
+
+	ud.lock.RLock()
+	defer ud.lock.RUnlock()
+
+	table, err = ud.registers(regType)
+	if err != nil {
+		return
+	}
+
+	values, err = copyOut(table, addr, quantity)
+	if err != nil {
+		return
+	}
+
+	raw = uint16sToBytes(BIG_ENDIAN, values)
+
+	return
+}
+
+// Sets registers starting at addr from bytes, as they would come off the
+// wire.
+func (ud *UnitData) setBytes(addr uint16, regType RegType, raw []byte) (err error) {
+	var table []uint16
+
+	ud.lock.Lock()
+	defer ud.lock.Unlock()
+
+	table, err = ud.registers(regType)
+	if err != nil {
+		return
+	}
+
+	err = copyIn(table, addr, bytesToUint16s(BIG_ENDIAN, raw))
+
+	return
+}
+
+// Copies values into table, starting at addr.
+// Must be called with the lock held for writing.
+func copyIn[T bool | uint16](table []T, addr uint16, values []T) (err error) {
+	if int(addr)+len(values) > len(table) {
+		err = ErrIllegalDataAddress
+		return
+	}
+
+	copy(table[addr:], values)
+
+	return
+}
+
+// Returns a copy of quantity values of table, starting at addr.
+func copyOut[T bool | uint16](table []T, addr uint16, quantity uint16) (values []T, err error) {
+	if int(addr)+int(quantity) > len(table) {
+		err = ErrIllegalDataAddress
+		return
+	}
+
+	values = make([]T, quantity)
+	copy(values, table[addr:])
+
+	return
+}
@@ -0,0 +1,204 @@
+package modbus
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDataStore(t *testing.T) {
+	var ds *DataStore
+	var ud *UnitData
+	var client *ModbusClient
+	var err error
+	var u32 uint32
+	var f32 float32
+	var f64 float64
+	var reg uint16
+	var regs []uint16
+	var coil bool
+
+	_, err = NewDataStore(&DataStoreConfiguration{Coils: 65537})
+	if err != ErrConfigurationError {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	_, err = NewDataStore(&DataStoreConfiguration{Endianness: 3})
+	if err != ErrConfigurationError {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	ds, err = NewDataStore(&DataStoreConfiguration{
+		Coils:            10,
+		DiscreteInputs:   10,
+		HoldingRegisters: 20,
+		InputRegisters:   20,
+		UnitIds:          []uint8{1, 2},
+	})
+	if err != nil {
+		t.Fatalf("NewDataStore() should have succeeded, got: %v", err)
+	}
+
+	if ds.Unit(3) != nil {
+		t.Errorf("unit 3 should not be served")
+	}
+
+	// typed accessors should honour the encoding of the store
+	ud = ds.Unit(1)
+	err = ud.SetUint32(0, HOLDING_REGISTER, 0x12345678)
+	if err != nil {
+		t.Errorf("SetUint32() should have succeeded, got: %v", err)
+	}
+	err = ud.SetFloat32(2, INPUT_REGISTER, 3.5)
+	if err != nil {
+		t.Errorf("SetFloat32() should have succeeded, got: %v", err)
+	}
+	err = ud.SetFloat64(16, HOLDING_REGISTER, -1.25)
+	if err != nil {
+		t.Errorf("SetFloat64() should have succeeded, got: %v", err)
+	}
+	err = ud.SetDiscreteInput(9, true)
+	if err != nil {
+		t.Errorf("SetDiscreteInput() should have succeeded, got: %v", err)
+	}
+
+	regs, err = ds.HandleHoldingRegisters(&HoldingRegistersRequest{
+		UnitId: 1, Addr: 0, Quantity: 2,
+	})
+	if err != nil {
+		t.Errorf("HandleHoldingRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0x1234 || regs[1] != 0x5678 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	// out of range accesses should be rejected
+	err = ud.SetUint64(17, HOLDING_REGISTER, 1)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+	_, err = ud.GetCoil(10)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+	_, err = ud.GetRegister(0, RegType(3))
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// serve the store to a client
+	client, err = NewFakeClient(ds)
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	client.SetUnitId(1)
+	u32, err = client.ReadUint32(0, HOLDING_REGISTER)
+	if err != nil || u32 != 0x12345678 {
+		t.Errorf("expected 0x12345678, got: 0x%08x, %v", u32, err)
+	}
+	f32, err = client.ReadFloat32(2, INPUT_REGISTER)
+	if err != nil || f32 != 3.5 {
+		t.Errorf("expected 3.5, got: %v, %v", f32, err)
+	}
+	f64, err = client.ReadFloat64(16, HOLDING_REGISTER)
+	if err != nil || f64 != -1.25 {
+		t.Errorf("expected -1.25, got: %v, %v", f64, err)
+	}
+	coil, err = client.ReadDiscreteInput(9)
+	if err != nil || !coil {
+		t.Errorf("expected true, got: %v, %v", coil, err)
+	}
+
+	_, err = client.ReadRegisters(18, 3, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// client writes should be visible through accessors
+	err = client.WriteCoil(3, true)
+	if err != nil {
+		t.Errorf("WriteCoil() should have succeeded, got: %v", err)
+	}
+	coil, err = ud.GetCoil(3)
+	if err != nil || !coil {
+		t.Errorf("expected true, got: %v, %v", coil, err)
+	}
+
+	// units should be independent
+	client.SetUnitId(2)
+	reg, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != nil || reg != 0 {
+		t.Errorf("expected 0, got: %v, %v", reg, err)
+	}
+
+	client.SetUnitId(3)
+	_, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != ErrGWPathUnavailable {
+		t.Errorf("expected ErrGWPathUnavailable, got: %v", err)
+	}
+
+	return
+}
+
+func TestDataStoreEncoding(t *testing.T) {
+	var ds *DataStore
+	var client *ModbusClient
+	var err error
+	var wg sync.WaitGroup
+	var u32 uint32
+	var reg uint16
+
+	// stores without unit ids should serve all unit ids
+	ds, err = NewDataStore(&DataStoreConfiguration{
+		HoldingRegisters: 4,
+		Endianness:       LITTLE_ENDIAN,
+		WordOrder:        LOW_WORD_FIRST,
+	})
+	if err != nil {
+		t.Fatalf("NewDataStore() should have succeeded, got: %v", err)
+	}
+	if ds.Unit(0) != ds.Unit(200) {
+		t.Errorf("all unit ids should share the same tables")
+	}
+
+	client, err = NewFakeClient(ds)
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(200)
+	client.SetEncoding(LITTLE_ENDIAN, LOW_WORD_FIRST)
+
+	ds.Unit(5).SetUint32(0, HOLDING_REGISTER, 0x12345678)
+	ds.Unit(5).SetRegister(2, HOLDING_REGISTER, 0xabcd)
+
+	u32, err = client.ReadUint32(0, HOLDING_REGISTER)
+	if err != nil || u32 != 0x12345678 {
+		t.Errorf("expected 0x12345678, got: 0x%08x, %v", u32, err)
+	}
+	reg, err = client.ReadRegister(2, HOLDING_REGISTER)
+	if err != nil || reg != 0xabcd {
+		t.Errorf("expected 0xabcd, got: 0x%04x, %v", reg, err)
+	}
+
+	// accessors should be safe for concurrent use with client requests
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := uint32(0); j < 50; j++ {
+				ds.Unit(0).SetUint32(0, HOLDING_REGISTER, j)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				client.WriteUint32(0, uint32(j))
+			}
+		}()
+	}
+	wg.Wait()
+
+	return
+}
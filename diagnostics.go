@@ -0,0 +1,329 @@
+package modbus
+
+import (
+	"sync"
+)
+
+const (
+	// max number of events kept in the communication event log
+	maxCommEvents int = 64
+
+	// communication event log entries
+	evRemoteDeviceReceive   byte = 0x80 // receive event
+	evReceiveCommError      byte = 0x02 // communication error (receive event)
+	evReceiveListenOnly     byte = 0x20 // currently in listen only mode (receive event)
+	evRemoteDeviceSend      byte = 0x40 // send event
+	evSendReadException     byte = 0x01 // exception codes 1 to 3 sent (send event)
+	evSendAbortException    byte = 0x02 // exception code 4 sent (send event)
+	evSendBusyException     byte = 0x04 // exception codes 5 and 6 sent (send event)
+	evSendNAKException      byte = 0x08 // exception code 7 sent (send event)
+	evEnteredListenOnlyMode byte = 0x04
+	evCommRestart           byte = 0x00
+)
+
+// Server-side diagnostic counters, communication event log and listen only
+// mode state, as exposed by the serial line diagnostics function codes
+// (0x08, 0x0b and 0x0c).
+// A single set of counters is kept per server, shared by all clients.
+type serverDiagnostics struct {
+	lock                  sync.Mutex
+	listenOnly            bool
+	diagnosticRegister    uint16
+	busMessageCount       uint16
+	busCommErrorCount     uint16
+	exceptionErrorCount   uint16
+	serverMessageCount    uint16
+	serverNoResponseCount uint16
+	serverNAKCount        uint16
+	serverBusyCount       uint16
+	busCharOverrunCount   uint16
+	commEventCounter      uint16
+	eventLog              []byte
+}
+
+// Accounts for a request received from the bus, addressed to the server
+// or to another device.
+func (sd *serverDiagnostics) countRequest(addressed bool) {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	sd.busMessageCount++
+	if !addressed {
+		return
+	}
+	sd.serverMessageCount++
+
+	if sd.listenOnly {
+		sd.logEvent(evRemoteDeviceReceive | evReceiveListenOnly)
+	} else {
+		sd.logEvent(evRemoteDeviceReceive)
+	}
+
+	return
+}
+
+// Accounts for a corrupted frame received from the bus.
+func (sd *serverDiagnostics) countCommError() {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	sd.busCommErrorCount++
+	sd.logEvent(evRemoteDeviceReceive | evReceiveCommError)
+
+	return
+}
+
+// Accounts for a request left unanswered.
+func (sd *serverDiagnostics) countNoResponse() {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	sd.serverNoResponseCount++
+
+	return
+}
+
+// Accounts for a response sent to the bus.
+func (sd *serverDiagnostics) countResponse(res *pdu) {
+	var event byte
+
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	event = evRemoteDeviceSend
+
+	if res.functionCode&0x80 != 0 && len(res.payload) == 1 {
+		sd.exceptionErrorCount++
+
+		switch res.payload[0] {
+		case exIllegalFunction, exIllegalDataAddress, exIllegalDataValue:
+			event |= evSendReadException
+		case exServerDeviceFailure:
+			event |= evSendAbortException
+		case exAcknowledge:
+			event |= evSendBusyException
+		case exServerDeviceBusy:
+			event |= evSendBusyException
+			sd.serverBusyCount++
+		case exNegativeAcknowledge:
+			event |= evSendNAKException
+			sd.serverNAKCount++
+		}
+	} else if res.functionCode != fcGetCommEventCounter &&
+		res.functionCode != fcGetCommEventLog {
+		// the event counter is incremented for each successful message
+		// completion, save for event counter and event log fetches
+		sd.commEventCounter++
+	}
+
+	sd.logEvent(event)
+
+	return
+}
+
+// Returns true if the server is in listen only mode.
+func (sd *serverDiagnostics) isListenOnly() (listenOnly bool) {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	listenOnly = sd.listenOnly
+
+	return
+}
+
+// Handles a diagnostics (0x08) request payload, returning the response
+// payload. errNoResponse is returned if no response should be sent back.
+func (sd *serverDiagnostics) handleRequest(payload []byte) (res []byte, err error) {
+	var subFunction DiagSubFunction
+	var data uint16
+	var wasListenOnly bool
+
+	if len(payload) < 2 {
+		err = ErrProtocolError
+		return
+	}
+	subFunction = DiagSubFunction(bytesToUint16(BIG_ENDIAN, payload[0:2]))
+
+	// return query data is the only sub-function taking arbitrary data
+	if subFunction == DIAG_RETURN_QUERY_DATA {
+		res = append(res, payload...)
+		return
+	}
+
+	// all other sub-functions take a single 16-bit data field
+	if len(payload) != 4 {
+		err = ErrProtocolError
+		return
+	}
+	data = bytesToUint16(BIG_ENDIAN, payload[2:4])
+
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	switch subFunction {
+	case DIAG_RESTART_COMMUNICATIONS:
+		if data != 0x0000 && data != 0xff00 {
+			err = ErrIllegalDataValue
+			return
+		}
+
+		// bring the server out of listen only mode, clear all counters and
+		// optionally, the event log
+		wasListenOnly = sd.listenOnly
+		sd.listenOnly = false
+		sd.clearCounters()
+		sd.commEventCounter = 0
+		if data == 0xff00 {
+			sd.eventLog = nil
+		}
+		sd.logEvent(evCommRestart)
+
+		// requests received in listen only mode are never answered
+		if wasListenOnly {
+			err = errNoResponse
+			return
+		}
+		res = append(res, payload...)
+
+	case DIAG_RETURN_DIAGNOSTIC_REGISTER:
+		if data != 0x0000 {
+			err = ErrIllegalDataValue
+			return
+		}
+		res = append(res, payload[0:2]...)
+		res = append(res, uint16ToBytes(BIG_ENDIAN, sd.diagnosticRegister)...)
+
+	case DIAG_FORCE_LISTEN_ONLY_MODE:
+		if data != 0x0000 {
+			err = ErrIllegalDataValue
+			return
+		}
+
+		// no response is ever returned to this request
+		sd.listenOnly = true
+		sd.logEvent(evEnteredListenOnlyMode)
+		err = errNoResponse
+
+	case DIAG_CLEAR_COUNTERS:
+		if data != 0x0000 {
+			err = ErrIllegalDataValue
+			return
+		}
+		sd.clearCounters()
+		res = append(res, payload...)
+
+	case DIAG_BUS_MESSAGE_COUNT,
+		DIAG_BUS_COMM_ERROR_COUNT,
+		DIAG_BUS_EXCEPTION_ERROR_COUNT,
+		DIAG_SERVER_MESSAGE_COUNT,
+		DIAG_SERVER_NO_RESPONSE_COUNT,
+		DIAG_SERVER_NAK_COUNT,
+		DIAG_SERVER_BUSY_COUNT,
+		DIAG_BUS_CHAR_OVERRUN_COUNT:
+		if data != 0x0000 {
+			err = ErrIllegalDataValue
+			return
+		}
+		res = append(res, payload[0:2]...)
+		res = append(res, uint16ToBytes(BIG_ENDIAN, sd.counter(subFunction))...)
+
+	case DIAG_CLEAR_OVERRUN_COUNTER:
+		if data != 0x0000 {
+			err = ErrIllegalDataValue
+			return
+		}
+		sd.busCharOverrunCount = 0
+		res = append(res, payload...)
+
+	default:
+		// includes DIAG_CHANGE_ASCII_INPUT_DELIMITER, as ASCII transports
+		// only ever use LF as end of frame delimiter
+		err = ErrIllegalFunction
+	}
+
+	return
+}
+
+// Returns the communication event counter (0x0b) response payload.
+func (sd *serverDiagnostics) commEventCounterPayload() (payload []byte) {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	// status word (never busy, as requests are processed synchronously)
+	payload = uint16ToBytes(BIG_ENDIAN, 0x0000)
+	// event count
+	payload = append(payload, uint16ToBytes(BIG_ENDIAN, sd.commEventCounter)...)
+
+	return
+}
+
+// Returns the communication event log (0x0c) response payload.
+func (sd *serverDiagnostics) commEventLogPayload() (payload []byte) {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	// byte count (6 bytes of status, event count and message count,
+	// followed by events)
+	payload = []byte{byte(6 + len(sd.eventLog))}
+	// status word
+	payload = append(payload, uint16ToBytes(BIG_ENDIAN, 0x0000)...)
+	// event count
+	payload = append(payload, uint16ToBytes(BIG_ENDIAN, sd.commEventCounter)...)
+	// message count
+	payload = append(payload, uint16ToBytes(BIG_ENDIAN, sd.busMessageCount)...)
+	// events, most recent first
+	payload = append(payload, sd.eventLog...)
+
+	return
+}
+
+// Returns the value of a diagnostic counter. Expects sd.lock to be held.
+func (sd *serverDiagnostics) counter(subFunction DiagSubFunction) (value uint16) {
+	switch subFunction {
+	case DIAG_BUS_MESSAGE_COUNT:
+		value = sd.busMessageCount
+	case DIAG_BUS_COMM_ERROR_COUNT:
+		value = sd.busCommErrorCount
+	case DIAG_BUS_EXCEPTION_ERROR_COUNT:
+		value = sd.exceptionErrorCount
+	case DIAG_SERVER_MESSAGE_COUNT:
+		value = sd.serverMessageCount
+	case DIAG_SERVER_NO_RESPONSE_COUNT:
+		value = sd.serverNoResponseCount
+	case DIAG_SERVER_NAK_COUNT:
+		value = sd.serverNAKCount
+	case DIAG_SERVER_BUSY_COUNT:
+		value = sd.serverBusyCount
+	case DIAG_BUS_CHAR_OVERRUN_COUNT:
+		value = sd.busCharOverrunCount
+	}
+
+	return
+}
+
+// Clears all diagnostic counters and the diagnostic register.
+// Expects sd.lock to be held.
+func (sd *serverDiagnostics) clearCounters() {
+	sd.diagnosticRegister = 0
+	sd.busMessageCount = 0
+	sd.busCommErrorCount = 0
+	sd.exceptionErrorCount = 0
+	sd.serverMessageCount = 0
+	sd.serverNoResponseCount = 0
+	sd.serverNAKCount = 0
+	sd.serverBusyCount = 0
+	sd.busCharOverrunCount = 0
+
+	return
+}
+
+// Adds an event at the head of the event log, dropping the oldest event
+// if the log is full. Expects sd.lock to be held.
+func (sd *serverDiagnostics) logEvent(event byte) {
+	sd.eventLog = append([]byte{event}, sd.eventLog...)
+	if len(sd.eventLog) > maxCommEvents {
+		sd.eventLog = sd.eventLog[:maxCommEvents]
+	}
+
+	return
+}
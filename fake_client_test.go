@@ -0,0 +1,95 @@
+package modbus
+
+import (
+	"testing"
+)
+
+// Consumer code, depending on the Client interface rather than on
+// a concrete client type.
+func readAndIncrement(c Client, addr uint16) (value uint32, err error) {
+	value, err = c.ReadUint32(addr, HOLDING_REGISTER)
+	if err != nil {
+		return
+	}
+
+	value++
+	err = c.WriteUint32(addr, value)
+
+	return
+}
+
+func TestFakeClient(t *testing.T) {
+	var client *ModbusClient
+	var handler *tcpTestHandler
+	var err error
+	var value uint32
+
+	handler = &tcpTestHandler{}
+	handler.holding[2] = 0x1234
+	handler.holding[3] = 0xffff
+
+	client, err = NewFakeClient(handler)
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	value, err = readAndIncrement(client, 2)
+	if err != nil {
+		t.Errorf("readAndIncrement() should have succeeded, got: %v", err)
+	}
+	if value != 0x12350000 {
+		t.Errorf("expected 0x12350000, got 0x%08x", value)
+	}
+	if handler.holding[2] != 0x1235 || handler.holding[3] != 0x0000 {
+		t.Errorf("unexpected register values: %v", handler.holding)
+	}
+
+	// encoding settings should apply
+	client.SetEncoding(BIG_ENDIAN, LOW_WORD_FIRST)
+	value, err = client.ReadUint32(2, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadUint32() should have succeeded, got: %v", err)
+	}
+	if value != 0x00001235 {
+		t.Errorf("expected 0x00001235, got 0x%08x", value)
+	}
+
+	// handler errors should make it back to the client
+	_, err = readAndIncrement(client, 9)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	client.SetUnitId(8)
+	_, err = readAndIncrement(client, 2)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	// the fake client should close and re-open like any other client
+	err = client.Close()
+	if err != nil {
+		t.Errorf("client.Close() should have succeeded, got: %v", err)
+	}
+
+	_, err = client.ReadCoil(0)
+	if err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	_, err = client.ReadCoils(0, 10)
+	if err != nil {
+		t.Errorf("client.ReadCoils() should have succeeded, got: %v", err)
+	}
+
+	client.Close()
+
+	return
+}
@@ -5,4 +5,5 @@ go 1.23
 require (
 	github.com/goburrow/serial v0.1.0
 	github.com/google/go-cmp v0.7.0
+	gopkg.in/yaml.v3 v3.0.1
 )
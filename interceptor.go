@@ -0,0 +1,182 @@
+package modbus
+
+import (
+	"context"
+	"time"
+)
+
+// Call object, describing a request run by a client and, once run, its
+// outcome. Calls are passed along interceptor chains.
+type Call struct {
+	// Request holds the request, as built by the client method being called
+	Request *RawRequest
+	// Response holds the response (which may be an exception response, see
+	// Exception()), set once the request has run successfully
+	Response *RawResponse
+	// Latency holds the time spent running the request across the
+	// transport, retries included, set once the request has run
+	Latency time.Duration
+}
+
+// Invoker runs a call, setting its response on success.
+type Invoker func(ctx context.Context, call *Call) error
+
+// Interceptor wraps the execution of client requests.
+//
+// Interceptors are given each call before it is run, along with the next
+// invoker of the chain. They may inspect or modify call.Request before
+// passing the call on, inspect or modify call.Response, call.Latency and
+// the returned error afterwards, or short-circuit the call altogether by
+// not invoking next and either setting call.Response or returning an error.
+//
+// Note that client methods expect responses matching their request: a
+// short-circuited call must be answered with the unit id and function
+// code of the request, and with a payload of the expected length.
+//
+// Interceptors are run with the client lock held: they must not call any
+// client method other than State().
+type Interceptor func(ctx context.Context, call *Call, next Invoker) error
+
+// Returns the address and quantity of coils, discrete inputs or registers
+// read by the request, if any.
+// ok is false for function codes which do not read from a range of
+// addresses, or if the request payload is too short.
+func (c *Call) ReadRange() (addr uint16, quantity uint16, ok bool) {
+	var payload = c.Request.Payload
+
+	switch c.Request.FunctionCode {
+	case fcReadCoils, fcReadDiscreteInputs, fcReadHoldingRegisters,
+		fcReadInputRegisters, fcReadWriteMultipleRegisters:
+		if len(payload) >= 4 {
+			addr = bytesToUint16(BIG_ENDIAN, payload[0:2])
+			quantity = bytesToUint16(BIG_ENDIAN, payload[2:4])
+			ok = true
+		}
+	}
+
+	return
+}
+
+// Returns the address and quantity of coils or registers written to by
+// the request, if any.
+// ok is false for function codes which do not write to a range of
+// addresses, or if the request payload is too short.
+func (c *Call) WriteRange() (addr uint16, quantity uint16, ok bool) {
+	var payload = c.Request.Payload
+
+	switch c.Request.FunctionCode {
+	case fcWriteSingleCoil, fcWriteSingleRegister, fcMaskWriteRegister:
+		if len(payload) >= 2 {
+			addr = bytesToUint16(BIG_ENDIAN, payload[0:2])
+			quantity = 1
+			ok = true
+		}
+
+	case fcWriteMultipleCoils, fcWriteMultipleRegisters:
+		if len(payload) >= 4 {
+			addr = bytesToUint16(BIG_ENDIAN, payload[0:2])
+			quantity = bytesToUint16(BIG_ENDIAN, payload[2:4])
+			ok = true
+		}
+
+	case fcReadWriteMultipleRegisters:
+		if len(payload) >= 8 {
+			addr = bytesToUint16(BIG_ENDIAN, payload[4:6])
+			quantity = bytesToUint16(BIG_ENDIAN, payload[6:8])
+			ok = true
+		}
+	}
+
+	return
+}
+
+// Returns true if the request leaves the state of the remote device
+// untouched (see RetryPolicy).
+func (c *Call) IsReadOnly() bool {
+	return isReadOnlyRequest(c.pdu())
+}
+
+// Returns the error matching the exception code of the response, if the
+// call was answered with an exception response, nil otherwise.
+func (c *Call) Exception() (err error) {
+	if c.Response != nil &&
+		c.Response.FunctionCode == (c.Request.FunctionCode|0x80) &&
+		len(c.Response.Payload) == 1 {
+		err = mapExceptionCodeToError(c.Response.Payload[0])
+	}
+
+	return
+}
+
+// Returns the request of the call as a pdu.
+func (c *Call) pdu() *pdu {
+	return &pdu{
+		unitId:         c.Request.UnitId,
+		functionCode:   c.Request.FunctionCode,
+		payload:        c.Request.Payload,
+		responseLength: c.Request.ResponseLength,
+	}
+}
+
+// Runs a request through the interceptor chain of the client.
+func (mc *ModbusClient) executeInterceptedRequest(ctx context.Context, req *pdu) (res *pdu, err error) {
+	var call = &Call{
+		Request: &RawRequest{
+			UnitId:         req.unitId,
+			FunctionCode:   req.functionCode,
+			Payload:        req.payload,
+			ResponseLength: req.responseLength,
+		},
+	}
+
+	err = mc.invoker(0)(ctx, call)
+	if err != nil {
+		return
+	}
+
+	if call.Response == nil {
+		mc.logger.Errorf("interceptor returned neither a response nor an error")
+		err = ErrProtocolError
+		return
+	}
+
+	res = &pdu{
+		unitId:       call.Response.UnitId,
+		functionCode: call.Response.FunctionCode,
+		payload:      call.Response.Payload,
+	}
+
+	return
+}
+
+// Returns the invoker passing calls to the interceptor at index i of the
+// chain, or to the transport past the end of the chain.
+func (mc *ModbusClient) invoker(i int) Invoker {
+	if i == len(mc.conf.Interceptors) {
+		return mc.invokeTransport
+	}
+
+	return func(ctx context.Context, call *Call) error {
+		return mc.conf.Interceptors[i](ctx, call, mc.invoker(i+1))
+	}
+}
+
+// Runs a call across the transport, sets its response and latency.
+func (mc *ModbusClient) invokeTransport(ctx context.Context, call *Call) (err error) {
+	var res *pdu
+	var ts = time.Now()
+
+	res, err = mc.runRequest(ctx, call.pdu())
+	call.Latency = time.Since(ts)
+	if err != nil {
+		return
+	}
+
+	call.Response = &RawResponse{
+		UnitId:       res.unitId,
+		FunctionCode: res.functionCode,
+		Payload:      res.payload,
+	}
+
+	return
+}
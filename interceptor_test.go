@@ -0,0 +1,163 @@
+package modbus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClientInterceptors(t *testing.T) {
+	var client *ModbusClient
+	var handler *flakyTestHandler
+	var err error
+	var calls []*Call
+	var errs []error
+	var order []string
+	var cache map[uint16]*RawResponse
+	var regs []uint16
+
+	handler = &flakyTestHandler{}
+	handler.holding[1] = 0x1234
+
+	client, err = NewFakeClient(handler)
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	cache = make(map[uint16]*RawResponse)
+	client.conf.Interceptors = []Interceptor{
+		// records calls and their outcome
+		func(ctx context.Context, call *Call, next Invoker) (err error) {
+			order = append(order, "record")
+			err = next(ctx, call)
+			if err == nil {
+				err = call.Exception()
+			}
+			calls = append(calls, call)
+			errs = append(errs, err)
+
+			return
+		},
+		// routes requests to unit 9
+		func(ctx context.Context, call *Call, next Invoker) error {
+			order = append(order, "route")
+			call.Request.UnitId = 9
+
+			return next(ctx, call)
+		},
+		// denies writes
+		func(ctx context.Context, call *Call, next Invoker) error {
+			if !call.IsReadOnly() {
+				return ErrIllegalFunction
+			}
+
+			return next(ctx, call)
+		},
+		// caches single register reads
+		func(ctx context.Context, call *Call, next Invoker) (err error) {
+			addr, quantity, ok := call.ReadRange()
+			if !ok || quantity != 1 {
+				return next(ctx, call)
+			}
+
+			if cache[addr] != nil {
+				call.Response = cache[addr]
+				return
+			}
+
+			err = next(ctx, call)
+			if err == nil && call.Exception() == nil {
+				cache[addr] = call.Response
+			}
+
+			return
+		},
+	}
+
+	// the first read should reach the handler, the second one be served
+	// from the cache
+	handler.fail(0, nil)
+	for i := 0; i < 2; i++ {
+		regs, err = client.ReadRegisters(1, 1, HOLDING_REGISTER)
+		if err != nil {
+			t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+		}
+		if len(regs) != 1 || regs[0] != 0x1234 {
+			t.Errorf("unexpected register values: %v", regs)
+		}
+	}
+
+	if handler.attempts() != 1 {
+		t.Errorf("expected 1 handler access, saw %v", handler.attempts())
+	}
+
+	if len(order) != 4 || order[0] != "record" || order[1] != "route" {
+		t.Errorf("unexpected interceptor order: %v", order)
+	}
+
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 recorded calls, got %v", len(calls))
+	}
+	if calls[0].Request.UnitId != 9 ||
+		calls[0].Request.FunctionCode != fcReadHoldingRegisters {
+		t.Errorf("unexpected request: %+v", calls[0].Request)
+	}
+	if calls[0].Response == nil || len(calls[0].Response.Payload) != 3 {
+		t.Errorf("unexpected response: %+v", calls[0].Response)
+	}
+	if calls[0].Latency <= 0 {
+		t.Errorf("latency should have been set")
+	}
+	// the cached call never reached the transport
+	if calls[1].Latency != 0 {
+		t.Errorf("latency should not have been set, got: %v", calls[1].Latency)
+	}
+
+	// exceptions should be visible to interceptors and make it back to
+	// the caller
+	_, err = client.ReadRegisters(8, 4, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+	if errs[2] != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", errs[2])
+	}
+
+	addr, quantity, ok := calls[2].ReadRange()
+	if !ok || addr != 8 || quantity != 4 {
+		t.Errorf("unexpected read range: %v, %v, %v", addr, quantity, ok)
+	}
+
+	// writes should be denied without reaching the handler
+	handler.fail(0, nil)
+	err = client.WriteRegisters(2, []uint16{0x0001, 0x0002})
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	if handler.attempts() != 0 {
+		t.Errorf("expected no handler access, saw %v", handler.attempts())
+	}
+
+	addr, quantity, ok = calls[3].WriteRange()
+	if !ok || addr != 2 || quantity != 2 {
+		t.Errorf("unexpected write range: %v, %v, %v", addr, quantity, ok)
+	}
+	_, _, ok = calls[3].ReadRange()
+	if ok {
+		t.Errorf("write requests should have no read range")
+	}
+
+	// interceptors answering neither a response nor an error are a bug
+	client.conf.Interceptors = []Interceptor{
+		func(ctx context.Context, call *Call, next Invoker) error {
+			return nil
+		},
+	}
+
+	_, err = client.ReadCoil(0)
+	if err != ErrProtocolError {
+		t.Errorf("expected ErrProtocolError, got: %v", err)
+	}
+
+	return
+}
@@ -0,0 +1,27 @@
+package modbus
+
+type lrc struct {
+	sum uint8
+}
+
+// Prepares the LRC generator for use.
+func (l *lrc) init() {
+	l.sum = 0
+}
+
+// Adds the given bytes to the LRC.
+func (l *lrc) add(in []byte) {
+	for _, b := range in {
+		l.sum += b
+	}
+}
+
+// Returns the LRC i.e. the two's complement of the 8-bit sum of all bytes
+// added so far.
+func (l *lrc) value() byte {
+	return -l.sum
+}
+
+func (l *lrc) isEqual(value byte) bool {
+	return (l.value() == value)
+}
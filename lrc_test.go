@@ -0,0 +1,45 @@
+package modbus
+
+import (
+	"testing"
+)
+
+func TestLRC(t *testing.T) {
+	var l lrc
+
+	// initialize the LRC object and make sure we get 0x00 as init value
+	l.init()
+	if l.value() != 0x00 {
+		t.Errorf("expected 0x00, saw 0x%02x", l.value())
+	}
+
+	// read 10 holding registers at address 0x0001 from unit #17
+	// (example from the modbus over serial line spec)
+	l.add([]byte{0x11, 0x03, 0x00, 0x01, 0x00, 0x0a})
+	if l.value() != 0xe1 {
+		t.Errorf("expected 0xe1, saw 0x%02x", l.value())
+	}
+
+	if !l.isEqual(0xe1) {
+		t.Errorf("isEqual(0xe1) should have returned true")
+	}
+
+	if l.isEqual(0xe2) {
+		t.Errorf("isEqual(0xe2) should have returned false")
+	}
+
+	// the sum of all bytes, LRC included, should be zero
+	l.add([]byte{0xe1})
+	if l.value() != 0x00 {
+		t.Errorf("expected 0x00, saw 0x%02x", l.value())
+	}
+
+	// make sure the sum wraps around
+	l.init()
+	l.add([]byte{0xff, 0xff, 0x03})
+	if l.value() != 0xff {
+		t.Errorf("expected 0xff, saw 0x%02x", l.value())
+	}
+
+	return
+}
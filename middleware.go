@@ -0,0 +1,517 @@
+package modbus
+
+import (
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Server request descriptor, as seen by middlewares.
+//
+// All handler requests are described the same way, whatever their type:
+// fields which do not apply to a request (e.g. Addr for a report server id
+// request) are left zero-valued.
+//
+// Mask write register (0x16) and read/write multiple registers (0x17)
+// requests, which the server carries out as a read and a write (or a write
+// and a read) of holding registers, are described (and passed through
+// middlewares) once, as writes: Addr and Quantity cover the registers
+// written, ReadAddr and ReadQuantity the registers read.
+//
+// Diagnostics (0x08) and comm event (0x0b, 0x0c) requests, which the server
+// implements itself, go through middlewares as well but never reach the
+// handler.
+type ServerRequest struct {
+	ClientAddr   string   // the source (client) address
+	ClientRole   string   // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId       uint8    // the requested unit id (slave id)
+	FunctionCode uint8    // the function code of the request
+	Addr         uint16   // the base address (or FIFO pointer address) requested
+	Quantity     uint16   // the number of consecutive coils or registers covered (1 for FIFO reads)
+	IsWrite      bool     // true if the request is a write (always for unknown function codes)
+	Coils        []bool   // the coil values to be written (coil writes only)
+	Registers    []uint16 // the register values to be written (register writes only, unknown for 0x16)
+	ReadAddr     uint16   // the base address of the registers read (0x16 and 0x17 only)
+	ReadQuantity uint16   // the number of registers read (0x16 and 0x17 only)
+	Request      any      // the request object passed to the handler (e.g. *CoilsRequest, nil for 0x08, 0x0b, 0x0c, 0x16 and 0x17)
+}
+
+// Middleware is a function wrapping the handling of server requests.
+//
+// A middleware may inspect req, then either call next to pass the request
+// down the chain (ultimately to the handler) and return its error, or
+// reject the request by returning an error without calling next, in which
+// case the client is sent the matching exception (e.g. ErrIllegalFunction
+// or ErrServerDeviceBusy).
+// req should be considered read-only.
+type Middleware func(req *ServerRequest, next func() error) (err error)
+
+// Handlers able to pass requests spanning several handler calls (mask write
+// register and read/write multiple registers requests), or carried out by
+// the server itself (diagnostics and comm event requests), to their
+// middleware chain once, as a whole.
+type compoundHandler interface {
+	// Runs phases against the handler serving req once req has gone through
+	// all middleware chains on the way (phases may ignore the handler).
+	handleCompound(req *ServerRequest, phases func(h RequestHandler, unitId uint8) error) error
+}
+
+// Runs phases (the handler calls making up req) against h, passing req
+// through the middleware chains found on the way to the target handler.
+func runCompound(h RequestHandler, req *ServerRequest,
+	phases func(h RequestHandler, unitId uint8) error) (err error) {
+	if ch, ok := h.(compoundHandler); ok {
+		err = ch.handleCompound(req, phases)
+	} else {
+		err = phases(h, req.UnitId)
+	}
+
+	return
+}
+
+// Returns true if fc is implemented by the server itself rather than by
+// handlers (diagnostics and comm event functions).
+func isServerFunction(fc uint8) bool {
+	return fc == fcDiagnostics || fc == fcGetCommEventCounter || fc == fcGetCommEventLog
+}
+
+// Returns the descriptor of a request to a function implemented by the
+// server itself (diagnostics and comm event functions). Diagnostics
+// sub-functions altering the state of the server are considered writes.
+func serverFunctionRequest(req *pdu, clientAddr string, clientRole string) (sr *ServerRequest) {
+	sr = &ServerRequest{
+		ClientAddr:   clientAddr,
+		ClientRole:   clientRole,
+		UnitId:       req.unitId,
+		FunctionCode: req.functionCode,
+	}
+
+	if req.functionCode == fcDiagnostics && len(req.payload) >= 2 {
+		switch DiagSubFunction(bytesToUint16(BIG_ENDIAN, req.payload[0:2])) {
+		case DIAG_RESTART_COMMUNICATIONS, DIAG_CHANGE_ASCII_INPUT_DELIMITER,
+			DIAG_FORCE_LISTEN_ONLY_MODE, DIAG_CLEAR_COUNTERS,
+			DIAG_CLEAR_OVERRUN_COUNTER:
+			sr.IsWrite = true
+		}
+	}
+
+	return
+}
+
+// Request handler wrapped in a middleware chain.
+type middlewareHandler struct {
+	handler     RequestHandler
+	middlewares []Middleware
+}
+
+// Wraps handler with middlewares, the first middleware being the outermost
+// (i.e. the first to see requests).
+// The returned handler supports all optional handler interfaces (e.g.
+// FIFOHandler): requests are passed through the chain then fail with
+// ErrIllegalFunction if handler does not implement the matching interface.
+// Mask write register (0x16) and read/write multiple registers (0x17)
+// requests go through the chain once (see ServerRequest), and so do
+// diagnostics and comm event requests, provided the returned handler is
+// reached by the server directly, through other middleware chains or
+// through UnitMux objects.
+func WithMiddleware(handler RequestHandler, middlewares ...Middleware) RequestHandler {
+	return &middlewareHandler{
+		handler:     handler,
+		middlewares: slices.Clone(middlewares),
+	}
+}
+
+// HandleCoils passes coil requests through the chain (see RequestHandler).
+func (mh *middlewareHandler) HandleCoils(req *CoilsRequest) (res []bool, err error) {
+	var sr = &ServerRequest{
+		ClientAddr:   req.ClientAddr,
+		ClientRole:   req.ClientRole,
+		UnitId:       req.UnitId,
+		FunctionCode: req.FunctionCode,
+		Addr:         req.Addr,
+		Quantity:     req.Quantity,
+		IsWrite:      req.IsWrite,
+		Request:      req,
+	}
+
+	if req.IsWrite {
+		sr.Coils = req.Args
+	}
+
+	err = mh.run(sr, func() (err error) {
+		res, err = mh.handler.HandleCoils(req)
+		return
+	})
+
+	return
+}
+
+// HandleDiscreteInputs passes discrete input requests through the chain
+// (see RequestHandler).
+func (mh *middlewareHandler) HandleDiscreteInputs(req *DiscreteInputsRequest) (res []bool, err error) {
+	var sr = &ServerRequest{
+		ClientAddr:   req.ClientAddr,
+		ClientRole:   req.ClientRole,
+		UnitId:       req.UnitId,
+		FunctionCode: req.FunctionCode,
+		Addr:         req.Addr,
+		Quantity:     req.Quantity,
+		Request:      req,
+	}
+
+	err = mh.run(sr, func() (err error) {
+		res, err = mh.handler.HandleDiscreteInputs(req)
+		return
+	})
+
+	return
+}
+
+// HandleHoldingRegisters passes holding register requests through the
+// chain (see RequestHandler).
+func (mh *middlewareHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	var sr = &ServerRequest{
+		ClientAddr:   req.ClientAddr,
+		ClientRole:   req.ClientRole,
+		UnitId:       req.UnitId,
+		FunctionCode: req.FunctionCode,
+		Addr:         req.Addr,
+		Quantity:     req.Quantity,
+		IsWrite:      req.IsWrite,
+		Request:      req,
+	}
+
+	if req.IsWrite {
+		sr.Registers = req.Args
+	}
+
+	err = mh.run(sr, func() (err error) {
+		res, err = mh.handler.HandleHoldingRegisters(req)
+		return
+	})
+
+	return
+}
+
+// HandleInputRegisters passes input register requests through the chain
+// (see RequestHandler).
+func (mh *middlewareHandler) HandleInputRegisters(req *InputRegistersRequest) (res []uint16, err error) {
+	var sr = &ServerRequest{
+		ClientAddr:   req.ClientAddr,
+		ClientRole:   req.ClientRole,
+		UnitId:       req.UnitId,
+		FunctionCode: req.FunctionCode,
+		Addr:         req.Addr,
+		Quantity:     req.Quantity,
+		Request:      req,
+	}
+
+	err = mh.run(sr, func() (err error) {
+		res, err = mh.handler.HandleInputRegisters(req)
+		return
+	})
+
+	return
+}
+
+// HandleFIFOQueue passes FIFO queue requests through the chain (see
+// FIFOHandler).
+func (mh *middlewareHandler) HandleFIFOQueue(req *FIFOQueueRequest) (res []uint16, err error) {
+	var sr = &ServerRequest{
+		ClientAddr:   req.ClientAddr,
+		ClientRole:   req.ClientRole,
+		UnitId:       req.UnitId,
+		FunctionCode: fcReadFifoQueue,
+		Addr:         req.Addr,
+		Quantity:     1,
+		Request:      req,
+	}
+
+	err = mh.run(sr, func() (err error) {
+		if fh, ok := mh.handler.(FIFOHandler); ok {
+			res, err = fh.HandleFIFOQueue(req)
+		} else {
+			err = ErrIllegalFunction
+		}
+		return
+	})
+
+	return
+}
+
+// HandleFileRecords passes file record requests through the chain (see
+// FileRecordHandler).
+func (mh *middlewareHandler) HandleFileRecords(req *FileRecordsRequest) (res [][]uint16, err error) {
+	var sr = &ServerRequest{
+		ClientAddr:   req.ClientAddr,
+		ClientRole:   req.ClientRole,
+		UnitId:       req.UnitId,
+		FunctionCode: fcReadFileRecord,
+		IsWrite:      req.IsWrite,
+		Request:      req,
+	}
+
+	if req.IsWrite {
+		sr.FunctionCode = fcWriteFileRecord
+	}
+
+	err = mh.run(sr, func() (err error) {
+		if frh, ok := mh.handler.(FileRecordHandler); ok {
+			res, err = frh.HandleFileRecords(req)
+		} else {
+			err = ErrIllegalFunction
+		}
+		return
+	})
+
+	return
+}
+
+// HandleDeviceIdentification passes device identification requests through
+// the chain (see DeviceIdentificationHandler).
+func (mh *middlewareHandler) HandleDeviceIdentification(req *DeviceIdentificationRequest) (res map[uint8]string, err error) {
+	var sr = &ServerRequest{
+		ClientAddr:   req.ClientAddr,
+		ClientRole:   req.ClientRole,
+		UnitId:       req.UnitId,
+		FunctionCode: fcEncapsulatedInterface,
+		Request:      req,
+	}
+
+	err = mh.run(sr, func() (err error) {
+		if dih, ok := mh.handler.(DeviceIdentificationHandler); ok {
+			res, err = dih.HandleDeviceIdentification(req)
+		} else {
+			err = ErrIllegalFunction
+		}
+		return
+	})
+
+	return
+}
+
+// HandleExceptionStatus passes exception status requests through the chain
+// (see ExceptionStatusHandler).
+func (mh *middlewareHandler) HandleExceptionStatus(req *ExceptionStatusRequest) (res uint8, err error) {
+	var sr = &ServerRequest{
+		ClientAddr:   req.ClientAddr,
+		ClientRole:   req.ClientRole,
+		UnitId:       req.UnitId,
+		FunctionCode: fcReadExceptionStatus,
+		Request:      req,
+	}
+
+	err = mh.run(sr, func() (err error) {
+		if esh, ok := mh.handler.(ExceptionStatusHandler); ok {
+			res, err = esh.HandleExceptionStatus(req)
+		} else {
+			err = ErrIllegalFunction
+		}
+		return
+	})
+
+	return
+}
+
+// HandleServerId passes report server id requests through the chain (see
+// ServerIdHandler).
+func (mh *middlewareHandler) HandleServerId(req *ServerIdRequest) (serverId []byte, running bool, err error) {
+	var sr = &ServerRequest{
+		ClientAddr:   req.ClientAddr,
+		ClientRole:   req.ClientRole,
+		UnitId:       req.UnitId,
+		FunctionCode: fcReportServerId,
+		Request:      req,
+	}
+
+	err = mh.run(sr, func() (err error) {
+		if sih, ok := mh.handler.(ServerIdHandler); ok {
+			serverId, running, err = sih.HandleServerId(req)
+		} else {
+			err = ErrIllegalFunction
+		}
+		return
+	})
+
+	return
+}
+
+// HandleUnknownFunction passes requests carrying other function codes
+// through the chain (see FallbackHandler).
+func (mh *middlewareHandler) HandleUnknownFunction(req *UnknownFunctionRequest) (res []byte, err error) {
+	var sr = &ServerRequest{
+		ClientAddr:   req.ClientAddr,
+		ClientRole:   req.ClientRole,
+		UnitId:       req.UnitId,
+		FunctionCode: req.FunctionCode,
+		IsWrite:      true,
+		Request:      req,
+	}
+
+	err = mh.run(sr, func() (err error) {
+		if fh, ok := mh.handler.(FallbackHandler); ok {
+			res, err = fh.HandleUnknownFunction(req)
+		} else {
+			err = ErrIllegalFunction
+		}
+		return
+	})
+
+	return
+}
+
+// handleCompound passes compound requests through the chain (see
+// compoundHandler).
+func (mh *middlewareHandler) handleCompound(req *ServerRequest,
+	phases func(h RequestHandler, unitId uint8) error) (err error) {
+	err = mh.run(req, func() error {
+		return runCompound(mh.handler, req, phases)
+	})
+
+	return
+}
+
+// Runs req through the middleware chain, handle being the innermost link.
+func (mh *middlewareHandler) run(req *ServerRequest, handle func() error) (err error) {
+	err = mh.next(0, req, handle)()
+
+	return
+}
+
+// Returns the function invoking the i-th middleware of the chain.
+func (mh *middlewareHandler) next(i int, req *ServerRequest, handle func() error) func() error {
+	if i >= len(mh.middlewares) {
+		return handle
+	}
+
+	return func() error {
+		return mh.middlewares[i](req, mh.next(i+1, req, handle))
+	}
+}
+
+// Returns a middleware logging every request, along with its outcome and
+// latency, to customLogger (or to stdout if nil).
+func LoggingMiddleware(customLogger *log.Logger) Middleware {
+	var l = newLogger("modbus-server", customLogger)
+
+	return func(req *ServerRequest, next func() error) (err error) {
+		var ts = time.Now()
+
+		err = next()
+
+		if err == nil || err == errNoResponse {
+			l.Infof("client '%s' (role: '%s'), unit id %v, fc 0x%02x, "+
+				"addr %v, quantity %v: ok (%v)", req.ClientAddr, req.ClientRole,
+				req.UnitId, req.FunctionCode, req.Addr, req.Quantity, time.Since(ts))
+		} else {
+			l.Warningf("client '%s' (role: '%s'), unit id %v, fc 0x%02x, "+
+				"addr %v, quantity %v: %v (%v)", req.ClientAddr, req.ClientRole,
+				req.UnitId, req.FunctionCode, req.Addr, req.Quantity, err, time.Since(ts))
+		}
+
+		return
+	}
+}
+
+// Returns a middleware limiting each client host to rate requests per
+// second on average, with bursts of up to burst requests (token bucket).
+// Requests over the limit are rejected with ErrServerDeviceBusy.
+func RateLimitMiddleware(rate float64, burst uint) Middleware {
+	var rl = &rateLimiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	return func(req *ServerRequest, next func() error) (err error) {
+		if !rl.allow(req.ClientAddr, time.Now()) {
+			err = ErrServerDeviceBusy
+			return
+		}
+
+		err = next()
+
+		return
+	}
+}
+
+// Returns a middleware turning handler panics into ErrServerDeviceFailure
+// errors, logging the panic value to customLogger (or to stdout if nil).
+// The server recovers from handler panics on its own: this middleware is
+// useful to have the panic go through outer middlewares (e.g. for logging)
+// like any other error.
+func RecoveryMiddleware(customLogger *log.Logger) Middleware {
+	var l = newLogger("modbus-server", customLogger)
+
+	return func(req *ServerRequest, next func() error) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				l.Errorf("handler panic (client address: '%s', "+
+					"function code: 0x%02x): %v", req.ClientAddr, req.FunctionCode, r)
+				err = ErrServerDeviceFailure
+			}
+		}()
+
+		err = next()
+
+		return
+	}
+}
+
+// Per client token bucket rate limiter.
+type rateLimiter struct {
+	lock    sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Takes a token from the bucket of clientAddr, returning false if the
+// bucket is empty.
+func (rl *rateLimiter) allow(clientAddr string, now time.Time) (ok bool) {
+	var host string
+	var b *tokenBucket
+	var err error
+
+	// rate limit clients by host rather than by connection
+	host, _, err = net.SplitHostPort(clientAddr)
+	if err != nil {
+		host = clientAddr
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	b = rl.buckets[host]
+	if b == nil {
+		// forget about clients whose bucket has refilled, to keep the
+		// bucket map from growing without bounds
+		if len(rl.buckets) >= 1024 {
+			for h, old := range rl.buckets {
+				if old.tokens+now.Sub(old.last).Seconds()*rl.rate >= rl.burst {
+					delete(rl.buckets, h)
+				}
+			}
+		}
+
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[host] = b
+	}
+
+	b.tokens = min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	}
+
+	return
+}
@@ -0,0 +1,370 @@
+package modbus
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Handler panicking on holding register accesses to address 9.
+type panicTestHandler struct {
+	*DataStore
+}
+
+func (pth *panicTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	if req.Addr == 9 {
+		panic("boom")
+	}
+
+	res, err = pth.DataStore.HandleHoldingRegisters(req)
+
+	return
+}
+
+func TestServerMiddleware(t *testing.T) {
+	var ds *DataStore
+	var client *ModbusClient
+	var seen []ServerRequest
+	var order []string
+	var buf bytes.Buffer
+	var err error
+	var regs []uint16
+
+	ds, err = NewDataStore(&DataStoreConfiguration{
+		Coils:            10,
+		HoldingRegisters: 10,
+	})
+	if err != nil {
+		t.Fatalf("NewDataStore() should have succeeded, got: %v", err)
+	}
+
+	record := func(req *ServerRequest, next func() error) error {
+		seen = append(seen, *req)
+		order = append(order, "record")
+		return next()
+	}
+	readOnly := func(req *ServerRequest, next func() error) error {
+		order = append(order, "readOnly")
+		if req.IsWrite && req.Addr < 5 {
+			return ErrIllegalFunction
+		}
+		return next()
+	}
+
+	client, err = NewFakeClient(WithMiddleware(ds,
+		LoggingMiddleware(log.New(&buf, "", 0)), record, readOnly))
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	// middlewares should run in order and see a uniform descriptor
+	err = client.WriteRegisters(6, []uint16{0x1111, 0x2222})
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+	if len(order) != 2 || order[0] != "record" || order[1] != "readOnly" {
+		t.Errorf("unexpected middleware order: %v", order)
+	}
+	if len(seen) != 1 || seen[0].FunctionCode != fcWriteMultipleRegisters ||
+		seen[0].Addr != 6 || seen[0].Quantity != 2 || !seen[0].IsWrite ||
+		len(seen[0].Registers) != 2 || seen[0].Registers[1] != 0x2222 ||
+		seen[0].ClientAddr == "" {
+		t.Errorf("unexpected request descriptor: %+v", seen)
+	}
+
+	err = client.WriteCoil(7, true)
+	if err != nil {
+		t.Errorf("WriteCoil() should have succeeded, got: %v", err)
+	}
+	if seen[1].FunctionCode != fcWriteSingleCoil || len(seen[1].Coils) != 1 ||
+		!seen[1].Coils[0] || seen[1].Registers != nil {
+		t.Errorf("unexpected request descriptor: %+v", seen[1])
+	}
+
+	// rejected requests should never reach the handler
+	err = client.WriteRegister(2, 0x3333)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	regs, err = client.ReadRegisters(2, 1, HOLDING_REGISTER)
+	if err != nil || regs[0] != 0 {
+		t.Errorf("expected 0, got: %v, %v", regs, err)
+	}
+	if seen[3].FunctionCode != fcReadHoldingRegisters || seen[3].IsWrite {
+		t.Errorf("unexpected request descriptor: %+v", seen[3])
+	}
+
+	// optional interfaces not implemented by the handler should fail
+	_, err = client.ReadFIFOQueue(0)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	if seen[4].FunctionCode != fcReadFifoQueue {
+		t.Errorf("unexpected request descriptor: %+v", seen[4])
+	}
+
+	if !strings.Contains(buf.String(), "fc 0x10, addr 6, quantity 2: ok") ||
+		!strings.Contains(buf.String(), "fc 0x06, addr 2, quantity 1: illegal function") {
+		t.Errorf("unexpected log output: %s", buf.String())
+	}
+
+	return
+}
+
+func TestServerMiddlewareCompoundRequests(t *testing.T) {
+	var ds *DataStore
+	var mux *UnitMux
+	var client *ModbusClient
+	var seen []ServerRequest
+	var buf bytes.Buffer
+	var err error
+	var regs []uint16
+	var reg uint16
+
+	ds, _ = NewDataStore(&DataStoreConfiguration{HoldingRegisters: 10})
+
+	record := func(req *ServerRequest, next func() error) error {
+		seen = append(seen, *req)
+		return next()
+	}
+	noReadsPast5 := func(req *ServerRequest, next func() error) error {
+		if req.ReadQuantity > 0 && req.ReadAddr+req.ReadQuantity > 5 {
+			return ErrIllegalFunction
+		}
+		return next()
+	}
+
+	// middlewares of handlers mapped to a unit mux should be reached too
+	mux = NewUnitMux()
+	mux.Handle(1, WithMiddleware(ds, record, noReadsPast5))
+
+	client, err = NewFakeClient(WithMiddleware(mux,
+		LoggingMiddleware(log.New(&buf, "", 0)), RateLimitMiddleware(0.001, 2)))
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(1)
+
+	// read/write and mask write requests should go through the chain once
+	regs, err = client.ReadWriteRegisters(0, 2, 1, []uint16{0x1234})
+	if err != nil || len(regs) != 2 || regs[1] != 0x1234 {
+		t.Errorf("expected [0 0x1234], got: %v, %v", regs, err)
+	}
+	if len(seen) != 1 || seen[0].FunctionCode != fcReadWriteMultipleRegisters ||
+		!seen[0].IsWrite || seen[0].Addr != 1 || seen[0].Quantity != 1 ||
+		len(seen[0].Registers) != 1 || seen[0].Registers[0] != 0x1234 ||
+		seen[0].ReadAddr != 0 || seen[0].ReadQuantity != 2 {
+		t.Errorf("unexpected request descriptors: %+v", seen)
+	}
+
+	err = client.MaskWriteRegister(1, 0xff00, 0x0056)
+	if err != nil {
+		t.Errorf("MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+	if len(seen) != 2 || seen[1].FunctionCode != fcMaskWriteRegister ||
+		!seen[1].IsWrite || seen[1].Addr != 1 || seen[1].ReadAddr != 1 ||
+		seen[1].Quantity != 1 || seen[1].ReadQuantity != 1 {
+		t.Errorf("unexpected request descriptors: %+v", seen)
+	}
+
+	if strings.Count(buf.String(), "fc 0x17") != 1 ||
+		strings.Count(buf.String(), "fc 0x16") != 1 {
+		t.Errorf("unexpected log output: %s", buf.String())
+	}
+
+	// ... and be charged once by the rate limiter (burst of 2)
+	_, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if err != ErrServerDeviceBusy {
+		t.Errorf("expected ErrServerDeviceBusy, got: %v", err)
+	}
+
+	// a rejected read/write request should not leave its write behind
+	client, err = NewFakeClient(mux)
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(1)
+
+	_, err = client.ReadWriteRegisters(4, 2, 1, []uint16{0x5678})
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	reg, _ = ds.Unit(1).GetRegister(1, HOLDING_REGISTER)
+	if reg != 0x1256 {
+		t.Errorf("expected 0x1256, got: 0x%04x", reg)
+	}
+
+	return
+}
+
+func TestServerMiddlewareServerFunctions(t *testing.T) {
+	var ds *DataStore
+	var mux *UnitMux
+	var client *ModbusClient
+	var seen []ServerRequest
+	var buf bytes.Buffer
+	var err error
+
+	ds, _ = NewDataStore(&DataStoreConfiguration{HoldingRegisters: 10})
+
+	record := func(req *ServerRequest, next func() error) error {
+		seen = append(seen, *req)
+		return next()
+	}
+	noEventLog := func(req *ServerRequest, next func() error) error {
+		if req.FunctionCode == fcGetCommEventLog {
+			return ErrIllegalFunction
+		}
+		return next()
+	}
+
+	mux = NewUnitMux()
+	mux.Handle(1, WithMiddleware(ds, record))
+
+	client, err = NewFakeClient(WithMiddleware(mux,
+		LoggingMiddleware(log.New(&buf, "", 0)), noEventLog))
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(1)
+
+	// diagnostics and comm event requests should go through middlewares,
+	// down to those of the handler mapped to their unit id
+	err = client.ReturnQueryData([]byte{0x12, 0x34})
+	if err != nil {
+		t.Errorf("ReturnQueryData() should have succeeded, got: %v", err)
+	}
+	_, _, err = client.GetCommEventCounter()
+	if err != nil {
+		t.Errorf("GetCommEventCounter() should have succeeded, got: %v", err)
+	}
+	if len(seen) != 2 || seen[0].FunctionCode != fcDiagnostics ||
+		seen[1].FunctionCode != fcGetCommEventCounter || seen[1].UnitId != 1 {
+		t.Errorf("unexpected request descriptors: %+v", seen)
+	}
+
+	// and be rejected by them like any other request
+	_, err = client.GetCommEventLog()
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+	if len(seen) != 2 {
+		t.Errorf("unexpected request descriptors: %+v", seen)
+	}
+
+	// requests to unmapped unit ids should still be served by the server
+	_, _, err = client.Unit(5).GetCommEventCounter()
+	if err != nil {
+		t.Errorf("GetCommEventCounter() should have succeeded, got: %v", err)
+	}
+
+	if strings.Count(buf.String(), "fc 0x0b") != 2 ||
+		!strings.Contains(buf.String(), "fc 0x08") ||
+		!strings.Contains(buf.String(), "fc 0x0c, addr 0, quantity 0: illegal function") {
+		t.Errorf("unexpected log output: %s", buf.String())
+	}
+
+	return
+}
+
+func TestServerMiddlewareRateLimit(t *testing.T) {
+	var ds *DataStore
+	var client *ModbusClient
+	var err error
+
+	ds, _ = NewDataStore(&DataStoreConfiguration{HoldingRegisters: 10})
+
+	client, err = NewFakeClient(WithMiddleware(ds, RateLimitMiddleware(0.001, 3)))
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err = client.ReadRegister(0, HOLDING_REGISTER)
+		if err != nil {
+			t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+		}
+	}
+
+	_, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != ErrServerDeviceBusy {
+		t.Errorf("expected ErrServerDeviceBusy, got: %v", err)
+	}
+
+	// buckets should refill over time and be kept per client host
+	var rl = &rateLimiter{
+		rate:    10,
+		burst:   1,
+		buckets: make(map[string]*tokenBucket),
+	}
+	var ts = time.Now()
+
+	if !rl.allow("10.0.0.1:1000", ts) {
+		t.Errorf("first request should have been allowed")
+	}
+	if rl.allow("10.0.0.1:1001", ts) {
+		t.Errorf("second request from the same host should have been rejected")
+	}
+	if !rl.allow("10.0.0.2:1000", ts) {
+		t.Errorf("first request from another host should have been allowed")
+	}
+	if !rl.allow("10.0.0.1:1000", ts.Add(100*time.Millisecond)) {
+		t.Errorf("request should have been allowed after a refill")
+	}
+
+	return
+}
+
+func TestServerHandlerPanic(t *testing.T) {
+	var ds *DataStore
+	var client *ModbusClient
+	var buf bytes.Buffer
+	var err error
+	var reg uint16
+
+	ds, _ = NewDataStore(&DataStoreConfiguration{HoldingRegisters: 10})
+
+	// the server should survive handler panics on its own
+	client, err = NewFakeClient(&panicTestHandler{DataStore: ds})
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.ReadRegister(9, HOLDING_REGISTER)
+	if err != ErrServerDeviceFailure {
+		t.Errorf("expected ErrServerDeviceFailure, got: %v", err)
+	}
+
+	// and keep serving the client
+	reg, err = client.ReadRegister(8, HOLDING_REGISTER)
+	if err != nil || reg != 0 {
+		t.Errorf("expected 0, got: %v, %v", reg, err)
+	}
+
+	// the recovery middleware should let outer middlewares see the failure
+	client, err = NewFakeClient(WithMiddleware(&panicTestHandler{DataStore: ds},
+		LoggingMiddleware(log.New(&buf, "", 0)), RecoveryMiddleware(log.New(&buf, "", 0))))
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.ReadRegister(9, HOLDING_REGISTER)
+	if err != ErrServerDeviceFailure {
+		t.Errorf("expected ErrServerDeviceFailure, got: %v", err)
+	}
+	if !strings.Contains(buf.String(), "handler panic") ||
+		!strings.Contains(buf.String(), "addr 9, quantity 1: server device failure") {
+		t.Errorf("unexpected log output: %s", buf.String())
+	}
+
+	return
+}
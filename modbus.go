@@ -9,6 +9,9 @@ type pdu struct {
 	unitId       uint8
 	functionCode uint8
 	payload      []byte
+	// optional, used by RTU transports to frame responses to requests
+	// they cannot size on their own (see RawRequest.ResponseLength)
+	responseLength func(payload []byte) (int, error)
 }
 
 const (
@@ -33,6 +36,17 @@ const (
 	fcReadFileRecord  uint8 = 0x14
 	fcWriteFileRecord uint8 = 0x15
 
+	// diagnostics (serial line only)
+	fcReadExceptionStatus uint8 = 0x07
+	fcDiagnostics         uint8 = 0x08
+	fcGetCommEventCounter uint8 = 0x0b
+	fcGetCommEventLog     uint8 = 0x0c
+	fcReportServerId      uint8 = 0x11
+
+	// encapsulated interface transport
+	fcEncapsulatedInterface     uint8 = 0x2b
+	meiReadDeviceIdentification uint8 = 0x0e
+
 	// exception codes
 	exIllegalFunction         uint8 = 0x01
 	exIllegalDataAddress      uint8 = 0x02
@@ -40,6 +54,7 @@ const (
 	exServerDeviceFailure     uint8 = 0x04
 	exAcknowledge             uint8 = 0x05
 	exServerDeviceBusy        uint8 = 0x06
+	exNegativeAcknowledge     uint8 = 0x07
 	exMemoryParityError       uint8 = 0x08
 	exGWPathUnavailable       uint8 = 0x0a
 	exGWTargetFailedToRespond uint8 = 0x0b
@@ -54,16 +69,19 @@ var (
 	ErrServerDeviceFailure     = errors.New("server device failure")
 	ErrAcknowledge             = errors.New("request acknowledged")
 	ErrServerDeviceBusy        = errors.New("server device busy")
+	ErrNegativeAcknowledge     = errors.New("negative acknowledge")
 	ErrMemoryParityError       = errors.New("memory parity error")
 	ErrGWPathUnavailable       = errors.New("gateway path unavailable")
 	ErrGWTargetFailedToRespond = errors.New("gateway target device failed to respond")
 	ErrBadCRC                  = errors.New("bad crc")
+	ErrBadLRC                  = errors.New("bad lrc")
 	ErrShortFrame              = errors.New("short frame")
 	ErrProtocolError           = errors.New("protocol error")
 	ErrBadUnitId               = errors.New("bad unit id")
 	ErrBadTransactionId        = errors.New("bad transaction id")
 	ErrUnknownProtocolId       = errors.New("unknown protocol identifier")
 	ErrUnexpectedParameters    = errors.New("unexpected parameters")
+	ErrNotConnected            = errors.New("not connected")
 )
 
 // mapExceptionCodeToError turns a modbus exception code into a higher level Error object.
@@ -84,6 +102,8 @@ func mapExceptionCodeToError(exceptionCode uint8) error {
 		err = ErrMemoryParityError
 	case exServerDeviceBusy:
 		err = ErrServerDeviceBusy
+	case exNegativeAcknowledge:
+		err = ErrNegativeAcknowledge
 	case exGWPathUnavailable:
 		err = ErrGWPathUnavailable
 	case exGWTargetFailedToRespond:
@@ -111,6 +131,8 @@ func mapErrorToExceptionCode(err error) (exceptionCode uint8) {
 		exceptionCode = exMemoryParityError
 	case ErrServerDeviceBusy:
 		exceptionCode = exServerDeviceBusy
+	case ErrNegativeAcknowledge:
+		exceptionCode = exNegativeAcknowledge
 	case ErrGWPathUnavailable:
 		exceptionCode = exGWPathUnavailable
 	case ErrGWTargetFailedToRespond:
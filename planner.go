@@ -0,0 +1,305 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+)
+
+type TagType uint
+
+const (
+	// tag types and the go type of their values, as returned by ReadTags()
+	TAG_UINT16         TagType = 1  // uint16, 1 register
+	TAG_INT16          TagType = 2  // int16, 1 register
+	TAG_UINT32         TagType = 3  // uint32, 2 registers
+	TAG_INT32          TagType = 4  // int32, 2 registers
+	TAG_FLOAT32        TagType = 5  // float32, 2 registers
+	TAG_UINT64         TagType = 6  // uint64, 4 registers
+	TAG_INT64          TagType = 7  // int64, 4 registers
+	TAG_FLOAT64        TagType = 8  // float64, 4 registers
+	TAG_STRING         TagType = 9  // string, Length bytes
+	TAG_COIL           TagType = 10 // bool, 1 coil
+	TAG_DISCRETE_INPUT TagType = 11 // bool, 1 discrete input
+)
+
+// Tag definition object, describing a value to be read by ReadTags().
+type Tag struct {
+	Name    string  // the name of the tag, unique within a read plan
+	Type    TagType // the type of the tag
+	Addr    uint16  // the (first) coil, discrete input or register address
+	RegType RegType // the register type (register tags only)
+	// Length sets the length of the string in bytes (TAG_STRING only).
+	// Strings are decoded as with ReadBytes(), trailing null bytes removed.
+	Length uint16
+}
+
+// Read plan configuration object.
+type ReadPlanConfiguration struct {
+	// MaxGap sets the max number of unused registers (or coils or discrete
+	// inputs) read between two tags to have them read by the same request
+	// (defaults to 0: only adjacent or overlapping tags are merged). Note
+	// that some devices fail reads covering unmapped addresses.
+	MaxGap uint16
+	// MaxRegisters sets the max number of registers per request (defaults
+	// to 125)
+	MaxRegisters uint16
+	// MaxBits sets the max number of coils or discrete inputs per request
+	// (defaults to 2000)
+	MaxBits uint16
+}
+
+// Read plan object, as returned by NewReadPlan() and passed to ReadTags().
+//
+// A read plan covers a set of tags with as few read requests as possible,
+// by merging tags of the same table (coils, discrete inputs, holding or
+// input registers) located within MaxGap items of each other, up to the
+// max number of items per request.
+// Read plans are immutable and may be shared by multiple clients.
+type ReadPlan struct {
+	requests []*plannedRead
+}
+
+// plannedRead is a single read request covering one or more tags.
+type plannedRead struct {
+	table    TagType // TAG_COIL, TAG_DISCRETE_INPUT or TAG_UINT16
+	regType  RegType
+	addr     uint16
+	quantity uint16
+	tags     []Tag
+}
+
+// Returns the number of registers (or coils or discrete inputs) spanned
+// by the tag.
+func (t *Tag) size() (size uint16, err error) {
+	switch t.Type {
+	case TAG_UINT16, TAG_INT16, TAG_COIL, TAG_DISCRETE_INPUT:
+		size = 1
+	case TAG_UINT32, TAG_INT32, TAG_FLOAT32:
+		size = 2
+	case TAG_UINT64, TAG_INT64, TAG_FLOAT64:
+		size = 4
+	case TAG_STRING:
+		if t.Length == 0 {
+			err = fmt.Errorf("%w: tag %q: string length is 0", ErrConfigurationError, t.Name)
+			return
+		}
+		size = t.Length/2 + t.Length%2
+	default:
+		err = fmt.Errorf("%w: tag %q: unknown tag type %v", ErrConfigurationError, t.Name, t.Type)
+	}
+
+	return
+}
+
+// Returns the table the tag is read from, as a TAG_COIL, TAG_DISCRETE_INPUT
+// or TAG_UINT16 (registers) tag type, along with its register type.
+func (t *Tag) table() (table TagType, regType RegType) {
+	switch t.Type {
+	case TAG_COIL, TAG_DISCRETE_INPUT:
+		table = t.Type
+	default:
+		table = TAG_UINT16
+		regType = t.RegType
+	}
+
+	return
+}
+
+// NewReadPlan plans the reads of tags, merging nearby tags into as few
+// requests as conf allows for. A nil conf selects default settings.
+// Invalid tag definitions yield errors wrapping ErrConfigurationError.
+func NewReadPlan(tags []Tag, conf *ReadPlanConfiguration) (rp *ReadPlan, err error) {
+	var sorted []Tag
+	var names = make(map[string]bool)
+	var maxRegisters uint16 = 125
+	var maxBits uint16 = 2000
+	var maxGap uint16
+	var last *plannedRead
+
+	if conf != nil {
+		if conf.MaxRegisters > 125 || conf.MaxBits > 2000 {
+			err = ErrConfigurationError
+			return
+		}
+		if conf.MaxRegisters != 0 {
+			maxRegisters = conf.MaxRegisters
+		}
+		if conf.MaxBits != 0 {
+			maxBits = conf.MaxBits
+		}
+		maxGap = conf.MaxGap
+	}
+
+	for _, tag := range tags {
+		var size uint16
+		var table TagType
+		var regType RegType
+
+		size, err = tag.size()
+		if err != nil {
+			return
+		}
+
+		table, regType = tag.table()
+		if (table == TAG_UINT16 && size > maxRegisters) ||
+			(table != TAG_UINT16 && size > maxBits) {
+			err = fmt.Errorf("%w: tag %q: size exceeds the max per request", ErrConfigurationError, tag.Name)
+			return
+		}
+
+		if uint32(tag.Addr)+uint32(size)-1 > 0xffff {
+			err = fmt.Errorf("%w: tag %q: end address is past 0xffff", ErrConfigurationError, tag.Name)
+			return
+		}
+
+		if table == TAG_UINT16 && regType != HOLDING_REGISTER && regType != INPUT_REGISTER {
+			err = fmt.Errorf("%w: tag %q: unexpected register type %v", ErrConfigurationError, tag.Name, regType)
+			return
+		}
+
+		if names[tag.Name] {
+			err = fmt.Errorf("%w: tag %q: duplicate tag name", ErrConfigurationError, tag.Name)
+			return
+		}
+		names[tag.Name] = true
+
+		sorted = append(sorted, tag)
+	}
+
+	// group tags by table, then by address
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ti, ri := sorted[i].table()
+		tj, rj := sorted[j].table()
+
+		if ti != tj {
+			return ti < tj
+		}
+		if ri != rj {
+			return ri < rj
+		}
+
+		return sorted[i].Addr < sorted[j].Addr
+	})
+
+	rp = &ReadPlan{}
+	for _, tag := range sorted {
+		var size, _ = tag.size()
+		var table, regType = tag.table()
+		var limit = maxRegisters
+		var end uint32
+
+		if table != TAG_UINT16 {
+			limit = maxBits
+		}
+
+		// extend the last request to cover the tag if close enough and if
+		// the request would not exceed the max size, otherwise start a new
+		// request
+		if last != nil && last.table == table && last.regType == regType {
+			end = max(uint32(last.addr)+uint32(last.quantity), uint32(tag.Addr)+uint32(size))
+
+			if uint32(tag.Addr) <= uint32(last.addr)+uint32(last.quantity)+uint32(maxGap) &&
+				end-uint32(last.addr) <= uint32(limit) {
+				last.quantity = uint16(end - uint32(last.addr))
+				last.tags = append(last.tags, tag)
+				continue
+			}
+		}
+
+		last = &plannedRead{
+			table:    table,
+			regType:  regType,
+			addr:     tag.Addr,
+			quantity: size,
+			tags:     []Tag{tag},
+		}
+		rp.requests = append(rp.requests, last)
+	}
+
+	return
+}
+
+// Returns the number of requests needed to read all tags of the plan.
+func (rp *ReadPlan) Requests() int {
+	return len(rp.requests)
+}
+
+// Reads all tags of a read plan and returns their values, indexed by tag
+// name (see TagType for the type of each value).
+// Register tags are decoded using the encoding of the client.
+// Fails on the first failed request.
+func (mc *ModbusClient) ReadTags(plan *ReadPlan) (map[string]any, error) {
+	return mc.ReadTagsCtx(context.Background(), plan)
+}
+
+// ReadTagsCtx is like ReadTags, bounded by ctx (see ModbusClient).
+func (mc *ModbusClient) ReadTagsCtx(ctx context.Context, plan *ReadPlan) (values map[string]any, err error) {
+	var bools []bool
+	var regs []byte
+
+	values = make(map[string]any)
+
+	for _, req := range plan.requests {
+		switch req.table {
+		case TAG_COIL, TAG_DISCRETE_INPUT:
+			bools, err = mc.readBools(ctx, req.addr, req.quantity, req.table == TAG_DISCRETE_INPUT)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, tag := range req.tags {
+				values[tag.Name] = bools[tag.Addr-req.addr]
+			}
+
+		default:
+			regs, err = mc.readRegisters(ctx, req.addr, req.quantity, req.regType)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, tag := range req.tags {
+				values[tag.Name] = mc.decodeTag(&tag, regs[2*(tag.Addr-req.addr):])
+			}
+		}
+	}
+
+	return
+}
+
+// Decodes the value of a register tag from register bytes, as they come
+// off the wire, starting at the address of the tag.
+func (mc *ModbusClient) decodeTag(tag *Tag, in []byte) (value any) {
+	switch tag.Type {
+	case TAG_UINT16:
+		value = bytesToUint16(mc.endianness, in[0:2])
+	case TAG_INT16:
+		value = int16(bytesToUint16(mc.endianness, in[0:2]))
+	case TAG_UINT32:
+		value = bytesToUint32s(mc.endianness, mc.wordOrder, in[0:4])[0]
+	case TAG_INT32:
+		value = int32(bytesToUint32s(mc.endianness, mc.wordOrder, in[0:4])[0])
+	case TAG_FLOAT32:
+		value = bytesToFloat32s(mc.endianness, mc.wordOrder, in[0:4])[0]
+	case TAG_UINT64:
+		value = bytesToUint64s(mc.endianness, mc.wordOrder, in[0:8])[0]
+	case TAG_INT64:
+		value = int64(bytesToUint64s(mc.endianness, mc.wordOrder, in[0:8])[0])
+	case TAG_FLOAT64:
+		value = bytesToFloat64s(mc.endianness, mc.wordOrder, in[0:8])[0]
+	case TAG_STRING:
+		var buf = make([]byte, 2*(tag.Length/2+tag.Length%2))
+
+		copy(buf, in)
+		// swap bytes on register boundaries, as ReadBytes() does
+		if mc.endianness == LITTLE_ENDIAN {
+			for i := 0; i < len(buf); i += 2 {
+				buf[i], buf[i+1] = buf[i+1], buf[i]
+			}
+		}
+		value = string(bytes.TrimRight(buf[0:tag.Length], "\x00"))
+	}
+
+	return
+}
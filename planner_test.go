@@ -0,0 +1,166 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNewReadPlan(t *testing.T) {
+	var plan *ReadPlan
+	var err error
+	var tags = []Tag{
+		{Name: "coil-50", Type: TAG_COIL, Addr: 50},
+		{Name: "u16", Type: TAG_UINT16, Addr: 0},
+		{Name: "f32", Type: TAG_FLOAT32, Addr: 1},
+		{Name: "str", Type: TAG_STRING, Addr: 100, Length: 5},
+		{Name: "i32", Type: TAG_INT32, Addr: 3},
+		{Name: "u64", Type: TAG_UINT64, Addr: 10},
+		{Name: "coil-5", Type: TAG_COIL, Addr: 5},
+		{Name: "coil-6", Type: TAG_COIL, Addr: 6},
+		{Name: "input", Type: TAG_UINT16, Addr: 0, RegType: INPUT_REGISTER},
+	}
+
+	// only adjacent tags should be merged by default
+	plan, err = NewReadPlan(tags, nil)
+	if err != nil {
+		t.Fatalf("NewReadPlan() should have succeeded, got: %v", err)
+	}
+	if plan.Requests() != 6 {
+		t.Errorf("expected 6 requests, got %v", plan.Requests())
+	}
+
+	// nearby tags should be merged within the max gap...
+	plan, err = NewReadPlan(tags, &ReadPlanConfiguration{MaxGap: 10})
+	if err != nil {
+		t.Fatalf("NewReadPlan() should have succeeded, got: %v", err)
+	}
+	if plan.Requests() != 5 {
+		t.Errorf("expected 5 requests, got %v", plan.Requests())
+	}
+	if plan.requests[0].addr != 0 || plan.requests[0].quantity != 14 ||
+		len(plan.requests[0].tags) != 4 {
+		t.Errorf("unexpected request: %+v", plan.requests[0])
+	}
+
+	// ... and the max request size
+	plan, err = NewReadPlan(tags, &ReadPlanConfiguration{MaxGap: 200, MaxRegisters: 13})
+	if err != nil {
+		t.Fatalf("NewReadPlan() should have succeeded, got: %v", err)
+	}
+	if plan.Requests() != 5 {
+		t.Errorf("expected 5 requests, got %v", plan.Requests())
+	}
+
+	// invalid tags should be rejected
+	for _, invalid := range [][]Tag{
+		{{Name: "a", Type: TAG_UINT16}, {Name: "a", Type: TAG_UINT32, Addr: 2}},
+		{{Name: "a", Type: TAG_STRING}},
+		{{Name: "a", Type: TAG_UINT64, Addr: 0xfffe}},
+		{{Name: "a", Type: TagType(0)}},
+		{{Name: "a", Type: TAG_UINT16, RegType: RegType(5)}},
+		{{Name: "a", Type: TAG_STRING, Length: 252}},
+	} {
+		_, err = NewReadPlan(invalid, nil)
+		if !errors.Is(err, ErrConfigurationError) {
+			t.Errorf("expected ErrConfigurationError for %+v, got: %v", invalid, err)
+		}
+	}
+
+	_, err = NewReadPlan(tags, &ReadPlanConfiguration{MaxRegisters: 126})
+	if err != ErrConfigurationError {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	return
+}
+
+func TestClientReadTags(t *testing.T) {
+	var client *ModbusClient
+	var handler *chunkTestHandler
+	var plan *ReadPlan
+	var err error
+	var values map[string]any
+	var requests int
+
+	handler = &chunkTestHandler{}
+	copy(handler.holding[0:], []uint16{
+		// u16, f32, i32 and a 1-register gap
+		0x1234, 0x4049, 0x0fdb, 0xffff, 0xfffe, 0x0000,
+		// u64 and a 1-register gap
+		0x0102, 0x0304, 0x0506, 0x0708, 0x0000,
+		// str
+		0x6865, 0x6c6c, 0x6f00,
+	})
+	handler.coils[6] = true
+
+	client, err = NewFakeClient(handler)
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	client.conf.Interceptors = []Interceptor{
+		func(ctx context.Context, call *Call, next Invoker) error {
+			requests++
+
+			return next(ctx, call)
+		},
+	}
+
+	plan, err = NewReadPlan([]Tag{
+		{Name: "u16", Type: TAG_UINT16, Addr: 0},
+		{Name: "f32", Type: TAG_FLOAT32, Addr: 1},
+		{Name: "i32", Type: TAG_INT32, Addr: 3},
+		{Name: "u64", Type: TAG_UINT64, Addr: 6},
+		{Name: "str", Type: TAG_STRING, Addr: 11, Length: 6},
+		{Name: "coil-5", Type: TAG_COIL, Addr: 5},
+		{Name: "coil-6", Type: TAG_COIL, Addr: 6},
+	}, &ReadPlanConfiguration{MaxGap: 1})
+	if err != nil {
+		t.Fatalf("NewReadPlan() should have succeeded, got: %v", err)
+	}
+
+	values, err = client.ReadTags(plan)
+	if err != nil {
+		t.Fatalf("client.ReadTags() should have succeeded, got: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, saw %v", requests)
+	}
+
+	for name, expected := range map[string]any{
+		"u16":    uint16(0x1234),
+		"f32":    float32(3.1415927),
+		"i32":    int32(-2),
+		"u64":    uint64(0x0102030405060708),
+		"str":    "hello",
+		"coil-5": false,
+		"coil-6": true,
+	} {
+		if values[name] != expected {
+			t.Errorf("%s: expected %v (%T), got %v (%T)",
+				name, expected, expected, values[name], values[name])
+		}
+	}
+
+	// values should be decoded using the encoding of the client
+	client.SetEncoding(LITTLE_ENDIAN, LOW_WORD_FIRST)
+	values, err = client.ReadTags(plan)
+	if err != nil {
+		t.Fatalf("client.ReadTags() should have succeeded, got: %v", err)
+	}
+	if values["u16"] != uint16(0x3412) || values["i32"] != int32(-16777217) ||
+		values["u64"] != uint64(0x0807060504030201) || values["str"] != "ehll\x00o" {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	// failed requests should fail the whole read
+	handler.bad = 6
+	_, err = client.ReadTags(plan)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	return
+}
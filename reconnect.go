@@ -0,0 +1,238 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// ClientState describes the state of the link between a client and its
+// remote device.
+type ClientState uint32
+
+const (
+	// not open yet, closed by the user or given up on
+	STATE_CLOSED ClientState = 0
+	// open and ready to carry requests
+	STATE_CONNECTED ClientState = 1
+	// broken, being re-established in the background
+	STATE_RECONNECTING ClientState = 2
+)
+
+func (cs ClientState) String() string {
+	switch cs {
+	case STATE_CLOSED:
+		return "closed"
+	case STATE_CONNECTED:
+		return "connected"
+	case STATE_RECONNECTING:
+		return "reconnecting"
+	}
+
+	return fmt.Sprintf("unknown (%v)", uint32(cs))
+}
+
+// Reconnect policy object.
+//
+// When the link to the remote device breaks, the client closes it and
+// attempts to re-establish it in the background, waiting InitialBackoff
+// before the first attempt and Multiplier times longer before each
+// subsequent attempt, up to MaxBackoff.
+// Requests made while reconnecting fail right away with ErrNotConnected:
+// they are never retried, as the remote device may have acted on them.
+type ReconnectPolicy struct {
+	// InitialBackoff sets the delay before the first reconnection attempt
+	// (defaults to 100ms)
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two reconnection attempts
+	// (defaults to 30s)
+	MaxBackoff time.Duration
+	// Multiplier sets the factor by which the delay grows after each
+	// failed attempt (defaults to 2, must be at least 1)
+	Multiplier float64
+	// MaxRetries sets the number of reconnection attempts after which the
+	// client gives up and moves to STATE_CLOSED (0 means no limit)
+	MaxRetries uint
+}
+
+// Returns a copy of the policy with default values filled in.
+func (rp *ReconnectPolicy) withDefaults() (res *ReconnectPolicy, err error) {
+	res = &ReconnectPolicy{}
+	*res = *rp
+
+	if res.InitialBackoff == 0 {
+		res.InitialBackoff = 100 * time.Millisecond
+	}
+
+	if res.MaxBackoff == 0 {
+		res.MaxBackoff = 30 * time.Second
+	}
+
+	if res.Multiplier == 0 {
+		res.Multiplier = 2
+	}
+
+	switch {
+	case res.InitialBackoff < 0 || res.MaxBackoff < 0:
+		err = errors.New("backoff delays must be positive")
+	case res.InitialBackoff > res.MaxBackoff:
+		err = errors.New("initial backoff exceeds max backoff")
+	case res.Multiplier < 1:
+		err = errors.New("multiplier must be at least 1")
+	}
+
+	return
+}
+
+// Returns the delay to wait for before the reconnection attempt
+// following one made after delay.
+func (rp *ReconnectPolicy) nextBackoff(delay time.Duration) (next time.Duration) {
+	next = time.Duration(float64(delay) * rp.Multiplier)
+	if next > rp.MaxBackoff || next < delay {
+		next = rp.MaxBackoff
+	}
+
+	return
+}
+
+// Returns true if err denotes a broken link rather than a transient error.
+func isLinkError(err error) bool {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed):
+		return true
+	case errors.As(err, &netErr):
+		return !netErr.Timeout()
+	}
+
+	return false
+}
+
+// Returns true if the client runs over a connection-oriented network link,
+// which can break and be re-established.
+func (mc *ModbusClient) isStreamLink() bool {
+	switch mc.transportType {
+	case modbusTCP, modbusTCPOverTLS, modbusRTUOverTCP, modbusASCIIOverTCP:
+		return true
+	}
+
+	return false
+}
+
+// Installs t as the transport of the client.
+// Must be called with the client lock held.
+func (mc *ModbusClient) connected(t transport) {
+	mc.transport = t
+	mc.state.Store(uint32(STATE_CONNECTED))
+
+	if mc.conf.OnConnect != nil {
+		mc.conf.OnConnect()
+	}
+
+	return
+}
+
+// Removes the transport of the client, which is expected to be closed.
+// Must be called with the client lock held.
+func (mc *ModbusClient) disconnected(cause error, state ClientState) {
+	mc.transport = nil
+	mc.state.Store(uint32(state))
+
+	if mc.conf.OnDisconnect != nil {
+		mc.conf.OnDisconnect(cause)
+	}
+
+	return
+}
+
+// Closes a broken transport and starts reconnecting in the background.
+// Must be called with the client lock held and a reconnect policy
+// configured.
+func (mc *ModbusClient) linkLost(cause error) {
+	mc.logger.Warningf("link lost: %v", cause)
+
+	mc.transport.Close()
+	mc.disconnected(cause, STATE_RECONNECTING)
+
+	mc.reconnectStop = make(chan struct{})
+	go mc.reconnect(mc.reconnectStop)
+
+	return
+}
+
+// Stops the reconnection goroutine, if any.
+// Must be called with the client lock held.
+func (mc *ModbusClient) stopReconnecting() {
+	if mc.reconnectStop != nil {
+		close(mc.reconnectStop)
+		mc.reconnectStop = nil
+		mc.state.Store(uint32(STATE_CLOSED))
+	}
+
+	return
+}
+
+// Attempts to re-establish the link as per the reconnect policy, until
+// either an attempt succeeds, the policy gives up or stop is closed.
+func (mc *ModbusClient) reconnect(stop chan struct{}) {
+	var t transport
+	var err error
+	var timer *time.Timer
+	var policy = mc.conf.Reconnect
+	var delay = policy.InitialBackoff
+
+	for attempt := uint(1); ; attempt++ {
+		timer = time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
+
+		// dial without holding the lock, so as not to block other
+		// client methods (e.g. Close()) for the duration of the attempt
+		t, err = mc.dial()
+
+		mc.lock.Lock()
+
+		// Open() or Close() may have been called in the meantime
+		select {
+		case <-stop:
+			mc.lock.Unlock()
+			if t != nil {
+				t.Close()
+			}
+			return
+		default:
+		}
+
+		if err == nil {
+			mc.reconnectStop = nil
+			mc.connected(t)
+			mc.lock.Unlock()
+
+			mc.logger.Infof("reconnected after %v attempt(s)", attempt)
+			return
+		}
+
+		if policy.MaxRetries > 0 && attempt >= policy.MaxRetries {
+			mc.reconnectStop = nil
+			mc.state.Store(uint32(STATE_CLOSED))
+			mc.lock.Unlock()
+
+			mc.logger.Errorf("giving up after %v reconnection attempt(s): %v",
+				attempt, err)
+			return
+		}
+
+		mc.lock.Unlock()
+
+		mc.logger.Warningf("reconnection attempt #%v failed: %v", attempt, err)
+		delay = policy.nextBackoff(delay)
+	}
+}
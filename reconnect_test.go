@@ -0,0 +1,331 @@
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconnectPolicyBackoff(t *testing.T) {
+	var rp *ReconnectPolicy
+	var err error
+	var delay time.Duration
+
+	// defaults should be filled in
+	rp, err = (&ReconnectPolicy{}).withDefaults()
+	if err != nil {
+		t.Fatalf("withDefaults() should have succeeded, got: %v", err)
+	}
+	if rp.InitialBackoff != 100*time.Millisecond || rp.MaxBackoff != 30*time.Second ||
+		rp.Multiplier != 2 || rp.MaxRetries != 0 {
+		t.Errorf("unexpected defaults: %+v", rp)
+	}
+
+	// delays should grow exponentially, up to MaxBackoff
+	rp, err = (&ReconnectPolicy{
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+	}).withDefaults()
+	if err != nil {
+		t.Fatalf("withDefaults() should have succeeded, got: %v", err)
+	}
+
+	delay = rp.InitialBackoff
+	for _, expected := range []time.Duration{
+		2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second,
+	} {
+		delay = rp.nextBackoff(delay)
+		if delay != expected {
+			t.Errorf("expected %v, got %v", expected, delay)
+		}
+	}
+
+	// invalid policies should be rejected
+	for _, policy := range []*ReconnectPolicy{
+		{Multiplier: 0.5},
+		{InitialBackoff: -1},
+		{InitialBackoff: 2 * time.Second, MaxBackoff: 1 * time.Second},
+	} {
+		_, err = policy.withDefaults()
+		if err == nil {
+			t.Errorf("withDefaults() should have failed for %+v", policy)
+		}
+	}
+
+	_, err = NewClient(&ClientConfiguration{
+		URL:       "tcp://localhost:5504",
+		Reconnect: &ReconnectPolicy{Multiplier: 0.5},
+	})
+	if err != ErrConfigurationError {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	return
+}
+
+func TestTCPClientReconnect(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var err error
+	var events chan error
+	var cause error
+
+	startServer := func() {
+		server, err = NewServer(&ServerConfiguration{
+			URL:        "tcp://localhost:5504",
+			MaxClients: 2,
+		}, &tcpTestHandler{})
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+
+		err = server.Start()
+		if err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+	}
+
+	// connection events: nil on connect, the cause on disconnect
+	events = make(chan error, 10)
+
+	startServer()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:     "tcp://localhost:5504",
+		Timeout: 500 * time.Millisecond,
+		Reconnect: &ReconnectPolicy{
+			InitialBackoff: 50 * time.Millisecond,
+			MaxBackoff:     100 * time.Millisecond,
+		},
+		OnConnect: func() {
+			events <- nil
+		},
+		OnDisconnect: func(cause error) {
+			if cause == nil {
+				cause = ErrNotConnected
+			}
+			events <- cause
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	if client.State() != STATE_CLOSED {
+		t.Errorf("expected STATE_CLOSED, got %v", client.State())
+	}
+
+	// requests made before opening the client should fail
+	_, err = client.ReadCoil(0)
+	if err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	if client.State() != STATE_CONNECTED {
+		t.Errorf("expected STATE_CONNECTED, got %v", client.State())
+	}
+	if cause = <-events; cause != nil {
+		t.Errorf("expected a connect event, got: %v", cause)
+	}
+
+	err = client.WriteRegister(1, 0x1234)
+	if err != nil {
+		t.Errorf("client.WriteRegister() should have succeeded, got: %v", err)
+	}
+
+	// stop the server, which drops the connection
+	server.Stop()
+
+	_, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if err == nil {
+		t.Errorf("client.ReadRegister() should have failed")
+	}
+	if client.State() != STATE_RECONNECTING {
+		t.Errorf("expected STATE_RECONNECTING, got %v", client.State())
+	}
+	if cause = <-events; cause == nil || cause == ErrNotConnected {
+		t.Errorf("expected a disconnect event with a cause, got: %v", cause)
+	}
+
+	// requests should fail fast while reconnecting
+	_, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got: %v", err)
+	}
+
+	// let a few reconnection attempts fail before bringing the server back
+	time.Sleep(200 * time.Millisecond)
+	startServer()
+
+	select {
+	case cause = <-events:
+		if cause != nil {
+			t.Errorf("expected a connect event, got: %v", cause)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("client failed to reconnect")
+	}
+
+	if client.State() != STATE_CONNECTED {
+		t.Errorf("expected STATE_CONNECTED, got %v", client.State())
+	}
+
+	_, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegister() should have succeeded, got: %v", err)
+	}
+
+	// closing the client should report a disconnection without a cause
+	client.Close()
+	if client.State() != STATE_CLOSED {
+		t.Errorf("expected STATE_CLOSED, got %v", client.State())
+	}
+	if cause = <-events; cause != ErrNotConnected {
+		t.Errorf("expected a disconnect event without a cause, got: %v", cause)
+	}
+
+	server.Stop()
+
+	return
+}
+
+func TestTCPClientReconnectGiveUp(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var err error
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+		Reconnect: &ReconnectPolicy{
+			InitialBackoff: 10 * time.Millisecond,
+			MaxRetries:     3,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+
+	server.Stop()
+
+	_, err = client.ReadCoil(0)
+	if err == nil {
+		t.Errorf("client.ReadCoil() should have failed")
+	}
+
+	// 3 attempts should be made 10, 20 and 40ms apart before giving up
+	time.Sleep(300 * time.Millisecond)
+	if client.State() != STATE_CLOSED {
+		t.Errorf("expected STATE_CLOSED, got %v", client.State())
+	}
+
+	_, err = client.ReadCoil(0)
+	if err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got: %v", err)
+	}
+
+	client.Close()
+
+	return
+}
+
+func TestTCPClientLinkLostWithoutReconnect(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var err error
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.SetUnitId(9)
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+
+	_, err = client.ReadCoil(0)
+	if err != nil {
+		t.Errorf("client.ReadCoil() should have succeeded, got: %v", err)
+	}
+
+	// drop all connections but keep listening
+	server.lock.Lock()
+	for _, sock := range server.tcpClients {
+		sock.Close()
+	}
+	server.lock.Unlock()
+
+	_, err = client.ReadCoil(0)
+	if err == nil {
+		t.Errorf("client.ReadCoil() should have failed")
+	}
+
+	// without a reconnect policy, the transport should be left as is:
+	// the client stays connected and keeps reporting i/o errors...
+	time.Sleep(50 * time.Millisecond)
+	if client.State() != STATE_CONNECTED {
+		t.Errorf("expected STATE_CONNECTED, got %v", client.State())
+	}
+
+	_, err = client.ReadCoil(0)
+	if err == nil || err == ErrNotConnected {
+		t.Errorf("expected an i/o error, got: %v", err)
+	}
+
+	// ... until closed and opened again
+	client.Close()
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+
+	_, err = client.ReadCoil(0)
+	if err != nil {
+		t.Errorf("client.ReadCoil() should have succeeded, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
@@ -0,0 +1,200 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Retry policy object.
+//
+// Requests failing with one of the RetryOn errors are retried up to
+// MaxRetries times, Delay (plus a random duration of up to Jitter) apart.
+// Requests which modify the state of the remote device (writes, but also
+// raw requests and diagnostics which clear counters or restart the device)
+// are only retried if RetryWrites is set, as the device may have acted on
+// the request before its response got lost or corrupted.
+//
+// Requests failing after having been retried return a *RetryError
+// wrapping the last error: use errors.Is() rather than == to test
+// for specific errors.
+type RetryPolicy struct {
+	// MaxRetries sets the number of retries following the initial attempt
+	// (defaults to 3, use NO_RETRIES to disable retries while keeping the
+	// policy installed)
+	MaxRetries uint
+	// Delay sets the delay between two attempts (defaults to 100ms)
+	Delay time.Duration
+	// Jitter sets the max random duration added to Delay (defaults to 0)
+	Jitter time.Duration
+	// RetryOn sets which errors are worth retrying on (defaults to
+	// DefaultRetryableErrors)
+	RetryOn []error
+	// RetryWrites allows for requests modifying device state to be retried
+	RetryWrites bool
+}
+
+// Value of RetryPolicy.MaxRetries disabling retries, a zero MaxRetries
+// standing for the default number of retries.
+const NO_RETRIES uint = math.MaxUint
+
+// Errors considered transient by default, i.e. when RetryPolicy.RetryOn
+// is nil.
+var DefaultRetryableErrors = []error{
+	ErrRequestTimedOut,
+	ErrBadCRC,
+	ErrBadLRC,
+	ErrServerDeviceBusy,
+	ErrAcknowledge,
+	ErrGWTargetFailedToRespond,
+}
+
+// RetryError is returned by requests which failed after being retried.
+type RetryError struct {
+	// Attempts holds the number of attempts made, initial attempt included
+	Attempts uint
+	// Err holds the error returned by the last attempt
+	Err error
+}
+
+func (re *RetryError) Error() string {
+	return fmt.Sprintf("%v (after %v attempts)", re.Err, re.Attempts)
+}
+
+func (re *RetryError) Unwrap() error {
+	return re.Err
+}
+
+// Returns a copy of the policy with default values filled in.
+func (rp *RetryPolicy) withDefaults() (res *RetryPolicy, err error) {
+	res = &RetryPolicy{}
+	*res = *rp
+
+	switch res.MaxRetries {
+	case 0:
+		res.MaxRetries = 3
+	case NO_RETRIES:
+		res.MaxRetries = 0
+	}
+
+	if res.Delay == 0 {
+		res.Delay = 100 * time.Millisecond
+	}
+
+	if res.RetryOn == nil {
+		res.RetryOn = DefaultRetryableErrors
+	}
+
+	if res.Delay < 0 || res.Jitter < 0 {
+		err = errors.New("delays must be positive")
+	}
+
+	return
+}
+
+// Returns true if req may be retried under this policy.
+func (rp *RetryPolicy) allows(req *pdu) bool {
+	return rp.RetryWrites || isReadOnlyRequest(req)
+}
+
+// Returns true if err is worth retrying on.
+func (rp *RetryPolicy) retryable(err error) bool {
+	for _, target := range rp.RetryOn {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the delay to wait for before the next attempt.
+func (rp *RetryPolicy) delay() (d time.Duration) {
+	d = rp.Delay
+	if rp.Jitter > 0 {
+		d += rand.N(rp.Jitter)
+	}
+
+	return
+}
+
+// Returns true if req leaves the state of the remote device untouched.
+func isReadOnlyRequest(req *pdu) bool {
+	var subFunction DiagSubFunction
+
+	switch req.functionCode {
+	case fcReadCoils, fcReadDiscreteInputs, fcReadHoldingRegisters,
+		fcReadInputRegisters, fcReadFifoQueue, fcReadFileRecord,
+		fcReadExceptionStatus, fcGetCommEventCounter, fcGetCommEventLog,
+		fcReportServerId, fcEncapsulatedInterface:
+		return true
+
+	case fcDiagnostics:
+		if len(req.payload) < 2 {
+			return false
+		}
+
+		// sub-functions echoing data or returning counters are harmless,
+		// others restart the device, clear counters or change its mode
+		subFunction = DiagSubFunction(bytesToUint16(BIG_ENDIAN, req.payload[0:2]))
+		switch {
+		case subFunction == DIAG_RETURN_QUERY_DATA,
+			subFunction == DIAG_RETURN_DIAGNOSTIC_REGISTER,
+			subFunction >= DIAG_BUS_MESSAGE_COUNT &&
+				subFunction <= DIAG_BUS_CHAR_OVERRUN_COUNT:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Runs a request across the transport, retrying as per the retry policy
+// of the client if needed.
+// Exception responses are returned as errors once the request has been
+// retried, and as responses otherwise.
+func (mc *ModbusClient) executeRequestWithRetries(ctx context.Context, req *pdu) (res *pdu, err error) {
+	var attempt uint
+	var reqErr error
+	var policy = mc.conf.Retry
+
+	for attempt = 1; ; attempt++ {
+		res, err = mc.executeRequestOnce(ctx, req)
+
+		// look at exceptions as well as errors to decide whether to retry
+		reqErr = err
+		if err == nil && res.functionCode == (req.functionCode|0x80) &&
+			len(res.payload) == 1 {
+			reqErr = mapExceptionCodeToError(res.payload[0])
+		}
+
+		if reqErr == nil || attempt > policy.MaxRetries ||
+			!policy.retryable(reqErr) {
+			break
+		}
+
+		mc.logger.Warningf("attempt #%v failed (%v), retrying", attempt, reqErr)
+
+		mc.unlockedIfPipelined(func() {
+			err = sleepCtx(ctx, policy.delay())
+		})
+		if err != nil {
+			res = nil
+			reqErr = err
+			break
+		}
+	}
+
+	if attempt > 1 && reqErr != nil {
+		res = nil
+		err = &RetryError{
+			Attempts: attempt,
+			Err:      reqErr,
+		}
+	}
+
+	return
+}
@@ -0,0 +1,305 @@
+package modbus
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsReadOnlyRequest(t *testing.T) {
+	for _, tc := range []struct {
+		req      *pdu
+		readOnly bool
+	}{
+		{&pdu{functionCode: fcReadHoldingRegisters}, true},
+		{&pdu{functionCode: fcReadCoils}, true},
+		{&pdu{functionCode: fcEncapsulatedInterface}, true},
+		{&pdu{functionCode: fcWriteSingleRegister}, false},
+		{&pdu{functionCode: fcReadWriteMultipleRegisters}, false},
+		{&pdu{functionCode: fcMaskWriteRegister}, false},
+		{&pdu{functionCode: 0x41}, false},
+		{&pdu{functionCode: fcDiagnostics, payload: []byte{0x00, 0x00, 0x12}}, true},
+		{&pdu{functionCode: fcDiagnostics, payload: []byte{0x00, 0x0e, 0x00, 0x00}}, true},
+		{&pdu{functionCode: fcDiagnostics, payload: []byte{0x00, 0x01, 0x00, 0x00}}, false},
+		{&pdu{functionCode: fcDiagnostics, payload: []byte{0x00, 0x0a, 0x00, 0x00}}, false},
+		{&pdu{functionCode: fcDiagnostics, payload: []byte{0x00, 0x14, 0x00, 0x00}}, false},
+	} {
+		if isReadOnlyRequest(tc.req) != tc.readOnly {
+			t.Errorf("expected %v for function code 0x%02x/payload %v",
+				tc.readOnly, tc.req.functionCode, tc.req.payload)
+		}
+	}
+
+	return
+}
+
+func TestTCPClientRetries(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var handler *flakyTestHandler
+	var err error
+	var retryErr *RetryError
+	var reg uint16
+
+	handler = &flakyTestHandler{}
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, handler)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+		Retry: &RetryPolicy{
+			MaxRetries: 2,
+			Delay:      10 * time.Millisecond,
+			Jitter:     5 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(9)
+
+	// a read failing twice should succeed on the third attempt
+	handler.fail(2, ErrServerDeviceBusy)
+	reg, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegister() should have succeeded, got: %v", err)
+	}
+	if reg != 0 {
+		t.Errorf("expected 0, got 0x%04x", reg)
+	}
+	if handler.attempts() != 3 {
+		t.Errorf("expected 3 attempts, saw %v", handler.attempts())
+	}
+
+	// a read failing more than MaxRetries times should return a RetryError
+	handler.fail(5, ErrAcknowledge)
+	_, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("expected a RetryError, got: %v", err)
+	}
+	if retryErr.Attempts != 3 || !errors.Is(err, ErrAcknowledge) {
+		t.Errorf("unexpected RetryError: %v", retryErr)
+	}
+	if handler.attempts() != 3 {
+		t.Errorf("expected 3 attempts, saw %v", handler.attempts())
+	}
+
+	// non-retryable errors should be returned right away, unwrapped
+	handler.fail(1, ErrIllegalDataValue)
+	_, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if err != ErrIllegalDataValue {
+		t.Errorf("expected ErrIllegalDataValue, got: %v", err)
+	}
+	if handler.attempts() != 1 {
+		t.Errorf("expected 1 attempt, saw %v", handler.attempts())
+	}
+
+	// writes should not be retried by default
+	handler.fail(1, ErrServerDeviceBusy)
+	err = client.WriteRegister(1, 0x1234)
+	if err != ErrServerDeviceBusy {
+		t.Errorf("expected ErrServerDeviceBusy, got: %v", err)
+	}
+	if handler.attempts() != 1 {
+		t.Errorf("expected 1 attempt, saw %v", handler.attempts())
+	}
+
+	client.Close()
+
+	// ... unless explicitly allowed
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+		Retry: &RetryPolicy{
+			Delay:       10 * time.Millisecond,
+			RetryWrites: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	handler.fail(1, ErrServerDeviceBusy)
+	err = client.WriteRegister(1, 0x1234)
+	if err != nil {
+		t.Errorf("client.WriteRegister() should have succeeded, got: %v", err)
+	}
+	if handler.attempts() != 2 {
+		t.Errorf("expected 2 attempts, saw %v", handler.attempts())
+	}
+
+	reg, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegister() should have succeeded, got: %v", err)
+	}
+	if reg != 0x1234 {
+		t.Errorf("expected 0x1234, got 0x%04x", reg)
+	}
+
+	client.Close()
+
+	// NO_RETRIES should disable retries altogether
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+		Retry: &RetryPolicy{
+			MaxRetries:  NO_RETRIES,
+			RetryWrites: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(9)
+
+	handler.fail(1, ErrServerDeviceBusy)
+	_, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if err != ErrServerDeviceBusy {
+		t.Errorf("expected ErrServerDeviceBusy, got: %v", err)
+	}
+	if handler.attempts() != 1 {
+		t.Errorf("expected 1 attempt, saw %v", handler.attempts())
+	}
+
+	return
+}
+
+func TestRTUTransportRetryOnBadCRC(t *testing.T) {
+	var client *ModbusClient
+	var p1, p2 net.Conn
+	var err error
+	var reg uint16
+	var done chan struct{}
+
+	p1, p2 = net.Pipe()
+	done = make(chan struct{})
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "rtu:///dev/null",
+		Retry: &RetryPolicy{
+			Delay: 10 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.transport = newRTUTransport(p2, "", 19200, 100*time.Millisecond, nil)
+	client.SetUnitId(0x11)
+
+	// play the role of the remote device: reply with a corrupted frame,
+	// then with a valid one
+	go func() {
+		var rxbuf = make([]byte, 8)
+		var rt = &rtuTransport{}
+		var frame []byte
+
+		defer close(done)
+
+		for i := 0; i < 2; i++ {
+			_, err := io.ReadFull(p1, rxbuf)
+			if err != nil {
+				t.Errorf("failed to read request: %v", err)
+				return
+			}
+
+			frame = rt.assembleRTUFrame(&pdu{
+				unitId:       0x11,
+				functionCode: fcReadHoldingRegisters,
+				payload:      []byte{0x02, 0x12, 0x34},
+			})
+			if i == 0 {
+				frame[len(frame)-1] ^= 0xff
+			}
+
+			_, err = p1.Write(frame)
+			if err != nil {
+				t.Errorf("failed to write response: %v", err)
+			}
+		}
+	}()
+
+	reg, err = client.ReadRegister(0x0001, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+	}
+	if reg != 0x1234 {
+		t.Errorf("expected 0x1234, got 0x%04x", reg)
+	}
+
+	<-done
+	p1.Close()
+	p2.Close()
+}
+
+// flakyTestHandler fails a set number of holding register accesses.
+type flakyTestHandler struct {
+	tcpTestHandler
+	lock     sync.Mutex
+	failures int
+	err      error
+	count    int
+}
+
+// Makes the next n accesses fail with err, and resets the access counter.
+func (fh *flakyTestHandler) fail(n int, err error) {
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+
+	fh.failures = n
+	fh.err = err
+	fh.count = 0
+}
+
+// Returns the number of accesses since the last call to fail().
+func (fh *flakyTestHandler) attempts() int {
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+
+	return fh.count
+}
+
+func (fh *flakyTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+
+	fh.count++
+	if fh.failures > 0 {
+		fh.failures--
+		err = fh.err
+		return
+	}
+
+	res, err = fh.tcpTestHandler.HandleHoldingRegisters(req)
+
+	return
+}
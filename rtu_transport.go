@@ -1,9 +1,11 @@
 package modbus
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"time"
 )
 
@@ -13,11 +15,16 @@ const (
 
 type rtuTransport struct {
 	logger       *logger
-	link         rtuLink
+	link         *ctxLink
 	timeout      time.Duration
 	lastActivity time.Time
 	t35          time.Duration
 	t1           time.Duration
+	// request in flight, used to size responses echoing request data
+	req *pdu
+	// set when a request is abandoned after being sent, in which case
+	// its response may still come off the link
+	resync bool
 }
 
 type rtuLink interface {
@@ -31,7 +38,7 @@ type rtuLink interface {
 func newRTUTransport(link rtuLink, addr string, speed uint, timeout time.Duration, customLogger *log.Logger) (rt *rtuTransport) {
 	rt = &rtuTransport{
 		logger:  newLogger(fmt.Sprintf("rtu-transport(%s)", addr), customLogger),
-		link:    link,
+		link:    newCtxLink(link),
 		timeout: timeout,
 		t1:      serialCharTime(speed),
 	}
@@ -56,11 +63,26 @@ func (rt *rtuTransport) Close() (err error) {
 }
 
 // Runs a request across the rtu link and returns a response.
-func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
+// I/O deadlines and delays are bounded by ctx.
+func (rt *rtuTransport) ExecuteRequest(ctx context.Context, req *pdu) (res *pdu, err error) {
 	var ts time.Time
 	var t time.Duration
 	var n int
 
+	defer rt.link.bind(ctx)()
+
+	// if the previous request was abandoned, give its response enough
+	// time to come off the link and discard it
+	if rt.resync {
+		err = sleepCtx(ctx, time.Until(
+			rt.lastActivity.Add(time.Duration(maxRTUFrameLength)*rt.t1)))
+		if err != nil {
+			return
+		}
+		discard(rt.link)
+		rt.resync = false
+	}
+
 	// set an i/o deadline on the link
 	err = rt.link.SetDeadline(time.Now().Add(rt.timeout))
 	if err != nil {
@@ -71,7 +93,10 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 	// let t3.5 expire before transmitting
 	t = time.Since(rt.lastActivity.Add(rt.t35))
 	if t < 0 {
-		time.Sleep(t * (-1))
+		err = sleepCtx(ctx, t*(-1))
+		if err != nil {
+			return
+		}
 	}
 
 	ts = time.Now()
@@ -89,10 +114,25 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 	rt.lastActivity = ts.Add(time.Duration(n) * rt.t1)
 
 	// observe inter-frame delays
-	time.Sleep(rt.lastActivity.Add(rt.t35).Sub(time.Now()))
+	err = sleepCtx(ctx, time.Until(rt.lastActivity.Add(rt.t35)))
+	if err != nil {
+		rt.resync = true
+		return
+	}
 
 	// read the response back from the wire
+	rt.req = req
 	res, err = rt.readRTUFrame()
+	rt.req = nil
+
+	if err != nil && ctxErr(ctx) != nil {
+		// the request was abandoned: rather than waiting for the link
+		// to go quiet now, re-sync before sending the next request
+		rt.resync = true
+		rt.lastActivity = time.Now()
+		err = ctxErr(ctx)
+		return
+	}
 
 	if err == ErrBadCRC || err == ErrProtocolError || err == ErrShortFrame {
 		// wait for and flush any data coming off the link to allow
@@ -111,8 +151,32 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 
 // Reads a request from the rtu link.
 func (rt *rtuTransport) ReadRequest() (req *pdu, err error) {
-	// reading requests from RTU links is currently unsupported
-	err = fmt.Errorf("unimplemented")
+	// set an i/o deadline on the link
+	err = rt.link.SetDeadline(time.Now().Add(rt.timeout))
+	if err != nil {
+		return
+	}
+
+	req, err = rt.readFrame(2, expectedRequestFrameLength)
+
+	if err == ErrBadCRC || err == ErrProtocolError || err == ErrShortFrame {
+		// wait for and flush any data coming off the link to
+		// re-sync with the next frame
+		time.Sleep(time.Duration(maxRTUFrameLength) * rt.t1)
+		discard(rt.link)
+	}
+
+	// mark the time if we heard anything back
+	if err != ErrRequestTimedOut && !os.IsTimeout(err) {
+		rt.lastActivity = time.Now()
+	}
+
+	return
+}
+
+// Ties request reads to ctx (see readBinder).
+func (rt *rtuTransport) bindReads(ctx context.Context) (unbind func()) {
+	unbind = rt.link.bindReads(ctx, rt.timeout)
 
 	return
 }
@@ -120,6 +184,13 @@ func (rt *rtuTransport) ReadRequest() (req *pdu, err error) {
 // Writes a response to the rtu link.
 func (rt *rtuTransport) WriteResponse(res *pdu) (err error) {
 	var n int
+	var t time.Duration
+
+	// let t3.5 expire after the end of the request before transmitting
+	t = time.Since(rt.lastActivity.Add(rt.t35))
+	if t < 0 {
+		time.Sleep(t * (-1))
+	}
 
 	// build an RTU ADU out of the request object and
 	// send the final ADU+CRC on the wire
@@ -133,57 +204,110 @@ func (rt *rtuTransport) WriteResponse(res *pdu) (err error) {
 	return
 }
 
-// Waits for, reads and decodes a frame from the rtu link.
+// Waits for, reads and decodes a response frame from the rtu link.
 func (rt *rtuTransport) readRTUFrame() (res *pdu, err error) {
+	// read the serial ADU header: unit id (1 byte), function code (1 byte) and
+	// PDU length/exception code (1 byte)
+	res, err = rt.readFrame(3, func(frame []byte) (int, error) {
+		return expectedResponseFrameLength(rt.req, frame)
+	})
+
+	return
+}
+
+// Waits for, reads and decodes a frame from the rtu link, starting with
+// headerLength bytes of header. frameLength is called to compute the length
+// of the frame from the bytes received so far, and may return -1 if the
+// length cannot be known, in which case the end of the frame is detected
+// by line silence.
+func (rt *rtuTransport) readFrame(headerLength int,
+	frameLength func(frame []byte) (int, error)) (res *pdu, err error) {
 	var rxbuf []byte
 	var byteCount int
+	var bytesRead int
 	var bytesNeeded int
 	var crc crc
 
 	rxbuf = make([]byte, maxRTUFrameLength)
 
-	// read the serial ADU header: unit id (1 byte), function code (1 byte) and
-	// PDU length/exception code (1 byte)
-	byteCount, err = io.ReadFull(rt.link, rxbuf[0:3])
-	if (byteCount > 0 || err == nil) && byteCount != 3 {
+	byteCount, err = io.ReadFull(rt.link, rxbuf[0:headerLength])
+	if (byteCount > 0 || err == nil) && byteCount != headerLength {
 		err = ErrShortFrame
 		return
 	}
 	if err != nil && err != io.ErrUnexpectedEOF {
 		return
 	}
-
-	// figure out how many further bytes to read
-	bytesNeeded, err = expectedResponseLenth(uint8(rxbuf[1]), uint8(rxbuf[2]))
-	if err != nil {
-		return
+	bytesRead = headerLength
+
+	for {
+		// figure out how many further bytes to read
+		bytesNeeded, err = frameLength(rxbuf[0:bytesRead])
+		if err != nil {
+			return
+		}
+
+		// if the frame length is unknown, read until the line goes silent
+		if bytesNeeded < 0 {
+			bytesRead, err = rt.readUntilSilence(rxbuf, bytesRead)
+			if err != nil {
+				return
+			}
+
+			// expect at least 2 bytes of CRC
+			if bytesRead < headerLength+2 {
+				err = ErrShortFrame
+				return
+			}
+			bytesRead -= 2
+			break
+		}
+
+		// stop once the entire frame (minus the CRC) has been read
+		if bytesNeeded <= bytesRead {
+			break
+		}
+
+		// never read more than the max allowed frame length
+		// (accounting for 2 bytes of CRC after the payload)
+		if bytesNeeded+2 > maxRTUFrameLength {
+			err = ErrProtocolError
+			return
+		}
+
+		byteCount, err = io.ReadFull(rt.link, rxbuf[bytesRead:bytesNeeded])
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return
+		}
+		if byteCount != bytesNeeded-bytesRead {
+			rt.logger.Warningf("expected %v bytes, received %v",
+				bytesNeeded-bytesRead, byteCount)
+			err = ErrShortFrame
+			return
+		}
+		bytesRead = bytesNeeded
 	}
 
-	// we need to read 2 additional bytes of CRC after the payload
-	bytesNeeded += 2
-
-	// never read more than the max allowed frame length
-	if byteCount+bytesNeeded > maxRTUFrameLength {
-		err = ErrProtocolError
-		return
-	}
-
-	byteCount, err = io.ReadFull(rt.link, rxbuf[3:3+bytesNeeded])
-	if err != nil && err != io.ErrUnexpectedEOF {
-		return
-	}
-	if byteCount != bytesNeeded {
-		rt.logger.Warningf("expected %v bytes, received %v", bytesNeeded, byteCount)
-		err = ErrShortFrame
-		return
+	// we need to read 2 additional bytes of CRC after the payload,
+	// unless they were already read while waiting for silence
+	if bytesNeeded >= 0 {
+		byteCount, err = io.ReadFull(rt.link, rxbuf[bytesRead:bytesRead+2])
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return
+		}
+		if byteCount != 2 {
+			rt.logger.Warningf("expected 2 bytes of CRC, received %v", byteCount)
+			err = ErrShortFrame
+			return
+		}
 	}
 
 	// compute the CRC on the entire frame, excluding the CRC
 	crc.init()
-	crc.add(rxbuf[0 : 3+bytesNeeded-2])
+	crc.add(rxbuf[0:bytesRead])
 
 	// compare CRC values
-	if !crc.isEqual(rxbuf[3+bytesNeeded-2], rxbuf[3+bytesNeeded-1]) {
+	if !crc.isEqual(rxbuf[bytesRead], rxbuf[bytesRead+1]) {
 		err = ErrBadCRC
 		return
 	}
@@ -192,7 +316,51 @@ func (rt *rtuTransport) readRTUFrame() (res *pdu, err error) {
 		unitId:       rxbuf[0],
 		functionCode: rxbuf[1],
 		// pass the byte count + trailing data as payload, withtout the CRC
-		payload: rxbuf[2 : 3+bytesNeeded-2],
+		payload: rxbuf[2:bytesRead],
+	}
+
+	return
+}
+
+// Reads bytes into rxbuf, starting at offset, until either the line goes
+// silent for t3.5 (with a floor of 10ms to account for OS scheduling and
+// serial driver latencies) or rxbuf is full.
+// Returns the total number of bytes in rxbuf.
+func (rt *rtuTransport) readUntilSilence(rxbuf []byte, offset int) (length int, err error) {
+	var n int
+	var silence time.Duration
+
+	silence = rt.t35
+	if silence < 10*time.Millisecond {
+		silence = 10 * time.Millisecond
+	}
+
+	length = offset
+	for length < len(rxbuf) {
+		err = rt.link.SetDeadline(time.Now().Add(silence))
+		if err != nil {
+			return
+		}
+
+		n, err = rt.link.Read(rxbuf[length:])
+		length += n
+
+		// a timeout, an empty read (as serial ports do after their own
+		// internal timeout) or the end of a datagram marks the end of
+		// the frame
+		if err == ErrRequestTimedOut || os.IsTimeout(err) ||
+			(n == 0 && err == nil) || err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	// frames longer than the max allowed frame length are invalid
+	if length == len(rxbuf) {
+		err = ErrProtocolError
 	}
 
 	return
@@ -216,14 +384,172 @@ func (rt *rtuTransport) assembleRTUFrame(p *pdu) (adu []byte) {
 	return
 }
 
+// Computes the expected length of a modbus RTU response frame (unit id,
+// function code and payload, CRC excluded) from the bytes received so far.
+// If the length cannot be determined from those bytes alone, the returned
+// length covers the bytes needed to make further progress.
+// req is the request being answered, if known.
+func expectedResponseFrameLength(req *pdu, frame []byte) (frameLength int, err error) {
+	// defer to the request-provided length function, if any
+	if req != nil && req.responseLength != nil {
+		switch frame[1] {
+		case req.functionCode:
+			frameLength, err = req.responseLength(frame[2:])
+			frameLength += 2
+			return
+		case req.functionCode | 0x80:
+			// exception responses carry a single byte of exception code
+			frameLength = 3
+			return
+		}
+	}
+
+	switch frame[1] {
+	case fcDiagnostics:
+		// the response starts with the 2-byte sub-function of the request
+		if len(frame) < 4 {
+			frameLength = 4
+			return
+		}
+
+		// return query data responses echo the data field of the request,
+		// while all other sub-functions carry a 2-byte data field
+		if bytesToUint16(BIG_ENDIAN, frame[2:4]) == uint16(DIAG_RETURN_QUERY_DATA) {
+			if req == nil || req.functionCode != fcDiagnostics {
+				err = ErrProtocolError
+				return
+			}
+			frameLength = 2 + len(req.payload)
+		} else {
+			frameLength = 6
+		}
+	case fcReadFifoQueue:
+		// the FIFO queue response carries a 2-byte byte count field
+		if len(frame) < 4 {
+			frameLength = 4
+			return
+		}
+		frameLength = 4 + int(bytesToUint16(BIG_ENDIAN, frame[2:4]))
+	case fcEncapsulatedInterface:
+		// only read device identification responses are supported
+		if frame[2] != meiReadDeviceIdentification {
+			err = ErrProtocolError
+			return
+		}
+
+		// the response header is 8 bytes long, with the number of objects
+		// at the very end
+		if len(frame) < 8 {
+			frameLength = 8
+			return
+		}
+
+		// each object is made of 1 byte of id, 1 byte of length and
+		// a variable length value
+		frameLength = 8
+		for i := 0; i < int(frame[7]); i++ {
+			if len(frame) < frameLength+2 {
+				frameLength += 2
+				return
+			}
+			frameLength += 2 + int(frame[frameLength+1])
+		}
+	default:
+		frameLength, err = expectedResponseLenth(frame[1], frame[2])
+		frameLength += 3
+	}
+
+	return
+}
+
+// Computes the expected length of a modbus RTU request frame (unit id,
+// function code and payload, CRC excluded) from the bytes received so far.
+// Returns -1 if the length of the frame cannot be determined from its
+// contents (in which case the end of the frame is marked by line silence).
+func expectedRequestFrameLength(frame []byte) (frameLength int, err error) {
+	switch frame[1] {
+	case fcReadExceptionStatus,
+		fcGetCommEventCounter,
+		fcGetCommEventLog,
+		fcReportServerId:
+		// unit id and function code only
+		frameLength = 2
+	case fcReadFifoQueue:
+		// FIFO pointer address
+		frameLength = 4
+	case fcEncapsulatedInterface:
+		// MEI type, read device id code and object id
+		frameLength = 5
+	case fcReadCoils,
+		fcReadDiscreteInputs,
+		fcReadHoldingRegisters,
+		fcReadInputRegisters,
+		fcWriteSingleCoil,
+		fcWriteSingleRegister:
+		// address and quantity/value
+		frameLength = 6
+	case fcMaskWriteRegister:
+		// address, AND mask and OR mask
+		frameLength = 8
+	case fcReadFileRecord, fcWriteFileRecord:
+		// byte count followed by sub-requests
+		if len(frame) < 3 {
+			frameLength = 3
+			return
+		}
+		frameLength = 3 + int(frame[2])
+	case fcWriteMultipleCoils, fcWriteMultipleRegisters:
+		// address, quantity and byte count followed by values
+		if len(frame) < 7 {
+			frameLength = 7
+			return
+		}
+		frameLength = 7 + int(frame[6])
+	case fcReadWriteMultipleRegisters:
+		// read address, read quantity, write address, write quantity and
+		// byte count followed by values
+		if len(frame) < 11 {
+			frameLength = 11
+			return
+		}
+		frameLength = 11 + int(frame[10])
+	case fcDiagnostics:
+		// return query data requests carry data of arbitrary length,
+		// while all other sub-functions carry a 2-byte data field
+		if len(frame) < 4 {
+			frameLength = 4
+			return
+		}
+		if bytesToUint16(BIG_ENDIAN, frame[2:4]) == uint16(DIAG_RETURN_QUERY_DATA) {
+			frameLength = -1
+		} else {
+			frameLength = 6
+		}
+	default:
+		// unknown (e.g. user-defined) function codes
+		frameLength = -1
+	}
+
+	return
+}
+
 // Computes the expected length of a modbus RTU response.
 func expectedResponseLenth(responseCode uint8, responseLength uint8) (byteCount int, err error) {
 	switch responseCode {
 	case fcReadHoldingRegisters,
 		fcReadInputRegisters,
 		fcReadCoils,
-		fcReadDiscreteInputs:
+		fcReadDiscreteInputs,
+		fcReadWriteMultipleRegisters,
+		fcReadFileRecord,
+		fcWriteFileRecord,
+		fcGetCommEventLog,
+		fcReportServerId:
 		byteCount = int(responseLength)
+	case fcReadExceptionStatus:
+		byteCount = 0
+	case fcGetCommEventCounter:
+		byteCount = 3
 	case fcWriteSingleRegister,
 		fcWriteMultipleRegisters,
 		fcWriteSingleCoil,
@@ -239,7 +565,17 @@ func expectedResponseLenth(responseCode uint8, responseLength uint8) (byteCount
 		fcWriteMultipleRegisters | 0x80,
 		fcWriteSingleCoil | 0x80,
 		fcWriteMultipleCoils | 0x80,
-		fcMaskWriteRegister | 0x80:
+		fcMaskWriteRegister | 0x80,
+		fcReadWriteMultipleRegisters | 0x80,
+		fcReadFifoQueue | 0x80,
+		fcReadFileRecord | 0x80,
+		fcWriteFileRecord | 0x80,
+		fcEncapsulatedInterface | 0x80,
+		fcReadExceptionStatus | 0x80,
+		fcDiagnostics | 0x80,
+		fcGetCommEventCounter | 0x80,
+		fcGetCommEventLog | 0x80,
+		fcReportServerId | 0x80:
 		byteCount = 0
 	default:
 		err = ErrProtocolError
@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"context"
 	"io"
 	"net"
 	"testing"
@@ -135,6 +136,144 @@ func TestRTUTransportReadRTUFrame(t *testing.T) {
 		}
 	}
 
+	// read a read/write multiple registers response
+	txchan <- []byte{
+		0x31, 0x17, // unit id and response code
+		0x02,       // length
+		0x12, 0x34, // register #1
+		0xf0, 0xc7, // CRC
+	}
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Errorf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x17 {
+		t.Errorf("expected 0x17 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 3 {
+		t.Errorf("expected a length of 3, got %v", len(res.payload))
+	}
+
+	// read a FIFO queue response, which uses a 2-byte byte count field
+	txchan <- []byte{
+		0x31, 0x18, // unit id and response code
+		0x00, 0x06, // byte count
+		0x00, 0x02, // FIFO count
+		0x01, 0xb8, // register #1
+		0x12, 0x84, // register #2
+		0x19, 0xe7, // CRC
+	}
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Errorf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x18 {
+		t.Errorf("expected 0x18 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 8 {
+		t.Fatalf("expected a length of 8, got %v", len(res.payload))
+	}
+	for i, b := range []byte{
+		0x00, 0x06,
+		0x00, 0x02,
+		0x01, 0xb8,
+		0x12, 0x84,
+	} {
+		if res.payload[i] != b {
+			t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+				b, i, res.payload[i])
+		}
+	}
+
+	// read a read file record response (example from the modbus
+	// application protocol spec, section 6.14)
+	txchan <- []byte{
+		0x31, 0x14, // unit id and response code
+		0x0c,       // response data length
+		0x05, 0x06, // file response length and reference type
+		0x0d, 0xfe, // register #1
+		0x00, 0x20, // register #2
+		0x05, 0x06, // file response length and reference type
+		0x33, 0xcd, // register #1
+		0x00, 0x40, // register #2
+		0x49, 0xb5, // CRC
+	}
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Errorf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x14 {
+		t.Errorf("expected 0x14 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 13 {
+		t.Errorf("expected a length of 13, got %v", len(res.payload))
+	}
+
+	// read a read device identification response, made of a fixed
+	// header followed by variable length objects
+	txchan <- []byte{
+		0x31, 0x2b, // unit id and response code
+		0x0e, 0x01, // MEI type and read device id code
+		0x01, 0x00, // conformity level and more follows
+		0x00, 0x02, // next object id and number of objects
+		0x00, 0x03, 0x41, 0x43, 0x4d, // object #0: "ACM"
+		0x01, 0x02, 0x41, 0x42, // object #1: "AB"
+		0x65, 0x3f, // CRC
+	}
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Errorf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x2b {
+		t.Errorf("expected 0x2b as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 15 {
+		t.Errorf("expected a length of 15, got %v", len(res.payload))
+	}
+
+	// read a diagnostics return query data response, whose length
+	// depends on that of the request
+	rt.req = &pdu{
+		unitId:       0x31,
+		functionCode: fcDiagnostics,
+		payload:      []byte{0x00, 0x00, 0x12, 0x34, 0x56},
+	}
+	txchan <- []byte{
+		0x31, 0x08, // unit id and response code
+		0x00, 0x00, // sub-function
+		0x12, 0x34, 0x56, // echoed data
+		0x0c, 0x70, // CRC
+	}
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Errorf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x08 {
+		t.Errorf("expected 0x08 as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 5 {
+		t.Errorf("expected a length of 5, got %v", len(res.payload))
+	}
+	rt.req = nil
+
+	// read a get comm event counter response
+	txchan <- []byte{
+		0x31, 0x0b, // unit id and response code
+		0x00, 0x00, // status
+		0x01, 0x08, // event count
+		0xa1, 0xad, // CRC
+	}
+	res, err = rt.readRTUFrame()
+	if err != nil {
+		t.Errorf("readRTUFrame() should have succeeded, got %v", err)
+	}
+	if res.functionCode != 0x0b {
+		t.Errorf("expected 0x0b as function code, got 0x%02x", res.functionCode)
+	}
+	if len(res.payload) != 4 {
+		t.Errorf("expected a length of 4, got %v", len(res.payload))
+	}
+
 	p1.Close()
 	p2.Close()
 }
@@ -177,3 +316,242 @@ func TestModbusRTUSerialCharTime(t *testing.T) {
 		t.Errorf("unexpected serial char duration: %v", d)
 	}
 }
+
+func TestRTUTransportMaskWriteRegister(t *testing.T) {
+	var client *ModbusClient
+	var p1, p2 net.Conn
+	var err error
+	var done chan struct{}
+
+	p1, p2 = net.Pipe()
+	done = make(chan struct{})
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "rtu:///dev/null",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.transport = newRTUTransport(p2, "", 19200, 100*time.Millisecond, nil)
+	client.SetUnitId(0x11)
+
+	// play the role of the remote device: expect a mask write request
+	// and echo it back as the response
+	go func() {
+		var rxbuf = make([]byte, 10)
+		var rt = &rtuTransport{}
+
+		defer close(done)
+
+		_, err := io.ReadFull(p1, rxbuf)
+		if err != nil {
+			t.Errorf("failed to read request: %v", err)
+			return
+		}
+
+		for i, b := range rt.assembleRTUFrame(&pdu{
+			unitId:       0x11,
+			functionCode: 0x16,
+			payload: []byte{
+				0x00, 0x04, // register address
+				0x00, 0xf2, // AND mask
+				0x00, 0x25, // OR mask
+			},
+		}) {
+			if rxbuf[i] != b {
+				t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+					b, i, rxbuf[i])
+			}
+		}
+
+		_, err = p1.Write(rxbuf)
+		if err != nil {
+			t.Errorf("failed to write response: %v", err)
+		}
+	}()
+
+	err = client.MaskWriteRegister(0x0004, 0x00f2, 0x0025)
+	if err != nil {
+		t.Errorf("MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+
+	<-done
+	p1.Close()
+	p2.Close()
+}
+
+func TestRTUTransportRawRequest(t *testing.T) {
+	var client *ModbusClient
+	var p1, p2 net.Conn
+	var err error
+	var res *RawResponse
+	var done chan struct{}
+
+	p1, p2 = net.Pipe()
+	done = make(chan struct{})
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "rtu:///dev/null",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.transport = newRTUTransport(p2, "", 19200, 100*time.Millisecond, nil)
+
+	// play the role of the remote device: expect a user-defined function
+	// code request and reply with a length-prefixed response, followed by
+	// an exception response
+	go func() {
+		var rxbuf = make([]byte, 6)
+		var rt = &rtuTransport{}
+
+		defer close(done)
+
+		for _, res := range []*pdu{
+			{
+				unitId:       0x22,
+				functionCode: 0x41,
+				payload:      []byte{0x03, 0xaa, 0xbb, 0xcc},
+			},
+			{
+				unitId:       0x22,
+				functionCode: 0xc1,
+				payload:      []byte{0x03},
+			},
+		} {
+			_, err := io.ReadFull(p1, rxbuf)
+			if err != nil {
+				t.Errorf("failed to read request: %v", err)
+				return
+			}
+
+			for i, b := range rt.assembleRTUFrame(&pdu{
+				unitId:       0x22,
+				functionCode: 0x41,
+				payload:      []byte{0x01, 0x02},
+			}) {
+				if rxbuf[i] != b {
+					t.Errorf("expected 0x%02x at position %v, got 0x%02x",
+						b, i, rxbuf[i])
+				}
+			}
+
+			_, err = p1.Write(rt.assembleRTUFrame(res))
+			if err != nil {
+				t.Errorf("failed to write response: %v", err)
+			}
+		}
+	}()
+
+	req := &RawRequest{
+		UnitId:       0x22,
+		FunctionCode: 0x41,
+		Payload:      []byte{0x01, 0x02},
+		// the first byte of the response carries the number of bytes
+		// following it
+		ResponseLength: func(payload []byte) (int, error) {
+			return 1 + int(payload[0]), nil
+		},
+	}
+
+	res, err = client.ExecuteRawRequest(req)
+	if err != nil {
+		t.Errorf("ExecuteRawRequest() should have succeeded, got: %v", err)
+	}
+	if res.UnitId != 0x22 || res.FunctionCode != 0x41 {
+		t.Errorf("unexpected unit id/function code: 0x%02x/0x%02x",
+			res.UnitId, res.FunctionCode)
+	}
+	if len(res.Payload) != 4 || res.Payload[3] != 0xcc {
+		t.Errorf("unexpected payload: %v", res.Payload)
+	}
+
+	_, err = client.ExecuteRawRequest(req)
+	if err != ErrIllegalDataValue {
+		t.Errorf("expected ErrIllegalDataValue, got: %v", err)
+	}
+
+	<-done
+
+	// exception function codes should be caught client-side
+	_, err = client.ExecuteRawRequest(&RawRequest{FunctionCode: 0x81})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	p1.Close()
+	p2.Close()
+}
+
+func TestRTUTransportAbandonedRequest(t *testing.T) {
+	var client *ModbusClient
+	var p1, p2 net.Conn
+	var err error
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var reg uint16
+	var done chan struct{}
+
+	p1, p2 = net.Pipe()
+	done = make(chan struct{})
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "rtu:///dev/null",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.transport = newRTUTransport(p2, "", 19200, 500*time.Millisecond, nil)
+	client.SetUnitId(0x11)
+
+	// play the role of the remote device: reply late to the first request,
+	// then promptly to the second one
+	go func() {
+		var rxbuf = make([]byte, 8)
+		var rt = &rtuTransport{}
+
+		defer close(done)
+
+		for i, value := range []byte{0xaa, 0xbb} {
+			_, err := io.ReadFull(p1, rxbuf)
+			if err != nil {
+				t.Errorf("failed to read request: %v", err)
+				return
+			}
+
+			if i == 0 {
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			_, err = p1.Write(rt.assembleRTUFrame(&pdu{
+				unitId:       0x11,
+				functionCode: fcReadHoldingRegisters,
+				payload:      []byte{0x02, 0x00, value},
+			}))
+			if err != nil {
+				t.Errorf("failed to write response: %v", err)
+			}
+		}
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Millisecond)
+	_, err = client.ReadRegisterCtx(ctx, 0x0001, HOLDING_REGISTER)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	// the late response to the first request should be discarded rather
+	// than taken as the response to the second one
+	reg, err = client.ReadRegister(0x0001, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+	}
+	if reg != 0x00bb {
+		t.Errorf("expected 0x00bb, got 0x%04x", reg)
+	}
+
+	<-done
+	p1.Close()
+	p2.Close()
+}
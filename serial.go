@@ -1,6 +1,8 @@
 package modbus
 
 import (
+	"net"
+	"sync"
 	"time"
 
 	"github.com/goburrow/serial"
@@ -12,7 +14,10 @@ import (
 type serialPortWrapper struct {
 	conf     *serialPortConfig
 	port     serial.Port
+	lock     sync.Mutex // protects deadline
 	deadline time.Time
+	portLock sync.RWMutex // keeps Close() from racing with Read() and Write()
+	closed   bool
 }
 
 type serialPortConfig struct {
@@ -56,7 +61,17 @@ func (spw *serialPortWrapper) Open() (err error) {
 }
 
 // Closes the serial port.
+// Waits for any Read() or Write() in progress to return first (reads
+// block for at most 10ms), after which both fail with net.ErrClosed.
 func (spw *serialPortWrapper) Close() (err error) {
+	spw.portLock.Lock()
+	defer spw.portLock.Unlock()
+
+	if spw.closed {
+		return
+	}
+
+	spw.closed = true
 	err = spw.port.Close()
 
 	return
@@ -77,12 +92,26 @@ func (spw *serialPortWrapper) Close() (err error) {
 // as many times as necessary until either enough bytes have been read or an
 // error is returned (ErrRequestTimedOut or any other i/o error).
 func (spw *serialPortWrapper) Read(rxbuf []byte) (cnt int, err error) {
+	var deadline time.Time
+
+	spw.lock.Lock()
+	deadline = spw.deadline
+	spw.lock.Unlock()
+
 	// return a timeout error if the deadline has passed
-	if time.Now().After(spw.deadline) {
+	if time.Now().After(deadline) {
 		err = ErrRequestTimedOut
 		return
 	}
 
+	spw.portLock.RLock()
+	defer spw.portLock.RUnlock()
+
+	if spw.closed {
+		err = net.ErrClosed
+		return
+	}
+
 	cnt, err = spw.port.Read(rxbuf)
 	// mask serial.ErrTimeout errors from the serial port
 	if err != nil && err == serial.ErrTimeout {
@@ -94,14 +123,25 @@ func (spw *serialPortWrapper) Read(rxbuf []byte) (cnt int, err error) {
 
 // Sends the bytes over the wire.
 func (spw *serialPortWrapper) Write(txbuf []byte) (cnt int, err error) {
+	spw.portLock.RLock()
+	defer spw.portLock.RUnlock()
+
+	if spw.closed {
+		err = net.ErrClosed
+		return
+	}
+
 	cnt, err = spw.port.Write(txbuf)
 
 	return
 }
 
 // Saves the i/o deadline (only used by Read).
+// Safe to call while a Read() is in progress.
 func (spw *serialPortWrapper) SetDeadline(deadline time.Time) (err error) {
+	spw.lock.Lock()
 	spw.deadline = deadline
+	spw.lock.Unlock()
 
 	return
 }
@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
@@ -8,11 +9,16 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// Returned internally when a request should be left unanswered.
+var errNoResponse = errors.New("no response")
+
 // Modbus Role PEM OID (see R-21 of the MBAPS spec)
 var modbusRoleOID asn1.ObjectIdentifier = asn1.ObjectIdentifier{
 	1, 3, 6, 1, 4, 1, 50316, 802, 1,
@@ -26,6 +32,7 @@ type ServerConfiguration struct {
 	// be closed if idle for this long)
 	Timeout time.Duration
 	// MaxClients sets the maximum number of concurrent client connections
+	// (tcp, tcp+tls, rtuovertcp and asciiovertcp only)
 	MaxClients uint
 	// TLSServerCert sets the server-side TLS key pair (tcp+tls only)
 	TLSServerCert *tls.Certificate
@@ -33,6 +40,30 @@ type ServerConfiguration struct {
 	// client connections (tcp+tls only). Leaf (i.e. client) certificates can
 	// also be used in case of self-signed certs, or if cert pinning is required.
 	TLSClientCAs *x509.CertPool
+	// Speed sets the serial link speed (in bps, rtu and ascii only). On
+	// rtuovertcp and rtuoverudp servers, it is only used to compute
+	// inter-frame delays.
+	Speed uint
+	// DataBits sets the number of bits per serial character (rtu and
+	// ascii only)
+	DataBits uint
+	// Parity sets the serial link parity mode (rtu and ascii only)
+	Parity uint
+	// StopBits sets the number of serial stop bits (rtu and ascii only)
+	StopBits uint
+	// InterCharTimeout sets the maximum delay between two characters of
+	// the same frame (ascii and asciiovertcp only)
+	InterCharTimeout time.Duration
+	// UnitIds sets the list of unit ids (slave ids) served by the server.
+	// Requests addressed to any other unit id are silently ignored, which
+	// allows the server to share a serial bus with other devices.
+	// If empty, requests are served regardless of their unit id.
+	UnitIds []uint8
+	// AuthorizationPolicy sets the role-based authorization policy
+	// enforced before handlers run (see AuthorizationPolicy).
+	// If nil, all requests are passed to the handler. Should not be
+	// modified once passed to NewServer().
+	AuthorizationPolicy *AuthorizationPolicy
 	// Logger provides a custom sink for log messages.
 	// If nil, messages will be written to stdout.
 	Logger *log.Logger
@@ -40,11 +71,12 @@ type ServerConfiguration struct {
 
 // Request object passed to the coil handler.
 type CoilsRequest struct {
-	ClientAddr string // the source (client) IP address
-	ClientRole string // the client role as encoded in the client certificate (tcp+tls only)
-	UnitId     uint8  // the requested unit id (slave id)
-	Addr       uint16 // the base coil address requested
-	Quantity   uint16 // the number of consecutive coils covered by this request
+	ClientAddr   string // the source (client) IP address
+	ClientRole   string // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId       uint8  // the requested unit id (slave id)
+	FunctionCode uint8  // the function code of the request (0x01, 0x05 or 0x0f)
+	Addr         uint16 // the base coil address requested
+	Quantity     uint16 // the number of consecutive coils covered by this request
 	// (first address: Addr, last address: Addr + Quantity - 1)
 	IsWrite bool   // true if the request is a write, false if a read
 	Args    []bool // a slice of bool values of the coils to be set, ordered
@@ -53,32 +85,83 @@ type CoilsRequest struct {
 
 // Request object passed to the discrete input handler.
 type DiscreteInputsRequest struct {
-	ClientAddr string // the source (client) IP address
-	ClientRole string // the client role as encoded in the client certificate (tcp+tls only)
-	UnitId     uint8  // the requested unit id (slave id)
-	Addr       uint16 // the base discrete input address requested
-	Quantity   uint16 // the number of consecutive discrete inputs covered by this request
+	ClientAddr   string // the source (client) IP address
+	ClientRole   string // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId       uint8  // the requested unit id (slave id)
+	FunctionCode uint8  // the function code of the request (0x02)
+	Addr         uint16 // the base discrete input address requested
+	Quantity     uint16 // the number of consecutive discrete inputs covered by this request
 }
 
 // Request object passed to the holding register handler.
 type HoldingRegistersRequest struct {
-	ClientAddr string   // the source (client) IP address
-	ClientRole string   // the client role as encoded in the client certificate (tcp+tls only)
-	UnitId     uint8    // the requested unit id (slave id)
-	Addr       uint16   // the base register address requested
-	Quantity   uint16   // the number of consecutive registers covered by this request
-	IsWrite    bool     // true if the request is a write, false if a read
-	Args       []uint16 // a slice of register values to be set, ordered from
+	ClientAddr   string   // the source (client) IP address
+	ClientRole   string   // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId       uint8    // the requested unit id (slave id)
+	FunctionCode uint8    // the function code of the request (e.g. 0x03, 0x06 or 0x10)
+	Addr         uint16   // the base register address requested
+	Quantity     uint16   // the number of consecutive registers covered by this request
+	IsWrite      bool     // true if the request is a write, false if a read
+	Args         []uint16 // a slice of register values to be set, ordered from
 	// Addr to Addr + Quantity - 1 (for writes only)
 }
 
 // Request object passed to the input register handler.
 type InputRegistersRequest struct {
+	ClientAddr   string // the source (client) IP address
+	ClientRole   string // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId       uint8  // the requested unit id (slave id)
+	FunctionCode uint8  // the function code of the request (0x04)
+	Addr         uint16 // the base register address requested
+	Quantity     uint16 // the number of consecutive registers covered by this request
+}
+
+// Request object passed to the FIFO queue handler.
+type FIFOQueueRequest struct {
+	ClientAddr string // the source (client) IP address
+	ClientRole string // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8  // the requested unit id (slave id)
+	Addr       uint16 // the FIFO pointer address requested
+}
+
+// Request object passed to the file record handler.
+type FileRecordsRequest struct {
+	ClientAddr string       // the source (client) IP address
+	ClientRole string       // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8        // the requested unit id (slave id)
+	IsWrite    bool         // true if the request is a write, false if a read
+	Records    []FileRecord // the file records covered by this request, in request order
+	// (RecordLength is set for both reads and writes, Data for writes only)
+}
+
+// Request object passed to the exception status handler.
+type ExceptionStatusRequest struct {
 	ClientAddr string // the source (client) IP address
 	ClientRole string // the client role as encoded in the client certificate (tcp+tls only)
 	UnitId     uint8  // the requested unit id (slave id)
-	Addr       uint16 // the base register address requested
-	Quantity   uint16 // the number of consecutive registers covered by this request
+}
+
+// Request object passed to the server id handler.
+type ServerIdRequest struct {
+	ClientAddr string // the source (client) IP address
+	ClientRole string // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8  // the requested unit id (slave id)
+}
+
+// Request object passed to the device identification handler.
+type DeviceIdentificationRequest struct {
+	ClientAddr string // the source (client) IP address
+	ClientRole string // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8  // the requested unit id (slave id)
+}
+
+// Request object passed to the fallback handler.
+type UnknownFunctionRequest struct {
+	ClientAddr   string // the source (client) IP address
+	ClientRole   string // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId       uint8  // the requested unit id (slave id)
+	FunctionCode uint8  // the function code of the request
+	Payload      []byte // the raw request data, following the function code
 }
 
 // The RequestHandler interface should be implemented by the handler
@@ -116,7 +199,13 @@ type RequestHandler interface {
 	HandleDiscreteInputs(req *DiscreteInputsRequest) (res []bool, err error)
 
 	// HandleHoldingRegisters handles the read holding registers (0x03),
-	// write single register (0x06) and write multiple registers (0x10).
+	// write single register (0x06), write multiple registers (0x10),
+	// mask write register (0x16) and read/write multiple registers (0x17)
+	// function codes.
+	// Mask write requests are served as a single register read followed by
+	// a single register write of the masked value.
+	// Read/write multiple registers requests are served as a write followed
+	// by a read, in that order.
 	// A HoldingRegistersRequest object is passed to the handler (see above).
 	//
 	// Expected return values:
@@ -139,16 +228,137 @@ type RequestHandler interface {
 	HandleInputRegisters(req *InputRegistersRequest) (res []uint16, err error)
 }
 
+// The FIFOHandler interface may optionally be implemented by the handler
+// object passed to NewServer, in addition to RequestHandler.
+// Read FIFO queue (0x18) requests are answered with ErrIllegalFunction
+// if the handler does not implement it.
+type FIFOHandler interface {
+	// HandleFIFOQueue handles the read FIFO queue (0x18) function code.
+	// A FIFOQueueRequest object is passed to the handler (see above).
+	//
+	// Expected return values:
+	// - res:	a slice of uint16 containing the queued register values
+	//		(up to 31), starting with the value at the head of the queue,
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleFIFOQueue(req *FIFOQueueRequest) (res []uint16, err error)
+}
+
+// The FileRecordHandler interface may optionally be implemented by the handler
+// object passed to NewServer, in addition to RequestHandler.
+// Read file record (0x14) and write file record (0x15) requests are answered
+// with ErrIllegalFunction if the handler does not implement it.
+type FileRecordHandler interface {
+	// HandleFileRecords handles the read file record (0x14) and write file
+	// record (0x15) function codes.
+	// A FileRecordsRequest object is passed to the handler (see above).
+	//
+	// Expected return values:
+	// - res:	a slice of uint16 slices, one per record in request order,
+	//		each holding RecordLength register values to be sent back
+	//		to the client (only sent for reads),
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleFileRecords(req *FileRecordsRequest) (res [][]uint16, err error)
+}
+
+// The DeviceIdentificationHandler interface may optionally be implemented by
+// the handler object passed to NewServer, in addition to RequestHandler.
+// Read device identification (0x2b / MEI type 0x0e) requests are answered
+// with ErrIllegalFunction if the handler does not implement it.
+type DeviceIdentificationHandler interface {
+	// HandleDeviceIdentification handles the read device identification
+	// (0x2b / MEI type 0x0e) function code.
+	// A DeviceIdentificationRequest object is passed to the handler (see above).
+	// The server takes care of category filtering, of splitting objects
+	// across multiple responses and of conformity level reporting.
+	//
+	// Expected return values:
+	// - res:	a map of all identification objects published by the unit,
+	//		indexed by object id (see OBJECT_ID_* in client.go). Basic
+	//		objects (vendor name, product code and revision) are
+	//		mandatory as per the spec. Values are at most 244 bytes long,
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleDeviceIdentification(req *DeviceIdentificationRequest) (res map[uint8]string, err error)
+}
+
+// The ExceptionStatusHandler interface may optionally be implemented by the
+// handler object passed to NewServer, in addition to RequestHandler.
+// Read exception status (0x07) requests are answered with ErrIllegalFunction
+// if the handler does not implement it.
+type ExceptionStatusHandler interface {
+	// HandleExceptionStatus handles the read exception status (0x07)
+	// function code.
+	// An ExceptionStatusRequest object is passed to the handler (see above).
+	//
+	// Expected return values:
+	// - res:	the eight exception status outputs, packed into a byte,
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleExceptionStatus(req *ExceptionStatusRequest) (res uint8, err error)
+}
+
+// The ServerIdHandler interface may optionally be implemented by the
+// handler object passed to NewServer, in addition to RequestHandler.
+// Report server id (0x11) requests are answered with ErrIllegalFunction
+// if the handler does not implement it.
+type ServerIdHandler interface {
+	// HandleServerId handles the report server id (0x11) function code.
+	// A ServerIdRequest object is passed to the handler (see above).
+	//
+	// Expected return values:
+	// - serverId:	the device specific server id (up to 250 bytes),
+	// - running:	the run indicator status, sent as 0xff if true,
+	//		0x00 otherwise,
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleServerId(req *ServerIdRequest) (serverId []byte, running bool, err error)
+}
+
+// The FallbackHandler interface may optionally be implemented by the handler
+// object passed to NewServer, in addition to RequestHandler.
+// Requests carrying function codes the server does not know about (e.g.
+// user-defined function codes 0x41 to 0x48 and 0x64 to 0x6e) are passed
+// to it rather than answered with ErrIllegalFunction.
+type FallbackHandler interface {
+	// HandleUnknownFunction handles any function code not otherwise
+	// supported by the server.
+	// An UnknownFunctionRequest object is passed to the handler (see above).
+	//
+	// Expected return values:
+	// - res:	the raw response data (up to 252 bytes), sent back to the
+	//		client after the function code of the request,
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleUnknownFunction(req *UnknownFunctionRequest) (res []byte, err error)
+}
+
 // Modbus server object.
 type ModbusServer struct {
 	conf          ServerConfiguration
 	logger        *logger
 	lock          sync.Mutex
+	rmwLock       sync.Mutex // serializes multi-step register transactions
+	diag          serverDiagnostics
 	started       bool
+	shuttingDown  bool
 	handler       RequestHandler
 	tcpListener   net.Listener
 	tcpClients    []net.Conn
+	serialLink    transport
+	udpSock       net.PacketConn
 	transportType transportType
+	transports    map[transport]struct{} // active transports
+	readCtx       context.Context        // request reads are bound to it
+	stopReads     context.CancelFunc     // cancels readCtx on shutdown
+	wg            sync.WaitGroup         // tracks listener and client goroutines
 }
 
 // Returns a new modbus server.
@@ -180,6 +390,104 @@ func NewServer(conf *ServerConfiguration, reqHandler RequestHandler) (
 	}
 
 	switch serverType {
+	case "rtu":
+		// set useful defaults (see NewClient() for a discussion on
+		// serial line defaults)
+		if ms.conf.Speed == 0 {
+			ms.conf.Speed = 19200
+		}
+
+		if ms.conf.DataBits == 0 {
+			ms.conf.DataBits = 8
+		}
+
+		if ms.conf.StopBits == 0 {
+			if ms.conf.Parity == PARITY_NONE {
+				ms.conf.StopBits = 2
+			} else {
+				ms.conf.StopBits = 1
+			}
+		}
+
+		// the serial link is never closed on inactivity: the timeout only
+		// bounds the time spent waiting for a single request
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 1 * time.Second
+		}
+
+		ms.transportType = modbusRTU
+
+	case "rtuovertcp":
+		// the serial link speed is used to compute RTU inter-frame delays
+		if ms.conf.Speed == 0 {
+			ms.conf.Speed = 19200
+		}
+
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 120 * time.Second
+		}
+
+		if ms.conf.MaxClients == 0 {
+			ms.conf.MaxClients = 10
+		}
+
+		ms.transportType = modbusRTUOverTCP
+
+	case "rtuoverudp":
+		if ms.conf.Speed == 0 {
+			ms.conf.Speed = 19200
+		}
+
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 1 * time.Second
+		}
+
+		ms.transportType = modbusRTUOverUDP
+
+	case "ascii":
+		// set useful defaults (see NewClient() for a discussion on
+		// ASCII serial line defaults)
+		if ms.conf.Speed == 0 {
+			ms.conf.Speed = 19200
+		}
+
+		if ms.conf.DataBits == 0 {
+			ms.conf.DataBits = 7
+		}
+
+		if ms.conf.StopBits == 0 {
+			if ms.conf.Parity == PARITY_NONE {
+				ms.conf.StopBits = 2
+			} else {
+				ms.conf.StopBits = 1
+			}
+		}
+
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 1 * time.Second
+		}
+
+		if ms.conf.InterCharTimeout == 0 {
+			ms.conf.InterCharTimeout = 1 * time.Second
+		}
+
+		ms.transportType = modbusASCII
+
+	case "asciiovertcp":
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 120 * time.Second
+		}
+
+		if ms.conf.InterCharTimeout == 0 {
+			ms.conf.InterCharTimeout = 1 * time.Second
+		}
+
+		if ms.conf.MaxClients == 0 {
+			ms.conf.MaxClients = 10
+		}
+
+		ms.transportType = modbusASCIIOverTCP
+
 	case "tcp":
 		if ms.conf.Timeout == 0 {
 			ms.conf.Timeout = 120 * time.Second
@@ -191,6 +499,13 @@ func NewServer(conf *ServerConfiguration, reqHandler RequestHandler) (
 
 		ms.transportType = modbusTCP
 
+	case "udp":
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 1 * time.Second
+		}
+
+		ms.transportType = modbusTCPOverUDP
+
 	case "tcp+tls":
 		if ms.conf.Timeout == 0 {
 			ms.conf.Timeout = 120 * time.Second
@@ -222,6 +537,17 @@ func NewServer(conf *ServerConfiguration, reqHandler RequestHandler) (
 		return
 	}
 
+	// enforce the authorization policy, if any, before handlers run
+	if ms.conf.AuthorizationPolicy != nil {
+		err = ms.conf.AuthorizationPolicy.validate()
+		if err != nil {
+			ms.logger.Errorf("invalid authorization policy: %v", err)
+			return
+		}
+
+		ms.handler = WithMiddleware(ms.handler, ms.authorize)
+	}
+
 	return
 }
 
@@ -234,8 +560,17 @@ func (ms *ModbusServer) Start() (err error) {
 		return
 	}
 
+	// refuse to start while a shutdown is in progress
+	if ms.shuttingDown {
+		err = ErrConfigurationError
+		return
+	}
+
+	// pending request reads are cut short on shutdown
+	ms.readCtx, ms.stopReads = context.WithCancel(context.Background())
+
 	switch ms.transportType {
-	case modbusTCP, modbusTCPOverTLS:
+	case modbusTCP, modbusTCPOverTLS, modbusRTUOverTCP, modbusASCIIOverTCP:
 		// bind to a TCP socket
 		ms.tcpListener, err = net.Listen("tcp", ms.conf.URL)
 		if err != nil {
@@ -243,8 +578,56 @@ func (ms *ModbusServer) Start() (err error) {
 		}
 
 		// accept client connections in a goroutine
+		ms.wg.Add(1)
 		go ms.acceptTCPClients()
 
+	case modbusRTU, modbusASCII:
+		var spw *serialPortWrapper
+
+		// create a serial port wrapper object
+		spw = newSerialPortWrapper(&serialPortConfig{
+			Device:   ms.conf.URL,
+			Speed:    ms.conf.Speed,
+			DataBits: ms.conf.DataBits,
+			Parity:   ms.conf.Parity,
+			StopBits: ms.conf.StopBits,
+		})
+
+		// open the serial device
+		err = spw.Open()
+		if err != nil {
+			return
+		}
+
+		// discard potentially stale serial data
+		discard(spw)
+
+		// serve requests off the serial link in a goroutine
+		if ms.transportType == modbusASCII {
+			ms.serialLink = newASCIITransport(
+				spw, ms.conf.URL, ms.conf.Timeout, ms.conf.InterCharTimeout,
+				ms.conf.Logger)
+		} else {
+			ms.serialLink = newRTUTransport(
+				spw, ms.conf.URL, ms.conf.Speed, ms.conf.Timeout, ms.conf.Logger)
+		}
+		ms.wg.Add(1)
+		go func() {
+			defer ms.wg.Done()
+			ms.handleTransport(ms.serialLink, ms.conf.URL, "")
+		}()
+
+	case modbusTCPOverUDP, modbusRTUOverUDP:
+		// bind to a UDP socket
+		ms.udpSock, err = net.ListenPacket("udp", ms.conf.URL)
+		if err != nil {
+			return
+		}
+
+		// serve datagrams in a goroutine
+		ms.wg.Add(1)
+		go ms.serveUDP()
+
 	default:
 		err = ErrConfigurationError
 		return
@@ -255,7 +638,9 @@ func (ms *ModbusServer) Start() (err error) {
 	return
 }
 
-// Stops accepting new client connections and closes any active session.
+// Stops accepting new client connections and closes any active session,
+// then waits for all client connections to be done with, handler calls in
+// progress included. Should not be called from within handlers.
 func (ms *ModbusServer) Stop() (err error) {
 	ms.lock.Lock()
 	defer ms.lock.Unlock()
@@ -266,7 +651,12 @@ func (ms *ModbusServer) Stop() (err error) {
 
 	ms.started = false
 
-	if ms.transportType == modbusTCP || ms.transportType == modbusTCPOverTLS {
+	// cut pending request reads short
+	ms.stopReads()
+
+	if ms.transportType == modbusTCP || ms.transportType == modbusTCPOverTLS ||
+		ms.transportType == modbusRTUOverTCP ||
+		ms.transportType == modbusASCIIOverTCP {
 		// close the server socket if we're listening over TCP
 		err = ms.tcpListener.Close()
 
@@ -276,6 +666,109 @@ func (ms *ModbusServer) Stop() (err error) {
 		}
 	}
 
+	if ms.transportType == modbusRTU || ms.transportType == modbusASCII {
+		// close the serial link (which waits for any pending read to
+		// return)
+		err = ms.serialLink.Close()
+	}
+
+	if ms.transportType == modbusTCPOverUDP || ms.transportType == modbusRTUOverUDP {
+		// close the UDP socket
+		err = ms.udpSock.Close()
+	}
+
+	// wait for the listener and client goroutines to exit (which requires
+	// the lock to be released)
+	ms.lock.Unlock()
+	ms.wg.Wait()
+	ms.lock.Lock()
+
+	return
+}
+
+// Gracefully shuts the server down: stops accepting new client connections
+// (or datagrams), closes idle client connections, lets in-flight requests
+// complete and their responses be written, then waits for all client
+// connections to close.
+// If ctx expires before then, all remaining connections are forcibly closed
+// and ctx.Err() is returned. Handler calls which are still running at that
+// point are not waited for, but the server cannot be restarted until they
+// return (Start() fails with ErrConfigurationError until then).
+func (ms *ModbusServer) Shutdown(ctx context.Context) (err error) {
+	var done = make(chan struct{})
+
+	ms.lock.Lock()
+	if !ms.started {
+		ms.lock.Unlock()
+		return
+	}
+
+	ms.started = false
+	ms.shuttingDown = true
+
+	switch ms.transportType {
+	case modbusTCP, modbusTCPOverTLS, modbusRTUOverTCP, modbusASCIIOverTCP:
+		// stop accepting new connections
+		ms.tcpListener.Close()
+
+	case modbusTCPOverUDP, modbusRTUOverUDP:
+		// stop reading datagrams, but keep the socket open for responses
+		// to in-flight requests to be sent
+		ms.udpSock.SetReadDeadline(time.Now())
+	}
+
+	// cut pending request reads short rather than closing idle transports,
+	// so that requests read in the meantime can still be answered:
+	// transports close on their own once done with their current request
+	ms.stopReads()
+	ms.lock.Unlock()
+
+	go func() {
+		ms.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+
+		// forcibly close all remaining connections
+		ms.lock.Lock()
+		for t := range ms.transports {
+			t.Close()
+		}
+		for _, sock := range ms.tcpClients {
+			sock.Close()
+		}
+		ms.lock.Unlock()
+	}
+
+	switch ms.transportType {
+	case modbusRTU, modbusASCII:
+		ms.serialLink.Close()
+
+	case modbusTCPOverUDP, modbusRTUOverUDP:
+		ms.udpSock.Close()
+	}
+
+	endShutdown := func() {
+		ms.lock.Lock()
+		ms.shuttingDown = false
+		ms.lock.Unlock()
+	}
+
+	if err == nil {
+		endShutdown()
+	} else {
+		// keep the server from being restarted until the goroutines left
+		// behind (e.g. stuck in handler calls) are gone
+		go func() {
+			<-done
+			endShutdown()
+		}()
+	}
+
 	return
 }
 
@@ -287,6 +780,8 @@ func (ms *ModbusServer) acceptTCPClients() {
 	var err error
 	var accepted bool
 
+	defer ms.wg.Done()
+
 	for {
 		sock, err = ms.tcpListener.Accept()
 		if err != nil {
@@ -305,6 +800,7 @@ func (ms *ModbusServer) acceptTCPClients() {
 			accepted = true
 			// add the new client connection to the pool
 			ms.tcpClients = append(ms.tcpClients, sock)
+			ms.wg.Add(1)
 		} else {
 			accepted = false
 		}
@@ -331,6 +827,8 @@ func (ms *ModbusServer) handleTCPClient(sock net.Conn) {
 	var clientRole string
 	var tlsSock net.Conn
 
+	defer ms.wg.Done()
+
 	switch ms.transportType {
 	case modbusTCP:
 		// serve modbus requests over the raw TCP connection
@@ -338,6 +836,22 @@ func (ms *ModbusServer) handleTCPClient(sock net.Conn) {
 			newTCPTransport(sock, ms.conf.Timeout, ms.conf.Logger),
 			sock.RemoteAddr().String(), "")
 
+	case modbusRTUOverTCP:
+		// serve modbus requests over the raw TCP connection, using
+		// RTU framing
+		ms.handleTransport(
+			newRTUTransport(sock, sock.RemoteAddr().String(), ms.conf.Speed,
+				ms.conf.Timeout, ms.conf.Logger),
+			sock.RemoteAddr().String(), "")
+
+	case modbusASCIIOverTCP:
+		// serve modbus requests over the raw TCP connection, using
+		// ASCII framing
+		ms.handleTransport(
+			newASCIITransport(sock, sock.RemoteAddr().String(), ms.conf.Timeout,
+				ms.conf.InterCharTimeout, ms.conf.Logger),
+			sock.RemoteAddr().String(), "")
+
 	case modbusTCPOverTLS:
 		// start TLS negotiation over the raw TCP connection
 		tlsSock, clientRole, err = ms.startTLS(sock)
@@ -370,6 +884,52 @@ func (ms *ModbusServer) handleTCPClient(sock net.Conn) {
 	sock.Close()
 }
 
+// Serves requests received on the UDP socket, one datagram at a time.
+// Responses are sent back to the source address of each datagram.
+func (ms *ModbusServer) serveUDP() {
+	var rxbuf []byte
+	var n int
+	var addr net.Addr
+	var conn *udpDatagramConn
+	var err error
+
+	defer ms.wg.Done()
+
+	rxbuf = make([]byte, maxTCPFrameLength)
+
+	for {
+		n, addr, err = ms.udpSock.ReadFrom(rxbuf)
+		if err != nil {
+			// if the server socket has just been closed or the server
+			// is shutting down, return here
+			if errors.Is(err, net.ErrClosed) || ms.isShuttingDown() {
+				return
+			}
+			ms.logger.Warningf("failed to read datagram: %v", err)
+			continue
+		}
+
+		// wrap the datagram into a connection object, replying
+		// to its source address
+		conn = newUDPDatagramConn(ms.udpSock, addr,
+			append([]byte(nil), rxbuf[0:n]...))
+
+		// serve the request(s) held in the datagram
+		switch ms.transportType {
+		case modbusTCPOverUDP:
+			ms.handleTransport(
+				newTCPTransport(conn, ms.conf.Timeout, ms.conf.Logger),
+				addr.String(), "")
+
+		case modbusRTUOverUDP:
+			ms.handleTransport(
+				newRTUTransport(conn, addr.String(), ms.conf.Speed,
+					ms.conf.Timeout, ms.conf.Logger),
+				addr.String(), "")
+		}
+	}
+}
+
 // For each request read from the transport, performs decoding and validation,
 // calls the user-provided handler, then encodes and writes the response
 // to the transport.
@@ -377,430 +937,1372 @@ func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRo
 	var req *pdu
 	var res *pdu
 	var err error
-	var addr uint16
-	var quantity uint16
+	var unbind func()
+	var ok bool
+
+	if !ms.trackTransport(t) {
+		t.Close()
+		return
+	}
+	defer ms.untrackTransport(t)
 
 	for {
-		req, err = t.ReadRequest()
-		if err != nil {
+		// stop reading requests if the server is shutting down
+		unbind, ok = ms.bindReads(t)
+		if !ok {
 			return
 		}
 
-		switch req.functionCode {
-		case fcReadCoils, fcReadDiscreteInputs:
-			var coils []bool
-			var resCount int
-
-			if len(req.payload) != 4 {
-				err = ErrProtocolError
-				break
+		req, err = t.ReadRequest()
+		unbind()
+		if err != nil {
+			if err == ErrBadCRC || err == ErrBadLRC {
+				ms.diag.countCommError()
 			}
 
-			// decode address and quantity fields
-			addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
-
-			// ensure the reply never exceeds the maximum PDU length and we
-			// never read past 0xffff
-			if quantity > 2000 || quantity == 0 {
-				err = ErrProtocolError
-				break
-			}
-			if uint32(addr)+uint32(quantity)-1 > 0xffff {
-				err = ErrIllegalDataAddress
-				break
+			// serial framed links re-sync after framing errors
+			if ms.usesSerialFraming() && (err == ErrBadCRC || err == ErrBadLRC ||
+				err == ErrShortFrame || err == ErrProtocolError) {
+				continue
 			}
 
-			// invoke the appropriate handler
-			if req.functionCode == fcReadCoils {
-				coils, err = ms.handler.HandleCoils(&CoilsRequest{
-					ClientAddr: clientAddr,
-					ClientRole: clientRole,
-					UnitId:     req.unitId,
-					Addr:       addr,
-					Quantity:   quantity,
-					IsWrite:    false,
-					Args:       nil,
-				})
-			} else {
-				coils, err = ms.handler.HandleDiscreteInputs(
-					&DiscreteInputsRequest{
-						ClientAddr: clientAddr,
-						ClientRole: clientRole,
-						UnitId:     req.unitId,
-						Addr:       addr,
-						Quantity:   quantity,
-					})
+			// shared links stay open through timeouts
+			if ms.isSharedLink() &&
+				(err == ErrRequestTimedOut || os.IsTimeout(err)) {
+				continue
 			}
-			resCount = len(coils)
+			return
+		}
 
-			// make sure the handler returned the expected number of items
-			if err == nil && resCount != int(quantity) {
-				ms.logger.Errorf("handler returned %v bools, "+
-					"expected %v", resCount, quantity)
-				err = ErrServerDeviceFailure
-				break
-			}
+		// silently ignore requests addressed to other units
+		if !ms.servesUnitId(req.unitId) {
+			ms.diag.countRequest(false)
+			continue
+		}
 
-			if err != nil {
-				break
-			}
+		ms.diag.countRequest(true)
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:       req.unitId,
-				functionCode: req.functionCode,
-				payload:      []byte{0},
-			}
+		// in listen only mode, requests are neither processed nor answered,
+		// save for restart communications diagnostics requests
+		if ms.diag.isListenOnly() &&
+			!(req.functionCode == fcDiagnostics && len(req.payload) >= 2 &&
+				bytesToUint16(BIG_ENDIAN, req.payload[0:2]) ==
+					uint16(DIAG_RESTART_COMMUNICATIONS)) {
+			ms.diag.countNoResponse()
+			continue
+		}
 
-			// byte count (1 byte for 8 coils)
-			res.payload[0] = uint8(resCount / 8)
-			if resCount%8 != 0 {
-				res.payload[0]++
-			}
+		res, err = ms.processRequest(req, clientAddr, clientRole)
 
-			// coil values
-			res.payload = append(res.payload, encodeBools(coils)...)
+		// if there was no error processing the request but the response is nil
+		// (which should never happen), emit a server failure exception code
+		// and log an error
+		if err == nil && res == nil {
+			err = ErrServerDeviceFailure
+			ms.logger.Errorf("internal server error (req: %v, res: %v, err: %v)",
+				req, res, err)
+		}
 
-		case fcWriteSingleCoil:
-			if len(req.payload) != 4 {
-				err = ErrProtocolError
-				break
+		// map go errors to modbus errors, unless the error is a protocol error,
+		// in which case close the transport and return.
+		if err != nil {
+			if err == errNoResponse {
+				ms.diag.countNoResponse()
+				req = nil
+				res = nil
+				continue
+			} else if err == ErrProtocolError && ms.isSharedLink() {
+				// shared links cannot be closed: leave malformed
+				// requests unanswered
+				ms.logger.Warningf("protocol error, ignoring request")
+				ms.diag.countNoResponse()
+				req = nil
+				res = nil
+				continue
+			} else if err == ErrProtocolError {
+				ms.logger.Warningf(
+					"protocol error, closing link (client address: '%s')",
+					clientAddr)
+				t.Close()
+				return
+			} else {
+				res = &pdu{
+					unitId:       req.unitId,
+					functionCode: (0x80 | req.functionCode),
+					payload:      []byte{mapErrorToExceptionCode(err)},
+				}
 			}
+		}
 
-			// decode the address field
-			addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		// broadcast requests (unit id 0) are processed but never answered
+		// on serial framed links
+		if req.unitId == 0x00 && ms.usesSerialFraming() {
+			ms.diag.countNoResponse()
+			req = nil
+			res = nil
+			continue
+		}
 
-			// validate the value field (should be either 0xff00 or 0x0000)
-			if (req.payload[2] != 0xff && req.payload[2] != 0x00) ||
-				req.payload[3] != 0x00 {
-				err = ErrProtocolError
-				break
-			}
+		ms.diag.countResponse(res)
+
+		// write the response to the transport
+		err = t.WriteResponse(res)
+		if err != nil {
+			ms.logger.Warningf("failed to write response: %v", err)
+		}
+
+		// avoid holding on to stale data
+		req = nil
+		res = nil
+	}
+}
+
+// Decodes and validates a request, then invokes the appropriate handler.
+// Handler panics are recovered from and reported as server device failures,
+// so that a misbehaving handler cannot take the whole server down.
+func (ms *ModbusServer) processRequest(req *pdu, clientAddr string, clientRole string) (res *pdu, err error) {
+	var addr uint16
+	var quantity uint16
+
+	defer func() {
+		if r := recover(); r != nil {
+			ms.logger.Errorf("handler panic (client address: '%s', "+
+				"function code: 0x%02x): %v", clientAddr, req.functionCode, r)
+			res = nil
+			err = ErrServerDeviceFailure
+		}
+	}()
+
+	switch req.functionCode {
+	case fcReadCoils, fcReadDiscreteInputs:
+		var coils []bool
+		var resCount int
+
+		if len(req.payload) != 4 {
+			err = ErrProtocolError
+			break
+		}
 
-			// invoke the coil handler
-			_, err = ms.handler.HandleCoils(&CoilsRequest{
-				ClientAddr: clientAddr,
-				ClientRole: clientRole,
-				UnitId:     req.unitId,
-				Addr:       addr,
-				Quantity:   1,    // request for a single coil
-				IsWrite:    true, // this is a write request
-				Args:       []bool{(req.payload[2] == 0xff)},
+		// decode address and quantity fields
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		quantity = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+
+		// ensure the reply never exceeds the maximum PDU length and we
+		// never read past 0xffff
+		if quantity > 2000 || quantity == 0 {
+			err = ErrProtocolError
+			break
+		}
+		if uint32(addr)+uint32(quantity)-1 > 0xffff {
+			err = ErrIllegalDataAddress
+			break
+		}
+
+		// invoke the appropriate handler
+		if req.functionCode == fcReadCoils {
+			coils, err = ms.handler.HandleCoils(&CoilsRequest{
+				ClientAddr:   clientAddr,
+				ClientRole:   clientRole,
+				UnitId:       req.unitId,
+				FunctionCode: req.functionCode,
+				Addr:         addr,
+				Quantity:     quantity,
+				IsWrite:      false,
+				Args:         nil,
 			})
+		} else {
+			coils, err = ms.handler.HandleDiscreteInputs(
+				&DiscreteInputsRequest{
+					ClientAddr:   clientAddr,
+					ClientRole:   clientRole,
+					UnitId:       req.unitId,
+					FunctionCode: req.functionCode,
+					Addr:         addr,
+					Quantity:     quantity,
+				})
+		}
+		resCount = len(coils)
 
-			if err != nil {
-				break
-			}
+		// make sure the handler returned the expected number of items
+		if err == nil && resCount != int(quantity) {
+			ms.logger.Errorf("handler returned %v bools, "+
+				"expected %v", resCount, quantity)
+			err = ErrServerDeviceFailure
+			break
+		}
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:       req.unitId,
-				functionCode: req.functionCode,
-			}
+		if err != nil {
+			break
+		}
 
-			// echo the address and value in the response
-			res.payload = append(res.payload,
-				uint16ToBytes(BIG_ENDIAN, addr)...)
-			res.payload = append(res.payload,
-				req.payload[2], req.payload[3])
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      []byte{0},
+		}
 
-		case fcWriteMultipleCoils:
-			var expectedLen int
+		// byte count (1 byte for 8 coils)
+		res.payload[0] = uint8(resCount / 8)
+		if resCount%8 != 0 {
+			res.payload[0]++
+		}
 
-			if len(req.payload) < 6 {
-				err = ErrProtocolError
-				break
-			}
+		// coil values
+		res.payload = append(res.payload, encodeBools(coils)...)
 
-			// decode address and quantity fields
-			addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	case fcWriteSingleCoil:
+		if len(req.payload) != 4 {
+			err = ErrProtocolError
+			break
+		}
 
-			// ensure the reply never exceeds the maximum PDU length and we
-			// never read past 0xffff
-			if quantity > 0x7b0 || quantity == 0 {
-				err = ErrProtocolError
-				break
-			}
-			if uint32(addr)+uint32(quantity)-1 > 0xffff {
-				err = ErrIllegalDataAddress
-				break
-			}
+		// decode the address field
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
 
-			// validate the byte count field (1 byte for 8 coils)
-			expectedLen = int(quantity) / 8
-			if quantity%8 != 0 {
-				expectedLen++
-			}
+		// validate the value field (should be either 0xff00 or 0x0000)
+		if (req.payload[2] != 0xff && req.payload[2] != 0x00) ||
+			req.payload[3] != 0x00 {
+			err = ErrProtocolError
+			break
+		}
 
-			if req.payload[4] != uint8(expectedLen) {
-				err = ErrProtocolError
-				break
-			}
+		// invoke the coil handler
+		_, err = ms.handler.HandleCoils(&CoilsRequest{
+			ClientAddr:   clientAddr,
+			ClientRole:   clientRole,
+			UnitId:       req.unitId,
+			FunctionCode: req.functionCode,
+			Addr:         addr,
+			Quantity:     1,    // request for a single coil
+			IsWrite:      true, // this is a write request
+			Args:         []bool{(req.payload[2] == 0xff)},
+		})
 
-			// make sure we have enough bytes
-			if len(req.payload)-5 != expectedLen {
-				err = ErrProtocolError
-				break
-			}
+		if err != nil {
+			break
+		}
 
-			// invoke the coil handler
-			_, err = ms.handler.HandleCoils(&CoilsRequest{
-				ClientAddr: clientAddr,
-				ClientRole: clientRole,
-				UnitId:     req.unitId,
-				Addr:       addr,
-				Quantity:   quantity,
-				IsWrite:    true, // this is a write request
-				Args:       decodeBools(quantity, req.payload[5:]),
-			})
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
 
-			if err != nil {
-				break
-			}
+		// echo the address and value in the response
+		res.payload = append(res.payload,
+			uint16ToBytes(BIG_ENDIAN, addr)...)
+		res.payload = append(res.payload,
+			req.payload[2], req.payload[3])
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:       req.unitId,
-				functionCode: req.functionCode,
-			}
+	case fcWriteMultipleCoils:
+		var expectedLen int
 
-			// echo the address and quantity in the response
-			res.payload = append(res.payload,
-				uint16ToBytes(BIG_ENDIAN, addr)...)
-			res.payload = append(res.payload,
-				uint16ToBytes(BIG_ENDIAN, quantity)...)
+		if len(req.payload) < 6 {
+			err = ErrProtocolError
+			break
+		}
 
-		case fcReadHoldingRegisters, fcReadInputRegisters:
-			var regs []uint16
-			var resCount int
+		// decode address and quantity fields
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		quantity = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
 
-			if len(req.payload) != 4 {
-				err = ErrProtocolError
-				break
-			}
+		// ensure the reply never exceeds the maximum PDU length and we
+		// never read past 0xffff
+		if quantity > 0x7b0 || quantity == 0 {
+			err = ErrProtocolError
+			break
+		}
+		if uint32(addr)+uint32(quantity)-1 > 0xffff {
+			err = ErrIllegalDataAddress
+			break
+		}
 
-			// decode address and quantity fields
-			addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		// validate the byte count field (1 byte for 8 coils)
+		expectedLen = int(quantity) / 8
+		if quantity%8 != 0 {
+			expectedLen++
+		}
 
-			// ensure the reply never exceeds the maximum PDU length and we
-			// never read past 0xffff
-			if quantity > 0x007d || quantity == 0 {
-				err = ErrProtocolError
-				break
-			}
-			if uint32(addr)+uint32(quantity)-1 > 0xffff {
-				err = ErrIllegalDataAddress
-				break
-			}
+		if req.payload[4] != uint8(expectedLen) {
+			err = ErrProtocolError
+			break
+		}
 
-			// invoke the appropriate handler
-			if req.functionCode == fcReadHoldingRegisters {
-				regs, err = ms.handler.HandleHoldingRegisters(
-					&HoldingRegistersRequest{
-						ClientAddr: clientAddr,
-						ClientRole: clientRole,
-						UnitId:     req.unitId,
-						Addr:       addr,
-						Quantity:   quantity,
-						IsWrite:    false,
-						Args:       nil,
-					})
-			} else {
-				regs, err = ms.handler.HandleInputRegisters(
-					&InputRegistersRequest{
-						ClientAddr: clientAddr,
-						ClientRole: clientRole,
-						UnitId:     req.unitId,
-						Addr:       addr,
-						Quantity:   quantity,
-					})
-			}
-			resCount = len(regs)
+		// make sure we have enough bytes
+		if len(req.payload)-5 != expectedLen {
+			err = ErrProtocolError
+			break
+		}
 
-			// make sure the handler returned the expected number of items
-			if err == nil && resCount != int(quantity) {
-				ms.logger.Errorf("handler returned %v 16-bit values, "+
-					"expected %v", resCount, quantity)
-				err = ErrServerDeviceFailure
-				break
-			}
+		// invoke the coil handler
+		_, err = ms.handler.HandleCoils(&CoilsRequest{
+			ClientAddr:   clientAddr,
+			ClientRole:   clientRole,
+			UnitId:       req.unitId,
+			FunctionCode: req.functionCode,
+			Addr:         addr,
+			Quantity:     quantity,
+			IsWrite:      true, // this is a write request
+			Args:         decodeBools(quantity, req.payload[5:]),
+		})
 
-			if err != nil {
-				break
-			}
+		if err != nil {
+			break
+		}
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:       req.unitId,
-				functionCode: req.functionCode,
-				payload:      []byte{0},
-			}
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
 
-			// byte count (2 bytes per register)
-			res.payload[0] = uint8(resCount * 2)
+		// echo the address and quantity in the response
+		res.payload = append(res.payload,
+			uint16ToBytes(BIG_ENDIAN, addr)...)
+		res.payload = append(res.payload,
+			uint16ToBytes(BIG_ENDIAN, quantity)...)
 
-			// register values
-			res.payload = append(res.payload,
-				uint16sToBytes(BIG_ENDIAN, regs)...)
+	case fcReadHoldingRegisters, fcReadInputRegisters:
+		var regs []uint16
+		var resCount int
 
-		case fcWriteSingleRegister:
-			var value uint16
+		if len(req.payload) != 4 {
+			err = ErrProtocolError
+			break
+		}
 
-			if len(req.payload) != 4 {
-				err = ErrProtocolError
-				break
-			}
+		// decode address and quantity fields
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		quantity = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
 
-			// decode address and value fields
-			addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			value = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		// ensure the reply never exceeds the maximum PDU length and we
+		// never read past 0xffff
+		if quantity > 0x007d || quantity == 0 {
+			err = ErrProtocolError
+			break
+		}
+		if uint32(addr)+uint32(quantity)-1 > 0xffff {
+			err = ErrIllegalDataAddress
+			break
+		}
 
-			// invoke the handler
-			_, err = ms.handler.HandleHoldingRegisters(
+		// invoke the appropriate handler
+		if req.functionCode == fcReadHoldingRegisters {
+			regs, err = ms.handler.HandleHoldingRegisters(
 				&HoldingRegistersRequest{
-					ClientAddr: clientAddr,
-					ClientRole: clientRole,
-					UnitId:     req.unitId,
-					Addr:       addr,
-					Quantity:   1,    // request for a single register
-					IsWrite:    true, // request is a write
-					Args:       []uint16{value},
+					ClientAddr:   clientAddr,
+					ClientRole:   clientRole,
+					UnitId:       req.unitId,
+					FunctionCode: req.functionCode,
+					Addr:         addr,
+					Quantity:     quantity,
+					IsWrite:      false,
+					Args:         nil,
 				})
+		} else {
+			regs, err = ms.handler.HandleInputRegisters(
+				&InputRegistersRequest{
+					ClientAddr:   clientAddr,
+					ClientRole:   clientRole,
+					UnitId:       req.unitId,
+					FunctionCode: req.functionCode,
+					Addr:         addr,
+					Quantity:     quantity,
+				})
+		}
+		resCount = len(regs)
 
-			if err != nil {
-				break
-			}
+		// make sure the handler returned the expected number of items
+		if err == nil && resCount != int(quantity) {
+			ms.logger.Errorf("handler returned %v 16-bit values, "+
+				"expected %v", resCount, quantity)
+			err = ErrServerDeviceFailure
+			break
+		}
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:       req.unitId,
-				functionCode: req.functionCode,
-			}
+		if err != nil {
+			break
+		}
 
-			// echo the address and value in the response
-			res.payload = append(res.payload,
-				uint16ToBytes(BIG_ENDIAN, addr)...)
-			res.payload = append(res.payload,
-				uint16ToBytes(BIG_ENDIAN, value)...)
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      []byte{0},
+		}
 
-		case fcWriteMultipleRegisters:
-			var expectedLen int
+		// byte count (2 bytes per register)
+		res.payload[0] = uint8(resCount * 2)
 
-			if len(req.payload) < 6 {
-				err = ErrProtocolError
-				break
-			}
+		// register values
+		res.payload = append(res.payload,
+			uint16sToBytes(BIG_ENDIAN, regs)...)
 
-			// decode address and quantity fields
-			addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	case fcWriteSingleRegister:
+		var value uint16
 
-			// ensure the reply never exceeds the maximum PDU length and we
-			// never read past 0xffff
-			if quantity > 0x007b || quantity == 0 {
-				err = ErrProtocolError
-				break
-			}
-			if uint32(addr)+uint32(quantity)-1 > 0xffff {
-				err = ErrIllegalDataAddress
-				break
-			}
+		if len(req.payload) != 4 {
+			err = ErrProtocolError
+			break
+		}
 
-			// validate the byte count field (2 bytes per register)
-			expectedLen = int(quantity) * 2
+		// decode address and value fields
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		value = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+
+		// invoke the handler
+		_, err = ms.handler.HandleHoldingRegisters(
+			&HoldingRegistersRequest{
+				ClientAddr:   clientAddr,
+				ClientRole:   clientRole,
+				UnitId:       req.unitId,
+				FunctionCode: req.functionCode,
+				Addr:         addr,
+				Quantity:     1,    // request for a single register
+				IsWrite:      true, // request is a write
+				Args:         []uint16{value},
+			})
 
-			if req.payload[4] != uint8(expectedLen) {
-				err = ErrProtocolError
-				break
-			}
+		if err != nil {
+			break
+		}
 
-			// make sure we have enough bytes
-			if len(req.payload)-5 != expectedLen {
-				err = ErrProtocolError
-				break
-			}
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
 
-			// invoke the holding register handler
-			_, err = ms.handler.HandleHoldingRegisters(
-				&HoldingRegistersRequest{
-					ClientAddr: clientAddr,
-					ClientRole: clientRole,
-					UnitId:     req.unitId,
-					Addr:       addr,
-					Quantity:   quantity,
-					IsWrite:    true, // this is a write request
-					Args:       bytesToUint16s(BIG_ENDIAN, req.payload[5:]),
-				})
-			if err != nil {
-				break
-			}
+		// echo the address and value in the response
+		res.payload = append(res.payload,
+			uint16ToBytes(BIG_ENDIAN, addr)...)
+		res.payload = append(res.payload,
+			uint16ToBytes(BIG_ENDIAN, value)...)
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:       req.unitId,
-				functionCode: req.functionCode,
-			}
+	case fcWriteMultipleRegisters:
+		var expectedLen int
 
-			// echo the address and quantity in the response
-			res.payload = append(res.payload,
-				uint16ToBytes(BIG_ENDIAN, addr)...)
-			res.payload = append(res.payload,
-				uint16ToBytes(BIG_ENDIAN, quantity)...)
+		if len(req.payload) < 6 {
+			err = ErrProtocolError
+			break
+		}
 
-		default:
-			res = &pdu{
-				// reply with the request target unit ID
-				unitId: req.unitId,
-				// set the error bit
-				functionCode: (0x80 | req.functionCode),
-				// set the exception code to illegal function to indicate that
-				// the server does not know how to handle this function code.
-				payload: []byte{exIllegalFunction},
-			}
+		// decode address and quantity fields
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		quantity = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+
+		// ensure the reply never exceeds the maximum PDU length and we
+		// never read past 0xffff
+		if quantity > 0x007b || quantity == 0 {
+			err = ErrProtocolError
+			break
+		}
+		if uint32(addr)+uint32(quantity)-1 > 0xffff {
+			err = ErrIllegalDataAddress
+			break
 		}
 
-		// if there was no error processing the request but the response is nil
-		// (which should never happen), emit a server failure exception code
-		// and log an error
-		if err == nil && res == nil {
-			err = ErrServerDeviceFailure
-			ms.logger.Errorf("internal server error (req: %v, res: %v, err: %v)",
-				req, res, err)
+		// validate the byte count field (2 bytes per register)
+		expectedLen = int(quantity) * 2
+
+		if req.payload[4] != uint8(expectedLen) {
+			err = ErrProtocolError
+			break
 		}
 
-		// map go errors to modbus errors, unless the error is a protocol error,
-		// in which case close the transport and return.
-		if err != nil {
-			if err == ErrProtocolError {
-				ms.logger.Warningf(
-					"protocol error, closing link (client address: '%s')",
-					clientAddr)
-				t.Close()
-				return
-			} else {
-				res = &pdu{
-					unitId:       req.unitId,
-					functionCode: (0x80 | req.functionCode),
-					payload:      []byte{mapErrorToExceptionCode(err)},
-				}
-			}
+		// make sure we have enough bytes
+		if len(req.payload)-5 != expectedLen {
+			err = ErrProtocolError
+			break
 		}
 
-		// write the response to the transport
-		err = t.WriteResponse(res)
+		// invoke the holding register handler
+		_, err = ms.handler.HandleHoldingRegisters(
+			&HoldingRegistersRequest{
+				ClientAddr:   clientAddr,
+				ClientRole:   clientRole,
+				UnitId:       req.unitId,
+				FunctionCode: req.functionCode,
+				Addr:         addr,
+				Quantity:     quantity,
+				IsWrite:      true, // this is a write request
+				Args:         bytesToUint16s(BIG_ENDIAN, req.payload[5:]),
+			})
 		if err != nil {
-			ms.logger.Warningf("failed to write response: %v", err)
+			break
 		}
 
-		// avoid holding on to stale data
-		req = nil
-		res = nil
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
+
+		// echo the address and quantity in the response
+		res.payload = append(res.payload,
+			uint16ToBytes(BIG_ENDIAN, addr)...)
+		res.payload = append(res.payload,
+			uint16ToBytes(BIG_ENDIAN, quantity)...)
+
+	case fcMaskWriteRegister:
+		var andMask uint16
+		var orMask uint16
+
+		if len(req.payload) != 6 {
+			err = ErrProtocolError
+			break
+		}
+
+		// decode address, AND mask and OR mask fields
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		andMask = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		orMask = bytesToUint16(BIG_ENDIAN, req.payload[4:6])
+
+		// apply the masks to the current register value, making sure
+		// no other mask write request slips in between the read and the write
+		err = ms.maskWriteRegister(clientAddr, clientRole, req.unitId,
+			addr, andMask, orMask)
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
+
+		// echo the address and both masks in the response
+		res.payload = append(res.payload, req.payload[0:6]...)
+
+	case fcReadWriteMultipleRegisters:
+		var readAddr uint16
+		var readQuantity uint16
+		var regs []uint16
+
+		if len(req.payload) < 11 {
+			err = ErrProtocolError
+			break
+		}
+
+		// decode read address, read quantity, write address and
+		// write quantity fields
+		readAddr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		readQuantity = bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[4:6])
+		quantity = bytesToUint16(BIG_ENDIAN, req.payload[6:8])
+
+		// ensure the reply never exceeds the maximum PDU length and we
+		// never read or write past 0xffff
+		if readQuantity > 0x007d || readQuantity == 0 ||
+			quantity > 0x0079 || quantity == 0 {
+			err = ErrProtocolError
+			break
+		}
+		if uint32(readAddr)+uint32(readQuantity)-1 > 0xffff ||
+			uint32(addr)+uint32(quantity)-1 > 0xffff {
+			err = ErrIllegalDataAddress
+			break
+		}
+
+		// validate the byte count field (2 bytes per register)
+		if int(req.payload[8]) != int(quantity)*2 ||
+			len(req.payload)-9 != int(quantity)*2 {
+			err = ErrProtocolError
+			break
+		}
+
+		// perform the write then the read as a single transaction
+		regs, err = ms.readWriteRegisters(clientAddr, clientRole,
+			req.unitId, readAddr, readQuantity, addr, quantity,
+			bytesToUint16s(BIG_ENDIAN, req.payload[9:]))
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      []byte{0},
+		}
+
+		// byte count (2 bytes per register)
+		res.payload[0] = uint8(len(regs) * 2)
+
+		// register values
+		res.payload = append(res.payload,
+			uint16sToBytes(BIG_ENDIAN, regs)...)
+
+	case fcReadFifoQueue:
+		var fh FIFOHandler
+		var ok bool
+		var regs []uint16
+
+		if len(req.payload) != 2 {
+			err = ErrProtocolError
+			break
+		}
+
+		// FIFO queues are only supported if the handler knows about them
+		fh, ok = ms.handler.(FIFOHandler)
+		if !ok {
+			err = ErrIllegalFunction
+			break
+		}
+
+		// decode the FIFO pointer address field
+		addr = bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+
+		// invoke the FIFO handler
+		regs, err = fh.HandleFIFOQueue(&FIFOQueueRequest{
+			ClientAddr: clientAddr,
+			ClientRole: clientRole,
+			UnitId:     req.unitId,
+			Addr:       addr,
+		})
+		if err != nil {
+			break
+		}
+
+		// the queue can hold at most 31 registers
+		if len(regs) > 31 {
+			ms.logger.Errorf("handler returned %v 16-bit values, "+
+				"expected at most 31", len(regs))
+			err = ErrIllegalDataValue
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
+
+		// byte count (2 bytes of FIFO count + 2 bytes per register)
+		res.payload = uint16ToBytes(BIG_ENDIAN, uint16(2+2*len(regs)))
+		// FIFO count
+		res.payload = append(res.payload,
+			uint16ToBytes(BIG_ENDIAN, uint16(len(regs)))...)
+		// register values
+		res.payload = append(res.payload,
+			uint16sToBytes(BIG_ENDIAN, regs)...)
+
+	case fcReadFileRecord, fcWriteFileRecord:
+		var frh FileRecordHandler
+		var ok bool
+		var records []FileRecord
+		var values [][]uint16
+
+		if len(req.payload) < 1 || int(req.payload[0]) != len(req.payload)-1 {
+			err = ErrProtocolError
+			break
+		}
+
+		// file records are only supported if the handler knows about them
+		frh, ok = ms.handler.(FileRecordHandler)
+		if !ok {
+			err = ErrIllegalFunction
+			break
+		}
+
+		// decode sub-requests
+		records, err = decodeFileRecords(req.payload[1:],
+			req.functionCode == fcWriteFileRecord)
+		if err != nil {
+			break
+		}
+
+		// invoke the file record handler
+		values, err = frh.HandleFileRecords(&FileRecordsRequest{
+			ClientAddr: clientAddr,
+			ClientRole: clientRole,
+			UnitId:     req.unitId,
+			IsWrite:    req.functionCode == fcWriteFileRecord,
+			Records:    records,
+		})
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
+
+		// write responses are an echo of the request
+		if req.functionCode == fcWriteFileRecord {
+			res.payload = append(res.payload, req.payload...)
+			break
+		}
+
+		// make sure the handler returned the expected number of items
+		if len(values) != len(records) {
+			ms.logger.Errorf("handler returned %v records, expected %v",
+				len(values), len(records))
+			err = ErrServerDeviceFailure
+			break
+		}
+
+		// response data length, filled in below
+		res.payload = []byte{0x00}
+		for i := range records {
+			if len(values[i]) != int(records[i].RecordLength) {
+				ms.logger.Errorf("handler returned %v 16-bit values, "+
+					"expected %v", len(values[i]), records[i].RecordLength)
+				err = ErrServerDeviceFailure
+				break
+			}
+
+			// file response length (1 byte of reference type +
+			// 2 bytes per register)
+			res.payload = append(res.payload, byte(1+2*len(values[i])))
+			// reference type (always 6)
+			res.payload = append(res.payload, 0x06)
+			// register values
+			res.payload = append(res.payload,
+				uint16sToBytes(BIG_ENDIAN, values[i])...)
+		}
+		if err != nil {
+			break
+		}
+		res.payload[0] = byte(len(res.payload) - 1)
+
+	case fcReadExceptionStatus:
+		var esh ExceptionStatusHandler
+		var ok bool
+		var status uint8
+
+		if len(req.payload) != 0 {
+			err = ErrProtocolError
+			break
+		}
+
+		// exception status is only supported if the handler knows
+		// about it
+		esh, ok = ms.handler.(ExceptionStatusHandler)
+		if !ok {
+			err = ErrIllegalFunction
+			break
+		}
+
+		// invoke the exception status handler
+		status, err = esh.HandleExceptionStatus(&ExceptionStatusRequest{
+			ClientAddr: clientAddr,
+			ClientRole: clientRole,
+			UnitId:     req.unitId,
+		})
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      []byte{status},
+		}
+
+	case fcDiagnostics:
+		var payload []byte
+
+		err = ms.runServerFunction(req, clientAddr, clientRole, func() (err error) {
+			payload, err = ms.diag.handleRequest(req.payload)
+			return
+		})
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      payload,
+		}
+
+	case fcGetCommEventCounter, fcGetCommEventLog:
+		var payload []byte
+
+		if len(req.payload) != 0 {
+			err = ErrProtocolError
+			break
+		}
+
+		err = ms.runServerFunction(req, clientAddr, clientRole, func() (err error) {
+			if req.functionCode == fcGetCommEventCounter {
+				payload = ms.diag.commEventCounterPayload()
+			} else {
+				payload = ms.diag.commEventLogPayload()
+			}
+			return
+		})
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      payload,
+		}
+
+	case fcReportServerId:
+		var sih ServerIdHandler
+		var ok bool
+		var serverId []byte
+		var running bool
+
+		if len(req.payload) != 0 {
+			err = ErrProtocolError
+			break
+		}
+
+		// server ids are only supported if the handler knows about them
+		sih, ok = ms.handler.(ServerIdHandler)
+		if !ok {
+			err = ErrIllegalFunction
+			break
+		}
+
+		// invoke the server id handler
+		serverId, running, err = sih.HandleServerId(&ServerIdRequest{
+			ClientAddr: clientAddr,
+			ClientRole: clientRole,
+			UnitId:     req.unitId,
+		})
+		if err != nil {
+			break
+		}
+
+		// the server id and run indicator status must fit within the
+		// 253-byte PDU, function code and byte count included
+		if len(serverId) > 250 {
+			ms.logger.Errorf("handler returned a %v-byte server id, "+
+				"expected at most 250", len(serverId))
+			err = ErrServerDeviceFailure
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
+
+		// byte count
+		res.payload = []byte{byte(len(serverId) + 1)}
+		// server id
+		res.payload = append(res.payload, serverId...)
+		// run indicator status
+		if running {
+			res.payload = append(res.payload, 0xff)
+		} else {
+			res.payload = append(res.payload, 0x00)
+		}
+
+	case fcEncapsulatedInterface:
+		var dih DeviceIdentificationHandler
+		var ok bool
+		var objects map[uint8]string
+
+		if len(req.payload) < 1 {
+			err = ErrProtocolError
+			break
+		}
+
+		// read device identification is the only supported MEI type
+		if req.payload[0] != meiReadDeviceIdentification {
+			err = ErrIllegalFunction
+			break
+		}
+
+		if len(req.payload) != 3 {
+			err = ErrProtocolError
+			break
+		}
+
+		// device identification is only supported if the handler
+		// knows about it
+		dih, ok = ms.handler.(DeviceIdentificationHandler)
+		if !ok {
+			err = ErrIllegalFunction
+			break
+		}
+
+		// invoke the device identification handler
+		objects, err = dih.HandleDeviceIdentification(&DeviceIdentificationRequest{
+			ClientAddr: clientAddr,
+			ClientRole: clientRole,
+			UnitId:     req.unitId,
+		})
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+		}
+
+		res.payload, err = ms.encodeDeviceIdentification(
+			ReadDeviceIdCode(req.payload[1]), req.payload[2], objects)
+
+	default:
+		var fh FallbackHandler
+		var ok bool
+		var payload []byte
+
+		// pass unknown function codes to the handler if it knows
+		// how to deal with them
+		fh, ok = ms.handler.(FallbackHandler)
+		if !ok || req.functionCode&0x80 != 0 {
+			res = &pdu{
+				// reply with the request target unit ID
+				unitId: req.unitId,
+				// set the error bit
+				functionCode: (0x80 | req.functionCode),
+				// set the exception code to illegal function to indicate that
+				// the server does not know how to handle this function code.
+				payload: []byte{exIllegalFunction},
+			}
+			break
+		}
+
+		// invoke the fallback handler
+		payload, err = fh.HandleUnknownFunction(&UnknownFunctionRequest{
+			ClientAddr:   clientAddr,
+			ClientRole:   clientRole,
+			UnitId:       req.unitId,
+			FunctionCode: req.functionCode,
+			Payload:      req.payload,
+		})
+		if err != nil {
+			break
+		}
+
+		// the response should fit within the 253-byte PDU, function
+		// code included
+		if len(payload) > 252 {
+			ms.logger.Errorf("handler returned %v bytes, expected at most 252",
+				len(payload))
+			err = ErrServerDeviceFailure
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:       req.unitId,
+			functionCode: req.functionCode,
+			payload:      payload,
+		}
+	}
+
+	return
+}
+
+// Registers t as an active transport. Returns false if the server is
+// shutting down, in which case t should not be served.
+func (ms *ModbusServer) trackTransport(t transport) (ok bool) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if ms.shuttingDown {
+		return
+	}
+
+	if ms.transports == nil {
+		ms.transports = make(map[transport]struct{})
+	}
+	ms.transports[t] = struct{}{}
+	ok = true
+
+	return
+}
+
+// Unregisters t.
+func (ms *ModbusServer) untrackTransport(t transport) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	delete(ms.transports, t)
+
+	return
+}
+
+// Ties the next request read on t to the shutdown of the server: if the
+// server shuts down (or is stopped) while t is waiting for a request, the
+// read times out but t is left open, so that a request read in the
+// meantime can still be answered. unbind must be called once the read
+// returns.
+// Returns false if the server is shutting down or stopped.
+func (ms *ModbusServer) bindReads(t transport) (unbind func(), ok bool) {
+	var ctx context.Context
+
+	ms.lock.Lock()
+	ctx = ms.readCtx
+	ok = !ms.shuttingDown && (ctx == nil || ctx.Err() == nil)
+	ms.lock.Unlock()
+
+	unbind = func() {}
+
+	// servers driven without Start() (e.g. by fake clients) never shut down
+	if !ok || ctx == nil {
+		return
+	}
+
+	if rb, isReadBinder := t.(readBinder); isReadBinder {
+		unbind = rb.bindReads(ctx)
+	}
+
+	return
+}
+
+// Returns true if a shutdown is in progress.
+func (ms *ModbusServer) isShuttingDown() bool {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	return ms.shuttingDown
+}
+
+// Returns true if the server uses serial line (RTU or ASCII) framing, as
+// opposed to MBAP framing, in which case unit id 0 is the broadcast address.
+func (ms *ModbusServer) usesSerialFraming() bool {
+	return ms.transportType == modbusRTU ||
+		ms.transportType == modbusRTUOverTCP ||
+		ms.transportType == modbusRTUOverUDP ||
+		ms.transportType == modbusASCII ||
+		ms.transportType == modbusASCIIOverTCP
+}
+
+// Returns true if the server listens on a link shared by all clients
+// (serial port or UDP socket), which cannot be closed on client errors.
+func (ms *ModbusServer) isSharedLink() bool {
+	return ms.transportType == modbusRTU ||
+		ms.transportType == modbusASCII ||
+		ms.transportType == modbusRTUOverUDP ||
+		ms.transportType == modbusTCPOverUDP
+}
+
+// Returns true if requests addressed to unitId should be served.
+func (ms *ModbusServer) servesUnitId(unitId uint8) bool {
+	// serve everything if no filter was configured
+	if len(ms.conf.UnitIds) == 0 {
+		return true
+	}
+
+	// always accept broadcast requests on serial framed links
+	if unitId == 0x00 && ms.usesSerialFraming() {
+		return true
+	}
+
+	for _, id := range ms.conf.UnitIds {
+		if id == unitId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runServerFunction runs fn, carrying out a function implemented by the
+// server itself (diagnostics and comm event functions), once the request
+// has gone through middlewares like any other.
+func (ms *ModbusServer) runServerFunction(req *pdu, clientAddr string,
+	clientRole string, fn func() error) (err error) {
+
+	err = runCompound(ms.handler, serverFunctionRequest(req, clientAddr, clientRole),
+		func(RequestHandler, uint8) error {
+			return fn()
+		})
+
+	return
+}
+
+// maskWriteRegister reads a single holding register through the handler,
+// applies andMask and orMask to its value and writes the result back.
+// The request goes through middlewares once, before the read.
+func (ms *ModbusServer) maskWriteRegister(clientAddr string, clientRole string,
+	unitId uint8, addr uint16, andMask uint16, orMask uint16) (err error) {
+
+	ms.rmwLock.Lock()
+	defer ms.rmwLock.Unlock()
+
+	err = runCompound(ms.handler, &ServerRequest{
+		ClientAddr:   clientAddr,
+		ClientRole:   clientRole,
+		UnitId:       unitId,
+		FunctionCode: fcMaskWriteRegister,
+		Addr:         addr,
+		Quantity:     1,
+		IsWrite:      true,
+		ReadAddr:     addr,
+		ReadQuantity: 1,
+	}, func(h RequestHandler, unitId uint8) (err error) {
+		var regs []uint16
+
+		// read the current register value
+		regs, err = h.HandleHoldingRegisters(&HoldingRegistersRequest{
+			ClientAddr:   clientAddr,
+			ClientRole:   clientRole,
+			UnitId:       unitId,
+			FunctionCode: fcMaskWriteRegister,
+			Addr:         addr,
+			Quantity:     1,
+			IsWrite:      false,
+			Args:         nil,
+		})
+		if err != nil {
+			return
+		}
+
+		if len(regs) != 1 {
+			ms.logger.Errorf("handler returned %v 16-bit values, expected 1",
+				len(regs))
+			err = ErrServerDeviceFailure
+			return
+		}
+
+		// write back (current AND andMask) OR (orMask AND (NOT andMask))
+		_, err = h.HandleHoldingRegisters(&HoldingRegistersRequest{
+			ClientAddr:   clientAddr,
+			ClientRole:   clientRole,
+			UnitId:       unitId,
+			FunctionCode: fcMaskWriteRegister,
+			Addr:         addr,
+			Quantity:     1,
+			IsWrite:      true,
+			Args:         []uint16{(regs[0] & andMask) | (orMask &^ andMask)},
+		})
+
+		return
+	})
+
+	return
+}
+
+// readWriteRegisters writes writeQuantity holding registers starting at
+// writeAddr, then reads readQuantity holding registers starting at readAddr
+// through the handler.
+// The request goes through middlewares once, before the write, so that a
+// request rejected by a middleware cannot leave a write behind.
+func (ms *ModbusServer) readWriteRegisters(clientAddr string, clientRole string,
+	unitId uint8, readAddr uint16, readQuantity uint16, writeAddr uint16,
+	writeQuantity uint16, values []uint16) (regs []uint16, err error) {
+
+	ms.rmwLock.Lock()
+	defer ms.rmwLock.Unlock()
+
+	err = runCompound(ms.handler, &ServerRequest{
+		ClientAddr:   clientAddr,
+		ClientRole:   clientRole,
+		UnitId:       unitId,
+		FunctionCode: fcReadWriteMultipleRegisters,
+		Addr:         writeAddr,
+		Quantity:     writeQuantity,
+		IsWrite:      true,
+		Registers:    values,
+		ReadAddr:     readAddr,
+		ReadQuantity: readQuantity,
+	}, func(h RequestHandler, unitId uint8) (err error) {
+		// the write operation is performed before the read
+		_, err = h.HandleHoldingRegisters(&HoldingRegistersRequest{
+			ClientAddr:   clientAddr,
+			ClientRole:   clientRole,
+			UnitId:       unitId,
+			FunctionCode: fcReadWriteMultipleRegisters,
+			Addr:         writeAddr,
+			Quantity:     writeQuantity,
+			IsWrite:      true,
+			Args:         values,
+		})
+		if err != nil {
+			return
+		}
+
+		regs, err = h.HandleHoldingRegisters(&HoldingRegistersRequest{
+			ClientAddr:   clientAddr,
+			ClientRole:   clientRole,
+			UnitId:       unitId,
+			FunctionCode: fcReadWriteMultipleRegisters,
+			Addr:         readAddr,
+			Quantity:     readQuantity,
+			IsWrite:      false,
+			Args:         nil,
+		})
+
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	// make sure the handler returned the expected number of items
+	if len(regs) != int(readQuantity) {
+		ms.logger.Errorf("handler returned %v 16-bit values, "+
+			"expected %v", len(regs), readQuantity)
+		err = ErrServerDeviceFailure
+	}
+
+	return
+}
+
+// encodeDeviceIdentification builds a read device identification response
+// payload for the requested category, starting at objectId.
+// Objects which do not fit in a single response are left for the client to
+// request in a subsequent transaction, using the "more follows" and
+// "next object id" fields.
+func (ms *ModbusServer) encodeDeviceIdentification(category ReadDeviceIdCode,
+	objectId uint8, objects map[uint8]string) (payload []byte, err error) {
+	var conformityLevel byte
+	var lastObjectId int
+	var ids []int
+	var objectCount byte
+
+	// compute the conformity level from the objects available, with
+	// individual access always supported (0x80)
+	conformityLevel = 0x81
+	for id := range objects {
+		if id >= 0x80 {
+			conformityLevel = 0x83
+		} else if id > OBJECT_ID_MAJOR_MINOR_REVISION && conformityLevel < 0x82 {
+			conformityLevel = 0x82
+		}
+	}
+
+	switch category {
+	case READ_DEVICE_ID_BASIC:
+		lastObjectId = int(OBJECT_ID_MAJOR_MINOR_REVISION)
+	case READ_DEVICE_ID_REGULAR:
+		lastObjectId = 0x7f
+	case READ_DEVICE_ID_EXTENDED:
+		lastObjectId = 0xff
+	case READ_DEVICE_ID_SPECIFIC:
+		// individual access requires the object to exist
+		if _, ok := objects[objectId]; !ok {
+			err = ErrIllegalDataAddress
+			return
+		}
+		lastObjectId = int(objectId)
+	default:
+		err = ErrIllegalDataValue
+		return
+	}
+
+	// as per the spec, stream access requests pointing to an unknown
+	// object restart at the beginning of the category
+	if _, ok := objects[objectId]; !ok || int(objectId) > lastObjectId {
+		objectId = 0x00
+	}
+
+	for id := range objects {
+		if id >= objectId && int(id) <= lastObjectId {
+			ids = append(ids, int(id))
+		}
+	}
+	sort.Ints(ids)
+
+	// MEI type, read device id code, conformity level, more follows,
+	// next object id and number of objects (filled in below)
+	payload = []byte{
+		meiReadDeviceIdentification, byte(category), conformityLevel,
+		0x00, 0x00, 0x00,
+	}
+
+	for _, id := range ids {
+		// object id (1 byte), object length (1 byte) and value must fit
+		// within the 253-byte PDU, function code included
+		if 1+len(payload)+2+len(objects[uint8(id)]) > 253 {
+			// a single object larger than a response can never be sent
+			if objectCount == 0 {
+				ms.logger.Errorf("device identification object %v is too "+
+					"long (%v bytes)", id, len(objects[uint8(id)]))
+				err = ErrServerDeviceFailure
+				return
+			}
+
+			// more follows, starting at this object
+			payload[3] = 0xff
+			payload[4] = byte(id)
+			break
+		}
+
+		payload = append(payload, byte(id), byte(len(objects[uint8(id)])))
+		payload = append(payload, objects[uint8(id)]...)
+		objectCount++
+	}
+	payload[5] = objectCount
+
+	return
+}
+
+// decodeFileRecords decodes the sub-requests of a read file record or write
+// file record request.
+func decodeFileRecords(payload []byte, isWrite bool) (records []FileRecord, err error) {
+	var record FileRecord
+	var responseLength int
+
+	// expect at least one 7-byte sub-request
+	if len(payload) < 7 {
+		err = ErrProtocolError
+		return
+	}
+
+	for len(payload) > 0 {
+		if len(payload) < 7 {
+			err = ErrProtocolError
+			return
+		}
+
+		// the reference type should always be 6
+		if payload[0] != 0x06 {
+			err = ErrIllegalDataAddress
+			return
+		}
+
+		record = FileRecord{
+			FileNumber:   bytesToUint16(BIG_ENDIAN, payload[1:3]),
+			RecordNumber: bytesToUint16(BIG_ENDIAN, payload[3:5]),
+			RecordLength: bytesToUint16(BIG_ENDIAN, payload[5:7]),
+		}
+		payload = payload[7:]
+
+		if record.FileNumber == 0 || record.RecordNumber > 9999 {
+			err = ErrIllegalDataAddress
+			return
+		}
+
+		if record.RecordLength == 0 {
+			err = ErrIllegalDataValue
+			return
+		}
+
+		if isWrite {
+			// record data follows the sub-request header on writes
+			if len(payload) < 2*int(record.RecordLength) {
+				err = ErrProtocolError
+				return
+			}
+			record.Data = bytesToUint16s(BIG_ENDIAN,
+				payload[0:2*int(record.RecordLength)])
+			payload = payload[2*int(record.RecordLength):]
+		} else {
+			// make sure the response will fit in a PDU
+			responseLength += 2 + 2*int(record.RecordLength)
+			if responseLength > 0xf5 {
+				err = ErrIllegalDataValue
+				return
+			}
+		}
+
+		records = append(records, record)
 	}
 
-	// never reached
 	return
 }
 
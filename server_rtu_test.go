@@ -0,0 +1,198 @@
+//go:build linux
+
+package modbus
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestRTUServer(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var ptm *os.File
+	var pts string
+	var err error
+	var regs []uint16
+	var coils []bool
+	var value uint16
+
+	ptm, pts, err = openPTY()
+	if err != nil {
+		t.Skipf("failed to open pty pair: %v", err)
+	}
+	defer ptm.Close()
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:     fmt.Sprintf("rtu://%s", pts),
+		Speed:   19200,
+		UnitIds: []uint8{9},
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	// drive the master side of the pty with an RTU client transport
+	client, err = NewClient(&ClientConfiguration{
+		URL: "rtu:///dev/null",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.transport = newRTUTransport(ptm, "", 19200, 300*time.Millisecond, nil)
+	client.SetUnitId(9)
+
+	err = client.WriteRegisters(2, []uint16{0x1234, 0x5678})
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	regs, err = client.ReadRegisters(1, 3, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 3 || regs[0] != 0x0000 || regs[1] != 0x1234 || regs[2] != 0x5678 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	coils, err = client.ReadCoils(0, 10)
+	if err != nil {
+		t.Errorf("client.ReadCoils() should have succeeded, got: %v", err)
+	}
+	if len(coils) != 10 {
+		t.Errorf("expected 10 coils, got: %v", len(coils))
+	}
+
+	// exceptions should make it through
+	_, err = client.ReadRegister(20, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// return query data requests are delimited by line silence
+	err = client.ReturnQueryData([]byte{0x01, 0x02, 0x03, 0x04})
+	if err != nil {
+		t.Errorf("client.ReturnQueryData() should have succeeded, got: %v", err)
+	}
+
+	// requests addressed to other devices on the bus should be ignored
+	client.SetUnitId(10)
+	_, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != ErrRequestTimedOut {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+
+	// broadcast writes should be processed but left unanswered
+	client.SetUnitId(0)
+	err = client.WriteRegister(1, 0xbeef)
+	if err != ErrRequestTimedOut {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+
+	// ... which should show in the diagnostic counters
+	client.SetUnitId(9)
+	value, err = client.ReadDiagnosticCounter(DIAG_SERVER_NO_RESPONSE_COUNT)
+	if err != nil {
+		t.Errorf("client.ReadDiagnosticCounter() should have succeeded, got: %v", err)
+	}
+	if value != 1 {
+		t.Errorf("expected a no response count of 1, got: %v", value)
+	}
+
+	value, err = client.ReadDiagnosticCounter(DIAG_BUS_MESSAGE_COUNT)
+	if err != nil {
+		t.Errorf("client.ReadDiagnosticCounter() should have succeeded, got: %v", err)
+	}
+	if value != 9 {
+		t.Errorf("expected a bus message count of 9, got: %v", value)
+	}
+
+	value, err = client.ReadDiagnosticCounter(DIAG_SERVER_MESSAGE_COUNT)
+	if err != nil {
+		t.Errorf("client.ReadDiagnosticCounter() should have succeeded, got: %v", err)
+	}
+	// (the request addressed to unit #10 is not accounted for)
+	if value != 9 {
+		t.Errorf("expected a server message count of 9, got: %v", value)
+	}
+
+	// garbage on the line should be discarded
+	ptm.SetDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = ptm.Write([]byte{0x09, 0x03, 0x00, 0x00, 0x00, 0x01, 0xaa, 0xbb})
+	if err != nil {
+		t.Errorf("failed to write to pty: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	regs, err = client.ReadRegisters(2, 1, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 1 || regs[0] != 0x1234 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	value, err = client.ReadDiagnosticCounter(DIAG_BUS_COMM_ERROR_COUNT)
+	if err != nil {
+		t.Errorf("client.ReadDiagnosticCounter() should have succeeded, got: %v", err)
+	}
+	if value != 1 {
+		t.Errorf("expected a bus comm error count of 1, got: %v", value)
+	}
+
+	server.Stop()
+
+	return
+}
+
+// Opens a pseudo-terminal pair, returning the master side as a file and
+// the path to the slave device.
+func openPTY() (ptm *os.File, pts string, err error) {
+	var ptyNum uint32
+	var unlock int32
+
+	ptm, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return
+	}
+
+	// unlock the slave side and fetch its number (note: going through
+	// SyscallConn() rather than Fd() keeps the file in non-blocking mode,
+	// which deadlines depend on)
+	rawConn, err := ptm.SyscallConn()
+	if err != nil {
+		ptm.Close()
+		return
+	}
+
+	err = rawConn.Control(func(fd uintptr) {
+		var errno syscall.Errno
+
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd,
+			syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+		if errno == 0 {
+			_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd,
+				syscall.TIOCGPTN, uintptr(unsafe.Pointer(&ptyNum)))
+		}
+		if errno != 0 {
+			err = errno
+		}
+	})
+	if err != nil {
+		ptm.Close()
+		return
+	}
+
+	pts = fmt.Sprintf("/dev/pts/%d", ptyNum)
+
+	return
+}
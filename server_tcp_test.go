@@ -1,6 +1,10 @@
 package modbus
 
 import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -568,6 +572,1205 @@ func TestTCPServerHoldingAndInputRegisters(t *testing.T) {
 	return
 }
 
+func TestTCPServerMaskWriteRegister(t *testing.T) {
+	var server *ModbusServer
+	var err error
+	var client *ModbusClient
+	var th *tcpTestHandler
+	var reg uint16
+
+	th = &tcpTestHandler{}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, th)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	th.holding[3] = 0x0012
+
+	// example from the modbus application protocol spec (section 6.16):
+	// (0x0012 AND 0x00f2) OR (0x0025 AND NOT 0x00f2) = 0x0017
+	err = client.MaskWriteRegister(0x0003, 0x00f2, 0x0025)
+	if err != nil {
+		t.Errorf("client.MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+	if th.holding[3] != 0x0017 {
+		t.Errorf("expected 0x0017 at handler index 3, got: 0x%04x", th.holding[3])
+	}
+
+	// set the top bit only, leaving all other bits untouched
+	err = client.MaskWriteRegister(0x0003, 0x7fff, 0x8000)
+	if err != nil {
+		t.Errorf("client.MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+
+	reg, err = client.ReadRegister(0x0003, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegister() should have succeeded, got: %v", err)
+	}
+	if reg != 0x8017 {
+		t.Errorf("expected 0x8017, got: 0x%04x", reg)
+	}
+
+	// clear the lowest bit only
+	err = client.MaskWriteRegister(0x0003, 0xfffe, 0x0000)
+	if err != nil {
+		t.Errorf("client.MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+	if th.holding[3] != 0x8016 {
+		t.Errorf("expected 0x8016 at handler index 3, got: 0x%04x", th.holding[3])
+	}
+
+	// no other register should have been touched
+	for i := 0; i < 10; i++ {
+		if i != 3 && th.holding[i] != 0x0000 {
+			t.Errorf("expected 0x0000 at handler index %v, got: 0x%04x",
+				i, th.holding[i])
+		}
+	}
+
+	// mask writing past the end of the register space should fail
+	err = client.MaskWriteRegister(0x000a, 0x0000, 0xffff)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.MaskWriteRegister() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// unit ids other than #9 should be rejected by the handler
+	client.SetUnitId(2)
+	err = client.MaskWriteRegister(0x0003, 0x0000, 0xffff)
+	if err != ErrIllegalFunction {
+		t.Errorf("client.MaskWriteRegister() should have returned ErrIllegalFunction, got: %v", err)
+	}
+	if th.holding[3] != 0x8016 {
+		t.Errorf("expected 0x8016 at handler index 3, got: 0x%04x", th.holding[3])
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+func TestTCPServerReadWriteRegisters(t *testing.T) {
+	var server *ModbusServer
+	var err error
+	var client *ModbusClient
+	var th *tcpTestHandler
+	var regs []uint16
+	var u32s []uint32
+
+	th = &tcpTestHandler{}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, th)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	th.holding[0] = 0x1111
+	th.holding[1] = 0x2222
+
+	// write 2 registers at address 2 and read them back along with
+	// registers 0 and 1: the write should happen before the read
+	regs, err = client.ReadWriteRegisters(0x0000, 4, 0x0002, []uint16{
+		0x3333, 0x4444,
+	})
+	if err != nil {
+		t.Errorf("client.ReadWriteRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 4 {
+		t.Errorf("expected 4 values, got: %v", len(regs))
+	} else {
+		for i, v := range []uint16{0x1111, 0x2222, 0x3333, 0x4444} {
+			if regs[i] != v {
+				t.Errorf("expected 0x%04x at position %v, got: 0x%04x",
+					v, i, regs[i])
+			}
+		}
+	}
+
+	// swap encoding and use 32-bit values: the written value should
+	// be read back unchanged
+	err = client.SetEncoding(LITTLE_ENDIAN, LOW_WORD_FIRST)
+	if err != nil {
+		t.Errorf("client.SetEncoding() should have succeeded, got: %v", err)
+	}
+	u32s, err = client.ReadWriteUint32s(0x0004, 2, 0x0006, []uint32{0xaabbccdd})
+	if err != nil {
+		t.Errorf("client.ReadWriteUint32s() should have succeeded, got: %v", err)
+	}
+	if len(u32s) != 2 || u32s[0] != 0 || u32s[1] != 0xaabbccdd {
+		t.Errorf("unexpected values: %v", u32s)
+	}
+	// registers should have been stored low word first, little endian
+	if th.holding[6] != 0xddcc || th.holding[7] != 0xbbaa {
+		t.Errorf("unexpected register values: 0x%04x 0x%04x",
+			th.holding[6], th.holding[7])
+	}
+	client.SetEncoding(BIG_ENDIAN, HIGH_WORD_FIRST)
+
+	// a write past the end of the register space should fail and
+	// skip the read
+	_, err = client.ReadWriteRegisters(0x0000, 1, 0x0009, []uint16{
+		0x0001, 0x0002,
+	})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.ReadWriteRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// so should a read past the end of the register space, although
+	// the write should go through
+	_, err = client.ReadWriteRegisters(0x0009, 2, 0x0008, []uint16{0x0808})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.ReadWriteRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+	if th.holding[8] != 0x0808 {
+		t.Errorf("expected 0x0808 at handler index 8, got: 0x%04x", th.holding[8])
+	}
+
+	// out of spec quantities should be caught by the client
+	_, err = client.ReadWriteRegisters(0x0000, 0, 0x0000, []uint16{0x0001})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadWriteRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadWriteRegisters(0x0000, 1, 0x0000, []uint16{})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadWriteRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadWriteRegisters(0x0000, 126, 0x0000, []uint16{0x0001})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadWriteRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadWriteRegisters(0x0000, 1, 0x0000, make([]uint16, 122))
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadWriteRegisters() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+func TestTCPServerFIFOQueue(t *testing.T) {
+	var server *ModbusServer
+	var err error
+	var client *ModbusClient
+	var fh *fifoTestHandler
+	var regs []uint16
+
+	fh = &fifoTestHandler{}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, fh)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	// an empty queue should yield an empty slice
+	regs, err = client.ReadFIFOQueue(0x04de)
+	if err != nil {
+		t.Errorf("client.ReadFIFOQueue() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 0 {
+		t.Errorf("expected 0 values, got: %v", len(regs))
+	}
+
+	fh.queue = []uint16{0x01b8, 0x1284, 0xffff}
+	regs, err = client.ReadFIFOQueue(0x04de)
+	if err != nil {
+		t.Errorf("client.ReadFIFOQueue() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 3 {
+		t.Errorf("expected 3 values, got: %v", len(regs))
+	} else {
+		for i, v := range fh.queue {
+			if regs[i] != v {
+				t.Errorf("expected 0x%04x at position %v, got: 0x%04x",
+					v, i, regs[i])
+			}
+		}
+	}
+
+	// a full queue (31 values) should be returned in its entirety
+	fh.queue = make([]uint16, 31)
+	for i := range fh.queue {
+		fh.queue[i] = uint16(i)
+	}
+	regs, err = client.ReadFIFOQueue(0x04de)
+	if err != nil {
+		t.Errorf("client.ReadFIFOQueue() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 31 || regs[30] != 30 {
+		t.Errorf("unexpected values: %v", regs)
+	}
+
+	// the server should reject queues longer than 31 registers
+	fh.queue = make([]uint16, 32)
+	_, err = client.ReadFIFOQueue(0x04de)
+	if err != ErrIllegalDataValue {
+		t.Errorf("client.ReadFIFOQueue() should have returned ErrIllegalDataValue, got: %v", err)
+	}
+
+	// unknown FIFO pointer addresses should be rejected by the handler
+	_, err = client.ReadFIFOQueue(0x0001)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.ReadFIFOQueue() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	// handlers not implementing the FIFOHandler interface should cause
+	// read FIFO queue requests to be rejected with ErrIllegalFunction
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+
+	_, err = client.ReadFIFOQueue(0x04de)
+	if err != ErrIllegalFunction {
+		t.Errorf("client.ReadFIFOQueue() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+type fifoTestHandler struct {
+	tcpTestHandler
+	queue []uint16
+}
+
+func (fh *fifoTestHandler) HandleFIFOQueue(req *FIFOQueueRequest) (res []uint16, err error) {
+	if req.Addr != 0x04de {
+		err = ErrIllegalDataAddress
+		return
+	}
+
+	res = fh.queue
+
+	return
+}
+
+func TestTCPServerFileRecords(t *testing.T) {
+	var server *ModbusServer
+	var err error
+	var client *ModbusClient
+	var fh *fileRecordTestHandler
+	var values [][]uint16
+	var regs []uint16
+
+	fh = &fileRecordTestHandler{
+		files: map[uint16][]uint16{
+			4: make([]uint16, 10),
+			5: make([]uint16, 20),
+		},
+	}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, fh)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	// write to two files in a single request
+	err = client.WriteFileRecords([]FileRecord{
+		{FileNumber: 4, RecordNumber: 1, Data: []uint16{0x0df8, 0x0fe1}},
+		{FileNumber: 5, RecordNumber: 17, Data: []uint16{0x1112, 0x1314, 0x1516}},
+	})
+	if err != nil {
+		t.Errorf("client.WriteFileRecords() should have succeeded, got: %v", err)
+	}
+	if fh.files[4][1] != 0x0df8 || fh.files[4][2] != 0x0fe1 {
+		t.Errorf("unexpected file #4 contents: %v", fh.files[4])
+	}
+	if fh.files[5][17] != 0x1112 || fh.files[5][18] != 0x1314 ||
+		fh.files[5][19] != 0x1516 {
+		t.Errorf("unexpected file #5 contents: %v", fh.files[5])
+	}
+
+	// read them back, along with surrounding records, in a single request
+	values, err = client.ReadFileRecords([]FileRecord{
+		{FileNumber: 4, RecordNumber: 0, RecordLength: 4},
+		{FileNumber: 5, RecordNumber: 18, RecordLength: 2},
+		{FileNumber: 4, RecordNumber: 2, RecordLength: 1},
+	})
+	if err != nil {
+		t.Errorf("client.ReadFileRecords() should have succeeded, got: %v", err)
+	}
+	if len(values) != 3 {
+		t.Fatalf("expected 3 sub-responses, got: %v", len(values))
+	}
+	for i, expected := range [][]uint16{
+		{0x0000, 0x0df8, 0x0fe1, 0x0000},
+		{0x1314, 0x1516},
+		{0x0fe1},
+	} {
+		if len(values[i]) != len(expected) {
+			t.Errorf("expected %v values in sub-response #%v, got: %v",
+				len(expected), i, len(values[i]))
+			continue
+		}
+		for j := range expected {
+			if values[i][j] != expected[j] {
+				t.Errorf("expected 0x%04x at position %v of sub-response #%v, got: 0x%04x",
+					expected[j], j, i, values[i][j])
+			}
+		}
+	}
+
+	// single record helpers
+	err = client.WriteFileRecord(5, 0, []uint16{0xcafe})
+	if err != nil {
+		t.Errorf("client.WriteFileRecord() should have succeeded, got: %v", err)
+	}
+	regs, err = client.ReadFileRecord(5, 0, 1)
+	if err != nil {
+		t.Errorf("client.ReadFileRecord() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 1 || regs[0] != 0xcafe {
+		t.Errorf("unexpected values: %v", regs)
+	}
+
+	// out of bounds accesses should be rejected by the handler
+	_, err = client.ReadFileRecord(4, 8, 3)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.ReadFileRecord() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+	err = client.WriteFileRecord(6, 0, []uint16{0x0001})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("client.WriteFileRecord() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// invalid parameters should be caught by the client
+	_, err = client.ReadFileRecords([]FileRecord{})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadFileRecords() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadFileRecord(0, 0, 1)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadFileRecord() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadFileRecord(4, 10000, 1)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadFileRecord() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	_, err = client.ReadFileRecord(4, 0, 200)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.ReadFileRecord() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+	err = client.WriteFileRecord(4, 0, []uint16{})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("client.WriteFileRecord() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+type fileRecordTestHandler struct {
+	tcpTestHandler
+	files map[uint16][]uint16
+}
+
+func (fh *fileRecordTestHandler) HandleFileRecords(req *FileRecordsRequest) (res [][]uint16, err error) {
+	// validate all records before touching anything
+	for _, record := range req.Records {
+		file, ok := fh.files[record.FileNumber]
+		if !ok || int(record.RecordNumber)+int(record.RecordLength) > len(file) {
+			err = ErrIllegalDataAddress
+			return
+		}
+	}
+
+	for _, record := range req.Records {
+		file := fh.files[record.FileNumber]
+		if req.IsWrite {
+			copy(file[record.RecordNumber:], record.Data)
+		} else {
+			res = append(res, append([]uint16{},
+				file[record.RecordNumber:record.RecordNumber+record.RecordLength]...))
+		}
+	}
+
+	return
+}
+
+func TestTCPServerDeviceIdentification(t *testing.T) {
+	var server *ModbusServer
+	var err error
+	var client *ModbusClient
+	var dh *deviceIdTestHandler
+	var objects map[uint8]string
+
+	dh = &deviceIdTestHandler{
+		objects: map[uint8]string{
+			OBJECT_ID_VENDOR_NAME:          "ACME",
+			OBJECT_ID_PRODUCT_CODE:         "AX-200",
+			OBJECT_ID_MAJOR_MINOR_REVISION: "V1.02",
+			OBJECT_ID_PRODUCT_NAME:         "Widget controller",
+		},
+	}
+
+	// add enough extended objects to require multiple responses
+	for id := 0x80; id < 0x88; id++ {
+		dh.objects[uint8(id)] = strings.Repeat(string(rune('a'+id-0x80)), 60)
+	}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, dh)
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	// basic objects only
+	objects, err = client.ReadDeviceIdentification(READ_DEVICE_ID_BASIC, 0x00)
+	if err != nil {
+		t.Errorf("client.ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(objects) != 3 {
+		t.Errorf("expected 3 objects, got: %v", len(objects))
+	}
+	for id := OBJECT_ID_VENDOR_NAME; id <= OBJECT_ID_MAJOR_MINOR_REVISION; id++ {
+		if objects[id] != dh.objects[id] {
+			t.Errorf("expected '%s' for object %v, got: '%s'",
+				dh.objects[id], id, objects[id])
+		}
+	}
+
+	// regular objects include basic objects
+	objects, err = client.ReadDeviceIdentification(READ_DEVICE_ID_REGULAR, 0x00)
+	if err != nil {
+		t.Errorf("client.ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(objects) != 4 {
+		t.Errorf("expected 4 objects, got: %v", len(objects))
+	}
+	if objects[OBJECT_ID_PRODUCT_NAME] != "Widget controller" {
+		t.Errorf("unexpected product name: '%s'", objects[OBJECT_ID_PRODUCT_NAME])
+	}
+
+	// extended objects do not fit in a single response and should be
+	// reassembled from multiple transactions
+	objects, err = client.ReadDeviceIdentification(READ_DEVICE_ID_EXTENDED, 0x00)
+	if err != nil {
+		t.Errorf("client.ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(objects) != len(dh.objects) {
+		t.Errorf("expected %v objects, got: %v", len(dh.objects), len(objects))
+	}
+	for id, value := range dh.objects {
+		if objects[id] != value {
+			t.Errorf("expected '%s' for object %v, got: '%s'", value, id, objects[id])
+		}
+	}
+
+	// stream access starting at an unknown object restarts at object 0
+	objects, err = client.ReadDeviceIdentification(READ_DEVICE_ID_BASIC, 0x42)
+	if err != nil {
+		t.Errorf("client.ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(objects) != 3 {
+		t.Errorf("expected 3 objects, got: %v", len(objects))
+	}
+
+	// individual access
+	objects, err = client.ReadDeviceIdentification(READ_DEVICE_ID_SPECIFIC, 0x81)
+	if err != nil {
+		t.Errorf("client.ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(objects) != 1 || objects[0x81] != dh.objects[0x81] {
+		t.Errorf("unexpected objects: %v", objects)
+	}
+
+	// individual access to a missing object should fail
+	_, err = client.ReadDeviceIdentification(READ_DEVICE_ID_SPECIFIC, OBJECT_ID_MODEL_NAME)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// invalid categories should be caught client-side
+	_, err = client.ReadDeviceIdentification(0x05, 0x00)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// unit ids other than 9 are rejected by the handler
+	client.SetUnitId(10)
+	_, err = client.ReadDeviceIdentification(READ_DEVICE_ID_BASIC, 0x00)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	// handlers without device identification support should yield
+	// an illegal function exception
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	_, err = client.ReadDeviceIdentification(READ_DEVICE_ID_BASIC, 0x00)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+type deviceIdTestHandler struct {
+	tcpTestHandler
+	objects map[uint8]string
+}
+
+func (dh *deviceIdTestHandler) HandleDeviceIdentification(req *DeviceIdentificationRequest) (res map[uint8]string, err error) {
+	if req.UnitId != 9 {
+		err = ErrIllegalFunction
+		return
+	}
+
+	res = dh.objects
+
+	return
+}
+
+func TestTCPServerDiagnostics(t *testing.T) {
+	var server *ModbusServer
+	var err error
+	var client *ModbusClient
+	var value uint16
+	var status uint16
+	var eventCount uint16
+	var eventLog *CommEventLog
+	var exceptionStatus uint8
+	var serverId []byte
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, &diagTestHandler{})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:     "tcp://localhost:5504",
+		Timeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	// echo
+	err = client.ReturnQueryData([]byte{0x12, 0x34, 0x56})
+	if err != nil {
+		t.Errorf("client.ReturnQueryData() should have succeeded, got: %v", err)
+	}
+
+	// the bus message counter should account for both requests
+	value, err = client.ReadDiagnosticCounter(DIAG_BUS_MESSAGE_COUNT)
+	if err != nil {
+		t.Errorf("client.ReadDiagnosticCounter() should have succeeded, got: %v", err)
+	}
+	if value != 2 {
+		t.Errorf("expected a bus message count of 2, got: %v", value)
+	}
+
+	// trigger an exception response
+	_, err = client.ReadRegister(20, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	value, err = client.ReadDiagnosticCounter(DIAG_BUS_EXCEPTION_ERROR_COUNT)
+	if err != nil {
+		t.Errorf("client.ReadDiagnosticCounter() should have succeeded, got: %v", err)
+	}
+	if value != 1 {
+		t.Errorf("expected an exception error count of 1, got: %v", value)
+	}
+
+	// the event counter should only account for successful completions
+	status, eventCount, err = client.GetCommEventCounter()
+	if err != nil {
+		t.Errorf("client.GetCommEventCounter() should have succeeded, got: %v", err)
+	}
+	if status != 0x0000 {
+		t.Errorf("expected a status of 0x0000, got: 0x%04x", status)
+	}
+	if eventCount != 3 {
+		t.Errorf("expected an event count of 3, got: %v", eventCount)
+	}
+
+	eventLog, err = client.GetCommEventLog()
+	if err != nil {
+		t.Errorf("client.GetCommEventLog() should have succeeded, got: %v", err)
+	}
+	if eventLog.EventCount != 3 {
+		t.Errorf("expected an event count of 3, got: %v", eventLog.EventCount)
+	}
+	if eventLog.MessageCount != 6 {
+		t.Errorf("expected a message count of 6, got: %v", eventLog.MessageCount)
+	}
+	if len(eventLog.Events) != 11 {
+		t.Errorf("expected 11 events, got: %v", len(eventLog.Events))
+	} else {
+		// most recent events first, with the exception response (0x41)
+		// sent in response to the 3rd request
+		for i, b := range []byte{0x80, 0x40, 0x80, 0x40, 0x80, 0x41, 0x80} {
+			if eventLog.Events[i] != b {
+				t.Errorf("expected 0x%02x at position %v, got: 0x%02x",
+					b, i, eventLog.Events[i])
+			}
+		}
+	}
+
+	// clear counters
+	err = client.ClearDiagnosticCounters()
+	if err != nil {
+		t.Errorf("client.ClearDiagnosticCounters() should have succeeded, got: %v", err)
+	}
+
+	value, err = client.ReadDiagnosticCounter(DIAG_SERVER_MESSAGE_COUNT)
+	if err != nil {
+		t.Errorf("client.ReadDiagnosticCounter() should have succeeded, got: %v", err)
+	}
+	if value != 1 {
+		t.Errorf("expected a server message count of 1, got: %v", value)
+	}
+
+	value, err = client.ReadDiagnosticRegister()
+	if err != nil {
+		t.Errorf("client.ReadDiagnosticRegister() should have succeeded, got: %v", err)
+	}
+	if value != 0x0000 {
+		t.Errorf("expected a diagnostic register value of 0x0000, got: 0x%04x", value)
+	}
+
+	// unsupported sub-functions should yield an illegal function exception
+	_, err = client.Diagnostics(DIAG_CHANGE_ASCII_INPUT_DELIMITER, []byte{0x0a, 0x00})
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	// invalid counters should be caught client-side
+	_, err = client.ReadDiagnosticCounter(DIAG_CLEAR_COUNTERS)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// in listen only mode, requests should go unanswered
+	_, err = client.Diagnostics(DIAG_FORCE_LISTEN_ONLY_MODE, []byte{0x00, 0x00})
+	if err != ErrRequestTimedOut {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+
+	_, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != ErrRequestTimedOut {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+
+	// restarting communications brings the server out of listen only mode,
+	// but the restart request itself is not answered
+	err = client.RestartCommunications(true)
+	if err != ErrRequestTimedOut {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+
+	_, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegister() should have succeeded, got: %v", err)
+	}
+
+	// the event log should have been cleared by the restart
+	eventLog, err = client.GetCommEventLog()
+	if err != nil {
+		t.Errorf("client.GetCommEventLog() should have succeeded, got: %v", err)
+	}
+	if len(eventLog.Events) != 4 {
+		t.Errorf("expected 4 events, got: %v", len(eventLog.Events))
+	} else {
+		for i, b := range []byte{0x80, 0x40, 0x80, 0x00} {
+			if eventLog.Events[i] != b {
+				t.Errorf("expected 0x%02x at position %v, got: 0x%02x",
+					b, i, eventLog.Events[i])
+			}
+		}
+	}
+
+	// once out of listen only mode, restart requests are answered
+	err = client.RestartCommunications(false)
+	if err != nil {
+		t.Errorf("client.RestartCommunications() should have succeeded, got: %v", err)
+	}
+
+	exceptionStatus, err = client.ReadExceptionStatus()
+	if err != nil {
+		t.Errorf("client.ReadExceptionStatus() should have succeeded, got: %v", err)
+	}
+	if exceptionStatus != 0x6d {
+		t.Errorf("expected an exception status of 0x6d, got: 0x%02x", exceptionStatus)
+	}
+
+	serverId, err = client.ReportServerId()
+	if err != nil {
+		t.Errorf("client.ReportServerId() should have succeeded, got: %v", err)
+	}
+	if len(serverId) != 3 || serverId[0] != 0x01 || serverId[1] != 0x02 ||
+		serverId[2] != 0xff {
+		t.Errorf("unexpected server id: %v", serverId)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+type diagTestHandler struct {
+	tcpTestHandler
+}
+
+func (dh *diagTestHandler) HandleExceptionStatus(req *ExceptionStatusRequest) (res uint8, err error) {
+	res = 0x6d
+
+	return
+}
+
+func (dh *diagTestHandler) HandleServerId(req *ServerIdRequest) (serverId []byte, running bool, err error) {
+	serverId = []byte{0x01, 0x02}
+	running = true
+
+	return
+}
+
+func TestTCPServerUnknownFunction(t *testing.T) {
+	var server *ModbusServer
+	var err error
+	var client *ModbusClient
+	var res *RawResponse
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, &fallbackTestHandler{})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL: "tcp://localhost:5504",
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+
+	// user-defined function codes should be routed to the fallback handler
+	res, err = client.ExecuteRawRequest(&RawRequest{
+		UnitId:       9,
+		FunctionCode: 0x41,
+		Payload:      []byte{0x01, 0x02, 0x03},
+	})
+	if err != nil {
+		t.Errorf("client.ExecuteRawRequest() should have succeeded, got: %v", err)
+	}
+	if res.UnitId != 9 || res.FunctionCode != 0x41 {
+		t.Errorf("unexpected unit id/function code: %v/0x%02x",
+			res.UnitId, res.FunctionCode)
+	}
+	if len(res.Payload) != 3 ||
+		res.Payload[0] != 0x03 || res.Payload[1] != 0x02 || res.Payload[2] != 0x01 {
+		t.Errorf("unexpected payload: %v", res.Payload)
+	}
+
+	// handler errors should be mapped to exceptions
+	_, err = client.ExecuteRawRequest(&RawRequest{
+		UnitId:       9,
+		FunctionCode: 0x42,
+	})
+	if err != ErrIllegalDataValue {
+		t.Errorf("expected ErrIllegalDataValue, got: %v", err)
+	}
+
+	// standard function codes should still be handled by the server
+	res, err = client.ExecuteRawRequest(&RawRequest{
+		UnitId:       9,
+		FunctionCode: fcReadHoldingRegisters,
+		Payload:      []byte{0x00, 0x00, 0x00, 0x01},
+	})
+	if err != nil {
+		t.Errorf("client.ExecuteRawRequest() should have succeeded, got: %v", err)
+	}
+	if len(res.Payload) != 3 || res.Payload[0] != 0x02 {
+		t.Errorf("unexpected payload: %v", res.Payload)
+	}
+
+	client.Close()
+	server.Stop()
+
+	// without a fallback handler, unknown function codes should yield an
+	// illegal function exception
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+
+	_, err = client.ExecuteRawRequest(&RawRequest{
+		UnitId:       9,
+		FunctionCode: 0x41,
+	})
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+type fallbackTestHandler struct {
+	tcpTestHandler
+}
+
+func (fh *fallbackTestHandler) HandleUnknownFunction(req *UnknownFunctionRequest) (res []byte, err error) {
+	switch req.FunctionCode {
+	case 0x41:
+		// reverse the request payload
+		for i := len(req.Payload) - 1; i >= 0; i-- {
+			res = append(res, req.Payload[i])
+		}
+	default:
+		err = ErrIllegalDataValue
+	}
+
+	return
+}
+
+func TestRTUOverTCPServer(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var err error
+	var regs []uint16
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "rtuovertcp://localhost:5504",
+		MaxClients: 2,
+		UnitIds:    []uint8{9},
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:     "rtuovertcp://localhost:5504",
+		Timeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	err = client.WriteRegisters(6, []uint16{0xcafe, 0xbabe})
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	regs, err = client.ReadRegisters(6, 2, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0xcafe || regs[1] != 0xbabe {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	// exceptions should make it through
+	_, err = client.ReadRegister(20, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// requests addressed to other units should be ignored, as they would
+	// be on a serial bus
+	client.SetUnitId(10)
+	_, err = client.ReadRegister(0, HOLDING_REGISTER)
+	if err != ErrRequestTimedOut {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+
+	// ... without affecting the connection
+	client.SetUnitId(9)
+	_, err = client.ReadRegister(6, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegister() should have succeeded, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+func TestASCIIOverTCPServer(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var err error
+	var regs []uint16
+	var coils []bool
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "asciiovertcp://localhost:5504",
+		MaxClients: 2,
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Errorf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Errorf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:     "asciiovertcp://localhost:5504",
+		Timeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Errorf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Errorf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	err = client.WriteRegisters(6, []uint16{0xcafe, 0xbabe})
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	regs, err = client.ReadRegisters(6, 2, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0xcafe || regs[1] != 0xbabe {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	err = client.WriteCoils(1, []bool{true, false, true})
+	if err != nil {
+		t.Errorf("client.WriteCoils() should have succeeded, got: %v", err)
+	}
+
+	coils, err = client.ReadCoils(0, 4)
+	if err != nil {
+		t.Errorf("client.ReadCoils() should have succeeded, got: %v", err)
+	}
+	if len(coils) != 4 || coils[0] || !coils[1] || coils[2] || !coils[3] {
+		t.Errorf("unexpected coil values: %v", coils)
+	}
+
+	// exceptions should make it through
+	_, err = client.ReadRegister(20, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// variable length requests and responses should be framed properly
+	err = client.ReturnQueryData([]byte("hello, ascii"))
+	if err != nil {
+		t.Errorf("client.ReturnQueryData() should have succeeded, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
 type tcpTestHandler struct {
 	coils   [10]bool
 	di      [10]bool
@@ -656,3 +1859,497 @@ func (th *tcpTestHandler) HandleInputRegisters(req *InputRegistersRequest) (res
 
 	return
 }
+
+func TestTCPClientContext(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var err error
+	var regs []uint16
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var ts time.Time
+	var done chan error
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5504",
+		MaxClients: 2,
+	}, &slowTestHandler{delay: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:     "tcp://localhost:5504",
+		Timeout: 1 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+	client.SetUnitId(9)
+
+	err = client.WriteRegisters(0, []uint16{0x1111, 0x2222})
+	if err != nil {
+		t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	// a context deadline shorter than the client timeout should prevail
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	ts = time.Now()
+	_, err = client.ReadRegistersCtx(ctx, 9, 1, HOLDING_REGISTER)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	if time.Since(ts) > 200*time.Millisecond {
+		t.Errorf("ReadRegistersCtx() should have returned after ~50ms, took %v",
+			time.Since(ts))
+	}
+
+	// the late response to the abandoned request should be skipped
+	regs, err = client.ReadRegisters(0, 2, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0x1111 || regs[1] != 0x2222 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	// cancelling the context should abort the request in flight
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = client.ReadRegistersCtx(ctx, 9, 1, HOLDING_REGISTER)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	// a request made with an already cancelled context should fail
+	// right away
+	_, err = client.ReadRegistersCtx(ctx, 0, 1, HOLDING_REGISTER)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	// waiting for another request to complete should be bounded by the
+	// context as well
+	done = make(chan error, 1)
+	go func() {
+		_, err := client.ReadRegister(9, HOLDING_REGISTER)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = client.ReadRegistersCtx(ctx, 0, 1, HOLDING_REGISTER)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	err = <-done
+	if err != nil {
+		t.Errorf("client.ReadRegister() should have succeeded, got: %v", err)
+	}
+
+	client.Close()
+	server.Stop()
+
+	return
+}
+
+// slowTestHandler delays accesses to holding register #9.
+type slowTestHandler struct {
+	tcpTestHandler
+	delay time.Duration
+}
+
+func (sh *slowTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	if req.Addr+req.Quantity > 9 {
+		time.Sleep(sh.delay)
+	}
+
+	res, err = sh.tcpTestHandler.HandleHoldingRegisters(req)
+
+	return
+}
+
+func TestTCPServerShutdown(t *testing.T) {
+	var server *ModbusServer
+	var busy *ModbusClient
+	var idle *ModbusClient
+	var err error
+	var done chan error
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var ts time.Time
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5506",
+		MaxClients: 2,
+	}, &slowTestHandler{delay: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	newClient := func() (mc *ModbusClient) {
+		mc, err = NewClient(&ClientConfiguration{
+			URL:     "tcp://localhost:5506",
+			Timeout: 1 * time.Second,
+		})
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		err = mc.Open()
+		if err != nil {
+			t.Fatalf("client.Open() should have succeeded, got: %v", err)
+		}
+		mc.SetUnitId(9)
+
+		return
+	}
+
+	busy = newClient()
+	defer busy.Close()
+	idle = newClient()
+	defer idle.Close()
+
+	// make sure both connections are up
+	_, err = idle.ReadRegister(0, HOLDING_REGISTER)
+	if err != nil {
+		t.Fatalf("client.ReadRegister() should have succeeded, got: %v", err)
+	}
+
+	// start a slow request, then shut the server down while it is in flight
+	done = make(chan error, 1)
+	go func() {
+		_, err := busy.ReadRegister(9, HOLDING_REGISTER)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ts = time.Now()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	err = server.Shutdown(ctx)
+	cancel()
+	if err != nil {
+		t.Errorf("Shutdown() should have succeeded, got: %v", err)
+	}
+
+	// the in-flight request should have been allowed to complete
+	if time.Since(ts) < 200*time.Millisecond {
+		t.Errorf("Shutdown() should have waited for the in-flight request")
+	}
+	err = <-done
+	if err != nil {
+		t.Errorf("in-flight request should have succeeded, got: %v", err)
+	}
+
+	// idle connections should have been closed
+	_, err = idle.ReadRegister(0, HOLDING_REGISTER)
+	if err == nil {
+		t.Errorf("request on an idle connection should have failed")
+	}
+
+	// and new connections refused
+	_, err = net.DialTimeout("tcp", "localhost:5506", 100*time.Millisecond)
+	if err == nil {
+		t.Errorf("new connections should have been refused")
+	}
+
+	// shutting a stopped server down should be a no-op
+	err = server.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("Shutdown() should have succeeded, got: %v", err)
+	}
+
+	// connections still busy when ctx expires should be forcibly closed
+	server, err = NewServer(&ServerConfiguration{
+		URL:        "tcp://localhost:5506",
+		MaxClients: 2,
+	}, &slowTestHandler{delay: 1 * time.Second})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	busy = newClient()
+	defer busy.Close()
+
+	go func() {
+		_, err := busy.ReadRegister(9, HOLDING_REGISTER)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	err = server.Shutdown(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	err = <-done
+	if err == nil {
+		t.Errorf("forcibly closed request should have failed")
+	}
+
+	return
+}
+
+// shutdownRaceTransport holds the first request it reads back until resume
+// is closed, signalling on read once the request was read.
+type shutdownRaceTransport struct {
+	*tcpTransport
+	read   chan struct{}
+	resume chan struct{}
+}
+
+func (srt *shutdownRaceTransport) ReadRequest() (req *pdu, err error) {
+	req, err = srt.tcpTransport.ReadRequest()
+
+	if srt.read != nil {
+		close(srt.read)
+		srt.read = nil
+		<-srt.resume
+	}
+
+	return
+}
+
+func TestTCPServerShutdownRace(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var ds *DataStore
+	var err error
+	var read = make(chan struct{})
+	var resume = make(chan struct{})
+	var done = make(chan error, 1)
+	var shutdown = make(chan error, 1)
+	var reg uint16
+
+	ds, _ = NewDataStore(&DataStoreConfiguration{HoldingRegisters: 10})
+	ds.Unit(1).SetRegister(3, HOLDING_REGISTER, 0x1234)
+
+	server, err = NewServer(&ServerConfiguration{
+		URL: "tcp://localhost:5507",
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:     "tcp://fake",
+		Timeout: 1 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	client.dialer = func() (tr transport, err error) {
+		var p1, p2 net.Conn
+
+		p1, p2 = net.Pipe()
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			server.handleTransport(&shutdownRaceTransport{
+				tcpTransport: newTCPTransport(p1, time.Hour, nil),
+				read:         read,
+				resume:       resume,
+			}, "fake", "")
+			p1.Close()
+		}()
+
+		tr = newTCPTransport(p2, client.conf.Timeout, nil)
+
+		return
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("client.Open() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	go func() {
+		var err error
+
+		reg, err = client.ReadRegister(3, HOLDING_REGISTER)
+		done <- err
+	}()
+
+	// shut the server down right after the request was read, before it is
+	// processed
+	<-read
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+	for !server.isShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	close(resume)
+
+	// the request should still be answered
+	err = <-done
+	if err != nil || reg != 0x1234 {
+		t.Errorf("expected 0x1234, got: 0x%04x, %v", reg, err)
+	}
+
+	err = <-shutdown
+	if err != nil {
+		t.Errorf("Shutdown() should have succeeded, got: %v", err)
+	}
+
+	return
+}
+
+// blockingTestHandler blocks holding register accesses until release is
+// closed, signalling on entered when one starts.
+type blockingTestHandler struct {
+	tcpTestHandler
+	entered  chan struct{}
+	release  chan struct{}
+	returned atomic.Bool
+}
+
+func (bh *blockingTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	bh.entered <- struct{}{}
+	<-bh.release
+
+	res, err = bh.tcpTestHandler.HandleHoldingRegisters(req)
+	bh.returned.Store(true)
+
+	return
+}
+
+func TestTCPServerStopWaitsForHandlers(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var bh *blockingTestHandler
+	var err error
+	var stopped = make(chan error, 1)
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	newServer := func() {
+		bh = &blockingTestHandler{
+			entered: make(chan struct{}, 1),
+			release: make(chan struct{}),
+		}
+
+		server, err = NewServer(&ServerConfiguration{
+			URL: "tcp://localhost:5508",
+		}, bh)
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+
+		err = server.Start()
+		if err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+
+		client, err = NewClient(&ClientConfiguration{
+			URL:     "tcp://localhost:5508",
+			Timeout: 1 * time.Second,
+		})
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		err = client.Open()
+		if err != nil {
+			t.Fatalf("client.Open() should have succeeded, got: %v", err)
+		}
+
+		go client.ReadRegister(0, HOLDING_REGISTER)
+		select {
+		case <-bh.entered:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("request should have reached the handler")
+		}
+	}
+
+	// Stop() should not return before handler calls in progress do
+	newServer()
+	go func() {
+		stopped <- server.Stop()
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatalf("Stop() should have waited for the handler to return")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(bh.release)
+	select {
+	case <-stopped:
+	case <-time.After(1 * time.Second):
+		t.Fatalf("Stop() should have returned")
+	}
+	if !bh.returned.Load() {
+		t.Errorf("handler should have returned")
+	}
+	client.Close()
+
+	// servers should not restart until handler calls left behind by an
+	// expired shutdown return
+	newServer()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	err = server.Shutdown(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	err = server.Start()
+	if err != ErrConfigurationError {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	close(bh.release)
+	for i := 0; ; i++ {
+		err = server.Start()
+		if err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Start() should have succeeded, got: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	client.Close()
+	server.Stop()
+
+	return
+}
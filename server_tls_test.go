@@ -294,6 +294,28 @@ func TestTLSServer(t *testing.T) {
 		t.Errorf("unexpected register values: %v", regs)
 	}
 
+	// mask write requests should be subject to the same access rules
+	// as other holding register writes
+	c1.SetUnitId(4)
+	err = c1.MaskWriteRegister(2, 0xff00, 0x0011)
+	if err != ErrIllegalFunction {
+		t.Errorf("c1.MaskWriteRegister() should have failed with %v, got: %v",
+			ErrIllegalFunction, err)
+	}
+
+	err = c2.MaskWriteRegister(2, 0xff00, 0x0011)
+	if err != nil {
+		t.Errorf("c2.MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+
+	regs, err = c2.ReadRegisters(1, 2, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("c2.ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if regs[0] != 0 || regs[1] != 0x0011 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
 	// close the server and all client connections
 	server.Stop()
 
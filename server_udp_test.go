@@ -0,0 +1,115 @@
+package modbus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUDPServer(t *testing.T) {
+	for _, scheme := range []string{"udp", "rtuoverudp"} {
+		t.Run(scheme, func(t *testing.T) {
+			var server *ModbusServer
+			var client *ModbusClient
+			var err error
+			var regs []uint16
+			var coils []bool
+
+			server, err = NewServer(&ServerConfiguration{
+				URL: scheme + "://localhost:5505",
+			}, &tcpTestHandler{})
+			if err != nil {
+				t.Fatalf("failed to create server: %v", err)
+			}
+
+			err = server.Start()
+			if err != nil {
+				t.Fatalf("failed to start server: %v", err)
+			}
+
+			client, err = NewClient(&ClientConfiguration{
+				URL:     scheme + "://localhost:5505",
+				Timeout: 200 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			err = client.Open()
+			if err != nil {
+				t.Fatalf("client.Open() should have succeeded, got: %v", err)
+			}
+			client.SetUnitId(9)
+
+			err = client.WriteRegisters(2, []uint16{0x1234, 0x5678})
+			if err != nil {
+				t.Errorf("client.WriteRegisters() should have succeeded, got: %v", err)
+			}
+
+			regs, err = client.ReadRegisters(2, 2, HOLDING_REGISTER)
+			if err != nil {
+				t.Errorf("client.ReadRegisters() should have succeeded, got: %v", err)
+			}
+			if len(regs) != 2 || regs[0] != 0x1234 || regs[1] != 0x5678 {
+				t.Errorf("unexpected register values: %v", regs)
+			}
+
+			err = client.WriteCoil(3, true)
+			if err != nil {
+				t.Errorf("client.WriteCoil() should have succeeded, got: %v", err)
+			}
+
+			coils, err = client.ReadCoils(2, 3)
+			if err != nil {
+				t.Errorf("client.ReadCoils() should have succeeded, got: %v", err)
+			}
+			if len(coils) != 3 || coils[0] || !coils[1] || coils[2] {
+				t.Errorf("unexpected coil values: %v", coils)
+			}
+
+			// exceptions should make it through
+			_, err = client.ReadRegister(20, HOLDING_REGISTER)
+			if err != ErrIllegalDataAddress {
+				t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+			}
+
+			// a second client should get its own responses
+			client2, err := NewClient(&ClientConfiguration{
+				URL:     scheme + "://localhost:5505",
+				Timeout: 200 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			err = client2.Open()
+			if err != nil {
+				t.Fatalf("client2.Open() should have succeeded, got: %v", err)
+			}
+			client2.SetUnitId(9)
+
+			regs, err = client2.ReadRegisters(3, 1, HOLDING_REGISTER)
+			if err != nil {
+				t.Errorf("client2.ReadRegisters() should have succeeded, got: %v", err)
+			}
+			if len(regs) != 1 || regs[0] != 0x5678 {
+				t.Errorf("unexpected register values: %v", regs)
+			}
+
+			client.Close()
+			client2.Close()
+
+			// shutting down should stop reading datagrams promptly
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			err = server.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				t.Errorf("Shutdown() should have succeeded, got: %v", err)
+			}
+
+			server.Stop()
+		})
+	}
+
+	return
+}
@@ -0,0 +1,240 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// pipelinedTCPTransport is a client-side modbus TCP transport allowing for
+// multiple requests to be outstanding on the same connection.
+// Requests are tagged with distinct transaction ids and their responses
+// are matched by a dedicated reader goroutine, in whichever order they
+// come back.
+type pipelinedTCPTransport struct {
+	logger  *logger
+	socket  net.Conn
+	timeout time.Duration
+	// frames MBAP requests and responses
+	framer *tcpTransport
+	// one token per request allowed to be in flight
+	slots chan struct{}
+	// serializes writes to the socket
+	writeLock sync.Mutex
+	// closed when the reader goroutine exits
+	done chan struct{}
+
+	lock      sync.Mutex
+	lastTxnId uint16
+	pending   map[uint16]chan *pdu
+	// error which stopped the reader goroutine, if any
+	err error
+}
+
+// Returns a new pipelined TCP transport, allowing for up to maxInFlight
+// outstanding requests.
+func newPipelinedTCPTransport(socket net.Conn, timeout time.Duration,
+	maxInFlight uint, customLogger *log.Logger) (pt *pipelinedTCPTransport) {
+	pt = &pipelinedTCPTransport{
+		logger: newLogger(
+			fmt.Sprintf("tcp-pipelined-transport(%s)", socket.RemoteAddr()), customLogger),
+		socket:  socket,
+		timeout: timeout,
+		framer:  newTCPTransport(socket, timeout, customLogger),
+		slots:   make(chan struct{}, maxInFlight),
+		done:    make(chan struct{}),
+		pending: make(map[uint16]chan *pdu),
+	}
+
+	go pt.readResponses()
+
+	return
+}
+
+// Closes the underlying tcp socket, failing all outstanding requests.
+func (pt *pipelinedTCPTransport) Close() (err error) {
+	err = pt.socket.Close()
+
+	// wait for the reader goroutine to exit
+	<-pt.done
+
+	return
+}
+
+// Runs a request across the socket and returns a response.
+// The request is abandoned once the configured timeout elapses or ctx is
+// done, whichever happens first. Safe for concurrent use.
+func (pt *pipelinedTCPTransport) ExecuteRequest(ctx context.Context, req *pdu) (res *pdu, err error) {
+	var txnId uint16
+	var resChan chan *pdu
+	var timer *time.Timer
+	var deadline time.Time
+	var ctxDeadline time.Time
+	var ok bool
+
+	timer = time.NewTimer(pt.timeout)
+	defer timer.Stop()
+
+	// wait for an in-flight slot to be available
+	select {
+	case pt.slots <- struct{}{}:
+		defer func() { <-pt.slots }()
+	case <-timer.C:
+		err = ErrRequestTimedOut
+		return
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+
+	// register the request under an unused transaction id
+	txnId, resChan, err = pt.register()
+	if err != nil {
+		return
+	}
+	defer pt.unregister(txnId)
+
+	// send the request, bounding the write by the request deadline
+	deadline = time.Now().Add(pt.timeout)
+	if ctxDeadline, ok = ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	pt.writeLock.Lock()
+	err = pt.socket.SetWriteDeadline(deadline)
+	if err == nil {
+		_, err = pt.socket.Write(pt.framer.assembleMBAPFrame(txnId, req))
+	}
+	pt.writeLock.Unlock()
+
+	if err != nil {
+		// a partial write would leave the stream out of sync: give up
+		// on the connection
+		pt.socket.Close()
+		return
+	}
+
+	// wait for the response, which the reader goroutine will hand over
+	select {
+	case res, ok = <-resChan:
+		if !ok {
+			// the connection broke before the response was received
+			err = pt.readerError()
+		}
+	case <-timer.C:
+		err = ErrRequestTimedOut
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+// Not supported: pipelined TCP transports are client-side only.
+func (pt *pipelinedTCPTransport) ReadRequest() (*pdu, error) {
+	return nil, ErrConfigurationError
+}
+
+// Not supported: pipelined TCP transports are client-side only.
+func (pt *pipelinedTCPTransport) WriteResponse(res *pdu) error {
+	return ErrConfigurationError
+}
+
+// Allocates a transaction id and a response channel for a new request.
+func (pt *pipelinedTCPTransport) register() (txnId uint16, resChan chan *pdu, err error) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	if pt.err != nil {
+		err = pt.err
+		return
+	}
+
+	// skip transaction ids of requests still in flight, should the
+	// counter wrap around (there are at most maxInFlight of them)
+	for {
+		pt.lastTxnId++
+		if _, inUse := pt.pending[pt.lastTxnId]; !inUse {
+			break
+		}
+	}
+
+	txnId = pt.lastTxnId
+	resChan = make(chan *pdu, 1)
+	pt.pending[txnId] = resChan
+
+	return
+}
+
+// Releases the transaction id of a completed or abandoned request.
+// Late responses to that request will be dropped.
+func (pt *pipelinedTCPTransport) unregister(txnId uint16) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	delete(pt.pending, txnId)
+
+	return
+}
+
+// Returns the error which stopped the reader goroutine.
+func (pt *pipelinedTCPTransport) readerError() (err error) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	err = pt.err
+
+	return
+}
+
+// Reads responses off the socket and hands them over to the requests
+// waiting for them, until the connection breaks or is closed.
+func (pt *pipelinedTCPTransport) readResponses() {
+	var res *pdu
+	var txnId uint16
+	var resChan chan *pdu
+	var err error
+
+	defer close(pt.done)
+
+	for {
+		res, txnId, err = pt.framer.readMBAPFrame()
+
+		// ignore unknown protocol identifiers
+		if err == ErrUnknownProtocolId {
+			continue
+		}
+
+		// any other error leaves the stream unusable: fail all outstanding
+		// requests, as well as any subsequent one
+		if err != nil {
+			pt.lock.Lock()
+			pt.err = err
+			for id, resChan := range pt.pending {
+				close(resChan)
+				delete(pt.pending, id)
+			}
+			pt.lock.Unlock()
+
+			pt.socket.Close()
+			return
+		}
+
+		pt.lock.Lock()
+		resChan = pt.pending[txnId]
+		delete(pt.pending, txnId)
+		pt.lock.Unlock()
+
+		if resChan == nil {
+			pt.logger.Warningf("received unexpected transaction id 0x%04x", txnId)
+			continue
+		}
+
+		// never blocks as the channel is buffered and receives at most
+		// one response
+		resChan <- res
+	}
+}
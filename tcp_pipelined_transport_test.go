@@ -0,0 +1,225 @@
+package modbus
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Plays the role of a gateway: reads count requests off the link, then
+// replies to them in reverse order, with the value of each requested
+// holding register set to its address.
+// Returns an error if more than count requests were sent without waiting
+// for responses.
+func servePipelinedRequests(link net.Conn, count int) (err error) {
+	var tt *tcpTransport
+	var reqs []*pdu
+	var txnIds []uint16
+	var req *pdu
+	var txnId uint16
+	var addr uint16
+
+	tt = newTCPTransport(link, 100*time.Millisecond, nil)
+
+	for i := 0; i < count; i++ {
+		link.SetDeadline(time.Now().Add(time.Second))
+		req, txnId, err = tt.readMBAPFrame()
+		if err != nil {
+			return
+		}
+		reqs = append(reqs, req)
+		txnIds = append(txnIds, txnId)
+	}
+
+	// no further request should come through until responses are sent
+	link.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	_, _, err = tt.readMBAPFrame()
+	if err == nil {
+		err = ErrProtocolError
+		return
+	}
+	if !os.IsTimeout(err) {
+		return
+	}
+	err = nil
+
+	link.SetDeadline(time.Now().Add(time.Second))
+	for i := count - 1; i >= 0; i-- {
+		addr = bytesToUint16(BIG_ENDIAN, reqs[i].payload[0:2])
+		_, err = link.Write(tt.assembleMBAPFrame(txnIds[i], &pdu{
+			unitId:       reqs[i].unitId,
+			functionCode: reqs[i].functionCode,
+			payload:      append([]byte{0x02}, uint16ToBytes(BIG_ENDIAN, addr)...),
+		}))
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func TestPipelinedTCPTransport(t *testing.T) {
+	var client *ModbusClient
+	var p1, p2 net.Conn
+	var err error
+	var wg sync.WaitGroup
+	var done chan error
+
+	_, err = NewClient(&ClientConfiguration{
+		URL:         "rtuovertcp://localhost:5502",
+		MaxInFlight: 4,
+	})
+	if err != ErrConfigurationError {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	p1, p2 = net.Pipe()
+	done = make(chan error, 1)
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:         "tcp://localhost:5502",
+		MaxInFlight: 2,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.transport = newPipelinedTCPTransport(p2, 1*time.Second, 2, nil)
+
+	// expect 4 requests, 2 at a time, and reply to each pair out of order
+	go func() {
+		var err error
+
+		for i := 0; i < 2 && err == nil; i++ {
+			err = servePipelinedRequests(p1, 2)
+		}
+		done <- err
+	}()
+
+	for i := uint16(1); i <= 4; i++ {
+		wg.Add(1)
+		go func(addr uint16) {
+			defer wg.Done()
+
+			reg, err := client.ReadRegister(addr, HOLDING_REGISTER)
+			if err != nil {
+				t.Errorf("ReadRegister(%v) should have succeeded, got: %v", addr, err)
+			}
+			if reg != addr {
+				t.Errorf("expected %v, got %v", addr, reg)
+			}
+		}(i * 0x101)
+	}
+	wg.Wait()
+
+	err = <-done
+	if err != nil {
+		t.Errorf("failed to serve requests: %v", err)
+	}
+
+	// breaking the link should fail outstanding requests
+	go func() {
+		var tt = newTCPTransport(p1, 100*time.Millisecond, nil)
+
+		_, _, err := tt.readMBAPFrame()
+		p1.Close()
+		done <- err
+	}()
+
+	_, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if err == nil {
+		t.Errorf("ReadRegister() should have failed")
+	}
+
+	err = <-done
+	if err != nil {
+		t.Errorf("failed to read request: %v", err)
+	}
+
+	// ... as well as subsequent ones (without a reconnect policy, with
+	// the underlying i/o error)
+	_, err = client.ReadRegister(1, HOLDING_REGISTER)
+	if err == nil || err == ErrNotConnected {
+		t.Errorf("expected an i/o error, got: %v", err)
+	}
+
+	client.Close()
+	p2.Close()
+}
+
+func TestPipelinedTCPTransportTimeout(t *testing.T) {
+	var pt *pipelinedTCPTransport
+	var p1, p2 net.Conn
+	var err error
+	var res *pdu
+	var done chan error
+
+	p1, p2 = net.Pipe()
+	done = make(chan error, 1)
+
+	pt = newPipelinedTCPTransport(p2, 100*time.Millisecond, 4, nil)
+
+	// reply to the second request only, then late to the first one
+	go func() {
+		var tt = newTCPTransport(p1, 100*time.Millisecond, nil)
+		var txnIds []uint16
+		var req *pdu
+		var txnId uint16
+		var err error
+
+		for i := 0; i < 2; i++ {
+			req, txnId, err = tt.readMBAPFrame()
+			if err != nil {
+				done <- err
+				return
+			}
+			txnIds = append(txnIds, txnId)
+		}
+
+		_, err = p1.Write(tt.assembleMBAPFrame(txnIds[1], req))
+		if err == nil {
+			time.Sleep(150 * time.Millisecond)
+			_, err = p1.Write(tt.assembleMBAPFrame(txnIds[0], req))
+		}
+		done <- err
+	}()
+
+	go func() {
+		_, err := pt.ExecuteRequest(context.Background(), &pdu{
+			unitId:       0x01,
+			functionCode: 0x41,
+		})
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	res, err = pt.ExecuteRequest(context.Background(), &pdu{
+		unitId:       0x01,
+		functionCode: 0x41,
+		payload:      []byte{0x02},
+	})
+	if err != nil {
+		t.Errorf("ExecuteRequest() should have succeeded, got: %v", err)
+	}
+	if res == nil || len(res.payload) != 1 || res.payload[0] != 0x02 {
+		t.Errorf("unexpected response: %v", res)
+	}
+
+	// the first request should time out without affecting the second
+	err = <-done
+	if err != ErrRequestTimedOut {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+
+	// the late response should be dropped
+	err = <-done
+	if err != nil {
+		t.Errorf("failed to serve requests: %v", err)
+	}
+
+	pt.Close()
+	p1.Close()
+}
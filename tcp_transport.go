@@ -1,8 +1,8 @@
 package modbus
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"time"
@@ -15,15 +15,18 @@ const (
 
 type tcpTransport struct {
 	logger    *logger
-	socket    net.Conn
+	socket    *ctxLink
 	timeout   time.Duration
 	lastTxnId uint16
+	// frame being read, possibly left incomplete by an earlier i/o error
+	rxbuf [maxTCPFrameLength]byte
+	rxlen int
 }
 
 // Returns a new TCP transport.
 func newTCPTransport(socket net.Conn, timeout time.Duration, customLogger *log.Logger) (tt *tcpTransport) {
 	tt = &tcpTransport{
-		socket:  socket,
+		socket:  newCtxLink(socket),
 		timeout: timeout,
 		logger:  newLogger(fmt.Sprintf("tcp-transport(%s)", socket.RemoteAddr()), customLogger),
 	}
@@ -37,7 +40,10 @@ func (tt *tcpTransport) Close() (err error) {
 }
 
 // Runs a request across the socket and returns a response.
-func (tt *tcpTransport) ExecuteRequest(req *pdu) (*pdu, error) {
+// I/O deadlines are bounded by ctx.
+func (tt *tcpTransport) ExecuteRequest(ctx context.Context, req *pdu) (*pdu, error) {
+	defer tt.socket.bind(ctx)()
+
 	// set an i/o deadline on the socket (read and write)
 	err := tt.socket.SetDeadline(time.Now().Add(tt.timeout))
 	if err != nil {
@@ -70,6 +76,13 @@ func (tt *tcpTransport) ReadRequest() (*pdu, error) {
 	return req, nil
 }
 
+// Ties request reads to ctx (see readBinder).
+func (tt *tcpTransport) bindReads(ctx context.Context) (unbind func()) {
+	unbind = tt.socket.bindReads(ctx, tt.timeout)
+
+	return
+}
+
 // Writes a response to the socket.
 func (tt *tcpTransport) WriteResponse(res *pdu) error {
 	if _, err := tt.socket.Write(tt.assembleMBAPFrame(tt.lastTxnId, res)); err != nil {
@@ -112,6 +125,9 @@ func (tt *tcpTransport) readResponse() (res *pdu, err error) {
 }
 
 // Reads an entire frame (MBAP header + modbus PDU) from the socket.
+// A frame left incomplete by an i/o error (e.g. a timeout or an abandoned
+// request) is picked up where it was left off on the next call, which keeps
+// the stream in sync.
 func (tt *tcpTransport) readMBAPFrame() (*pdu, uint16, error) {
 	var rxbuf []byte
 	var bytesNeeded int
@@ -119,11 +135,11 @@ func (tt *tcpTransport) readMBAPFrame() (*pdu, uint16, error) {
 	var unitId uint8
 
 	// read the MBAP header
-	rxbuf = make([]byte, mbapHeaderLength)
-	_, err := io.ReadFull(tt.socket, rxbuf)
+	err := tt.fill(mbapHeaderLength)
 	if err != nil {
 		return nil, 0, err
 	}
+	rxbuf = tt.rxbuf[0:mbapHeaderLength]
 
 	// decode the transaction identifier
 	txnId := bytesToUint16(BIG_ENDIAN, rxbuf[0:2])
@@ -140,20 +156,26 @@ func (tt *tcpTransport) readMBAPFrame() (*pdu, uint16, error) {
 
 	// never read more than the max allowed frame length
 	if bytesNeeded+mbapHeaderLength > maxTCPFrameLength {
+		tt.rxlen = 0
 		return nil, 0, ErrProtocolError
 	}
 
 	// an MBAP length of 0 is illegal
 	if bytesNeeded <= 0 {
+		tt.rxlen = 0
 		return nil, 0, ErrProtocolError
 	}
 
 	// read the PDU
-	rxbuf = make([]byte, bytesNeeded)
-	_, err = io.ReadFull(tt.socket, rxbuf)
+	err = tt.fill(mbapHeaderLength + bytesNeeded)
 	if err != nil {
 		return nil, 0, err
 	}
+	rxbuf = make([]byte, bytesNeeded)
+	copy(rxbuf, tt.rxbuf[mbapHeaderLength:mbapHeaderLength+bytesNeeded])
+
+	// the frame is complete, start afresh on the next call
+	tt.rxlen = 0
 
 	// validate the protocol identifier
 	if protocolId != 0x0000 {
@@ -171,6 +193,21 @@ func (tt *tcpTransport) readMBAPFrame() (*pdu, uint16, error) {
 	return &p, txnId, nil
 }
 
+// Reads from the socket until tt.rxbuf holds at least length bytes.
+func (tt *tcpTransport) fill(length int) (err error) {
+	var n int
+
+	for tt.rxlen < length {
+		n, err = tt.socket.Read(tt.rxbuf[tt.rxlen:length])
+		tt.rxlen += n
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
 // Turns a PDU into an MBAP frame (MBAP header + PDU) and returns it as bytes.
 func (tt *tcpTransport) assembleMBAPFrame(txnId uint16, p *pdu) (payload []byte) {
 	// transaction identifier
@@ -1,19 +1,162 @@
 package modbus
 
+import (
+	"context"
+	"sync"
+	"time"
+)
+
 type transportType uint
 
 const (
-	modbusRTU        transportType = 1
-	modbusRTUOverTCP transportType = 2
-	modbusRTUOverUDP transportType = 3
-	modbusTCP        transportType = 4
-	modbusTCPOverTLS transportType = 5
-	modbusTCPOverUDP transportType = 6
+	modbusRTU          transportType = 1
+	modbusRTUOverTCP   transportType = 2
+	modbusRTUOverUDP   transportType = 3
+	modbusTCP          transportType = 4
+	modbusTCPOverTLS   transportType = 5
+	modbusTCPOverUDP   transportType = 6
+	modbusASCII        transportType = 7
+	modbusASCIIOverTCP transportType = 8
 )
 
 type transport interface {
 	Close() error
-	ExecuteRequest(*pdu) (*pdu, error)
+	ExecuteRequest(context.Context, *pdu) (*pdu, error)
 	ReadRequest() (*pdu, error)
 	WriteResponse(*pdu) error
 }
+
+// Server side transports whose request reads can be tied to a context.
+type readBinder interface {
+	// Makes pending and subsequent reads time out as soon as ctx is done,
+	// until the returned function is called.
+	bindReads(ctx context.Context) (unbind func())
+}
+
+// ctxLink wraps a link (serial port or network socket) to tie its i/o
+// deadlines to the context of the request in flight, if any:
+//   - deadlines set on the link never extend past that of the context,
+//   - pending and subsequent i/o operations time out as soon as the context
+//     is done.
+type ctxLink struct {
+	rtuLink
+	lock       sync.Mutex
+	ctx        context.Context
+	generation uint64
+}
+
+func newCtxLink(link rtuLink) (cl *ctxLink) {
+	cl = &ctxLink{
+		rtuLink: link,
+	}
+
+	return
+}
+
+// Binds the link to ctx until the returned function is called.
+func (cl *ctxLink) bind(ctx context.Context) (unbind func()) {
+	var generation uint64
+	var stop func() bool
+
+	cl.lock.Lock()
+	cl.generation++
+	generation = cl.generation
+	cl.ctx = ctx
+	cl.lock.Unlock()
+
+	stop = context.AfterFunc(ctx, func() {
+		cl.lock.Lock()
+		defer cl.lock.Unlock()
+
+		// unblock any pending i/o, unless the link has since been
+		// unbound from ctx
+		if cl.generation == generation && cl.ctx != nil {
+			cl.rtuLink.SetDeadline(time.Now())
+		}
+	})
+
+	unbind = func() {
+		stop()
+
+		cl.lock.Lock()
+		cl.ctx = nil
+		cl.lock.Unlock()
+	}
+
+	return
+}
+
+// Binds the link to ctx while a request is being read (server side).
+// Unbinding re-arms the i/o deadline of the link for timeout, so that a
+// request read just before ctx was done can still be answered.
+func (cl *ctxLink) bindReads(ctx context.Context, timeout time.Duration) (unbind func()) {
+	var unbindLink = cl.bind(ctx)
+
+	unbind = func() {
+		unbindLink()
+		cl.SetDeadline(time.Now().Add(timeout))
+	}
+
+	return
+}
+
+// Sets the i/o deadline of the underlying link, capped by that of the
+// context the link is bound to.
+func (cl *ctxLink) SetDeadline(deadline time.Time) (err error) {
+	var ctxDeadline time.Time
+	var ok bool
+
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	if cl.ctx != nil {
+		if cl.ctx.Err() != nil {
+			deadline = time.Now()
+		} else if ctxDeadline, ok = cl.ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+	}
+
+	err = cl.rtuLink.SetDeadline(deadline)
+
+	return
+}
+
+// Sleeps for duration d, or until ctx is done.
+// Returns ctx.Err() if ctx is done before d elapses.
+func sleepCtx(ctx context.Context, d time.Duration) (err error) {
+	var timer *time.Timer
+
+	if d <= 0 {
+		err = ctx.Err()
+		return
+	}
+
+	timer = time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+// Returns ctx.Err(), or context.DeadlineExceeded if the deadline of ctx has
+// passed. Link deadlines being capped by that of ctx, i/o operations may
+// time out slightly before ctx itself reports being done.
+func ctxErr(ctx context.Context) (err error) {
+	var deadline time.Time
+	var ok bool
+
+	err = ctx.Err()
+	if err == nil {
+		if deadline, ok = ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			err = context.DeadlineExceeded
+		}
+	}
+
+	return
+}
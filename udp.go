@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"bytes"
 	"net"
 	"time"
 )
@@ -100,3 +101,69 @@ func (usw *udpSockWrapper) RemoteAddr() (addr net.Addr) {
 
 	return
 }
+
+// udpDatagramConn wraps a single datagram received on a server-side UDP
+// socket (net.PacketConn) to satisfy the net.Conn interface: reads consume
+// the datagram payload, while writes are sent back to the datagram source
+// address.
+type udpDatagramConn struct {
+	rxbuf *bytes.Reader
+	sock  net.PacketConn
+	addr  net.Addr
+}
+
+func newUDPDatagramConn(sock net.PacketConn, addr net.Addr, payload []byte) (udc *udpDatagramConn) {
+	udc = &udpDatagramConn{
+		rxbuf: bytes.NewReader(payload),
+		sock:  sock,
+		addr:  addr,
+	}
+
+	return
+}
+
+// Reads bytes from the datagram, returning io.EOF once all bytes
+// have been consumed.
+func (udc *udpDatagramConn) Read(buf []byte) (rlen int, err error) {
+	rlen, err = udc.rxbuf.Read(buf)
+
+	return
+}
+
+// Sends the bytes to the datagram source address.
+func (udc *udpDatagramConn) Write(buf []byte) (wlen int, err error) {
+	wlen, err = udc.sock.WriteTo(buf, udc.addr)
+
+	return
+}
+
+// Closing a datagram is a no-op as the underlying socket is shared.
+func (udc *udpDatagramConn) Close() (err error) {
+	return
+}
+
+// Deadlines are meaningless when reading from memory and writes
+// to UDP sockets do not block.
+func (udc *udpDatagramConn) SetDeadline(deadline time.Time) (err error) {
+	return
+}
+
+func (udc *udpDatagramConn) SetReadDeadline(deadline time.Time) (err error) {
+	return
+}
+
+func (udc *udpDatagramConn) SetWriteDeadline(deadline time.Time) (err error) {
+	return
+}
+
+func (udc *udpDatagramConn) LocalAddr() (addr net.Addr) {
+	addr = udc.sock.LocalAddr()
+
+	return
+}
+
+func (udc *udpDatagramConn) RemoteAddr() (addr net.Addr) {
+	addr = udc.addr
+
+	return
+}
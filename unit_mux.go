@@ -0,0 +1,425 @@
+package modbus
+
+import (
+	"slices"
+	"sync"
+)
+
+// Unit id multiplexer object.
+//
+// A unit mux is a request handler dispatching requests to the handler
+// mapped to their unit id, or to the default handler if none is mapped,
+// allowing for a single server to host several (virtual) devices.
+// Requests to unmapped unit ids, absent a default handler, fail with
+// UnmappedError.
+//
+// Unless a handler is mapped to it, unit id 0 is the broadcast address:
+// broadcast writes (to coils, holding registers and file records, including
+// mask write register requests) are delivered to all mapped handlers, each
+// seeing the request as addressed to its own unit id, as well as to the
+// default handler with unit id 0. Broadcast requests are never answered,
+// and broadcast reads are dropped.
+//
+// Optional handler interfaces (e.g. FIFOHandler) are supported if the
+// target handler implements them, otherwise the request fails with
+// ErrIllegalFunction. Mappings may be changed while the mux is serving
+// requests.
+type UnitMux struct {
+	// UnmappedError sets the error returned for requests to unmapped unit
+	// ids (defaults to ErrGWPathUnavailable, ErrGWTargetFailedToRespond
+	// being the other usual choice for gateways). Should not be changed
+	// while the mux is serving requests.
+	UnmappedError error
+
+	lock           sync.RWMutex
+	handlers       map[uint8]RequestHandler
+	defaultHandler RequestHandler
+}
+
+// Returns a new, empty unit mux.
+func NewUnitMux() *UnitMux {
+	return &UnitMux{}
+}
+
+// Maps unitId to handler. A nil handler removes the mapping.
+func (um *UnitMux) Handle(unitId uint8, handler RequestHandler) {
+	um.lock.Lock()
+	defer um.lock.Unlock()
+
+	if um.handlers == nil {
+		um.handlers = make(map[uint8]RequestHandler)
+	}
+
+	if handler == nil {
+		delete(um.handlers, unitId)
+		return
+	}
+
+	um.handlers[unitId] = handler
+
+	return
+}
+
+// Sets the handler of requests to unmapped unit ids. A nil handler
+// removes the default handler.
+func (um *UnitMux) HandleDefault(handler RequestHandler) {
+	um.lock.Lock()
+	defer um.lock.Unlock()
+
+	um.defaultHandler = handler
+
+	return
+}
+
+// HandleCoils dispatches coil requests (see RequestHandler).
+func (um *UnitMux) HandleCoils(req *CoilsRequest) (res []bool, err error) {
+	var h RequestHandler
+
+	if um.isBroadcast(req.UnitId) {
+		if req.IsWrite {
+			um.broadcast(func(unitId uint8, h RequestHandler) {
+				var r = *req
+
+				r.UnitId = unitId
+				h.HandleCoils(&r)
+			})
+		}
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	res, err = h.HandleCoils(req)
+
+	return
+}
+
+// HandleDiscreteInputs dispatches discrete input requests (see
+// RequestHandler).
+func (um *UnitMux) HandleDiscreteInputs(req *DiscreteInputsRequest) (res []bool, err error) {
+	var h RequestHandler
+
+	if um.isBroadcast(req.UnitId) {
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	res, err = h.HandleDiscreteInputs(req)
+
+	return
+}
+
+// HandleHoldingRegisters dispatches holding register requests (see
+// RequestHandler).
+func (um *UnitMux) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	var h RequestHandler
+
+	if um.isBroadcast(req.UnitId) {
+		if req.IsWrite {
+			um.broadcast(func(unitId uint8, h RequestHandler) {
+				var r = *req
+
+				r.UnitId = unitId
+				h.HandleHoldingRegisters(&r)
+			})
+		}
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	res, err = h.HandleHoldingRegisters(req)
+
+	return
+}
+
+// HandleInputRegisters dispatches input register requests (see
+// RequestHandler).
+func (um *UnitMux) HandleInputRegisters(req *InputRegistersRequest) (res []uint16, err error) {
+	var h RequestHandler
+
+	if um.isBroadcast(req.UnitId) {
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	res, err = h.HandleInputRegisters(req)
+
+	return
+}
+
+// HandleFIFOQueue dispatches FIFO queue requests (see FIFOHandler).
+func (um *UnitMux) HandleFIFOQueue(req *FIFOQueueRequest) (res []uint16, err error) {
+	var h RequestHandler
+
+	if um.isBroadcast(req.UnitId) {
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	if fh, ok := h.(FIFOHandler); ok {
+		res, err = fh.HandleFIFOQueue(req)
+	} else {
+		err = ErrIllegalFunction
+	}
+
+	return
+}
+
+// HandleFileRecords dispatches file record requests (see
+// FileRecordHandler).
+func (um *UnitMux) HandleFileRecords(req *FileRecordsRequest) (res [][]uint16, err error) {
+	var h RequestHandler
+
+	if um.isBroadcast(req.UnitId) {
+		if req.IsWrite {
+			um.broadcast(func(unitId uint8, h RequestHandler) {
+				var r = *req
+
+				if frh, ok := h.(FileRecordHandler); ok {
+					r.UnitId = unitId
+					frh.HandleFileRecords(&r)
+				}
+			})
+		}
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	if frh, ok := h.(FileRecordHandler); ok {
+		res, err = frh.HandleFileRecords(req)
+	} else {
+		err = ErrIllegalFunction
+	}
+
+	return
+}
+
+// HandleDeviceIdentification dispatches device identification requests
+// (see DeviceIdentificationHandler).
+func (um *UnitMux) HandleDeviceIdentification(req *DeviceIdentificationRequest) (res map[uint8]string, err error) {
+	var h RequestHandler
+
+	if um.isBroadcast(req.UnitId) {
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	if dih, ok := h.(DeviceIdentificationHandler); ok {
+		res, err = dih.HandleDeviceIdentification(req)
+	} else {
+		err = ErrIllegalFunction
+	}
+
+	return
+}
+
+// HandleExceptionStatus dispatches exception status requests (see
+// ExceptionStatusHandler).
+func (um *UnitMux) HandleExceptionStatus(req *ExceptionStatusRequest) (res uint8, err error) {
+	var h RequestHandler
+
+	if um.isBroadcast(req.UnitId) {
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	if esh, ok := h.(ExceptionStatusHandler); ok {
+		res, err = esh.HandleExceptionStatus(req)
+	} else {
+		err = ErrIllegalFunction
+	}
+
+	return
+}
+
+// HandleServerId dispatches report server id requests (see
+// ServerIdHandler).
+func (um *UnitMux) HandleServerId(req *ServerIdRequest) (serverId []byte, running bool, err error) {
+	var h RequestHandler
+
+	if um.isBroadcast(req.UnitId) {
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	if sih, ok := h.(ServerIdHandler); ok {
+		serverId, running, err = sih.HandleServerId(req)
+	} else {
+		err = ErrIllegalFunction
+	}
+
+	return
+}
+
+// HandleUnknownFunction dispatches requests carrying other function codes
+// (see FallbackHandler).
+func (um *UnitMux) HandleUnknownFunction(req *UnknownFunctionRequest) (res []byte, err error) {
+	var h RequestHandler
+
+	// the semantics of unknown function codes are unknown as well: never
+	// broadcast them
+	if um.isBroadcast(req.UnitId) {
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	if fh, ok := h.(FallbackHandler); ok {
+		res, err = fh.HandleUnknownFunction(req)
+	} else {
+		err = ErrIllegalFunction
+	}
+
+	return
+}
+
+// handleCompound dispatches mask write register and read/write multiple
+// registers requests, as well as requests to functions implemented by the
+// server (see compoundHandler).
+func (um *UnitMux) handleCompound(req *ServerRequest,
+	phases func(h RequestHandler, unitId uint8) error) (err error) {
+	var h RequestHandler
+
+	// functions implemented by the server are carried out once, whatever
+	// the unit id: through the middlewares of the handler mapped to it, if
+	// any, or directly otherwise
+	if isServerFunction(req.FunctionCode) {
+		if !um.isBroadcast(req.UnitId) {
+			h, err = um.handler(req.UnitId)
+		}
+
+		if h != nil {
+			err = runCompound(h, req, phases)
+		} else {
+			err = phases(um, req.UnitId)
+		}
+		return
+	}
+
+	// both requests write to registers: run them against each handler in
+	// turn, as the read phase of a mask write (or the read-back of a
+	// read/write request) would otherwise be dropped as a broadcast read
+	if um.isBroadcast(req.UnitId) {
+		um.broadcast(func(unitId uint8, h RequestHandler) {
+			var r = *req
+
+			r.UnitId = unitId
+			runCompound(h, &r, phases)
+		})
+		err = errNoResponse
+		return
+	}
+
+	h, err = um.handler(req.UnitId)
+	if err != nil {
+		return
+	}
+
+	err = runCompound(h, req, phases)
+
+	return
+}
+
+// Returns true if requests to unitId are broadcast requests.
+func (um *UnitMux) isBroadcast(unitId uint8) bool {
+	um.lock.RLock()
+	defer um.lock.RUnlock()
+
+	return unitId == 0x00 && um.handlers[0x00] == nil
+}
+
+// Returns the handler of unitId.
+func (um *UnitMux) handler(unitId uint8) (h RequestHandler, err error) {
+	um.lock.RLock()
+	defer um.lock.RUnlock()
+
+	h = um.handlers[unitId]
+	if h == nil {
+		h = um.defaultHandler
+	}
+
+	if h == nil {
+		err = um.UnmappedError
+		if err == nil {
+			err = ErrGWPathUnavailable
+		}
+	}
+
+	return
+}
+
+// Runs fn over all mapped handlers in unit id order, then over the
+// default handler (with unit id 0), if any.
+func (um *UnitMux) broadcast(fn func(unitId uint8, h RequestHandler)) {
+	var unitIds []uint8
+	var handlers = make(map[uint8]RequestHandler)
+	var defaultHandler RequestHandler
+
+	// work on a snapshot of the mappings, as handlers may take a while
+	um.lock.RLock()
+	for unitId, h := range um.handlers {
+		unitIds = append(unitIds, unitId)
+		handlers[unitId] = h
+	}
+	defaultHandler = um.defaultHandler
+	um.lock.RUnlock()
+
+	slices.Sort(unitIds)
+	for _, unitId := range unitIds {
+		fn(unitId, handlers[unitId])
+	}
+
+	if defaultHandler != nil {
+		fn(0x00, defaultHandler)
+	}
+
+	return
+}
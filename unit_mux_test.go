@@ -0,0 +1,169 @@
+package modbus
+
+import (
+	"testing"
+)
+
+func TestUnitMux(t *testing.T) {
+	var mux *UnitMux
+	var ms *ModbusServer
+	var ds1, ds2, dsDefault *DataStore
+	var client *ModbusClient
+	var err error
+	var reg uint16
+	var regs []uint16
+
+	newStore := func() (ds *DataStore) {
+		ds, err = NewDataStore(&DataStoreConfiguration{
+			Coils:            10,
+			HoldingRegisters: 10,
+			// only serve unit ids the store is mapped to
+			UnitIds: []uint8{1, 2},
+		})
+		if err != nil {
+			t.Fatalf("NewDataStore() should have succeeded, got: %v", err)
+		}
+
+		return
+	}
+
+	ds1 = newStore()
+	ds2 = newStore()
+	dsDefault, _ = NewDataStore(&DataStoreConfiguration{HoldingRegisters: 10})
+
+	mux = NewUnitMux()
+	mux.Handle(1, ds1)
+	mux.Handle(2, ds2)
+
+	client, err = NewFakeClient(mux)
+	if err != nil {
+		t.Fatalf("NewFakeClient() should have succeeded, got: %v", err)
+	}
+	defer client.Close()
+
+	// requests should be routed by unit id
+	err = client.Unit(1).WriteRegister(3, 0x1111)
+	if err != nil {
+		t.Errorf("WriteRegister() should have succeeded, got: %v", err)
+	}
+	err = client.Unit(2).WriteRegister(3, 0x2222)
+	if err != nil {
+		t.Errorf("WriteRegister() should have succeeded, got: %v", err)
+	}
+
+	reg, _ = ds1.Unit(1).GetRegister(3, HOLDING_REGISTER)
+	if reg != 0x1111 {
+		t.Errorf("expected 0x1111, got 0x%04x", reg)
+	}
+	reg, _ = ds2.Unit(2).GetRegister(3, HOLDING_REGISTER)
+	if reg != 0x2222 {
+		t.Errorf("expected 0x2222, got 0x%04x", reg)
+	}
+
+	// unmapped unit ids should fail with the unmapped error
+	_, err = client.Unit(3).ReadRegister(3, HOLDING_REGISTER)
+	if err != ErrGWPathUnavailable {
+		t.Errorf("expected ErrGWPathUnavailable, got: %v", err)
+	}
+
+	mux.UnmappedError = ErrGWTargetFailedToRespond
+	_, err = client.Unit(3).ReadRegister(3, HOLDING_REGISTER)
+	if err != ErrGWTargetFailedToRespond {
+		t.Errorf("expected ErrGWTargetFailedToRespond, got: %v", err)
+	}
+
+	// ... or be passed to the default handler, if any
+	mux.HandleDefault(dsDefault)
+	err = client.Unit(3).WriteRegister(3, 0x3333)
+	if err != nil {
+		t.Errorf("WriteRegister() should have succeeded, got: %v", err)
+	}
+
+	// optional interfaces should be supported if the target handler
+	// implements them
+	_, err = client.Unit(1).ReadFIFOQueue(0)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	// broadcast writes should be delivered to all units but never answered
+	_, err = mux.HandleHoldingRegisters(&HoldingRegistersRequest{
+		UnitId:   0,
+		Addr:     5,
+		Quantity: 2,
+		IsWrite:  true,
+		Args:     []uint16{0xaaaa, 0xbbbb},
+	})
+	if err != errNoResponse {
+		t.Errorf("expected errNoResponse, got: %v", err)
+	}
+
+	for _, ud := range []*UnitData{ds1.Unit(1), ds2.Unit(2), dsDefault.Unit(0)} {
+		regs, _ = ud.GetRegisters(5, 2, HOLDING_REGISTER)
+		if len(regs) != 2 || regs[0] != 0xaaaa || regs[1] != 0xbbbb {
+			t.Errorf("unexpected register values: %v", regs)
+		}
+	}
+
+	_, err = mux.HandleCoils(&CoilsRequest{
+		UnitId:   0,
+		Addr:     9,
+		Quantity: 1,
+		IsWrite:  true,
+		Args:     []bool{true},
+	})
+	if err != errNoResponse {
+		t.Errorf("expected errNoResponse, got: %v", err)
+	}
+	if coil, _ := ds2.Unit(2).GetCoil(9); !coil {
+		t.Errorf("broadcast coil write should have been delivered")
+	}
+
+	// broadcast mask writes should read and write each unit in turn
+	ms, err = NewServer(&ServerConfiguration{URL: "tcp://fake"}, mux)
+	if err != nil {
+		t.Fatalf("NewServer() should have succeeded, got: %v", err)
+	}
+
+	err = ms.maskWriteRegister("fake", "", 0, 3, 0x00ff, 0x1234)
+	if err != errNoResponse {
+		t.Errorf("expected errNoResponse, got: %v", err)
+	}
+
+	for i, ud := range []*UnitData{ds1.Unit(1), ds2.Unit(2), dsDefault.Unit(0)} {
+		reg, _ = ud.GetRegister(3, HOLDING_REGISTER)
+		if expected := []uint16{0x1211, 0x1222, 0x1233}[i]; reg != expected {
+			t.Errorf("expected 0x%04x, got 0x%04x", expected, reg)
+		}
+	}
+
+	// broadcast reads should be dropped
+	_, err = mux.HandleHoldingRegisters(&HoldingRegistersRequest{
+		UnitId:   0,
+		Addr:     5,
+		Quantity: 2,
+	})
+	if err != errNoResponse {
+		t.Errorf("expected errNoResponse, got: %v", err)
+	}
+
+	// unless a handler is mapped to unit id 0
+	mux.Handle(0, dsDefault)
+	regs, err = client.Unit(0).ReadRegisters(5, 2, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0xaaaa {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	// removing mappings should make unit ids unmapped again
+	mux.Handle(2, nil)
+	mux.HandleDefault(nil)
+	_, err = client.Unit(2).ReadRegister(3, HOLDING_REGISTER)
+	if err != ErrGWTargetFailedToRespond {
+		t.Errorf("expected ErrGWTargetFailedToRespond, got: %v", err)
+	}
+
+	return
+}